@@ -13,7 +13,7 @@ BUILD_TIME=$(date -u '+%Y-%m-%d_%H:%M:%S')
 GIT_COMMIT=$(git rev-parse --short HEAD 2>/dev/null || echo "unknown")
 
 # Build flags
-LDFLAGS="-s -w -X main.Version=${VERSION} -X main.BuildTime=${BUILD_TIME} -X main.GitCommit=${GIT_COMMIT}"
+LDFLAGS="-s -w -X github.com/rios/worker/cmd.Version=${VERSION} -X github.com/rios/worker/pkg/update.ReleasePublicKey=${RELEASE_PUBLIC_KEY} -X main.BuildTime=${BUILD_TIME} -X main.GitCommit=${GIT_COMMIT}"
 
 # Detect OS
 OS=$(uname -s | tr '[:upper:]' '[:lower:]')
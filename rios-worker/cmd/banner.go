@@ -1,11 +1,21 @@
 package cmd
 
-import "fmt"
+import (
+	"fmt"
 
-const Version = "v0.1.0"
+	"github.com/rios/worker/pkg/logging"
+)
 
-// PrintBanner prints the RiOS ASCII art banner
+// Version is the worker's version, set at build time with
+// -X github.com/rios/worker/cmd.Version=...
+var Version = "v0.1.0"
+
+// PrintBanner prints the RiOS ASCII art banner, unless the output is meant
+// for a machine
 func PrintBanner() {
+	if logFormat != logging.FormatPretty {
+		return
+	}
 	fmt.Println()
 	fmt.Println("+-------------------------------------------------+")
 	fmt.Println("|   RRRRR   IIIII   OOOOO   SSSSS                 |")
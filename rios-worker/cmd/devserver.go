@@ -0,0 +1,131 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/rios/worker/pkg/api"
+	"github.com/rios/worker/pkg/devserver"
+	"github.com/rios/worker/pkg/update"
+	"github.com/spf13/cobra"
+)
+
+var (
+	devServerListen string
+	devServerScript string
+	devServerQuiet  bool
+
+	devServerMinVersion  string
+	devServerMinProtocol int
+	devServerReleases    string
+)
+
+// devServerCmd represents the dev-server command
+var devServerCmd = &cobra.Command{
+	Use:   "dev-server",
+	Short: "Run an in-memory orchestrator for local development",
+	Long: `Run a mock orchestrator that keeps everything in memory. It implements
+handshake, register, heartbeat, get-job, submit-result, reject-job,
+update-hardware, status, earnings and jobs, so a worker can be registered
+and run against it with no network:
+
+  rios-worker dev-server --script jobs.json
+  rios-worker register --api http://127.0.0.1:3000 --wallet 0xdev --yes
+  rios-worker run
+
+Jobs and faults can be loaded from a script and added while it runs:
+
+  POST   /dev/jobs     queue a job or a list of jobs
+  POST   /dev/faults   inject a fault, e.g. {"endpoint": "heartbeat", "status": 503, "times": 3}
+  DELETE /dev/faults   clear all faults
+  GET    /dev/state    nodes, queued and running jobs, results and faults
+
+To try updates, serve a release directory with a signed manifest
+(rios-worker update sign) and require a newer version in heartbeats:
+
+  rios-worker dev-server --releases dist --min-version v0.2.0
+  rios-worker update --manifest http://127.0.0.1:3000/releases/manifest.json`,
+	RunE: runDevServer,
+}
+
+func init() {
+	rootCmd.AddCommand(devServerCmd)
+	devServerCmd.Flags().StringVar(&devServerListen, "listen", "127.0.0.1:3000", "Address to listen on")
+	devServerCmd.Flags().StringVar(&devServerScript, "script", "", "JSON file with jobs to queue and faults to inject")
+	devServerCmd.Flags().BoolVarP(&devServerQuiet, "quiet", "q", false, "Don't log requests")
+	devServerCmd.Flags().StringVar(&devServerMinVersion, "min-version", "", "Oldest worker version to accept, sent in heartbeat responses")
+	devServerCmd.Flags().IntVar(&devServerMinProtocol, "min-protocol", api.ProtocolVersion, "Oldest worker protocol to accept; older workers get 426 Upgrade Required")
+	devServerCmd.Flags().StringVar(&devServerReleases, "releases", "", "Directory to serve under /releases/, e.g. a release manifest and binaries")
+}
+
+func runDevServer(cmd *cobra.Command, args []string) error {
+	if devServerMinVersion != "" {
+		if _, err := update.ParseVersion(devServerMinVersion); err != nil {
+			return fmt.Errorf("invalid --min-version: %w", err)
+		}
+	}
+
+	srv := devserver.New()
+	srv.MinVersion = devServerMinVersion
+	srv.MinProtocol = devServerMinProtocol
+	if srv.MaxProtocol < srv.MinProtocol {
+		srv.MaxProtocol = srv.MinProtocol
+	}
+	if !devServerQuiet {
+		srv.Logf = func(format string, args ...interface{}) {
+			fmt.Printf("%s  %s\n", time.Now().Format("15:04:05"), fmt.Sprintf(format, args...))
+		}
+	}
+
+	if devServerScript != "" {
+		script, err := devserver.LoadScript(devServerScript)
+		if err != nil {
+			return err
+		}
+		srv.Apply(script)
+		fmt.Printf("📜 Loaded %d jobs and %d faults from %s\n", len(script.Jobs), len(script.Faults), devServerScript)
+	}
+
+	listener, err := net.Listen("tcp", devServerListen)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", devServerListen, err)
+	}
+	handler := http.Handler(srv)
+	if devServerReleases != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/releases/", http.StripPrefix("/releases/", http.FileServer(http.Dir(devServerReleases))))
+		mux.Handle("/", srv)
+		handler = mux
+	}
+	server := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigChan
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(ctx)
+	}()
+
+	fmt.Printf("🧪 Dev orchestrator listening on http://%s\n", listener.Addr())
+	fmt.Println("   Press Ctrl+C to stop")
+	fmt.Println()
+
+	if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	fmt.Println()
+	fmt.Println("👋 Dev orchestrator stopped")
+	return nil
+}
@@ -0,0 +1,111 @@
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/rios/worker/pkg/config"
+	"github.com/rios/worker/pkg/doctor"
+	"github.com/spf13/cobra"
+)
+
+var (
+	doctorOutput       string
+	doctorProbeImage   string
+	doctorProbeTimeout time.Duration
+)
+
+// doctorCmd represents the doctor command
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Check that this machine can run jobs",
+	Long: `Check step by step that this machine is ready to run jobs: the NVIDIA
+driver and CUDA version, the container runtime and NVIDIA Container Toolkit (or
+CDI spec), GPU passthrough into a container, free disk space, and whether the
+orchestrator can be reached with a clock that agrees with it. Every problem
+comes with a fix.
+
+The passthrough probe runs nvidia-smi in a small image that is kept after the
+first run. The command exits with an error if any check fails; --output json
+prints the full report for monitoring.`,
+	SilenceUsage: true,
+	RunE:         runDoctor,
+}
+
+func init() {
+	rootCmd.AddCommand(doctorCmd)
+	doctorCmd.Flags().StringVarP(&doctorOutput, "output", "o", "text", "Output format: text or json")
+	doctorCmd.Flags().StringVar(&runtimeName, "runtime", "auto", "Container runtime to check: auto, docker, podman or nerdctl")
+	doctorCmd.Flags().StringVar(&doctorProbeImage, "probe-image", doctor.DefaultProbeImage, "Image the GPU passthrough probe runs in")
+	doctorCmd.Flags().DurationVar(&doctorProbeTimeout, "probe-timeout", 5*time.Minute, "How long the probe may take, including its download")
+}
+
+func runDoctor(cmd *cobra.Command, args []string) error {
+	if err := validateOutputFormat(doctorOutput); err != nil {
+		return err
+	}
+
+	dataDir, err := config.DataDir()
+	if err != nil {
+		return err
+	}
+
+	opts := doctor.Options{
+		ProbeImage:   doctorProbeImage,
+		ProbeTimeout: doctorProbeTimeout,
+		WorkDir:      filepath.Join(dataDir, "work"),
+		APIEndpoint:  apiEndpoint,
+
+		WorkerVersion: Version,
+	}
+	// A registered node checks the orchestrator it is registered with, and
+	// the disk space its policy keeps free
+	if cfg, err := config.Load(); err == nil {
+		if cfg.APIEndpoint != "" && !rootCmd.PersistentFlags().Changed("api") {
+			opts.APIEndpoint = cfg.APIEndpoint
+		}
+		opts.MinFreeDiskBytes = int64(cfg.Policy.MinFreeDiskGB * (1 << 30))
+	}
+	opts.Runtime, opts.RuntimeErr = newRuntime(runtimeName, "")
+
+	if doctorOutput == "text" {
+		fmt.Println("🩺 Checking this machine...")
+		fmt.Println()
+	}
+	report := doctor.Run(opts)
+
+	if doctorOutput == "json" {
+		if err := printJSON(report); err != nil {
+			return err
+		}
+	} else {
+		for _, c := range report.Checks {
+			fmt.Printf("%s %-13s %s\n", statusIcon(c.Status), c.Name, c.Detail)
+			if c.Fix != "" {
+				fmt.Printf("   💡 %s\n", c.Fix)
+			}
+		}
+		fmt.Println()
+		if report.Healthy {
+			fmt.Println("✅ This machine is ready to run jobs")
+		}
+	}
+
+	if !report.Healthy {
+		return fmt.Errorf("%d check(s) failed", report.Failed())
+	}
+	return nil
+}
+
+func statusIcon(status string) string {
+	switch status {
+	case doctor.StatusOK:
+		return "✅"
+	case doctor.StatusWarn:
+		return "⚠️ "
+	case doctor.StatusFail:
+		return "❌"
+	}
+	return "⏭️ "
+}
@@ -0,0 +1,121 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rios/worker/pkg/api"
+	"github.com/rios/worker/pkg/history"
+	"github.com/spf13/cobra"
+)
+
+var (
+	earningsDays   int
+	earningsLocal  bool
+	earningsOutput string
+)
+
+// earningsCmd represents the earnings command
+var earningsCmd = &cobra.Command{
+	Use:   "earnings",
+	Short: "Show earnings by day and task type",
+	Long: `Show the $ROS rewards earned by this node, grouped by day and task type,
+split into pending and paid amounts. The orchestrator is queried first; the
+local job history is used when it cannot be reached or --local is given.`,
+	RunE: runEarnings,
+}
+
+func init() {
+	rootCmd.AddCommand(earningsCmd)
+	earningsCmd.Flags().IntVarP(&earningsDays, "days", "d", 30, "Number of days to show")
+	earningsCmd.Flags().BoolVar(&earningsLocal, "local", false, "Only use the local job history, do not contact the orchestrator")
+	earningsCmd.Flags().StringVarP(&earningsOutput, "output", "o", "text", "Output format: text or json")
+}
+
+func runEarnings(cmd *cobra.Command, args []string) error {
+	if err := validateOutputFormat(earningsOutput); err != nil {
+		return err
+	}
+
+	_, client, err := newConfiguredClient()
+	if err != nil {
+		return err
+	}
+
+	var report *api.EarningsResponse
+	source := "orchestrator"
+
+	if !earningsLocal {
+		report, err = client.GetEarnings(earningsDays)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "⚠️  Orchestrator unreachable, showing local history: %v\n", err)
+			report = nil
+		}
+	}
+
+	if report == nil {
+		source = "local history"
+		report, err = localEarnings(earningsDays)
+		if err != nil {
+			return err
+		}
+	}
+
+	if earningsOutput == "json" {
+		if report.Days == nil {
+			report.Days = []api.EarningsDay{}
+		}
+		return printJSON(report)
+	}
+
+	fmt.Printf("💰 Earnings, last %d days (%s)\n", earningsDays, source)
+	fmt.Println("============================")
+	if len(report.Days) == 0 {
+		fmt.Println("No earnings yet.")
+	} else {
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "DATE\tTYPE\tJOBS\tPENDING\tPAID")
+		for _, day := range report.Days {
+			fmt.Fprintf(w, "%s\t%s\t%d\t%.8f\t%.8f\n", day.Date, day.TaskType, day.Jobs, day.Pending, day.Paid)
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+	fmt.Println()
+	fmt.Printf("Pending: %.8f $ROS\n", report.TotalPending)
+	fmt.Printf("Paid:    %.8f $ROS\n", report.TotalPaid)
+	return nil
+}
+
+// localEarnings builds an earnings report from the local job history
+func localEarnings(days int) (*api.EarningsResponse, error) {
+	store, err := history.OpenDefault()
+	if err != nil {
+		return nil, err
+	}
+	defer store.Close()
+
+	now := time.Now()
+	since := time.Date(now.Year(), now.Month(), now.Day()-days+1, 0, 0, 0, 0, now.Location())
+	rows, err := store.EarningsSince(since)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &api.EarningsResponse{Success: true}
+	for _, row := range rows {
+		report.Days = append(report.Days, api.EarningsDay{
+			Date:     row.Date,
+			TaskType: row.TaskType,
+			Jobs:     row.Jobs,
+			Pending:  row.Pending,
+			Paid:     row.Paid,
+		})
+		report.TotalPending += row.Pending
+		report.TotalPaid += row.Paid
+	}
+	return report, nil
+}
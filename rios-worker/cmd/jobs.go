@@ -0,0 +1,125 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rios/worker/pkg/history"
+	"github.com/spf13/cobra"
+)
+
+var (
+	jobsLimit  int
+	jobsLocal  bool
+	jobsOutput string
+)
+
+// jobsCmd represents the jobs command
+var jobsCmd = &cobra.Command{
+	Use:   "jobs",
+	Short: "List recent jobs with outcome and duration",
+	Long: `List the most recent jobs processed by this node with their outcome,
+duration and reward. The orchestrator is queried first; the local job history
+is used when it cannot be reached or --local is given.`,
+	RunE: runJobs,
+}
+
+func init() {
+	rootCmd.AddCommand(jobsCmd)
+	jobsCmd.Flags().IntVarP(&jobsLimit, "limit", "n", 20, "Number of jobs to show")
+	jobsCmd.Flags().BoolVar(&jobsLocal, "local", false, "Only use the local job history, do not contact the orchestrator")
+	jobsCmd.Flags().StringVarP(&jobsOutput, "output", "o", "text", "Output format: text or json")
+}
+
+func runJobs(cmd *cobra.Command, args []string) error {
+	if err := validateOutputFormat(jobsOutput); err != nil {
+		return err
+	}
+
+	_, client, err := newConfiguredClient()
+	if err != nil {
+		return err
+	}
+
+	var rows []jobRow
+	fromOrchestrator := false
+
+	if !jobsLocal {
+		remote, err := client.GetJobHistory(jobsLimit)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "⚠️  Orchestrator unreachable, showing local history: %v\n", err)
+		} else {
+			fromOrchestrator = true
+			for _, job := range remote.Jobs {
+				rows = append(rows, jobRow{
+					JobID:           job.JobID,
+					TaskType:        job.TaskType,
+					Status:          job.Status,
+					StartedAt:       job.StartedAt,
+					DurationSeconds: job.DurationSeconds,
+					Reward:          job.Reward,
+					RewardStatus:    job.RewardStatus,
+					Traffic:         job.Traffic,
+				})
+			}
+		}
+	}
+
+	if !fromOrchestrator {
+		store, err := history.OpenDefault()
+		if err != nil {
+			return err
+		}
+		defer store.Close()
+
+		records, err := store.RecentJobs(jobsLimit)
+		if err != nil {
+			return err
+		}
+		for i := range records {
+			rows = append(rows, jobRowFromRecord(&records[i]))
+		}
+	}
+
+	if jobsOutput == "json" {
+		if rows == nil {
+			rows = []jobRow{}
+		}
+		return printJSON(rows)
+	}
+
+	source := "local history"
+	if fromOrchestrator {
+		source = "orchestrator"
+	}
+	fmt.Printf("📋 Recent Jobs (%s)\n", source)
+	fmt.Println("============================")
+	if len(rows) == 0 {
+		fmt.Println("No jobs yet.")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "JOB ID\tTYPE\tSTATUS\tSTARTED\tDURATION\tTRAFFIC\tREWARD")
+	for _, row := range rows {
+		traffic := "-"
+		if row.Traffic != nil {
+			traffic = formatBytes(row.Traffic.Total())
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%.8f\n",
+			row.JobID, row.TaskType, row.Status, formatTimestamp(row.StartedAt),
+			formatDuration(row.duration()), traffic, row.Reward)
+	}
+	return w.Flush()
+}
+
+// formatTimestamp shortens an RFC 3339 timestamp to local date and time
+func formatTimestamp(ts string) string {
+	t, err := time.Parse(time.RFC3339, ts)
+	if err != nil {
+		return ts
+	}
+	return t.Local().Format("2006-01-02 15:04")
+}
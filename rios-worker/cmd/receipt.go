@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/rios/worker/pkg/receipt"
+	"github.com/spf13/cobra"
+)
+
+var receiptOutput string
+
+// receiptCmd represents the receipt command
+var receiptCmd = &cobra.Command{
+	Use:   "receipt <job-id>",
+	Short: "Show and verify the signed receipt of a job",
+	Long: `Show the execution receipt this node signed for a job: the image digest,
+input and output hashes, run times, GPU and exit code. The signature is checked
+against this node's key. With --output json the signed receipt is printed as
+stored, so it can be handed over as evidence in a reward dispute.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runReceipt,
+}
+
+func init() {
+	rootCmd.AddCommand(receiptCmd)
+	receiptCmd.Flags().StringVarP(&receiptOutput, "output", "o", "text", "Output format: text or json")
+}
+
+func runReceipt(cmd *cobra.Command, args []string) error {
+	if err := validateOutputFormat(receiptOutput); err != nil {
+		return err
+	}
+
+	dir, err := receipt.DefaultDir()
+	if err != nil {
+		return err
+	}
+	signed, err := receipt.Load(dir, args[0])
+	if err != nil {
+		return err
+	}
+	rcpt, err := receipt.Verify(signed)
+	if err != nil {
+		return err
+	}
+
+	keyPath, err := receipt.DefaultKeyPath()
+	if err != nil {
+		return err
+	}
+	nodeKey, err := receipt.LoadKey(keyPath)
+	if err != nil {
+		return err
+	}
+	ownKey := signed.PublicKey == receipt.PublicKey(nodeKey)
+
+	if receiptOutput == "json" {
+		// Indenting would reformat the signed bytes
+		data, err := json.Marshal(signed)
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+
+	fmt.Printf("🧾 Receipt for job %s\n", rcpt.JobID)
+	if ownKey {
+		fmt.Println("   Signature: ✅ valid, signed by this node")
+	} else {
+		fmt.Println("   Signature: ⚠️  valid, but signed by a different key")
+	}
+	fmt.Printf("   Node: %d (%d x %s)\n", rcpt.NodeID, rcpt.GPUCount, rcpt.GPUType)
+	fmt.Printf("   Image: %s\n", rcpt.Image)
+	if rcpt.ImageDigest != "" {
+		fmt.Printf("   Image digest: %s\n", rcpt.ImageDigest)
+	}
+	fmt.Printf("   Ran: %s – %s (%s)\n", rcpt.StartedAt.Local().Format("2006-01-02 15:04:05"),
+		rcpt.FinishedAt.Local().Format("2006-01-02 15:04:05"), rcpt.FinishedAt.Sub(rcpt.StartedAt).Round(time.Second))
+	fmt.Printf("   Status: %s, exit code %d\n", rcpt.Status, rcpt.ExitCode)
+
+	fmt.Println("   Inputs:")
+	for _, f := range rcpt.Inputs {
+		fmt.Printf("     %s  %s (%s)\n", f.SHA256, f.Path, formatBytes(f.SizeBytes))
+	}
+	fmt.Println("   Outputs:")
+	for _, f := range rcpt.Outputs {
+		fmt.Printf("     %s  %s (%s)\n", f.SHA256, f.Path, formatBytes(f.SizeBytes))
+	}
+	return nil
+}
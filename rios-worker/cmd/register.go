@@ -3,136 +3,218 @@ package cmd
 import (
 	"bufio"
 	"fmt"
+	"log/slog"
 	"os"
 	"strings"
 
 	"github.com/rios/worker/pkg/api"
 	"github.com/rios/worker/pkg/config"
-	"github.com/rios/worker/pkg/docker"
 	"github.com/rios/worker/pkg/gpu"
+	"github.com/rios/worker/pkg/logging"
+	"github.com/rios/worker/pkg/receipt"
 	"github.com/spf13/cobra"
 )
 
-var skipDocker bool
+var (
+	skipDocker     bool
+	walletAddress  string
+	workerName     string
+	assumeYes      bool
+	provisionToken string
+	registerOutput string
+)
 
 // registerCmd represents the register command
 var registerCmd = &cobra.Command{
 	Use:   "register",
 	Short: "Register this machine as a worker node",
 	Long: `Register this machine as a worker node in the RiOS network.
-This will detect your GPU configuration and register with the orchestrator.`,
+This will detect your GPU configuration and register with the orchestrator.
+
+For unattended provisioning (Ansible, cloud-init, ...) pass --wallet and --yes
+so that no prompts are shown. A fleet provisioning token (--provision-token or
+RIOS_PROVISION_TOKEN) lets the orchestrator approve the node automatically, and
+--output json prints a machine-readable result on stdout.`,
 	RunE: runRegister,
 }
 
 func init() {
 	rootCmd.AddCommand(registerCmd)
-	registerCmd.Flags().BoolVar(&skipDocker, "skip-docker", false, "Skip Docker checks (for testing)")
+	registerCmd.Flags().BoolVar(&skipDocker, "skip-docker", false, "Skip container runtime checks and register simulated GPUs (for testing)")
+	registerCmd.Flags().StringVar(&runtimeName, "runtime", "auto", "Container runtime to check: auto, docker, podman or nerdctl")
+	registerCmd.Flags().StringVar(&gpuInventory, "gpu-inventory", "", "JSON inventory of the simulated GPUs to register with --skip-docker (default: one 8 GB mock GPU)")
+	registerCmd.Flags().StringVar(&walletAddress, "wallet", "", "$ROS wallet address (BSC) to receive rewards")
+	registerCmd.Flags().StringVar(&workerName, "name", "", "Name for this worker (optional)")
+	registerCmd.Flags().BoolVarP(&assumeYes, "yes", "y", false, "Never prompt; fail if required values are missing and replace any existing registration")
+	registerCmd.Flags().StringVar(&provisionToken, "provision-token", "", "Pre-issued fleet provisioning token (or set RIOS_PROVISION_TOKEN)")
+	registerCmd.Flags().StringVarP(&registerOutput, "output", "o", "text", "Output format: text or json")
+}
+
+// registerResult is the machine-readable output of 'register --output json'
+type registerResult struct {
+	NodeID        int    `json:"node_id"`
+	Status        string `json:"status,omitempty"`
+	WalletAddress string `json:"wallet_address"`
+	Name          string `json:"name,omitempty"`
+	GPUType       string `json:"gpu_type"`
+	GPUCount      int    `json:"gpu_count"`
+	GPUVram       int    `json:"gpu_vram"`
+	APIEndpoint   string `json:"api_endpoint"`
+	ConfigPath    string `json:"config_path"`
 }
 
 func runRegister(cmd *cobra.Command, args []string) error {
-	PrintBanner()
+	if err := validateOutputFormat(registerOutput); err != nil {
+		return err
+	}
+	jsonOutput := registerOutput == "json"
+	interactive := !assumeYes && !jsonOutput
+
+	// In JSON mode stdout is reserved for the result, progress goes to stderr
+	log := slog.Default()
+	if jsonOutput {
+		logger, err := newLogger(os.Stderr)
+		if err != nil {
+			return err
+		}
+		log = logger
+	} else {
+		PrintBanner()
+	}
 
-	fmt.Println("🚀 Worker Registration")
-	fmt.Println("============================")
-	fmt.Println()
+	if provisionToken == "" {
+		provisionToken = os.Getenv("RIOS_PROVISION_TOKEN")
+	}
 
-	// Step 1: Check Docker
-	if !skipDocker {
-		fmt.Println("📦 Checking Docker installation...")
-		if err := docker.CheckDockerInstalled(); err != nil {
+	reader := bufio.NewReader(os.Stdin)
+
+	log.Info("Worker registration", logging.Icon("🚀"))
+
+	// Refuse to silently replace an existing registration. Registering again
+	// only replaces the identity and hardware, the rest of the configuration
+	// (governor, schedule, policy, ...) is kept.
+	cfg := &config.Config{}
+	if config.Exists() {
+		existing, err := config.Load()
+		if err != nil {
 			return err
 		}
-		fmt.Println("✅ Docker is installed")
+		cfg = existing
 
-		if err := docker.CheckDockerRunning(); err != nil {
+		configPath, _ := config.GetConfigPath()
+		if !assumeYes {
+			if !interactive {
+				return fmt.Errorf("this machine is already registered (%s). Pass --yes to register again", configPath)
+			}
+			log.Warn("This machine is already registered", "config", configPath)
+			if !confirm(reader, "   Register again and overwrite it? [y/N]: ") {
+				log.Info("Registration cancelled")
+				return nil
+			}
+		}
+	}
+
+	// Step 1: Check the container runtime
+	if !skipDocker {
+		log.Info("Checking container runtime...", logging.Icon("📦"))
+		runtime, err := newRuntime(runtimeName, "")
+		if err != nil {
+			return err
+		}
+		if err := runtime.Check(); err != nil {
 			return err
 		}
-		fmt.Println("✅ Docker daemon is running")
+		log.Info("Container runtime ready", logging.Icon("✅"), "runtime", runtime.Name())
 	} else {
-		fmt.Println("⚠️  Skipping Docker checks (--skip-docker enabled)")
+		log.Warn("Skipping container runtime checks (--skip-docker enabled)")
 	}
 
 	// Step 2: Detect GPU
-	fmt.Println()
 	var gpuInfo *gpu.GPUInfo
 
 	if skipDocker {
-		// 测试模式：允许使用 mock GPU
-		fmt.Println("🎮 Using mock GPU configuration (--skip-docker mode)...")
-		gpuInfo = &gpu.GPUInfo{
-			Type:  "Mock GPU (Testing)",
-			Count: 1,
-			VRam:  8,
+		// 测试模式：允许使用 simulated GPU
+		log.Info("Using simulated GPU configuration (--skip-docker mode)...", logging.Icon("🎮"))
+		gpus, err := newGPUProvider("simulated", gpuInventory)
+		if err != nil {
+			return err
+		}
+		if gpuInfo, err = gpus.Detect(); err != nil {
+			return err
 		}
-		fmt.Printf("   GPU: %s (%d GPU, %d GB VRAM)\n", gpuInfo.Type, gpuInfo.Count, gpuInfo.VRam)
 	} else {
 		// 生产模式：必须有真实的 NVIDIA GPU
-		fmt.Println("🎮 Detecting GPU configuration...")
+		log.Info("Detecting GPU configuration...", logging.Icon("🎮"))
 		var err error
 		gpuInfo, err = gpu.Detect()
 		if err != nil {
+			// Provisioning scripts need a non-zero exit code
+			if !interactive {
+				return fmt.Errorf("no NVIDIA GPU detected: %w", err)
+			}
+
 			// GPU detection failed - 优雅退出
-			fmt.Println()
-			fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
-			fmt.Println("  ⚠️  No NVIDIA GPU Detected")
-			fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
-			fmt.Println()
-			fmt.Println("RiOS Worker requires an NVIDIA GPU to process tasks.")
-			fmt.Println()
-			fmt.Println("📋 Requirements:")
-			fmt.Println("   • NVIDIA GPU (RTX 3060 or higher recommended)")
-			fmt.Println("   • NVIDIA drivers installed")
-			fmt.Println("   • nvidia-smi command available")
-			fmt.Println()
-			fmt.Println("💡 Solutions:")
-			fmt.Println("   1. Install NVIDIA drivers: https://www.nvidia.com/drivers")
-			fmt.Println("   2. Switch to a machine with NVIDIA GPU")
-			fmt.Println("   3. For testing API only: use --skip-docker flag")
-			fmt.Println()
-			fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
-			fmt.Println("  Registration cancelled.")
-			fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
-			fmt.Println()
+			log.Error("No NVIDIA GPU detected: RiOS Worker requires an NVIDIA GPU (RTX 3060 or higher recommended), "+
+				"the NVIDIA driver (https://www.nvidia.com/drivers) and nvidia-smi. To test the API only, use --skip-docker", "error", err)
+			log.Info("Registration cancelled")
 			os.Exit(0) // 优雅退出，不显示错误
 		}
-
-		fmt.Printf("✅ Detected GPU: %s\n", gpuInfo.Type)
-		fmt.Printf("   Count: %d\n", gpuInfo.Count)
-		fmt.Printf("   VRAM: %d GB\n", gpuInfo.VRam)
 	}
+	log.Info("GPU detected", logging.Icon("✅"), "gpu", gpuInfo.String())
 
 	// Step 3: Get wallet address
-	fmt.Println()
-	fmt.Print("💰 Enter your $ROS wallet address (BSC): ")
-	reader := bufio.NewReader(os.Stdin)
-	walletAddress, err := reader.ReadString('\n')
-	if err != nil {
-		return fmt.Errorf("failed to read wallet address: %w", err)
+	wallet := strings.TrimSpace(walletAddress)
+	if wallet == "" {
+		if !interactive {
+			return fmt.Errorf("--wallet is required when running non-interactively")
+		}
+		fmt.Print("💰 Enter your $ROS wallet address (BSC): ")
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return fmt.Errorf("failed to read wallet address: %w", err)
+		}
+		wallet = strings.TrimSpace(line)
 	}
-	walletAddress = strings.TrimSpace(walletAddress)
 
-	if len(walletAddress) != 42 || !strings.HasPrefix(walletAddress, "0x") {
-		return fmt.Errorf("invalid wallet address format. Must be a valid BSC address (0x...)")
+	if err := validateWalletAddress(wallet); err != nil {
+		return err
 	}
 
 	// Step 4: Optional contributor name
-	fmt.Print("📝 Enter a name for your worker (optional, press Enter to skip): ")
-	contributorName, _ := reader.ReadString('\n')
-	contributorName = strings.TrimSpace(contributorName)
+	contributorName := strings.TrimSpace(workerName)
+	if contributorName == "" && interactive && !cmd.Flags().Changed("name") {
+		fmt.Print("📝 Enter a name for your worker (optional, press Enter to skip): ")
+		line, _ := reader.ReadString('\n')
+		contributorName = strings.TrimSpace(line)
+	}
 
 	// Step 5: Register with API
-	fmt.Println()
-	fmt.Println("📡 Registering with RiOS Orchestrator...")
-	fmt.Printf("   API Endpoint: %s\n", apiEndpoint)
+	attrs := []any{logging.Icon("📡"), "api", apiEndpoint}
+	if provisionToken != "" {
+		attrs = append(attrs, "provisioning_token", true)
+	}
+	log.Info("Registering with RiOS Orchestrator...", attrs...)
+
+	client := newClient(apiEndpoint)
 
-	client := api.NewClient(apiEndpoint)
+	// The orchestrator checks job receipts against this node's key
+	keyPath, err := receipt.DefaultKeyPath()
+	if err != nil {
+		return err
+	}
+	nodeKey, err := receipt.LoadKey(keyPath)
+	if err != nil {
+		return err
+	}
 
 	req := &api.RegisterRequest{
 		GPUType:          gpuInfo.Type,
 		GPUVram:          gpuInfo.VRam,
 		GPUCount:         gpuInfo.Count,
-		RosWalletAddress: walletAddress,
+		RosWalletAddress: wallet,
 		ContributorName:  contributorName,
+		ProvisionToken:   provisionToken,
+		PublicKey:        receipt.PublicKey(nodeKey),
 	}
 
 	resp, err := client.Register(req)
@@ -145,33 +227,59 @@ func runRegister(cmd *cobra.Command, args []string) error {
 	}
 
 	// Step 6: Save configuration
-	fmt.Println()
-	fmt.Println("💾 Saving configuration...")
-
-	cfg := &config.Config{
-		NodeID:        resp.NodeID,
-		NodeAuthToken: resp.NodeAuthToken,
-		APIEndpoint:   apiEndpoint,
-		WalletAddress: walletAddress,
-	}
+	cfg.NodeID = resp.NodeID
+	cfg.NodeAuthToken = resp.NodeAuthToken
+	cfg.APIEndpoint = apiEndpoint
+	cfg.WalletAddress = wallet
+	cfg.GPUType = gpuInfo.Type
+	cfg.GPUVram = gpuInfo.VRam
+	cfg.GPUCount = gpuInfo.Count
 
 	if err := config.Save(cfg); err != nil {
 		return fmt.Errorf("failed to save configuration: %w", err)
 	}
 
 	configPath, _ := config.GetConfigPath()
-	fmt.Printf("✅ Configuration saved to: %s\n", configPath)
+	log.Info("Configuration saved", logging.Icon("💾"), "path", configPath)
+
+	if jsonOutput {
+		result := registerResult{
+			NodeID:        resp.NodeID,
+			Status:        resp.Status,
+			WalletAddress: wallet,
+			Name:          contributorName,
+			GPUType:       gpuInfo.Type,
+			GPUCount:      gpuInfo.Count,
+			GPUVram:       gpuInfo.VRam,
+			APIEndpoint:   apiEndpoint,
+			ConfigPath:    configPath,
+		}
+		return printJSON(result)
+	}
 
 	// Success!
-	fmt.Println()
-	fmt.Println("🎉 Registration Successful!")
-	fmt.Println("============================")
-	fmt.Printf("Node ID: %d\n", resp.NodeID)
-	fmt.Printf("Wallet: %s\n", walletAddress)
-	fmt.Println()
-	fmt.Println("🚀 Next Steps:")
-	fmt.Println("   Run 'rios-worker run' to start contributing and earning $ROS!")
-	fmt.Println()
+	attrs = []any{logging.Icon("🎉"), logging.KeyNodeID, resp.NodeID, "wallet", wallet}
+	if resp.Status != "" {
+		attrs = append(attrs, "status", resp.Status)
+	}
+	log.Info("Registration successful", attrs...)
+	log.Info("Run 'rios-worker run' to start contributing and earning $ROS!", logging.Icon("🚀"))
 
 	return nil
 }
+
+// validateWalletAddress checks that the address looks like a BSC address
+func validateWalletAddress(address string) error {
+	if len(address) != 42 || !strings.HasPrefix(address, "0x") {
+		return fmt.Errorf("invalid wallet address format. Must be a valid BSC address (0x...)")
+	}
+	return nil
+}
+
+// confirm asks a yes/no question and returns true only for an explicit yes
+func confirm(reader *bufio.Reader, prompt string) bool {
+	fmt.Print(prompt)
+	answer, _ := reader.ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
@@ -1,11 +1,25 @@
 package cmd
 
 import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/rios/worker/pkg/api"
+	"github.com/rios/worker/pkg/config"
+	"github.com/rios/worker/pkg/container"
+	"github.com/rios/worker/pkg/gpu"
+	"github.com/rios/worker/pkg/logging"
 	"github.com/spf13/cobra"
 )
 
 var (
 	apiEndpoint string
+	logFormat   string
+	logLevel    string
 )
 
 // rootCmd represents the base command
@@ -14,6 +28,9 @@ var rootCmd = &cobra.Command{
 	Short: "RiOS Worker CLI - Contribute GPU power to the RiOS network",
 	Long: `RiOS Worker CLI allows you to contribute your GPU computing power
 to the RiOS decentralized network and earn $ROS tokens as rewards.`,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		return setupLogging(cmd.Root())
+	},
 	Run: func(cmd *cobra.Command, args []string) {
 		PrintBanner()
 		cmd.Help()
@@ -27,5 +44,121 @@ func Execute() error {
 
 func init() {
 	rootCmd.PersistentFlags().StringVar(&apiEndpoint, "api", "http://localhost:3000", "RiOS API endpoint")
+	rootCmd.PersistentFlags().StringVar(&logFormat, "log-format", logging.FormatPretty, "Log format: pretty, text, json, or journald for a system service (pretty lines with their syslog priority)")
+	rootCmd.PersistentFlags().StringVar(&logLevel, "log-level", "info", "Minimum log level: debug, info, warn or error")
+}
+
+// setupLogging makes the logger selected by --log-format and --log-level
+// the default one
+func setupLogging(root *cobra.Command) error {
+	logger, err := newLogger(os.Stdout)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(logger)
+
+	if logFormat == logging.FormatJournald {
+		root.SetErr(logging.JournalWriter(os.Stderr, slog.LevelError))
+		// A usage dump is noise in the journal
+		root.SilenceUsage = true
+	}
+	return nil
+}
+
+// newLogger returns a logger writing to w in the --log-format and
+// --log-level of the command line
+func newLogger(w io.Writer) (*slog.Logger, error) {
+	level, err := logging.ParseLevel(logLevel)
+	if err != nil {
+		return nil, err
+	}
+	return logging.New(w, logFormat, level)
+}
+
+// newConfiguredClient loads the saved configuration and returns an
+// authenticated API client for it
+func newConfiguredClient() (*config.Config, *api.Client, error) {
+	cfg, err := config.Load()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	endpoint := apiEndpoint
+	if cfg.APIEndpoint != "" && !rootCmd.PersistentFlags().Changed("api") {
+		endpoint = cfg.APIEndpoint
+	}
+
+	client := newClient(endpoint)
+	client.SetAuthToken(cfg.NodeAuthToken)
+	return cfg, client, nil
 }
 
+// newClient returns an API client that tells the orchestrator this
+// worker's version
+func newClient(endpoint string) *api.Client {
+	client := api.NewClient(endpoint)
+	client.WorkerVersion = Version
+	return client
+}
+
+// newRuntime returns the container runtime selected by a --runtime flag;
+// script is the --fake-script of the fake runtime
+func newRuntime(name, script string) (container.Runtime, error) {
+	switch name {
+	case "auto", "docker", "podman", "nerdctl":
+		return container.New(name)
+	case "fake":
+		if script == "" {
+			return container.NewFake(nil), nil
+		}
+		s, err := container.LoadFakeScript(script)
+		if err != nil {
+			return nil, err
+		}
+		return container.NewFake(s), nil
+	}
+	return nil, fmt.Errorf("invalid --runtime %q: must be auto, docker, podman, nerdctl or fake", name)
+}
+
+// newGPUProvider returns the GPUs selected by a --gpu flag; inventory is
+// the --gpu-inventory of simulated GPUs
+func newGPUProvider(kind, inventory string) (gpu.Provider, error) {
+	switch kind {
+	case "nvidia":
+		return gpu.NVIDIA{}, nil
+	case "simulated":
+		if inventory == "" {
+			return gpu.DefaultSimulated(), nil
+		}
+		return gpu.LoadSimulated(inventory)
+	}
+	return nil, fmt.Errorf("invalid --gpu %q: must be nvidia or simulated", kind)
+}
+
+// executablePath returns the path of the running rios-worker binary with
+// symlinks resolved
+func executablePath() (string, error) {
+	binary, err := os.Executable()
+	if err != nil {
+		return "", fmt.Errorf("failed to locate rios-worker: %w", err)
+	}
+	if binary, err = filepath.EvalSymlinks(binary); err != nil {
+		return "", fmt.Errorf("failed to locate rios-worker: %w", err)
+	}
+	return binary, nil
+}
+
+// validateOutputFormat checks the value of an --output flag
+func validateOutputFormat(format string) error {
+	if format != "text" && format != "json" {
+		return fmt.Errorf("invalid --output %q: must be text or json", format)
+	}
+	return nil
+}
+
+// printJSON writes v to stdout as indented JSON
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
@@ -1,17 +1,28 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
+	"log/slog"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strings"
 	"syscall"
 	"time"
 
-	"github.com/rios/worker/pkg/api"
+	"github.com/rios/worker/pkg/admin"
+	"github.com/rios/worker/pkg/cache"
 	"github.com/rios/worker/pkg/config"
-	"github.com/rios/worker/pkg/docker"
+	"github.com/rios/worker/pkg/container"
+	"github.com/rios/worker/pkg/dashboard"
 	"github.com/rios/worker/pkg/gpu"
+	"github.com/rios/worker/pkg/history"
+	"github.com/rios/worker/pkg/logging"
+	"github.com/rios/worker/pkg/metrics"
+	"github.com/rios/worker/pkg/receipt"
+	"github.com/rios/worker/pkg/runner"
+	"github.com/rios/worker/pkg/schedule"
 	"github.com/rios/worker/pkg/worker"
 	"github.com/spf13/cobra"
 )
@@ -25,19 +36,38 @@ The worker will continuously fetch and execute jobs, earning $ROS tokens as rewa
 	RunE: runWorker,
 }
 
+var (
+	adminAddr         string
+	telemetryInterval time.Duration
+	runtimeName       string
+	fakeScript        string
+	gpuKind           string
+	gpuInventory      string
+	showDashboard     bool
+)
+
 func init() {
 	rootCmd.AddCommand(runCmd)
+	runCmd.Flags().DurationVar(&telemetryInterval, "telemetry-interval", 2*time.Second, "How often to sample GPU telemetry")
+	runCmd.Flags().StringVar(&adminAddr, "admin-addr", "", "Serve the admin API and Prometheus metrics on this address (e.g. 127.0.0.1:9465 or unix:/run/rios/admin.sock)")
+	runCmd.Flags().StringVar(&runtimeName, "runtime", "auto", "Container runtime: auto, docker, podman, nerdctl, or fake to run jobs from a script (for testing)")
+	runCmd.Flags().StringVar(&fakeScript, "fake-script", "", "JSON script of what fake containers do (default: one valid output per task type)")
+	runCmd.Flags().StringVar(&gpuKind, "gpu", "nvidia", "GPU provider: nvidia, or simulated (for testing)")
+	runCmd.Flags().StringVar(&gpuInventory, "gpu-inventory", "", "JSON inventory of simulated GPUs (default: one 8 GB mock GPU)")
+	runCmd.Flags().BoolVar(&showDashboard, "tui", false, "Show a full-screen dashboard with the log below it (falls back to the log when not on a terminal)")
 }
 
 func runWorker(cmd *cobra.Command, args []string) error {
+	if showDashboard && logFormat != logging.FormatPretty {
+		return fmt.Errorf("--tui can't be combined with --log-format %s", logFormat)
+	}
+
 	PrintBanner()
-	
-	fmt.Println("🚀 Worker Starting")
-	fmt.Println("========================")
-	fmt.Println()
+
+	log := slog.Default()
+	log.Info("Worker starting", logging.Icon("🚀"), "version", Version)
 
 	// Load configuration
-	fmt.Println("📋 Loading configuration...")
 	cfg, err := config.Load()
 	if err != nil {
 		return fmt.Errorf("failed to load config: %w. Please run 'rios-worker register' first", err)
@@ -48,173 +78,241 @@ func runWorker(cmd *cobra.Command, args []string) error {
 		apiEndpoint = cfg.APIEndpoint
 	}
 
-	fmt.Printf("✅ Configuration loaded\n")
-	fmt.Printf("   Node ID: %d\n", cfg.NodeID)
-	fmt.Printf("   API Endpoint: %s\n", apiEndpoint)
-	fmt.Printf("   Wallet: %s\n", cfg.WalletAddress)
-	fmt.Println()
+	log.Info("Configuration loaded", logging.Icon("✅"),
+		logging.KeyNodeID, cfg.NodeID, "api", apiEndpoint, "wallet", cfg.WalletAddress)
+	log = log.With(logging.KeyNodeID, cfg.NodeID)
 
-	// Check Docker
-	fmt.Println("📦 Checking Docker...")
-	if err := docker.CheckDockerInstalled(); err != nil {
+	runtime, err := newRuntime(runtimeName, fakeScript)
+	if err != nil {
 		return err
 	}
-	if err := docker.CheckDockerRunning(); err != nil {
+	if gpuInventory != "" && !cmd.Flags().Changed("gpu") {
+		gpuKind = "simulated"
+	}
+	gpus, err := newGPUProvider(gpuKind, gpuInventory)
+	if err != nil {
 		return err
 	}
-	fmt.Println("✅ Docker is ready")
-	fmt.Println()
+
+	// Check the container runtime
+	if err := runtime.Check(); err != nil {
+		return err
+	}
+	log.Info("Container runtime ready", logging.Icon("✅"), "runtime", runtime.Name())
+	if _, ok := runtime.(*container.Fake); ok {
+		log.Warn("Fake runtime: no containers are run, outputs come from the script")
+	}
 
 	// Check GPU (required for production)
-	fmt.Println("🎮 Verifying GPU configuration...")
-	_, err = gpu.Detect()
+	gpuInfo, err := gpus.Detect()
 	if err != nil {
-		fmt.Println()
-		fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
-		fmt.Println("  ⚠️  No NVIDIA GPU Detected")
-		fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
-		fmt.Println()
-		fmt.Println("This machine was registered but cannot run without a GPU.")
-		fmt.Println()
-		fmt.Println("📋 Requirements:")
-		fmt.Println("   • NVIDIA GPU (RTX 3060 or higher recommended)")
-		fmt.Println("   • NVIDIA drivers installed")
-		fmt.Println("   • nvidia-smi command available")
-		fmt.Println()
-		fmt.Println("💡 Please switch to a machine with NVIDIA GPU hardware.")
-		fmt.Println()
-		fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
-		fmt.Println("  Worker stopped.")
-		fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
-		fmt.Println()
+		log.Error("No NVIDIA GPU detected: this machine was registered but cannot run without one. "+
+			"It needs an NVIDIA GPU (RTX 3060 or higher recommended), the NVIDIA driver and nvidia-smi", "error", err)
+		log.Info("Worker stopped", logging.Icon("⏹️ "))
 		os.Exit(0) // 优雅退出，不显示错误
 	}
-	fmt.Println("✅ GPU verified")
-	fmt.Println()
+	log.Info("GPU verified", logging.Icon("✅"), "gpu", gpuInfo.String())
+	if _, ok := gpus.(*gpu.Simulated); ok {
+		log.Warn("Simulated GPUs: for testing only")
+	}
 
 	// Create API client
-	client := api.NewClient(apiEndpoint)
+	client := newClient(apiEndpoint)
 	client.SetAuthToken(cfg.NodeAuthToken)
 
+	// Agree on the protocol before talking to the orchestrator
+	handshake, err := runner.NegotiateProtocol(log, client)
+	if err != nil {
+		return err
+	}
+
+	// Make sure the orchestrator's view of our hardware is current
+	if err := runner.SyncHardware(log, client, cfg, gpuInfo); err != nil {
+		return err
+	}
+
 	// Create work directory
-	homeDir, _ := os.UserHomeDir()
-	workDir := filepath.Join(homeDir, ".rios", "work")
+	dataDir, err := config.DataDir()
+	if err != nil {
+		return err
+	}
+	workDir := filepath.Join(dataDir, "work")
 	if err := os.MkdirAll(workDir, 0755); err != nil {
 		return fmt.Errorf("failed to create work directory: %w", err)
 	}
 
 	// Create executor
 	executor := worker.NewExecutor(workDir)
+	executor.Runtime = runtime
+	executor.MinFreeDiskBytes = int64(cfg.Policy.MinFreeDiskGB * (1 << 30))
+	executor.FreeVRAM = gpus.FreeMemory
+	executor.MaxDownloadBytes = int64(cfg.Policy.MaxInputSizeGB * (1 << 30))
+	executor.ParallelDownloads = cfg.Downloads.Parallel
+	executor.DownloadSegments = cfg.Downloads.Segments
+	executor.MaxDownloadRate = int64(cfg.Downloads.MaxMbps * 1e6 / 8)
 
-	// Setup signal handling for graceful shutdown
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	// Share downloaded inputs between jobs
+	if !cfg.Cache.Disabled {
+		cacheDir := cfg.Cache.Dir
+		if cacheDir == "" {
+			if cacheDir, err = cache.DefaultDir(); err != nil {
+				return err
+			}
+		}
+		inputCache, err := cache.Open(cacheDir, int64(cfg.Cache.MaxSizeGB*(1<<30)))
+		if err != nil {
+			return err
+		}
+		executor.Cache = inputCache
+	}
 
-	// Start worker loop
-	fmt.Println("💪 Worker is now online and ready to process jobs!")
-	fmt.Println("   Press Ctrl+C to stop")
-	fmt.Println()
-
-	ticker := time.NewTicker(10 * time.Second)
-	defer ticker.Stop()
-
-	isProcessing := false
-	totalJobsCompleted := 0
-	totalRewardsEarned := 0.0
-
-	for {
-		select {
-		case <-sigChan:
-			fmt.Println()
-			fmt.Println("⏹️  Shutting down gracefully...")
-			
-			// Send offline heartbeat
-			if err := client.Heartbeat("offline"); err != nil {
-				fmt.Printf("⚠️  Warning: Failed to send offline heartbeat: %v\n", err)
+	// Open local job history
+	store, err := history.OpenDefault()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	if err := store.FailRunning("interrupted: worker stopped while the job was running"); err != nil {
+		log.Warn("Failed to clean up the job history", "error", err)
+	}
+
+	// Sign a receipt for every job with the node key
+	keyPath, err := receipt.DefaultKeyPath()
+	if err != nil {
+		return err
+	}
+	nodeKey, err := receipt.LoadKey(keyPath)
+	if err != nil {
+		return err
+	}
+	receiptDir, err := receipt.DefaultDir()
+	if err != nil {
+		return err
+	}
+
+	// Sample GPU telemetry for heartbeats, metrics and job results
+	samplerCtx, stopSampler := context.WithCancel(context.Background())
+	defer stopSampler()
+	sampler := gpu.NewSampler(gpus.Telemetry(telemetryInterval), telemetryInterval)
+	go sampler.Run(samplerCtx)
+
+	// Shared state between the job loop and the admin API
+	state := worker.NewState()
+	executor.OnPhase = state.SetPhase
+	m := metrics.New(sampler.Latest)
+
+	// From here on the dashboard shows the log below the worker's state
+	stopDashboard := func() {}
+	if showDashboard {
+		if !dashboard.Supported(os.Stdout) {
+			log.Warn("The dashboard needs a terminal, showing the log instead")
+		} else {
+			dash := dashboard.New(os.Stdout, dashboard.Info{
+				NodeID:  cfg.NodeID,
+				Version: Version,
+				API:     apiEndpoint,
+				GPU:     gpuInfo.String(),
+				Runtime: runtime.Name(),
+			}, state, sampler.Latest, store)
+			logger, err := newLogger(dash)
+			if err != nil {
+				return err
+			}
+			slog.SetDefault(logger)
+			log = logger.With(logging.KeyNodeID, cfg.NodeID)
+
+			dash.Start()
+			defer dash.Stop()
+			stopDashboard = dash.Stop
+		}
+	}
+
+	r := &runner.Runner{
+		Log:      log,
+		Client:   client,
+		Executor: executor,
+		Store:    store,
+		State:    state,
+		Metrics:  m,
+		Sampler:  sampler,
+		Runtime:  runtime,
+
+		Bandwidth: cfg.Bandwidth,
+		Version:   Version,
+
+		NodeID:     cfg.NodeID,
+		GPU:        *gpuInfo,
+		NodeKey:    nodeKey,
+		ReceiptDir: receiptDir,
+
+		Handshake: handshake,
+	}
+
+	// Protect the hardware from overheating
+	if !cfg.Governor.Disabled {
+		if cfg.Governor.PowerLimitW > 0 {
+			if err := gpus.SetPowerLimit(cfg.Governor.PowerLimitW); err != nil {
+				log.Warn("Failed to apply power limit", "error", err)
+			} else {
+				log.Info("Power limit set", logging.Icon("⚡"), "watts", cfg.Governor.PowerLimitW)
 			}
-			
-			fmt.Println("👋 Worker stopped")
-			fmt.Printf("📊 Session Summary:\n")
-			fmt.Printf("   Jobs Completed: %d\n", totalJobsCompleted)
-			fmt.Printf("   Total Rewards: %.8f $ROS\n", totalRewardsEarned)
-			return nil
-
-		case <-ticker.C:
-			if !isProcessing {
-				// Send heartbeat
-				if err := client.Heartbeat("online"); err != nil {
-					fmt.Printf("⚠️  Heartbeat failed: %v\n", err)
-					continue
-				}
-
-				// Try to get a job
-				job, err := client.GetJob()
-				if err != nil {
-					fmt.Printf("⚠️  Failed to get job: %v\n", err)
-					continue
-				}
-
-				if job == nil {
-					fmt.Printf("⏳ [%s] No jobs available, waiting...\n", time.Now().Format("15:04:05"))
-					continue
-				}
-
-				// Process job
-				isProcessing = true
-				go func() {
-					defer func() {
-						isProcessing = false
-					}()
-
-					fmt.Println()
-					fmt.Printf("🎯 New Job Received!\n")
-					fmt.Printf("   Job ID: %s\n", job.JobID)
-					fmt.Printf("   Type: %s\n", job.TaskType)
-					fmt.Printf("   Docker Image: %s\n", job.Payload.DockerImage)
-					fmt.Println()
-
-					// Send busy heartbeat
-					if err := client.Heartbeat("busy"); err != nil {
-						fmt.Printf("⚠️  Warning: Failed to send busy heartbeat: %v\n", err)
-					}
-
-					// Execute job
-					outputURL, err := executor.Execute(job)
-					
-					// Submit result
-					req := &api.SubmitResultRequest{
-						JobID: job.JobID,
-					}
-
-					if err != nil {
-						fmt.Printf("❌ Job failed: %v\n", err)
-						req.Status = "failed"
-						req.ErrorMessage = err.Error()
-					} else {
-						fmt.Println("✅ Job completed successfully!")
-						req.Status = "completed"
-						req.OutputS3URL = outputURL
-					}
-
-					resp, submitErr := client.SubmitResult(req)
-					if submitErr != nil {
-						fmt.Printf("⚠️  Failed to submit result: %v\n", submitErr)
-						return
-					}
-
-					if resp.Success {
-						totalJobsCompleted++
-						totalRewardsEarned += resp.RewardPaid
-						
-						fmt.Println()
-						fmt.Printf("💰 Reward earned: %.8f $ROS\n", resp.RewardPaid)
-						fmt.Printf("📊 Total earned this session: %.8f $ROS\n", totalRewardsEarned)
-						fmt.Printf("✅ Total jobs completed: %d\n", totalJobsCompleted)
-						fmt.Println()
-					}
-				}()
+		}
+		limits := cfg.Governor.WithDefaults()
+		log.Info("Thermal governor enabled", logging.Icon("🌡️ "),
+			"pause_intake_c", limits.MaxTemperatureC, "suspend_jobs_c", limits.CriticalTemperatureC)
+		governor := gpu.NewGovernor(cfg.Governor)
+		go governor.Run(samplerCtx, sampler, telemetryInterval, r.HandleGovernorEvent)
+	}
+
+	if adminAddr != "" {
+		if !admin.IsLoopback(adminAddr) {
+			log.Warn("Admin API is reachable from other machines and has no authentication", "addr", adminAddr)
+		}
+		adminServer := admin.NewServer(admin.Info{NodeID: cfg.NodeID, Version: Version}, state, m)
+		if err := adminServer.Listen(adminAddr); err != nil {
+			return err
+		}
+		go func() {
+			if err := adminServer.Serve(); err != nil {
+				log.Warn("Admin API stopped", "error", err)
 			}
+		}()
+		defer adminServer.Shutdown()
+		log.Info("Admin API listening (metrics at /metrics)", logging.Icon("🔧"), "addr", adminAddr)
+	}
+
+	// Restrict which jobs are taken
+	r.Policy = worker.NewPolicy(cfg.Policy, gpuInfo.VRam)
+
+	// Restrict when jobs are taken
+	r.Schedule, err = schedule.New(cfg.Schedule)
+	if err != nil {
+		return err
+	}
+	if len(cfg.Schedule.Windows) > 0 {
+		log.Info("Availability schedule", logging.Icon("🗓️ "),
+			"windows", strings.Join(cfg.Schedule.Windows, ", "), "location", r.Schedule.Location().String())
+	}
+	if cfg.Schedule.IdleOnly {
+		idleMinutes := cfg.Schedule.IdleMinutes
+		if idleMinutes == 0 {
+			idleMinutes = 5
 		}
+		r.Idle = schedule.NewIdleDetector(gpus.Processes, sampler, time.Duration(idleMinutes)*time.Minute)
+		log.Info("Idle-only mode: jobs are taken once the GPU is unused", logging.Icon("😴"), "idle_minutes", idleMinutes)
 	}
-}
 
+	// Stop gracefully on Ctrl+C and SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Start worker loop
+	log.Info("Worker is online and ready to process jobs, press Ctrl+C to stop", logging.Icon("💪"))
+	r.Run(ctx, 10*time.Second)
+
+	// The session summary stays on the terminal
+	stopDashboard()
+	r.Shutdown()
+	return nil
+}
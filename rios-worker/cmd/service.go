@@ -0,0 +1,264 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+
+	"github.com/rios/worker/pkg/config"
+	"github.com/rios/worker/pkg/container"
+	"github.com/rios/worker/pkg/service"
+	"github.com/spf13/cobra"
+)
+
+var (
+	serviceUser    string
+	serviceNoStart bool
+	servicePurge   bool
+	serviceOutput  string
+)
+
+// serviceCmd represents the service command
+var serviceCmd = &cobra.Command{
+	Use:   "service",
+	Short: "Run the worker as a systemd service",
+	Long: `Install, remove and inspect the rios-worker systemd service. The service
+runs 'rios-worker run' as a dedicated user, restarts it when it fails and logs
+to the journal. Its configuration and node key are kept in /etc/rios and its
+job history, receipts, cache and work files in /var/lib/rios.`,
+}
+
+var serviceInstallCmd = &cobra.Command{
+	Use:   "install [-- run flags]",
+	Short: "Install, enable and start the service",
+	Long: `Create the service user and directories, write the systemd unit and
+start the service. A registration made with 'rios-worker register' by the user
+running sudo is copied into /etc/rios. Flags after -- are passed to
+'rios-worker run', e.g.:
+
+  sudo rios-worker service install -- --admin-addr 127.0.0.1:9465`,
+	RunE: runServiceInstall,
+}
+
+var serviceUninstallCmd = &cobra.Command{
+	Use:   "uninstall",
+	Short: "Stop and remove the service",
+	Long: `Stop and disable the service and remove its unit. /etc/rios and
+/var/lib/rios are kept unless --purge is given, which also deletes the node
+key and with it the node's identity.`,
+	Args: cobra.NoArgs,
+	RunE: runServiceUninstall,
+}
+
+var serviceStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show whether the service is installed and running",
+	Args:  cobra.NoArgs,
+	RunE:  runServiceStatus,
+}
+
+func init() {
+	rootCmd.AddCommand(serviceCmd)
+	serviceCmd.AddCommand(serviceInstallCmd, serviceUninstallCmd, serviceStatusCmd)
+	serviceInstallCmd.Flags().StringVar(&serviceUser, "user", service.User, "User the service runs as (created if missing)")
+	serviceInstallCmd.Flags().StringVar(&runtimeName, "runtime", "auto", "Container runtime of the service: auto, docker, podman or nerdctl")
+	serviceInstallCmd.Flags().BoolVar(&serviceNoStart, "no-start", false, "Enable the service without starting it")
+	serviceUninstallCmd.Flags().BoolVar(&servicePurge, "purge", false, "Also delete /etc/rios, /var/lib/rios and the service user")
+	serviceStatusCmd.Flags().StringVarP(&serviceOutput, "output", "o", "text", "Output format: text or json")
+}
+
+// checkServiceHost makes sure the command can manage system services
+func checkServiceHost() error {
+	if !service.HasSystemd() {
+		return fmt.Errorf("this machine doesn't run systemd")
+	}
+	if os.Geteuid() != 0 {
+		return fmt.Errorf("managing the service needs root, run it with sudo")
+	}
+	return nil
+}
+
+func runServiceInstall(cmd *cobra.Command, args []string) error {
+	if err := checkServiceHost(); err != nil {
+		return err
+	}
+
+	binary, err := executablePath()
+	if err != nil {
+		return err
+	}
+
+	runtime, err := container.New(runtimeName)
+	if err != nil {
+		return err
+	}
+	// The runtime detected now is pinned, so the service can't switch to
+	// another one that the unit isn't set up for
+	unit := &service.Unit{Binary: binary, User: serviceUser,
+		RunArgs: append([]string{"--runtime", runtime.Name()}, args...)}
+	switch runtime.Name() {
+	case "docker":
+		unit.Groups = service.ExistingGroups("docker")
+		unit.After = []string{"docker.service"}
+	case "nerdctl":
+		unit.After = []string{"containerd.service"}
+		if serviceUser != "root" {
+			fmt.Println("⚠️  containerd's socket belongs to root; use --user root unless containerd runs rootless for this user")
+		}
+	case "podman":
+		fmt.Printf("⚠️  Podman runs rootless as %s and needs subordinate IDs for it in /etc/subuid and /etc/subgid\n", serviceUser)
+	}
+
+	// User
+	if serviceUser != "root" {
+		created, err := service.EnsureUser(serviceUser, unit.Groups)
+		if err != nil {
+			return err
+		}
+		if created {
+			fmt.Printf("✅ Created user %s\n", serviceUser)
+		}
+	}
+	uid, gid, err := service.Owner(serviceUser)
+	if err != nil {
+		return err
+	}
+
+	// Directories
+	if err := service.EnsureDir(service.ConfigDir, 0700, uid, gid); err != nil {
+		return err
+	}
+	if err := service.EnsureDir(service.DataDir, 0750, uid, gid); err != nil {
+		return err
+	}
+	fmt.Printf("✅ Configuration in %s, data in %s\n", service.ConfigDir, service.DataDir)
+
+	// An existing registration of the user running sudo
+	if sudoUser := os.Getenv("SUDO_USER"); sudoUser != "" {
+		if u, err := user.Lookup(sudoUser); err == nil {
+			migrated, err := service.Migrate(filepath.Join(u.HomeDir, config.ConfigDir), uid, gid)
+			if err != nil {
+				return err
+			}
+			if migrated {
+				fmt.Printf("✅ Copied the registration of %s from %s\n", sudoUser, filepath.Join(u.HomeDir, config.ConfigDir))
+			}
+		}
+	}
+
+	// Unit
+	if err := os.WriteFile(service.UnitPath, []byte(unit.Render()), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", service.UnitPath, err)
+	}
+	if err := service.Systemctl("daemon-reload"); err != nil {
+		return err
+	}
+	if err := service.Systemctl("enable", service.Name); err != nil {
+		return err
+	}
+	fmt.Printf("✅ Installed %s\n", service.UnitPath)
+
+	_, err = os.Stat(filepath.Join(service.ConfigDir, config.ConfigFile))
+	registered := err == nil
+	if !registered {
+		fmt.Println()
+		fmt.Println("⚠️  The node isn't registered yet. Register it, then start the service:")
+		fmt.Printf("   sudo -u %s %s=%s %s=%s %s register\n", serviceUser,
+			config.ConfigDirEnv, service.ConfigDir, config.DataDirEnv, service.DataDir, binary)
+		fmt.Printf("   sudo systemctl start %s\n", service.Name)
+		return nil
+	}
+
+	if !serviceNoStart {
+		if err := service.Systemctl("restart", service.Name); err != nil {
+			return err
+		}
+		fmt.Println("✅ Service started")
+	}
+	fmt.Println()
+	fmt.Printf("📋 Logs:   journalctl -u %s -f\n", service.Name)
+	fmt.Println("📋 Status: rios-worker service status")
+	return nil
+}
+
+func runServiceUninstall(cmd *cobra.Command, args []string) error {
+	if err := checkServiceHost(); err != nil {
+		return err
+	}
+
+	serviceUser := service.InstalledUser()
+	if _, err := os.Stat(service.UnitPath); err == nil {
+		if err := service.Systemctl("disable", "--now", service.Name); err != nil {
+			return err
+		}
+		if err := os.Remove(service.UnitPath); err != nil {
+			return fmt.Errorf("failed to remove %s: %w", service.UnitPath, err)
+		}
+		if err := service.Systemctl("daemon-reload"); err != nil {
+			return err
+		}
+		fmt.Printf("✅ Removed %s\n", service.UnitPath)
+	} else {
+		fmt.Println("⚠️  The service isn't installed")
+	}
+
+	if !servicePurge {
+		fmt.Printf("   Kept %s and %s (remove them with --purge)\n", service.ConfigDir, service.DataDir)
+		return nil
+	}
+	for _, dir := range []string{service.ConfigDir, service.DataDir} {
+		if err := os.RemoveAll(dir); err != nil {
+			return fmt.Errorf("failed to remove %s: %w", dir, err)
+		}
+		fmt.Printf("✅ Removed %s\n", dir)
+	}
+	if err := service.RemoveUser(serviceUser); err != nil {
+		return err
+	}
+	if serviceUser != "" && serviceUser != "root" {
+		fmt.Printf("✅ Removed user %s\n", serviceUser)
+	}
+	return nil
+}
+
+func runServiceStatus(cmd *cobra.Command, args []string) error {
+	if err := validateOutputFormat(serviceOutput); err != nil {
+		return err
+	}
+	if !service.HasSystemd() {
+		return fmt.Errorf("this machine doesn't run systemd")
+	}
+
+	status, err := service.GetStatus()
+	if err != nil {
+		return err
+	}
+	if serviceOutput == "json" {
+		return printJSON(status)
+	}
+
+	if !status.Installed {
+		fmt.Println("⚪ Service not installed (sudo rios-worker service install)")
+		return nil
+	}
+	icon := "🔴"
+	if status.Active == "active" {
+		icon = "🟢"
+	}
+	fmt.Printf("%s %s: %s (%s), %s\n", icon, service.Name, status.Active, status.SubState, status.Enabled)
+	if status.MainPID != 0 {
+		fmt.Printf("   PID:        %d\n", status.MainPID)
+	}
+	if status.Since != "" {
+		fmt.Printf("   Since:      %s\n", status.Since)
+	}
+	fmt.Printf("   Restarts:   %d\n", status.Restarts)
+	if status.Registered != nil {
+		fmt.Printf("   Registered: %t\n", *status.Registered)
+	}
+	fmt.Printf("   Config:     %s\n", status.ConfigDir)
+	fmt.Printf("   Data:       %s\n", status.DataDir)
+	fmt.Printf("   Logs:       journalctl -u %s -f\n", service.Name)
+	return nil
+}
@@ -0,0 +1,222 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/rios/worker/pkg/api"
+	"github.com/rios/worker/pkg/history"
+	"github.com/rios/worker/pkg/logging"
+	"github.com/spf13/cobra"
+)
+
+var (
+	statusLocal  bool
+	statusOutput string
+)
+
+// statusCmd represents the status command
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show node status, current job and hardware",
+	Long: `Show whether this node is online, the job it is currently running and
+the hardware it is registered with. The orchestrator is queried for the live
+state; the local job history is used when it cannot be reached.`,
+	RunE: runStatus,
+}
+
+func init() {
+	rootCmd.AddCommand(statusCmd)
+	statusCmd.Flags().BoolVar(&statusLocal, "local", false, "Only use the local job history, do not contact the orchestrator")
+	statusCmd.Flags().StringVarP(&statusOutput, "output", "o", "text", "Output format: text or json")
+}
+
+// statusReport is the machine-readable output of 'status --output json'
+type statusReport struct {
+	NodeID            int        `json:"node_id"`
+	APIEndpoint       string     `json:"api_endpoint"`
+	WalletAddress     string     `json:"wallet_address"`
+	Status            string     `json:"status"`
+	LastHeartbeat     string     `json:"last_heartbeat,omitempty"`
+	OrchestratorError string     `json:"orchestrator_error,omitempty"`
+	CurrentJob        *jobRow    `json:"current_job,omitempty"`
+	GPU               gpuSummary `json:"gpu"`
+	LifetimeJobs      int        `json:"lifetime_jobs"`
+	LifetimeRewards   float64    `json:"lifetime_rewards"`
+	Traffic           traffic    `json:"traffic"`
+}
+
+// traffic is the network usage of the current billing period
+type traffic struct {
+	PeriodStart string `json:"period_start"`
+	UsedBytes   int64  `json:"used_bytes"`
+	CapBytes    int64  `json:"cap_bytes,omitempty"`
+}
+
+type gpuSummary struct {
+	Type  string `json:"type"`
+	Count int    `json:"count"`
+	VRam  int    `json:"vram_gb"`
+}
+
+func runStatus(cmd *cobra.Command, args []string) error {
+	if err := validateOutputFormat(statusOutput); err != nil {
+		return err
+	}
+
+	cfg, client, err := newConfiguredClient()
+	if err != nil {
+		return err
+	}
+
+	store, err := history.OpenDefault()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	report := statusReport{
+		NodeID:        cfg.NodeID,
+		APIEndpoint:   client.BaseURL,
+		WalletAddress: cfg.WalletAddress,
+		Status:        "unknown",
+		GPU: gpuSummary{
+			Type:  cfg.GPUType,
+			Count: cfg.GPUCount,
+			VRam:  cfg.GPUVram,
+		},
+	}
+
+	// Local view first, the orchestrator's answer takes precedence below
+	current, err := store.CurrentJob()
+	if err != nil {
+		return err
+	}
+	if current != nil {
+		row := jobRowFromRecord(current)
+		report.CurrentJob = &row
+	}
+
+	report.LifetimeJobs, report.LifetimeRewards, err = store.TotalEarnings()
+	if err != nil {
+		return err
+	}
+
+	periodStart := cfg.Bandwidth.PeriodStart(time.Now())
+	report.Traffic.PeriodStart = periodStart.Format("2006-01-02")
+	report.Traffic.CapBytes = int64(cfg.Bandwidth.MonthlyCapGB * (1 << 30))
+	if report.Traffic.UsedBytes, err = store.TrafficSince(periodStart); err != nil {
+		return err
+	}
+
+	if !statusLocal {
+		remote, err := client.GetNodeStatus()
+		if err != nil {
+			report.OrchestratorError = err.Error()
+		} else {
+			report.Status = remote.Status
+			report.LastHeartbeat = remote.LastHeartbeat
+			if remote.GPUType != "" {
+				report.GPU = gpuSummary{
+					Type:  remote.GPUType,
+					Count: remote.GPUCount,
+					VRam:  remote.GPUVram,
+				}
+			}
+			if remote.CurrentJobID == "" {
+				report.CurrentJob = nil
+			} else if report.CurrentJob == nil || report.CurrentJob.JobID != remote.CurrentJobID {
+				report.CurrentJob = &jobRow{JobID: remote.CurrentJobID, Status: history.StatusRunning}
+			}
+		}
+	}
+
+	if statusOutput == "json" {
+		return printJSON(report)
+	}
+
+	fmt.Println("📡 Node Status")
+	fmt.Println("============================")
+	fmt.Printf("Node ID: %d\n", report.NodeID)
+	fmt.Printf("API Endpoint: %s\n", report.APIEndpoint)
+	fmt.Printf("Wallet: %s\n", report.WalletAddress)
+	fmt.Printf("Status: %s\n", report.Status)
+	if report.LastHeartbeat != "" {
+		fmt.Printf("Last Heartbeat: %s\n", report.LastHeartbeat)
+	}
+	if report.OrchestratorError != "" {
+		fmt.Printf("⚠️  Orchestrator unreachable: %s\n", report.OrchestratorError)
+	}
+	fmt.Println()
+
+	if report.GPU.Type != "" {
+		fmt.Printf("🎮 GPU: %d x %s (%d GB VRAM)\n", report.GPU.Count, report.GPU.Type, report.GPU.VRam)
+	} else {
+		fmt.Println("🎮 GPU: not recorded (run 'rios-worker run' to report hardware)")
+	}
+
+	if report.CurrentJob != nil {
+		fmt.Printf("🎯 Current Job: %s", report.CurrentJob.JobID)
+		if report.CurrentJob.TaskType != "" {
+			fmt.Printf(" (%s, running for %s)", report.CurrentJob.TaskType, formatDuration(report.CurrentJob.duration()))
+		}
+		fmt.Println()
+	} else {
+		fmt.Println("🎯 Current Job: none")
+	}
+
+	fmt.Printf("📊 Lifetime: %d jobs, %.8f $ROS\n", report.LifetimeJobs, report.LifetimeRewards)
+	fmt.Printf("📶 Traffic since %s: %s", report.Traffic.PeriodStart, formatBytes(report.Traffic.UsedBytes))
+	if report.Traffic.CapBytes > 0 {
+		fmt.Printf(" of %s monthly cap", formatBytes(report.Traffic.CapBytes))
+	}
+	fmt.Println()
+	fmt.Println()
+	return nil
+}
+
+// jobRow is a job as shown by the status and jobs commands
+type jobRow struct {
+	JobID           string       `json:"job_id"`
+	TaskType        string       `json:"task_type,omitempty"`
+	Status          string       `json:"status"`
+	StartedAt       string       `json:"started_at,omitempty"`
+	DurationSeconds float64      `json:"duration_seconds"`
+	Reward          float64      `json:"reward"`
+	RewardStatus    string       `json:"reward_status,omitempty"`
+	ErrorMessage    string       `json:"error_message,omitempty"`
+	Traffic         *api.Traffic `json:"traffic,omitempty"`
+}
+
+func jobRowFromRecord(rec *history.JobRecord) jobRow {
+	return jobRow{
+		JobID:           rec.JobID,
+		TaskType:        rec.TaskType,
+		Status:          rec.Status,
+		StartedAt:       rec.StartedAt.Format(time.RFC3339),
+		DurationSeconds: rec.Duration().Seconds(),
+		Reward:          rec.Reward,
+		RewardStatus:    rec.RewardStatus,
+		ErrorMessage:    rec.ErrorMessage,
+		Traffic: &api.Traffic{
+			DownloadBytes:    rec.DownloadBytes,
+			UploadBytes:      rec.UploadBytes,
+			ContainerRxBytes: rec.ContainerRxBytes,
+			ContainerTxBytes: rec.ContainerTxBytes,
+		},
+	}
+}
+
+func (r *jobRow) duration() time.Duration {
+	return time.Duration(r.DurationSeconds * float64(time.Second))
+}
+
+// formatBytes renders a byte count with a binary unit
+func formatBytes(n int64) string {
+	return logging.Bytes(n).String()
+}
+
+// formatDuration renders a duration rounded to the second
+func formatDuration(d time.Duration) string {
+	return d.Round(time.Second).String()
+}
@@ -0,0 +1,247 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/rios/worker/pkg/receipt"
+	"github.com/rios/worker/pkg/service"
+	"github.com/rios/worker/pkg/update"
+	"github.com/spf13/cobra"
+)
+
+var (
+	updateManifest  string
+	updatePublicKey string
+	updateDevKey    bool
+	updateCheck     bool
+	updateForce     bool
+	updateRollback  bool
+
+	signKey     string
+	signVersion string
+	signNotes   string
+	signBaseURL string
+)
+
+// updateCmd represents the update command
+var updateCmd = &cobra.Command{
+	Use:   "update",
+	Short: "Update rios-worker to the latest release",
+	Long: `Replace this binary with the latest release of rios-worker.
+
+The release manifest must be signed with the release key this binary was
+built with, and the downloaded binary must match the size and SHA-256 in the
+manifest. The binary is swapped in one rename and run once to check it; if it
+fails, the previous binary is put back. The previous binary is kept next to
+the new one (rios-worker.old) and can be restored with --rollback.
+
+A running worker keeps running the old version until it is restarted.`,
+	Args:         cobra.NoArgs,
+	SilenceUsage: true,
+	RunE:         runUpdate,
+}
+
+// updateSignCmd writes the signed manifest of a release, for the release
+// process rather than for workers
+var updateSignCmd = &cobra.Command{
+	Use:   "sign --key release.key --version vX.Y.Z [binaries]",
+	Short: "Sign the release manifest of binaries built with make build-all",
+	Long: `Write the signed manifest of a release to stdout. The binaries must be
+named like make build-all names them (rios-worker-<os>-<arch>[.exe]). The key
+is a PEM Ed25519 key that is created if it doesn't exist; its public half is
+what workers are built with (RELEASE_PUBLIC_KEY in the Makefile).`,
+	Hidden:       true,
+	Args:         cobra.MinimumNArgs(1),
+	SilenceUsage: true,
+	RunE:         runUpdateSign,
+}
+
+func init() {
+	rootCmd.AddCommand(updateCmd)
+	updateCmd.AddCommand(updateSignCmd)
+	updateCmd.Flags().StringVar(&updateManifest, "manifest", update.DefaultManifestURL, "URL or file of the signed release manifest")
+	updateCmd.Flags().StringVar(&updatePublicKey, "public-key", "", "Base64 Ed25519 key to verify a test manifest with instead of the release key (needs --manifest and --insecure-dev-key)")
+	updateCmd.Flags().BoolVar(&updateDevKey, "insecure-dev-key", false, "Allow --public-key to replace the release key")
+	updateCmd.Flags().BoolVar(&updateCheck, "check", false, "Only report whether an update is available")
+	updateCmd.Flags().BoolVar(&updateForce, "force", false, "Install the release even if it isn't newer")
+	updateCmd.Flags().BoolVar(&updateRollback, "rollback", false, "Restore the binary replaced by the last update")
+
+	// The release key is the trust root of a command usually run as root;
+	// replacing it is for testing releases only
+	updateCmd.Flags().MarkHidden("public-key")
+	updateCmd.Flags().MarkHidden("insecure-dev-key")
+
+	updateSignCmd.Flags().StringVar(&signKey, "key", "", "PEM file of the release key")
+	updateSignCmd.Flags().StringVar(&signVersion, "version", "", "Version of the release")
+	updateSignCmd.Flags().StringVar(&signNotes, "notes", "", "Release notes")
+	updateSignCmd.Flags().StringVar(&signBaseURL, "base-url", "", "URL the binaries are published under (default: next to the manifest)")
+	updateSignCmd.MarkFlagRequired("key")
+	updateSignCmd.MarkFlagRequired("version")
+}
+
+func runUpdate(cmd *cobra.Command, args []string) error {
+	exe, err := executablePath()
+	if err != nil {
+		return err
+	}
+
+	if updateRollback {
+		if err := update.Rollback(exe); err != nil {
+			return err
+		}
+		fmt.Printf("✅ Restored the previous binary of %s\n", exe)
+		printRestartHint()
+		return nil
+	}
+
+	publicKey := update.ReleasePublicKey
+	if updatePublicKey != "" {
+		if !updateDevKey || !cmd.Flags().Changed("manifest") {
+			return fmt.Errorf("--public-key replaces the release key and is only for testing releases; pass it with --manifest and --insecure-dev-key")
+		}
+		publicKey = updatePublicKey
+		fmt.Println("⚠️  Verifying the manifest with --public-key instead of the release key")
+	}
+	if publicKey == "" {
+		return fmt.Errorf("this build has no release key to verify updates with; install a release build")
+	}
+	key, err := update.ParsePublicKey(publicKey)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("🔍 Checking for updates...")
+	manifest, err := update.Fetch(updateManifest, key)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("   Installed: %s\n", Version)
+	fmt.Printf("   Latest:    %s", manifest.Version)
+	if !manifest.ReleasedAt.IsZero() {
+		fmt.Printf(" (released %s)", manifest.ReleasedAt.Format("2006-01-02"))
+	}
+	fmt.Println()
+
+	if !update.Older(Version, manifest.Version) && !updateForce {
+		fmt.Println("✅ rios-worker is up to date")
+		return nil
+	}
+	if manifest.Notes != "" {
+		fmt.Println()
+		for _, line := range strings.Split(strings.TrimSpace(manifest.Notes), "\n") {
+			fmt.Printf("   %s\n", line)
+		}
+	}
+	if updateCheck {
+		fmt.Println()
+		fmt.Println("⬆️  Update available, install it with: rios-worker update")
+		return nil
+	}
+
+	asset, err := manifest.Asset(runtime.GOOS, runtime.GOARCH)
+	if err != nil {
+		return err
+	}
+	fmt.Println()
+	fmt.Printf("📥 Downloading %s/%s binary (%.1f MB)...\n", asset.OS, asset.Arch, float64(asset.SizeBytes)/1e6)
+	staged, err := update.Download(updateManifest, asset, exe)
+	if err != nil {
+		return err
+	}
+	fmt.Println("✅ Signature and checksum verified")
+
+	if err := update.Install(exe, staged, manifest.Version); err != nil {
+		return err
+	}
+	fmt.Printf("✅ Updated %s to %s\n", exe, manifest.Version)
+	fmt.Printf("   The previous binary is kept at %s (rios-worker update --rollback)\n", update.BackupPath(exe))
+	printRestartHint()
+	return nil
+}
+
+// printRestartHint tells how to make a running worker use the new binary
+func printRestartHint() {
+	if status, err := service.GetStatus(); err == nil && status.Active == "active" {
+		fmt.Printf("🔄 Restart the service to run it: sudo systemctl restart %s\n", service.Name)
+		return
+	}
+	fmt.Println("🔄 Restart running workers to run it")
+}
+
+func runUpdateSign(cmd *cobra.Command, args []string) error {
+	if _, err := update.ParseVersion(signVersion); err != nil {
+		return err
+	}
+	key, err := receipt.LoadKey(signKey)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "🔑 Release public key: %s\n", receipt.PublicKey(key))
+
+	manifest := &update.Manifest{
+		Version:    signVersion,
+		ReleasedAt: time.Now().UTC().Truncate(time.Second),
+		Notes:      signNotes,
+	}
+	for _, path := range args {
+		asset, err := releaseAsset(path)
+		if err != nil {
+			return err
+		}
+		manifest.Assets = append(manifest.Assets, *asset)
+	}
+
+	signed, err := update.Sign(manifest, key)
+	if err != nil {
+		return err
+	}
+	// Indenting would reformat the signed bytes
+	data, err := json.Marshal(signed)
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(data))
+	return nil
+}
+
+// releaseAsset describes a binary named rios-worker-<os>-<arch>[.exe]
+func releaseAsset(path string) (*update.Asset, error) {
+	name := filepath.Base(path)
+	platform := strings.TrimSuffix(strings.TrimPrefix(name, "rios-worker-"), ".exe")
+	goos, goarch, ok := strings.Cut(platform, "-")
+	if !ok || update.AssetName(goos, goarch) != name {
+		return nil, fmt.Errorf("%s is not named rios-worker-<os>-<arch>[.exe]", name)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	defer f.Close()
+	hash := sha256.New()
+	size, err := io.Copy(hash, f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	url := name
+	if signBaseURL != "" {
+		url = strings.TrimSuffix(signBaseURL, "/") + "/" + name
+	}
+	return &update.Asset{
+		OS:        goos,
+		Arch:      goarch,
+		URL:       url,
+		SHA256:    hex.EncodeToString(hash.Sum(nil)),
+		SizeBytes: size,
+	}, nil
+}
@@ -0,0 +1,265 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/rios/worker/pkg/metrics"
+	"github.com/rios/worker/pkg/worker"
+)
+
+// HeaderAdmin must be set on requests that control the worker. A web page
+// can't send it to another origin without a CORS preflight, which this
+// server never allows.
+const HeaderAdmin = "X-RiOS-Admin"
+
+// Info is static information about the worker shown by /health
+type Info struct {
+	NodeID  int    `json:"node_id"`
+	Version string `json:"version"`
+}
+
+// Server is the local admin API of a running worker. It is meant to be bound
+// to localhost or a unix socket only; it has no authentication of its own,
+// but control requests must come from the operator rather than a web page
+// (see checkControl).
+type Server struct {
+	info     Info
+	state    *worker.State
+	metrics  *metrics.Metrics
+	server   *http.Server
+	listener net.Listener
+}
+
+// NewServer creates an admin server for state; metrics may be nil
+func NewServer(info Info, state *worker.State, m *metrics.Metrics) *Server {
+	s := &Server{
+		info:    info,
+		state:   state,
+		metrics: m,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", s.handleHealth)
+	mux.HandleFunc("/jobs", s.handleJobs)
+	mux.HandleFunc("/jobs/", s.handleJobAction)
+	mux.HandleFunc("/pause", s.handleControl(state.Pause))
+	mux.HandleFunc("/resume", s.handleControl(state.Resume))
+	mux.HandleFunc("/drain", s.handleControl(state.Drain))
+	if m != nil {
+		mux.Handle("/metrics", m.Handler())
+	}
+
+	s.server = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return s
+}
+
+// Listen binds the server to addr, either host:port or unix:/path/to/socket
+func (s *Server) Listen(addr string) error {
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		// Remove a stale socket left by a previous run
+		os.Remove(path)
+		l, err := net.Listen("unix", path)
+		if err != nil {
+			return fmt.Errorf("failed to listen on %s: %w", addr, err)
+		}
+		if err := os.Chmod(path, 0660); err != nil {
+			l.Close()
+			return fmt.Errorf("failed to set socket permissions: %w", err)
+		}
+		s.listener = l
+		return nil
+	}
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+	s.listener = l
+	return nil
+}
+
+// Addr returns the address the server is listening on
+func (s *Server) Addr() string {
+	if s.listener == nil {
+		return ""
+	}
+	return s.listener.Addr().String()
+}
+
+// Serve serves requests until Shutdown is called
+func (s *Server) Serve() error {
+	if err := s.server.Serve(s.listener); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// Shutdown stops the server
+func (s *Server) Shutdown() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return s.server.Shutdown(ctx)
+}
+
+// IsLoopback reports whether a listen address is only reachable locally
+func IsLoopback(addr string) bool {
+	if strings.HasPrefix(addr, "unix:") {
+		return true
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	return loopbackHost(host)
+}
+
+type healthResponse struct {
+	Status        string  `json:"status"`
+	State         string  `json:"state"`
+	Paused        bool    `json:"paused"`
+	Draining      bool    `json:"draining"`
+	HoldReason    string  `json:"hold_reason,omitempty"`
+	NodeID        int     `json:"node_id"`
+	Version       string  `json:"version"`
+	UptimeSeconds float64 `json:"uptime_seconds"`
+	RunningJobs   int     `json:"running_jobs"`
+}
+
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	writeJSON(w, http.StatusOK, healthResponse{
+		Status:        "ok",
+		State:         s.state.Status(),
+		Paused:        s.state.Paused(),
+		Draining:      s.state.Draining(),
+		HoldReason:    s.state.HoldReason(),
+		NodeID:        s.info.NodeID,
+		Version:       s.info.Version,
+		UptimeSeconds: time.Since(s.state.StartedAt()).Seconds(),
+		RunningJobs:   len(s.state.Jobs()),
+	})
+}
+
+func (s *Server) handleJobs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"jobs": s.state.Jobs(),
+	})
+}
+
+// handleJobAction serves POST /jobs/{id}/cancel
+func (s *Server) handleJobAction(w http.ResponseWriter, r *http.Request) {
+	jobID, action, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, "/jobs/"), "/")
+	if !ok || jobID == "" || action != "cancel" {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if !checkControl(w, r) {
+		return
+	}
+
+	if err := s.state.CancelJob(jobID, errors.New("job cancelled by worker operator")); err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"message": fmt.Sprintf("job %s cancelled", jobID),
+	})
+}
+
+// handleControl returns a handler running action on POST and reporting the new state
+func (s *Server) handleControl(action func()) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		if !checkControl(w, r) {
+			return
+		}
+		action()
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"success": true,
+			"state":   s.state.Status(),
+		})
+	}
+}
+
+// checkControl rejects control requests a web page in the operator's
+// browser could have sent: ones addressed to a host name other than a
+// loopback one (DNS rebinding), from another origin, or without
+// HeaderAdmin. It reports whether the request may go ahead.
+func checkControl(w http.ResponseWriter, r *http.Request) bool {
+	switch {
+	case !loopbackHost(r.Host):
+		writeError(w, http.StatusForbidden, fmt.Sprintf("host %q is not a loopback address", r.Host))
+	case !sameOrigin(r):
+		writeError(w, http.StatusForbidden, fmt.Sprintf("cross-origin request from %s", r.Header.Get("Origin")))
+	case r.Header.Get(HeaderAdmin) == "":
+		writeError(w, http.StatusForbidden, fmt.Sprintf("missing %s header", HeaderAdmin))
+	default:
+		return true
+	}
+	return false
+}
+
+// loopbackHost reports whether the Host of a request, with or without a
+// port, names this machine
+func loopbackHost(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.Trim(host, "[]")
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// sameOrigin reports whether a request has no Origin, as from curl, or the
+// origin of this server
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Scheme == "http" && u.Host == r.Host
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]interface{}{
+		"success": false,
+		"message": message,
+	})
+}
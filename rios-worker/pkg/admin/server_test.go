@@ -0,0 +1,83 @@
+package admin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rios/worker/pkg/worker"
+)
+
+func TestControlRequests(t *testing.T) {
+	tests := []struct {
+		name    string
+		method  string
+		path    string
+		host    string
+		headers map[string]string
+		want    int
+	}{
+		{"pause", "POST", "/pause", "127.0.0.1:9465", map[string]string{HeaderAdmin: "1"}, http.StatusOK},
+		{"localhost", "POST", "/drain", "localhost:9465", map[string]string{HeaderAdmin: "1"}, http.StatusOK},
+		{"IPv6 loopback", "POST", "/resume", "[::1]:9465", map[string]string{HeaderAdmin: "1"}, http.StatusOK},
+		{"same origin", "POST", "/pause", "127.0.0.1:9465", map[string]string{HeaderAdmin: "1", "Origin": "http://127.0.0.1:9465"}, http.StatusOK},
+		{"GET", "GET", "/pause", "127.0.0.1:9465", map[string]string{HeaderAdmin: "1"}, http.StatusMethodNotAllowed},
+
+		// Simple cross-origin POST from a web page
+		{"no admin header", "POST", "/pause", "127.0.0.1:9465", nil, http.StatusForbidden},
+		{"foreign origin", "POST", "/drain", "127.0.0.1:9465", map[string]string{HeaderAdmin: "1", "Origin": "http://evil.example"}, http.StatusForbidden},
+		{"opaque origin", "POST", "/drain", "127.0.0.1:9465", map[string]string{HeaderAdmin: "1", "Origin": "null"}, http.StatusForbidden},
+		{"other port", "POST", "/drain", "127.0.0.1:9465", map[string]string{HeaderAdmin: "1", "Origin": "http://127.0.0.1:8080"}, http.StatusForbidden},
+		// DNS rebinding: the page's host name resolves to 127.0.0.1
+		{"rebound host", "POST", "/pause", "evil.example:9465", map[string]string{HeaderAdmin: "1", "Origin": "http://evil.example:9465"}, http.StatusForbidden},
+		{"rebound host without origin", "POST", "/drain", "evil.example:9465", map[string]string{HeaderAdmin: "1"}, http.StatusForbidden},
+
+		{"cancel without admin header", "POST", "/jobs/job-1/cancel", "127.0.0.1:9465", nil, http.StatusForbidden},
+		{"cancel from rebound host", "POST", "/jobs/job-1/cancel", "evil.example:9465", map[string]string{HeaderAdmin: "1"}, http.StatusForbidden},
+		{"cancel unknown job", "POST", "/jobs/job-1/cancel", "127.0.0.1:9465", map[string]string{HeaderAdmin: "1"}, http.StatusNotFound},
+
+		// Reading the state needs nothing
+		{"health", "GET", "/health", "evil.example:9465", nil, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state := worker.NewState()
+			s := NewServer(Info{NodeID: 1, Version: "v1.0.0"}, state, nil)
+
+			req := httptest.NewRequest(tt.method, "http://"+tt.host+tt.path, nil)
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			rec := httptest.NewRecorder()
+			s.server.Handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Fatalf("%s %s = %d %s, want %d", tt.method, tt.path, rec.Code, rec.Body, tt.want)
+			}
+			changed := state.Paused() || state.Draining()
+			if tt.want == http.StatusForbidden && changed {
+				t.Error("rejected request changed the worker state")
+			}
+		})
+	}
+}
+
+func TestIsLoopback(t *testing.T) {
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{"127.0.0.1:9465", true},
+		{"localhost:9465", true},
+		{"[::1]:9465", true},
+		{"unix:/run/rios/admin.sock", true},
+		{"0.0.0.0:9465", false},
+		{":9465", false},
+		{"192.168.1.10:9465", false},
+	}
+	for _, tt := range tests {
+		if got := IsLoopback(tt.addr); got != tt.want {
+			t.Errorf("IsLoopback(%q) = %v, want %v", tt.addr, got, tt.want)
+		}
+	}
+}
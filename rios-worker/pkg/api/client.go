@@ -6,7 +6,12 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/rios/worker/pkg/gpu"
 )
 
 // Client represents the API client
@@ -14,6 +19,9 @@ type Client struct {
 	BaseURL    string
 	HTTPClient *http.Client
 	AuthToken  string
+
+	// WorkerVersion is the worker release sent with every request
+	WorkerVersion string
 }
 
 // NewClient creates a new API client
@@ -33,115 +41,157 @@ func (c *Client) SetAuthToken(token string) {
 
 // RegisterRequest represents the registration request
 type RegisterRequest struct {
-	GPUType           string `json:"gpuType"`
-	GPUVram           int    `json:"gpuVram"`
-	GPUCount          int    `json:"gpuCount"`
-	RosWalletAddress  string `json:"rosWalletAddress"`
-	ContributorName   string `json:"contributorName,omitempty"`
+	GPUType          string `json:"gpuType"`
+	GPUVram          int    `json:"gpuVram"`
+	GPUCount         int    `json:"gpuCount"`
+	RosWalletAddress string `json:"rosWalletAddress"`
+	ContributorName  string `json:"contributorName,omitempty"`
+	ProvisionToken   string `json:"provisionToken,omitempty"`
+	PublicKey        string `json:"publicKey,omitempty"` // base64 Ed25519 key that signs job receipts
 }
 
 // RegisterResponse represents the registration response
 type RegisterResponse struct {
-	Success        bool   `json:"success"`
-	NodeID         int    `json:"node_id"`
-	NodeAuthToken  string `json:"node_auth_token"`
-	Message        string `json:"message"`
+	Success       bool   `json:"success"`
+	NodeID        int    `json:"node_id"`
+	NodeAuthToken string `json:"node_auth_token"`
+	Message       string `json:"message"`
+	Status        string `json:"status,omitempty"` // e.g. "approved" when a provisioning token was accepted
 }
 
 // Register registers a new worker node
 func (c *Client) Register(req *RegisterRequest) (*RegisterResponse, error) {
-	data, err := json.Marshal(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal request: %w", err)
-	}
-
-	resp, err := c.HTTPClient.Post(
-		c.BaseURL+"/api/worker/register",
-		"application/json",
-		bytes.NewBuffer(data),
-	)
-	if err != nil {
-		return nil, fmt.Errorf("failed to send request: %w", err)
-	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %w", err)
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("registration failed (status %d): %s", resp.StatusCode, string(body))
-	}
-
 	var result RegisterResponse
-	if err := json.Unmarshal(body, &result); err != nil {
-		return nil, fmt.Errorf("failed to parse response: %w", err)
+	if err := c.doJSON("POST", "/api/worker/register", req, &result, "registration"); err != nil {
+		return nil, err
 	}
-
 	return &result, nil
 }
 
 // HeartbeatRequest represents the heartbeat request
 type HeartbeatRequest struct {
-	Status string `json:"status"`
+	Status string       `json:"status"`
+	Reason string       `json:"reason,omitempty"` // why the node is paused or stopped a job
+	GPUs   []gpu.Sample `json:"gpus,omitempty"`   // latest telemetry of each GPU
+
+	// Why there is no current GPU telemetry, if there isn't
+	TelemetryError string `json:"telemetry_error,omitempty"`
+
+	// SHA-256 digests of the inputs in the node's cache, for locality-aware scheduling
+	CachedInputs []string `json:"cached_inputs,omitempty"`
 }
 
 // HeartbeatResponse represents the heartbeat response
 type HeartbeatResponse struct {
 	Success bool   `json:"success"`
 	Message string `json:"message"`
+
+	// Oldest worker version the orchestrator accepts jobs from, if any
+	MinVersion string `json:"min_version,omitempty"`
 }
 
 // Heartbeat sends a heartbeat to the server
-func (c *Client) Heartbeat(status string) error {
-	req := &HeartbeatRequest{Status: status}
-	data, err := json.Marshal(req)
-	if err != nil {
-		return fmt.Errorf("failed to marshal request: %w", err)
+func (c *Client) Heartbeat(req *HeartbeatRequest) (*HeartbeatResponse, error) {
+	var result HeartbeatResponse
+	if err := c.doJSON("POST", "/api/worker/heartbeat", req, &result, "heartbeat"); err != nil {
+		return nil, err
 	}
+	return &result, nil
+}
 
-	httpReq, err := http.NewRequest(
-		"POST",
-		c.BaseURL+"/api/worker/heartbeat",
-		bytes.NewBuffer(data),
-	)
-	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
-	}
+// JobPayload represents the job payload
+type JobPayload struct {
+	DockerImage  string                 `json:"docker_image"`
+	InputS3URL   string                 `json:"input_s3_url"`
+	OutputS3Path string                 `json:"output_s3_path"`
+	Prompt       string                 `json:"prompt,omitempty"`
+	InitVideoURL string                 `json:"init_video_url,omitempty"`
+	WorkflowJSON interface{}            `json:"workflow_json,omitempty"`
+	Extra        map[string]interface{} `json:"-"`
+
+	// Declared resource requirements, used by the acceptance policy
+	MinVRAMGB      int   `json:"min_vram_gb,omitempty"`
+	ImageSizeBytes int64 `json:"image_size_bytes,omitempty"`
+	InputSizeBytes int64 `json:"input_size_bytes,omitempty"`
+
+	// Expected size and digest of each input, checked while downloading
+	InputDigest     *InputDigest `json:"input_digest,omitempty"`
+	InitVideoDigest *InputDigest `json:"init_video_digest,omitempty"`
+
+	// Inputs lists the job's files; InputS3URL and InitVideoURL are added to it
+	Inputs []JobInput `json:"inputs,omitempty"`
+
+	// ExpectedOutput is checked against the outputs before submitting
+	ExpectedOutput *OutputExpectations `json:"expected_output,omitempty"`
+
+	// Seed fixes the job's random seed. Deterministic also asks the container
+	// for deterministic kernels, so a rerun reproduces the outputs bit for bit.
+	Seed          *int64 `json:"seed,omitempty"`
+	Deterministic bool   `json:"deterministic,omitempty"`
+
+	// Verification marks a job whose outputs are compared with known hashes
+	Verification *Verification `json:"verification,omitempty"`
+}
 
-	httpReq.Header.Set("Content-Type", "application/json")
-	httpReq.Header.Set("Authorization", "Bearer "+c.AuthToken)
+// Verification kinds
+const (
+	VerifyReexecution = "reexecution" // rerun of a job another worker completed
+	VerifyCanary      = "canary"      // job with a known answer
+)
 
-	resp, err := c.HTTPClient.Do(httpReq)
-	if err != nil {
-		return fmt.Errorf("failed to send request: %w", err)
-	}
-	defer resp.Body.Close()
+// Verification gives the expected SHA-256 of a job's outputs, keyed by path
+// relative to the output directory. Outputs not listed are not compared.
+type Verification struct {
+	Kind      string            `json:"kind"`
+	Reference map[string]string `json:"reference"`
+}
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("heartbeat failed (status %d): %s", resp.StatusCode, string(body))
-	}
+// Verdict is the outcome of comparing a job's outputs with its reference
+type Verdict struct {
+	Kind       string     `json:"kind"`
+	Passed     bool       `json:"passed"`
+	Mismatches []Mismatch `json:"mismatches,omitempty"`
+}
 
-	return nil
+// Mismatch is an output that differs from the reference; an empty SHA256
+// means the output is missing
+type Mismatch struct {
+	Path     string `json:"path"`
+	Expected string `json:"expected"`
+	SHA256   string `json:"sha256,omitempty"`
 }
 
-// JobPayload represents the job payload
-type JobPayload struct {
-	DockerImage   string                 `json:"docker_image"`
-	InputS3URL    string                 `json:"input_s3_url"`
-	OutputS3Path  string                 `json:"output_s3_path"`
-	Prompt        string                 `json:"prompt,omitempty"`
-	InitVideoURL  string                 `json:"init_video_url,omitempty"`
-	WorkflowJSON  interface{}            `json:"workflow_json,omitempty"`
-	Extra         map[string]interface{} `json:"-"`
+// OutputExpectations describe what a job's outputs must look like. Zero
+// fields are not checked.
+type OutputExpectations struct {
+	Width              int     `json:"width,omitempty"`  // of every image, in pixels
+	Height             int     `json:"height,omitempty"` // of every image, in pixels
+	MinDurationSeconds float64 `json:"min_duration_seconds,omitempty"`
+}
+
+// JobInput is one named file of a job's input manifest
+type JobInput struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+	Path string `json:"path"` // relative to the input directory; the target directory for archives
+
+	// Extract unpacks the download into Path: "tar", "tar.gz" or "zip"
+	Extract string `json:"extract,omitempty"`
+
+	InputDigest // of the downloaded file, before extraction
+}
+
+// InputDigest is the expected size and SHA-256 of a job input
+type InputDigest struct {
+	SizeBytes int64  `json:"size_bytes,omitempty"`
+	SHA256    string `json:"sha256,omitempty"` // hex encoded
 }
 
 // Job represents a job
 type Job struct {
 	JobID    string      `json:"job_id"`
 	TaskType string      `json:"task_type"`
+	Reward   float64     `json:"reward,omitempty"` // offered reward in $ROS
 	Payload  *JobPayload `json:"payload"`
 }
 
@@ -152,39 +202,51 @@ type GetJobResponse struct {
 	Message string `json:"message"`
 }
 
-// GetJob gets a new job from the server
-func (c *Client) GetJob() (*Job, error) {
-	httpReq, err := http.NewRequest(
-		"GET",
-		c.BaseURL+"/api/worker/get-job",
-		nil,
-	)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
-	}
-
-	httpReq.Header.Set("Authorization", "Bearer "+c.AuthToken)
+// JobHints tell the orchestrator which jobs this worker will accept.
+// They are advisory; the worker still checks every job it receives.
+type JobHints struct {
+	TaskTypes         []string
+	MinReward         float64
+	MaxVRAMGB         int
+	MaxImageSizeBytes int64
+	MaxInputSizeBytes int64
+}
 
-	resp, err := c.HTTPClient.Do(httpReq)
-	if err != nil {
-		return nil, fmt.Errorf("failed to send request: %w", err)
+// Query encodes the hints as get-job query parameters
+func (h *JobHints) Query() url.Values {
+	q := url.Values{}
+	if h == nil {
+		return q
 	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %w", err)
+	if len(h.TaskTypes) > 0 {
+		q.Set("task_types", strings.Join(h.TaskTypes, ","))
+	}
+	if h.MinReward > 0 {
+		q.Set("min_reward", strconv.FormatFloat(h.MinReward, 'f', -1, 64))
+	}
+	if h.MaxVRAMGB > 0 {
+		q.Set("max_vram_gb", strconv.Itoa(h.MaxVRAMGB))
+	}
+	if h.MaxImageSizeBytes > 0 {
+		q.Set("max_image_size_bytes", strconv.FormatInt(h.MaxImageSizeBytes, 10))
+	}
+	if h.MaxInputSizeBytes > 0 {
+		q.Set("max_input_size_bytes", strconv.FormatInt(h.MaxInputSizeBytes, 10))
 	}
+	return q
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("get job failed (status %d): %s", resp.StatusCode, string(body))
+// GetJob gets a new job from the server; hints may be nil
+func (c *Client) GetJob(hints *JobHints) (*Job, error) {
+	path := "/api/worker/get-job"
+	if q := hints.Query(); len(q) > 0 {
+		path += "?" + q.Encode()
 	}
 
 	var result GetJobResponse
-	if err := json.Unmarshal(body, &result); err != nil {
-		return nil, fmt.Errorf("failed to parse response: %w", err)
+	if err := c.doJSON("GET", path, nil, &result, "get job"); err != nil {
+		return nil, err
 	}
-
 	return result.Job, nil
 }
 
@@ -194,6 +256,85 @@ type SubmitResultRequest struct {
 	Status       string `json:"status"`
 	OutputS3URL  string `json:"output_s3_url,omitempty"`
 	ErrorMessage string `json:"error_message,omitempty"`
+
+	// Peak and average GPU telemetry over the job's execution
+	GPUTelemetry []gpu.Stats `json:"gpu_telemetry,omitempty"`
+
+	// Network usage of the job
+	Traffic *Traffic `json:"traffic,omitempty"`
+
+	// Output files as checked by the worker, for auditing
+	Manifest *ResultManifest `json:"manifest,omitempty"`
+
+	// Outcome of a verification job
+	Verdict *Verdict `json:"verdict,omitempty"`
+
+	// Signed record of what ran, for settling disputes
+	Receipt *SignedReceipt `json:"receipt,omitempty"`
+}
+
+// Receipt records what a worker ran for a job: the exact image, the inputs
+// and outputs by hash, when it ran, on which GPU and how it exited
+type Receipt struct {
+	JobID       string        `json:"job_id"`
+	NodeID      int           `json:"node_id"`
+	Image       string        `json:"image"`
+	ImageDigest string        `json:"image_digest,omitempty"`
+	Inputs      []ReceiptFile `json:"inputs"`
+	Outputs     []ReceiptFile `json:"outputs"`
+	StartedAt   time.Time     `json:"started_at"`
+	FinishedAt  time.Time     `json:"finished_at"`
+	GPUType     string        `json:"gpu_type"`
+	GPUCount    int           `json:"gpu_count"`
+	ExitCode    int           `json:"exit_code"` // -1 if the container didn't exit on its own
+	Status      string        `json:"status"`
+}
+
+// ReceiptFile is an input or output file of a job
+type ReceiptFile struct {
+	Name      string `json:"name,omitempty"`
+	Path      string `json:"path"`
+	SizeBytes int64  `json:"size_bytes"`
+	SHA256    string `json:"sha256"`
+}
+
+// SignedReceipt is a receipt as signed by the node key. The signature
+// covers the exact bytes of Receipt, so they are sent as they were signed.
+type SignedReceipt struct {
+	Receipt   json.RawMessage `json:"receipt"`
+	PublicKey string          `json:"public_key"` // base64 Ed25519 key
+	Signature string          `json:"signature"`  // base64 Ed25519 signature
+}
+
+// Traffic counts the bytes a job moved over the network
+type Traffic struct {
+	DownloadBytes    int64 `json:"download_bytes"`     // job inputs
+	UploadBytes      int64 `json:"upload_bytes"`       // job outputs, 0 until outputs are uploaded
+	ContainerRxBytes int64 `json:"container_rx_bytes"` // received by the job's container
+	ContainerTxBytes int64 `json:"container_tx_bytes"` // sent by the job's container
+}
+
+// Total returns all bytes transferred
+func (t *Traffic) Total() int64 {
+	return t.DownloadBytes + t.UploadBytes + t.ContainerRxBytes + t.ContainerTxBytes
+}
+
+// ResultManifest lists the output files of a job
+type ResultManifest struct {
+	Files []ResultFile `json:"files"`
+}
+
+// ResultFile is an output file with its hash and what the worker found in it
+type ResultFile struct {
+	Path      string `json:"path"` // relative to the output directory
+	SizeBytes int64  `json:"size_bytes"`
+	SHA256    string `json:"sha256"`
+	Kind      string `json:"kind"` // "image", "video", "checkpoint" or "other"
+
+	Width           int     `json:"width,omitempty"`
+	Height          int     `json:"height,omitempty"`
+	DurationSeconds float64 `json:"duration_seconds,omitempty"`
+	Tensors         int     `json:"tensors,omitempty"` // in a checkpoint
 }
 
 // SubmitResultResponse represents the submit result response
@@ -201,47 +342,198 @@ type SubmitResultResponse struct {
 	Success    bool    `json:"success"`
 	Message    string  `json:"message"`
 	RewardPaid float64 `json:"reward_paid"`
+	// RewardStatus is "pending" until the reward has been paid out on-chain
+	RewardStatus string `json:"reward_status,omitempty"`
 }
 
 // SubmitResult submits the result of a job
 func (c *Client) SubmitResult(req *SubmitResultRequest) (*SubmitResultResponse, error) {
-	data, err := json.Marshal(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	var result SubmitResultResponse
+	if err := c.doJSON("POST", "/api/worker/submit-result", req, &result, "submit result"); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// doJSON sends a JSON request, authenticated once the node has a token, and
+// decodes the JSON response into out. action is used to prefix error
+// messages (e.g. "update hardware").
+func (c *Client) doJSON(method, path string, in, out interface{}, action string) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("failed to marshal request: %w", err)
+		}
+		body = bytes.NewBuffer(data)
 	}
 
-	httpReq, err := http.NewRequest(
-		"POST",
-		c.BaseURL+"/api/worker/submit-result",
-		bytes.NewBuffer(data),
-	)
+	httpReq, err := http.NewRequest(method, c.BaseURL+path, body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+		return fmt.Errorf("failed to create request: %w", err)
 	}
 
-	httpReq.Header.Set("Content-Type", "application/json")
-	httpReq.Header.Set("Authorization", "Bearer "+c.AuthToken)
+	if in != nil {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+	if c.AuthToken != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+c.AuthToken)
+	}
+	c.setProtocolHeaders(httpReq)
 
 	resp, err := c.HTTPClient.Do(httpReq)
 	if err != nil {
-		return nil, fmt.Errorf("failed to send request: %w", err)
+		return fmt.Errorf("failed to send request: %w", err)
 	}
 	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
+	respBody, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %w", err)
+		return fmt.Errorf("failed to read response: %w", err)
 	}
 
+	if resp.StatusCode == http.StatusUpgradeRequired {
+		upgradeErr := &UpgradeRequiredError{}
+		if json.Unmarshal(respBody, upgradeErr) != nil {
+			upgradeErr.Message = strings.TrimSpace(string(respBody))
+		}
+		return upgradeErr
+	}
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("submit result failed (status %d): %s", resp.StatusCode, string(body))
+		return &statusError{Action: action, StatusCode: resp.StatusCode, Body: string(respBody)}
 	}
 
-	var result SubmitResultResponse
-	if err := json.Unmarshal(body, &result); err != nil {
-		return nil, fmt.Errorf("failed to parse response: %w", err)
+	if out == nil || len(bytes.TrimSpace(respBody)) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(respBody, out); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	return nil
+}
+
+// UpdateHardwareRequest represents the update hardware request
+type UpdateHardwareRequest struct {
+	GPUType  string `json:"gpuType"`
+	GPUVram  int    `json:"gpuVram"`
+	GPUCount int    `json:"gpuCount"`
+}
+
+// UpdateHardwareResponse represents the update hardware response
+type UpdateHardwareResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+}
+
+// UpdateHardware reports a change in the node's GPU configuration
+func (c *Client) UpdateHardware(req *UpdateHardwareRequest) (*UpdateHardwareResponse, error) {
+	var result UpdateHardwareResponse
+	if err := c.doJSON("POST", "/api/worker/update-hardware", req, &result, "update hardware"); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// NodeStatusResponse represents the node status response
+type NodeStatusResponse struct {
+	Success       bool   `json:"success"`
+	Message       string `json:"message"`
+	NodeID        int    `json:"node_id"`
+	Status        string `json:"status"` // online, busy, paused or offline
+	CurrentJobID  string `json:"current_job_id,omitempty"`
+	LastHeartbeat string `json:"last_heartbeat,omitempty"`
+	GPUType       string `json:"gpu_type"`
+	GPUVram       int    `json:"gpu_vram"`
+	GPUCount      int    `json:"gpu_count"`
+}
+
+// GetNodeStatus returns the orchestrator's view of this node
+func (c *Client) GetNodeStatus() (*NodeStatusResponse, error) {
+	var result NodeStatusResponse
+	if err := c.doJSON("GET", "/api/worker/status", nil, &result, "get status"); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// EarningsDay aggregates rewards for one day and task type
+type EarningsDay struct {
+	Date     string  `json:"date"`
+	TaskType string  `json:"task_type"`
+	Jobs     int     `json:"jobs"`
+	Pending  float64 `json:"pending"`
+	Paid     float64 `json:"paid"`
+}
+
+// EarningsResponse represents the earnings response
+type EarningsResponse struct {
+	Success      bool          `json:"success"`
+	Message      string        `json:"message"`
+	TotalPending float64       `json:"total_pending"`
+	TotalPaid    float64       `json:"total_paid"`
+	Days         []EarningsDay `json:"days"`
+}
+
+// GetEarnings returns this node's earnings over the last days
+func (c *Client) GetEarnings(days int) (*EarningsResponse, error) {
+	var result EarningsResponse
+	path := fmt.Sprintf("/api/worker/earnings?days=%d", days)
+	if err := c.doJSON("GET", path, nil, &result, "get earnings"); err != nil {
+		return nil, err
 	}
+	return &result, nil
+}
+
+// JobSummary is a past job as recorded by the orchestrator
+type JobSummary struct {
+	JobID           string   `json:"job_id"`
+	TaskType        string   `json:"task_type"`
+	Status          string   `json:"status"`
+	StartedAt       string   `json:"started_at"`
+	FinishedAt      string   `json:"finished_at,omitempty"`
+	DurationSeconds float64  `json:"duration_seconds"`
+	Reward          float64  `json:"reward"`
+	RewardStatus    string   `json:"reward_status,omitempty"`
+	Traffic         *Traffic `json:"traffic,omitempty"`
+}
 
+// JobHistoryResponse represents the job history response
+type JobHistoryResponse struct {
+	Success bool         `json:"success"`
+	Message string       `json:"message"`
+	Jobs    []JobSummary `json:"jobs"`
+}
+
+// GetJobHistory returns up to limit of this node's most recent jobs
+func (c *Client) GetJobHistory(limit int) (*JobHistoryResponse, error) {
+	var result JobHistoryResponse
+	path := fmt.Sprintf("/api/worker/jobs?limit=%d", limit)
+	if err := c.doJSON("GET", path, nil, &result, "get jobs"); err != nil {
+		return nil, err
+	}
 	return &result, nil
 }
 
+// RejectJobRequest represents the reject job request
+type RejectJobRequest struct {
+	JobID  string `json:"job_id"`
+	Code   string `json:"code"`
+	Reason string `json:"reason"`
+}
+
+// RejectJobResponse represents the reject job response
+type RejectJobResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+}
+
+// RejectJob hands a job back to the orchestrator for reassignment without
+// counting it as a failure
+func (c *Client) RejectJob(req *RejectJobRequest) (*RejectJobResponse, error) {
+	var result RejectJobResponse
+	if err := c.doJSON("POST", "/api/worker/reject-job", req, &result, "reject job"); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
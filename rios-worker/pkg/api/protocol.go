@@ -0,0 +1,142 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ProtocolVersion is the version of the worker protocol this client speaks.
+// It is raised when a request or response changes in a way the other side
+// would misread, e.g. a job field an older worker would silently drop.
+const ProtocolVersion = 1
+
+// Headers sent with every request
+const (
+	HeaderProtocol      = "X-RiOS-Protocol"       // ProtocolVersion
+	HeaderWorkerVersion = "X-RiOS-Worker-Version" // release of the worker, e.g. v0.2.0
+	HeaderCapabilities  = "X-RiOS-Capabilities"   // comma-separated Capabilities
+)
+
+// Worker capabilities, so the orchestrator only hands out jobs this worker
+// understands in full
+const (
+	CapInputManifest  = "input-manifest"  // JobPayload.Inputs, including archive extraction
+	CapInputDigest    = "input-digest"    // size and SHA-256 checks of inputs
+	CapExpectedOutput = "expected-output" // output validation
+	CapDeterministic  = "deterministic"   // Seed and Deterministic
+	CapVerification   = "verification"    // re-execution and canary jobs
+	CapReceipts       = "receipts"        // signed receipts in results
+	CapRejectJob      = "reject-job"      // jobs handed back with a rejection code
+	CapJobHints       = "job-hints"       // acceptance hints on get-job
+	CapCachedInputs   = "cached-inputs"   // input cache digests in heartbeats
+)
+
+// Capabilities lists everything this worker supports
+var Capabilities = []string{
+	CapInputManifest,
+	CapInputDigest,
+	CapExpectedOutput,
+	CapDeterministic,
+	CapVerification,
+	CapReceipts,
+	CapRejectJob,
+	CapJobHints,
+	CapCachedInputs,
+}
+
+// HandshakeResponse is the orchestrator's side of the protocol negotiation
+type HandshakeResponse struct {
+	Success     bool     `json:"success"`
+	Message     string   `json:"message"`
+	MinProtocol int      `json:"min_protocol"`
+	MaxProtocol int      `json:"max_protocol"`
+	Features    []string `json:"features,omitempty"`    // feature flags of the orchestrator
+	MinVersion  string   `json:"min_version,omitempty"` // oldest worker release it accepts
+}
+
+// Supports reports whether the orchestrator speaks a protocol version
+func (h *HandshakeResponse) Supports(protocol int) bool {
+	return protocol >= h.MinProtocol && protocol <= h.MaxProtocol
+}
+
+// HasFeature reports whether the orchestrator has a feature flag set
+func (h *HandshakeResponse) HasFeature(feature string) bool {
+	for _, f := range h.Features {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
+
+// Handshake asks the orchestrator which protocol versions and features it
+// supports. An orchestrator without the handshake endpoint is taken to speak
+// protocol 1 only. If it doesn't speak ProtocolVersion, the response is
+// returned with an error, an *UpgradeRequiredError if this worker is too old.
+func (c *Client) Handshake() (*HandshakeResponse, error) {
+	var result HandshakeResponse
+	err := c.doJSON("GET", "/api/worker/handshake", nil, &result, "handshake")
+	var statusErr *statusError
+	if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound {
+		result = HandshakeResponse{Success: true, MinProtocol: 1, MaxProtocol: 1}
+	} else if err != nil {
+		return nil, err
+	}
+
+	if result.Supports(ProtocolVersion) {
+		return &result, nil
+	}
+	if ProtocolVersion < result.MinProtocol {
+		return &result, &UpgradeRequiredError{
+			MinProtocol: result.MinProtocol,
+			MinVersion:  result.MinVersion,
+		}
+	}
+	return &result, fmt.Errorf("the orchestrator speaks protocol %d to %d, this worker needs %d: the orchestrator has to be upgraded first",
+		result.MinProtocol, result.MaxProtocol, ProtocolVersion)
+}
+
+// UpgradeRequiredError is returned when the orchestrator no longer accepts
+// this worker's protocol or release (HTTP 426 Upgrade Required)
+type UpgradeRequiredError struct {
+	Message     string `json:"message"`
+	MinProtocol int    `json:"min_protocol,omitempty"`
+	MinVersion  string `json:"min_version,omitempty"`
+}
+
+func (e *UpgradeRequiredError) Error() string {
+	msg := "the orchestrator requires a newer worker"
+	switch {
+	case e.MinVersion != "":
+		msg += fmt.Sprintf(" (%s or later)", e.MinVersion)
+	case e.MinProtocol > 0:
+		msg += fmt.Sprintf(" (protocol %d, this worker speaks %d)", e.MinProtocol, ProtocolVersion)
+	}
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	return msg
+}
+
+// statusError is a response with a status other than 200 OK
+type statusError struct {
+	Action     string
+	StatusCode int
+	Body       string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("%s failed (status %d): %s", e.Action, e.StatusCode, e.Body)
+}
+
+// setProtocolHeaders tells the orchestrator which protocol, release and
+// capabilities this worker has
+func (c *Client) setProtocolHeaders(req *http.Request) {
+	req.Header.Set(HeaderProtocol, fmt.Sprint(ProtocolVersion))
+	if c.WorkerVersion != "" {
+		req.Header.Set(HeaderWorkerVersion, c.WorkerVersion)
+	}
+	req.Header.Set(HeaderCapabilities, strings.Join(Capabilities, ","))
+}
@@ -0,0 +1,219 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// respond answers every request with status and body
+func respond(status int, body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(body, "{") {
+			w.Header().Set("Content-Type", "application/json")
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}
+}
+
+func TestHandshake(t *testing.T) {
+	tests := []struct {
+		name         string
+		status       int
+		body         string
+		wantMax      int // 0 if no response is returned
+		wantFeature  string
+		wantUpgrade  *UpgradeRequiredError
+		wantErr      string
+		wantResponse bool // returned along with the error
+	}{
+		{
+			name:        "supported",
+			status:      http.StatusOK,
+			body:        `{"success": true, "min_protocol": 1, "max_protocol": 2, "features": ["job-hints"]}`,
+			wantMax:     2,
+			wantFeature: "job-hints",
+		},
+		{
+			name:    "orchestrator without a handshake",
+			status:  http.StatusNotFound,
+			body:    "404 page not found",
+			wantMax: 1,
+		},
+		{
+			name:         "worker too old",
+			status:       http.StatusOK,
+			body:         `{"success": true, "min_protocol": 2, "max_protocol": 3, "min_version": "v2.0.0"}`,
+			wantMax:      3,
+			wantUpgrade:  &UpgradeRequiredError{MinProtocol: 2, MinVersion: "v2.0.0"},
+			wantResponse: true,
+		},
+		{
+			name:         "orchestrator too old",
+			status:       http.StatusOK,
+			body:         `{"success": true, "min_protocol": 0, "max_protocol": 0}`,
+			wantErr:      "the orchestrator has to be upgraded first",
+			wantResponse: true,
+		},
+		{
+			name:        "upgrade required",
+			status:      http.StatusUpgradeRequired,
+			body:        `{"message": "protocol 1 is retired", "min_protocol": 2}`,
+			wantUpgrade: &UpgradeRequiredError{Message: "protocol 1 is retired", MinProtocol: 2},
+		},
+		{
+			name:    "server error",
+			status:  http.StatusInternalServerError,
+			body:    "database is down",
+			wantErr: "handshake failed (status 500): database is down",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(respond(tt.status, tt.body))
+			defer srv.Close()
+
+			resp, err := NewClient(srv.URL).Handshake()
+
+			var upgradeErr *UpgradeRequiredError
+			switch {
+			case tt.wantUpgrade != nil:
+				if !errors.As(err, &upgradeErr) || *upgradeErr != *tt.wantUpgrade {
+					t.Fatalf("err = %#v, want %#v", err, tt.wantUpgrade)
+				}
+			case tt.wantErr != "":
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) || errors.As(err, &upgradeErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+			case err != nil:
+				t.Fatal(err)
+			}
+
+			if tt.wantMax == 0 && !tt.wantResponse {
+				if resp != nil {
+					t.Errorf("response %+v, want none", resp)
+				}
+				return
+			}
+			if resp == nil {
+				t.Fatal("no response")
+			}
+			if tt.wantMax != 0 && resp.MaxProtocol != tt.wantMax {
+				t.Errorf("MaxProtocol = %d, want %d", resp.MaxProtocol, tt.wantMax)
+			}
+			if tt.wantFeature != "" && !resp.HasFeature(tt.wantFeature) {
+				t.Errorf("features %v, want %s", resp.Features, tt.wantFeature)
+			}
+		})
+	}
+}
+
+func TestUpgradeRequired(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    UpgradeRequiredError
+		wantMsg string
+	}{
+		{
+			name:    "minimum version",
+			body:    `{"message": "please update", "min_version": "v0.3.0"}`,
+			want:    UpgradeRequiredError{Message: "please update", MinVersion: "v0.3.0"},
+			wantMsg: "the orchestrator requires a newer worker (v0.3.0 or later): please update",
+		},
+		{
+			name:    "minimum protocol",
+			body:    `{"min_protocol": 2}`,
+			want:    UpgradeRequiredError{MinProtocol: 2},
+			wantMsg: "the orchestrator requires a newer worker (protocol 2, this worker speaks 1)",
+		},
+		{
+			name:    "plain text",
+			body:    "Upgrade Required\n",
+			want:    UpgradeRequiredError{Message: "Upgrade Required"},
+			wantMsg: "the orchestrator requires a newer worker: Upgrade Required",
+		},
+		{
+			name:    "empty body",
+			wantMsg: "the orchestrator requires a newer worker",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(respond(http.StatusUpgradeRequired, tt.body))
+			defer srv.Close()
+
+			// Every endpoint reports 426 the same way
+			c := NewClient(srv.URL)
+			_, heartbeatErr := c.Heartbeat(&HeartbeatRequest{Status: "online"})
+			_, getJobErr := c.GetJob(nil)
+			for _, err := range []error{heartbeatErr, getJobErr} {
+				var upgradeErr *UpgradeRequiredError
+				if !errors.As(err, &upgradeErr) {
+					t.Fatalf("err = %v, want an *UpgradeRequiredError", err)
+				}
+				if *upgradeErr != tt.want {
+					t.Errorf("parsed %+v, want %+v", *upgradeErr, tt.want)
+				}
+				if err.Error() != tt.wantMsg {
+					t.Errorf("message %q, want %q", err.Error(), tt.wantMsg)
+				}
+			}
+		})
+	}
+}
+
+func TestProtocolHeaders(t *testing.T) {
+	tests := []struct {
+		name          string
+		workerVersion string
+		token         string
+	}{
+		{name: "registered", workerVersion: "v0.2.0", token: "secret"},
+		{name: "development build"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []http.Header
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = append(got, r.Header.Clone())
+				w.Write([]byte(`{"success": true, "min_protocol": 1, "max_protocol": 1}`))
+			}))
+			defer srv.Close()
+
+			c := NewClient(srv.URL)
+			c.WorkerVersion = tt.workerVersion
+			c.SetAuthToken(tt.token)
+			if _, err := c.Handshake(); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := c.Heartbeat(&HeartbeatRequest{Status: "online"}); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := c.GetJob(nil); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(got) != 3 {
+				t.Fatalf("got %d requests, want 3", len(got))
+			}
+			for i, h := range got {
+				if h.Get(HeaderProtocol) != "1" {
+					t.Errorf("request %d: %s = %q, want 1", i, HeaderProtocol, h.Get(HeaderProtocol))
+				}
+				if h.Get(HeaderWorkerVersion) != tt.workerVersion {
+					t.Errorf("request %d: %s = %q, want %q", i, HeaderWorkerVersion, h.Get(HeaderWorkerVersion), tt.workerVersion)
+				}
+				if caps := h.Get(HeaderCapabilities); caps != strings.Join(Capabilities, ",") || !strings.Contains(caps, CapRejectJob) {
+					t.Errorf("request %d: %s = %q, want %v", i, HeaderCapabilities, caps, Capabilities)
+				}
+				if want := "Bearer " + tt.token; tt.token != "" && h.Get("Authorization") != want {
+					t.Errorf("request %d: Authorization = %q, want %q", i, h.Get("Authorization"), want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,264 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rios/worker/pkg/config"
+)
+
+// DefaultMaxBytes is the cache size used when none is configured
+const DefaultMaxBytes = 50 << 30
+
+// staleDownloadAge is how long an unfinished download is kept for resuming
+const staleDownloadAge = 7 * 24 * time.Hour
+
+// Cache is a content-addressed store of job inputs keyed by SHA-256. Files
+// are immutable once added. When the cache grows past its size limit the
+// least recently used files that no running job holds are evicted.
+type Cache struct {
+	dir      string
+	maxBytes int64
+
+	mu   sync.Mutex
+	pins map[string]int
+}
+
+// DefaultDir returns the cache directory in the data directory
+// (~/.rios/cache)
+func DefaultDir() (string, error) {
+	dir, err := config.DataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "cache"), nil
+}
+
+// Open opens (and creates if needed) the cache in dir
+func Open(dir string, maxBytes int64) (*Cache, error) {
+	if maxBytes <= 0 {
+		maxBytes = DefaultMaxBytes
+	}
+	c := &Cache{dir: dir, maxBytes: maxBytes, pins: make(map[string]int)}
+	for _, sub := range []string{c.objectsDir(), c.TempDir()} {
+		if err := os.MkdirAll(sub, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create cache directory: %w", err)
+		}
+	}
+	// Partial downloads are kept for resuming, unless nobody came back for them
+	tmp, _ := os.ReadDir(c.TempDir())
+	for _, entry := range tmp {
+		if info, err := entry.Info(); err == nil && time.Since(info.ModTime()) > staleDownloadAge {
+			os.RemoveAll(filepath.Join(c.TempDir(), entry.Name()))
+		}
+	}
+	return c, nil
+}
+
+func (c *Cache) objectsDir() string {
+	return filepath.Join(c.dir, "sha256")
+}
+
+// TempDir is where downloads are staged before Add; it is on the same
+// file system as the cache so adding a file is a rename. Partial downloads
+// in it survive restarts.
+func (c *Cache) TempDir() string {
+	return filepath.Join(c.dir, "tmp")
+}
+
+// normalize validates a hex SHA-256 digest and lower-cases it
+func normalize(digest string) (string, error) {
+	digest = strings.ToLower(digest)
+	if b, err := hex.DecodeString(digest); err != nil || len(b) != 32 {
+		return "", fmt.Errorf("invalid sha256 digest %q", digest)
+	}
+	return digest, nil
+}
+
+func (c *Cache) path(digest string) string {
+	return filepath.Join(c.objectsDir(), digest)
+}
+
+// Get returns the path of the file with the given digest and pins it so it
+// isn't evicted until Unpin. ok is false if the file isn't cached.
+func (c *Cache) Get(digest string) (path string, ok bool) {
+	digest, err := normalize(digest)
+	if err != nil {
+		return "", false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	path = c.path(digest)
+	if _, err := os.Stat(path); err != nil {
+		return "", false
+	}
+	now := time.Now()
+	os.Chtimes(path, now, now) // the modification time orders eviction
+	c.pins[digest]++
+	return path, true
+}
+
+// Add moves the file at src into the cache, pins it and returns its new
+// path. src must have been written to TempDir. sum is the SHA-256 of src
+// verified while downloading it; src is only hashed if it is empty. src is
+// removed if it doesn't match digest, so nothing else can be served under
+// that digest.
+func (c *Cache) Add(digest, src, sum string) (string, error) {
+	digest, err := normalize(digest)
+	if err != nil {
+		return "", err
+	}
+	if sum == "" {
+		if sum, err = hashFile(src); err != nil {
+			return "", fmt.Errorf("failed to add %s to the cache: %w", digest, err)
+		}
+	}
+	if sum = strings.ToLower(sum); sum != digest {
+		os.Remove(src)
+		return "", fmt.Errorf("refusing to cache %s: its SHA-256 is %s", digest, sum)
+	}
+
+	c.mu.Lock()
+	path := c.path(digest)
+	if _, err := os.Stat(path); err == nil {
+		// Another download of the same content won the race
+		os.Remove(src)
+	} else {
+		if err := os.Rename(src, path); err != nil {
+			c.mu.Unlock()
+			return "", fmt.Errorf("failed to add %s to the cache: %w", digest, err)
+		}
+	}
+	now := time.Now()
+	os.Chtimes(path, now, now)
+	c.pins[digest]++
+	c.mu.Unlock()
+
+	c.evict()
+	return path, nil
+}
+
+// hashFile returns the hex SHA-256 of the file at path
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Unpin releases a file pinned by Get or Add, evicting it if the cache
+// is over its size limit
+func (c *Cache) Unpin(digest string) {
+	digest, err := normalize(digest)
+	if err != nil {
+		return
+	}
+
+	c.mu.Lock()
+	released := c.pins[digest] <= 1
+	if released {
+		delete(c.pins, digest)
+	} else {
+		c.pins[digest]--
+	}
+	c.mu.Unlock()
+
+	if released {
+		c.evict()
+	}
+}
+
+type entry struct {
+	digest  string
+	size    int64
+	modTime time.Time
+}
+
+func (c *Cache) entries() []entry {
+	files, _ := os.ReadDir(c.objectsDir())
+	entries := make([]entry, 0, len(files))
+	for _, f := range files {
+		info, err := f.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		// Only files added under their digest are entries
+		if digest, err := normalize(f.Name()); err != nil || digest != f.Name() {
+			continue
+		}
+		entries = append(entries, entry{f.Name(), info.Size(), info.ModTime()})
+	}
+	return entries
+}
+
+// evict removes least recently used, unpinned files until the cache fits
+// its size limit
+func (c *Cache) evict() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entries := c.entries()
+	var total int64
+	for _, e := range entries {
+		total += e.size
+	}
+	if total <= c.maxBytes {
+		return
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].modTime.Before(entries[j].modTime)
+	})
+	for _, e := range entries {
+		if total <= c.maxBytes {
+			break
+		}
+		if c.pins[e.digest] > 0 {
+			continue
+		}
+		if err := os.Remove(c.path(e.digest)); err == nil {
+			total -= e.size
+		}
+	}
+}
+
+// Digests returns the SHA-256 digests of all cached files
+func (c *Cache) Digests() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entries := c.entries()
+	digests := make([]string, 0, len(entries))
+	for _, e := range entries {
+		digests = append(digests, e.digest)
+	}
+	return digests
+}
+
+// Size returns the total size of the cached files in bytes
+func (c *Cache) Size() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var total int64
+	for _, e := range c.entries() {
+		total += e.size
+	}
+	return total
+}
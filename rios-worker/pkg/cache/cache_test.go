@@ -0,0 +1,272 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func digestOf(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+// stage writes data to a new file in the cache's TempDir, as a download would
+func stage(t *testing.T, c *Cache, data string) string {
+	t.Helper()
+	f, err := os.CreateTemp(c.TempDir(), "download-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(data); err != nil {
+		t.Fatal(err)
+	}
+	return f.Name()
+}
+
+// add adds data to the cache, hashing it when verified is false
+func add(t *testing.T, c *Cache, data string, verified bool) string {
+	t.Helper()
+	var sum string
+	if verified {
+		sum = digestOf(data)
+	}
+	path, err := c.Add(digestOf(data), stage(t, c, data), sum)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func cached(c *Cache) []string {
+	digests := c.Digests()
+	sort.Strings(digests)
+	return digests
+}
+
+func digestsOf(data ...string) []string {
+	var digests []string
+	for _, d := range data {
+		digests = append(digests, digestOf(d))
+	}
+	sort.Strings(digests)
+	return digests
+}
+
+func TestAdd(t *testing.T) {
+	tests := []struct {
+		name     string
+		digest   string // declared digest, that of data if empty
+		sum      string // passed to Add as verified while downloading
+		data     string
+		wantErr  string
+		wantHits bool
+	}{
+		{name: "hashed", data: "model", wantHits: true},
+		{name: "verified", data: "model", sum: digestOf("model"), wantHits: true},
+		{name: "verified in upper case", data: "model", sum: strings.ToUpper(digestOf("model")), wantHits: true},
+		{name: "invalid digest", digest: "abc", data: "model", wantErr: "invalid sha256 digest"},
+		{name: "corrupted download", digest: digestOf("model"), data: "modem", wantErr: "refusing to cache"},
+		{name: "verified as other content", data: "model", sum: digestOf("other"), wantErr: "refusing to cache"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := Open(t.TempDir(), 1<<20)
+			if err != nil {
+				t.Fatal(err)
+			}
+			digest := tt.digest
+			if digest == "" {
+				digest = digestOf(tt.data)
+			}
+			src := stage(t, c, tt.data)
+
+			path, err := c.Add(digest, src, tt.sum)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, ok := c.Get(digest); ok != tt.wantHits {
+				t.Errorf("Get after Add: %v, want %v", ok, tt.wantHits)
+			}
+			if tt.wantErr != "" {
+				if len(c.Digests()) != 0 {
+					t.Errorf("cached %v after a failed Add", c.Digests())
+				}
+				if _, err := os.Stat(src); tt.wantErr == "refusing to cache" && err == nil {
+					t.Error("a download that doesn't match its digest was kept")
+				}
+				return
+			}
+			if got, err := os.ReadFile(path); err != nil || string(got) != tt.data {
+				t.Errorf("cached file has %q (%v), want %q", got, err, tt.data)
+			}
+			if _, err := os.Stat(src); err == nil {
+				t.Error("the staged download is still there")
+			}
+		})
+	}
+}
+
+func TestEviction(t *testing.T) {
+	ten := func(b byte) string { return strings.Repeat(string(b), 10) }
+	a, b, c3, d := ten('a'), ten('b'), ten('c'), ten('d')
+
+	tests := []struct {
+		name   string
+		limit  int64
+		pinned []string // held by a running job
+		want   []string
+	}{
+		{name: "within the limit", limit: 40, want: digestsOf(a, b, c3, d)},
+		{name: "least recently used first", limit: 35, want: digestsOf(a, c3, d)},
+		{name: "down to the limit", limit: 25, want: digestsOf(a, d)},
+		{name: "pinned files stay", limit: 25, pinned: []string{b}, want: digestsOf(b, d)},
+		{name: "over the limit while pinned", limit: 15, pinned: []string{b, c3}, want: digestsOf(b, c3, d)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// a, b and c are left from earlier jobs, c used last
+			dir := t.TempDir()
+			if err := os.MkdirAll(filepath.Join(dir, "sha256"), 0755); err != nil {
+				t.Fatal(err)
+			}
+			for i, data := range []string{a, b, c3} {
+				path := filepath.Join(dir, "sha256", digestOf(data))
+				if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+					t.Fatal(err)
+				}
+				at := time.Now().Add(-time.Duration(3-i) * time.Hour)
+				if err := os.Chtimes(path, at, at); err != nil {
+					t.Fatal(err)
+				}
+			}
+			c, err := Open(dir, tt.limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, data := range tt.pinned {
+				if _, ok := c.Get(digestOf(data)); !ok {
+					t.Fatalf("%s is not cached", data)
+				}
+			}
+
+			// Then a is used again and d is added
+			if _, ok := c.Get(digestOf(a)); !ok {
+				t.Fatal("a is not cached")
+			}
+			c.Unpin(digestOf(a))
+			add(t, c, d, true)
+			if got := cached(c); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("cached %v, want %v", got, tt.want)
+			}
+
+			// Once released, pinned files go too if the cache is still too big
+			for _, data := range append(tt.pinned, d) {
+				c.Unpin(digestOf(data))
+			}
+			if size := c.Size(); size > tt.limit {
+				t.Errorf("Size = %d after unpinning everything, limit is %d", size, tt.limit)
+			}
+		})
+	}
+}
+
+func TestConcurrentAddGet(t *testing.T) {
+	c, err := Open(t.TempDir(), 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const data = "shared model"
+	digest := digestOf(data)
+
+	// Staged up front: t.Fatal can't be called from the goroutines
+	srcs := make([]string, 16)
+	for i := range srcs {
+		srcs[i] = stage(t, c, data)
+	}
+
+	var wg sync.WaitGroup
+	paths := make([]string, len(srcs))
+	errs := make([]error, len(srcs))
+	added := make([]bool, len(srcs))
+	for i := range srcs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if path, ok := c.Get(digest); ok {
+				paths[i] = path
+				return
+			}
+			sum := ""
+			if i%2 == 0 {
+				sum = digest
+			}
+			paths[i], errs[i] = c.Add(digest, srcs[i], sum)
+			added[i] = true
+		}(i)
+	}
+	wg.Wait()
+
+	for i := range srcs {
+		if errs[i] != nil {
+			t.Fatalf("Add %d: %v", i, errs[i])
+		}
+		if paths[i] != c.path(digest) {
+			t.Errorf("job %d got %s, want %s", i, paths[i], c.path(digest))
+		}
+		// The losers of the race are dropped, not left behind
+		if _, err := os.Stat(srcs[i]); added[i] && err == nil {
+			t.Errorf("job %d: the staged download is still there", i)
+		}
+		c.Unpin(digest)
+	}
+	if got, err := os.ReadFile(c.path(digest)); err != nil || string(got) != data {
+		t.Errorf("cached file has %q (%v), want %q", got, err, data)
+	}
+	if c.Size() != int64(len(data)) {
+		t.Errorf("Size = %d, want %d", c.Size(), len(data))
+	}
+}
+
+func TestForeignFiles(t *testing.T) {
+	c, err := Open(t.TempDir(), 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	add(t, c, "model", false)
+	c.Unpin(digestOf("model"))
+
+	// Files that were not added under their digest are not entries
+	upper := strings.ToUpper(digestOf("other"))
+	for _, name := range []string{"notes.txt", upper} {
+		if err := os.WriteFile(filepath.Join(c.objectsDir(), name), []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(c.objectsDir(), digestOf("dir")), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := cached(c), digestsOf("model"); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Digests = %v, want %v", got, want)
+	}
+	if c.Size() != int64(len("model")) {
+		t.Errorf("Size = %d, want %d", c.Size(), len("model"))
+	}
+	if _, ok := c.Get(upper); ok {
+		t.Error("Get served a file that was not added")
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 )
 
 const (
@@ -12,23 +14,198 @@ const (
 	ConfigFile = "config.json"
 )
 
+// Environment variables that move the configuration and data out of the
+// home directory, as a system service does
+const (
+	ConfigDirEnv = "RIOS_CONFIG_DIR"
+	DataDirEnv   = "RIOS_DATA_DIR"
+)
+
 // Config represents the worker configuration
 type Config struct {
 	NodeID        int    `json:"node_id"`
 	NodeAuthToken string `json:"node_auth_token"`
 	APIEndpoint   string `json:"api_endpoint"`
 	WalletAddress string `json:"wallet_address"`
+
+	// Hardware as last reported to the orchestrator
+	GPUType  string `json:"gpu_type,omitempty"`
+	GPUVram  int    `json:"gpu_vram,omitempty"`
+	GPUCount int    `json:"gpu_count,omitempty"`
+
+	// Thermal and power safety limits
+	Governor GovernorConfig `json:"governor"`
+
+	// When the worker takes jobs
+	Schedule ScheduleConfig `json:"schedule"`
+
+	// Which jobs the worker takes
+	Policy PolicyConfig `json:"policy"`
+
+	// How job inputs are fetched
+	Downloads DownloadConfig `json:"downloads"`
+
+	// Shared cache of job inputs
+	Cache CacheConfig `json:"cache"`
+
+	// Network usage limits
+	Bandwidth BandwidthConfig `json:"bandwidth"`
 }
 
-// GetConfigPath returns the full path to the config file
-func GetConfigPath() (string, error) {
+// BandwidthConfig caps the network traffic of jobs
+type BandwidthConfig struct {
+	// Stop taking jobs once this much traffic was used in the billing period (default: no cap)
+	MonthlyCapGB float64 `json:"monthly_cap_gb,omitempty"`
+	// Day of the month the billing period starts, 1-28 (default 1)
+	ResetDay int `json:"reset_day,omitempty"`
+}
+
+// PeriodStart returns the start of the billing period containing t
+func (b BandwidthConfig) PeriodStart(t time.Time) time.Time {
+	day := b.ResetDay
+	if day < 1 || day > 28 {
+		day = 1
+	}
+	start := time.Date(t.Year(), t.Month(), day, 0, 0, 0, 0, t.Location())
+	if start.After(t) {
+		start = start.AddDate(0, -1, 0)
+	}
+	return start
+}
+
+// CacheConfig sizes the content-addressed input cache
+type CacheConfig struct {
+	Disabled bool `json:"disabled,omitempty"`
+	// Least recently used inputs are evicted above this size (default 50)
+	MaxSizeGB float64 `json:"max_size_gb,omitempty"`
+	// Cache location (default ~/.rios/cache)
+	Dir string `json:"dir,omitempty"`
+}
+
+// DownloadConfig tunes how job inputs are fetched
+type DownloadConfig struct {
+	// Inputs downloaded at the same time (default 4)
+	Parallel int `json:"parallel,omitempty"`
+	// Ranges of a large input fetched at the same time (default 4)
+	Segments int `json:"segments,omitempty"`
+	// Total download bandwidth in megabits per second (default: unlimited)
+	MaxMbps float64 `json:"max_mbps,omitempty"`
+}
+
+// PolicyConfig declares which jobs the worker accepts. Unset fields don't restrict.
+type PolicyConfig struct {
+	// Only accept these task types (e.g. "comfyui", "training")
+	TaskTypes []string `json:"task_types,omitempty"`
+	// Never accept these task types
+	ExcludeTaskTypes []string `json:"exclude_task_types,omitempty"`
+	// Minimum offered reward in $ROS
+	MinReward float64 `json:"min_reward,omitempty"`
+	// Maximum VRAM a job may require (default: the detected VRAM)
+	MaxVRAMGB int `json:"max_vram_gb,omitempty"`
+	// Maximum Docker image and input download sizes
+	MaxImageSizeGB float64 `json:"max_image_size_gb,omitempty"`
+	MaxInputSizeGB float64 `json:"max_input_size_gb,omitempty"`
+	// Only run images whose reference starts with one of these prefixes,
+	// e.g. "docker.io/rios/" or "ghcr.io/rios-network/"
+	AllowedImages []string `json:"allowed_images,omitempty"`
+	// Disk space to keep free in the work directory on top of a job's inputs (default 5)
+	MinFreeDiskGB float64 `json:"min_free_disk_gb,omitempty"`
+}
+
+// ScheduleConfig restricts when the worker takes jobs
+type ScheduleConfig struct {
+	// Windows in which jobs are accepted, e.g. "mon-fri 22:00-07:00" or
+	// "sat,sun *". No windows means always.
+	Windows []string `json:"windows,omitempty"`
+	// IANA time zone the windows are in, e.g. "Europe/Berlin" (default: system time zone)
+	Timezone string `json:"timezone,omitempty"`
+
+	// Only take jobs while no other program is using the GPU ...
+	IdleOnly bool `json:"idle_only,omitempty"`
+	// ... and hasn't for this many minutes (default 5)
+	IdleMinutes int `json:"idle_minutes,omitempty"`
+}
+
+// GovernorConfig configures the thermal and power safety governor.
+// Zero values fall back to the defaults applied by WithDefaults.
+type GovernorConfig struct {
+	Disabled bool `json:"disabled,omitempty"`
+
+	// Stop taking new jobs at or above this temperature ...
+	MaxTemperatureC float64 `json:"max_temperature_c,omitempty"`
+	// ... and take them again once every GPU has cooled to this one
+	ResumeTemperatureC float64 `json:"resume_temperature_c,omitempty"`
+
+	// Suspend the running container once a GPU stays at or above this
+	// temperature for CriticalSeconds, and kill it if it is still suspended
+	// after KillAfterSeconds
+	CriticalTemperatureC float64 `json:"critical_temperature_c,omitempty"`
+	CriticalSeconds      int     `json:"critical_seconds,omitempty"`
+	KillAfterSeconds     int     `json:"kill_after_seconds,omitempty"`
+
+	// Stop taking new jobs while a GPU draws more than this (0 = no limit)
+	MaxPowerW float64 `json:"max_power_w,omitempty"`
+	// Apply this power limit with nvidia-smi -pl at startup (0 = leave as is, needs root)
+	PowerLimitW float64 `json:"power_limit_w,omitempty"`
+	// Stop taking new jobs while a GPU is thermally or power throttled
+	PauseOnThrottle bool `json:"pause_on_throttle,omitempty"`
+}
+
+// WithDefaults returns a copy with unset limits replaced by safe defaults
+func (g GovernorConfig) WithDefaults() GovernorConfig {
+	if g.MaxTemperatureC == 0 {
+		g.MaxTemperatureC = 85
+	}
+	if g.ResumeTemperatureC == 0 {
+		g.ResumeTemperatureC = g.MaxTemperatureC - 10
+	}
+	if g.CriticalTemperatureC == 0 {
+		g.CriticalTemperatureC = 90
+	}
+	if g.CriticalSeconds == 0 {
+		g.CriticalSeconds = 30
+	}
+	if g.KillAfterSeconds == 0 {
+		g.KillAfterSeconds = 300
+	}
+	return g
+}
+
+// Dir returns the directory of the configuration and node key:
+// $RIOS_CONFIG_DIR, or ~/.rios
+func Dir() (string, error) {
+	if dir := os.Getenv(ConfigDirEnv); dir != "" {
+		return dir, nil
+	}
 	home, err := os.UserHomeDir()
 	if err != nil {
 		return "", fmt.Errorf("failed to get home directory: %w", err)
 	}
+	return filepath.Join(home, ConfigDir), nil
+}
+
+// DataDir returns the directory of the job history, receipts, input cache
+// and work files: $RIOS_DATA_DIR, the StateDirectory systemd gives a
+// service, or ~/.rios
+func DataDir() (string, error) {
+	if dir := os.Getenv(DataDirEnv); dir != "" {
+		return dir, nil
+	}
+	if dir := os.Getenv("STATE_DIRECTORY"); dir != "" {
+		// systemd separates several directories with colons
+		first, _, _ := strings.Cut(dir, ":")
+		return first, nil
+	}
+	return Dir()
+}
+
+// GetConfigPath returns the full path to the config file
+func GetConfigPath() (string, error) {
+	configDir, err := Dir()
+	if err != nil {
+		return "", err
+	}
 
-	configDir := filepath.Join(home, ConfigDir)
-	
 	// Create directory if it doesn't exist
 	if err := os.MkdirAll(configDir, 0755); err != nil {
 		return "", fmt.Errorf("failed to create config directory: %w", err)
@@ -37,6 +214,16 @@ func GetConfigPath() (string, error) {
 	return filepath.Join(configDir, ConfigFile), nil
 }
 
+// Exists reports whether a config file has already been written
+func Exists() bool {
+	configPath, err := GetConfigPath()
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(configPath)
+	return err == nil
+}
+
 // Load loads the configuration from the config file
 func Load() (*Config, error) {
 	configPath, err := GetConfigPath()
@@ -78,4 +265,3 @@ func Save(cfg *Config) error {
 
 	return nil
 }
-
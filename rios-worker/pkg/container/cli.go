@@ -0,0 +1,293 @@
+package container
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// GPU injection modes of a CLI runtime
+const (
+	// GPUFlag passes --gpus all, handled by the NVIDIA Container Toolkit hook
+	GPUFlag = "flag"
+	// GPUCDI requests the CDI device nvidia.com/gpu=all, described by a spec
+	// generated with nvidia-ctk
+	GPUCDI = "cdi"
+)
+
+// CDIDevice is the CDI device that gives a container every NVIDIA GPU
+const CDIDevice = "nvidia.com/gpu=all"
+
+// CLI runs job containers with a Docker-compatible command line. Docker,
+// Podman and nerdctl (containerd) take the same run, pause, inspect and stats
+// commands and differ in how GPUs are passed in.
+type CLI struct {
+	Binary     string // docker, podman or nerdctl
+	GPUMode    string // GPUFlag or GPUCDI
+	InstallURL string // shown when the binary is missing
+
+	// Command that resolves an image in its registry without pulling it,
+	// nil if the CLI has none and images are only found when pulled
+	ResolveArgs []string
+
+	// Relabel bind mounts (:z) so that SELinux lets containers use them
+	Relabel bool
+}
+
+// NewDocker returns the Docker runtime with the NVIDIA Container Toolkit
+func NewDocker() *CLI {
+	return &CLI{
+		Binary:      "docker",
+		GPUMode:     GPUFlag,
+		InstallURL:  "https://docs.docker.com/get-docker/",
+		ResolveArgs: []string{"manifest", "inspect"},
+	}
+}
+
+// NewPodman returns the Podman runtime, rootless or not. GPUs are injected
+// through CDI, and mounts are relabeled if SELinux is enabled.
+func NewPodman() *CLI {
+	return &CLI{
+		Binary:      "podman",
+		GPUMode:     GPUCDI,
+		InstallURL:  "https://podman.io/docs/installation",
+		ResolveArgs: []string{"manifest", "inspect"},
+		Relabel:     selinuxEnabled(),
+	}
+}
+
+// NewNerdctl returns the containerd runtime driven by nerdctl. GPUs are
+// injected through CDI.
+func NewNerdctl() *CLI {
+	return &CLI{
+		Binary:     "nerdctl",
+		GPUMode:    GPUCDI,
+		InstallURL: "https://github.com/containerd/nerdctl#install",
+	}
+}
+
+func (c *CLI) Name() string { return c.Binary }
+
+// Check makes sure the CLI is installed, its daemon (if it has one) is
+// running and, for CDI, that a spec describes the NVIDIA GPUs
+func (c *CLI) Check() error {
+	if err := c.CheckInstalled(); err != nil {
+		return err
+	}
+	if c.GPUMode == GPUCDI {
+		if _, err := FindCDISpec(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CheckInstalled makes sure the CLI is installed and its daemon (if it has
+// one) is running
+func (c *CLI) CheckInstalled() error {
+	if err := exec.Command(c.Binary, "--version").Run(); err != nil {
+		return fmt.Errorf("%s is not installed or not in PATH. Please install it: %s", c.Binary, c.InstallURL)
+	}
+	if output, err := exec.Command(c.Binary, "ps").CombinedOutput(); err != nil {
+		return fmt.Errorf("%s can't list containers, is its service running? %s", c.Binary, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// Version returns the first line of the CLI's version output
+func (c *CLI) Version() string {
+	output, err := exec.Command(c.Binary, "--version").Output()
+	if err != nil {
+		return ""
+	}
+	line, _, _ := strings.Cut(strings.TrimSpace(string(output)), "\n")
+	return line
+}
+
+func (c *CLI) Run(ctx context.Context, spec *Spec) error {
+	cmd := exec.CommandContext(ctx, c.Binary, c.runArgs(spec)...)
+	cmd.Stdout = spec.Stdout
+	cmd.Stderr = spec.Stderr
+	// Killing the CLI leaves the container running, stop it first
+	cmd.Cancel = func() error {
+		exec.Command(c.Binary, "kill", spec.Name).Run()
+		return cmd.Process.Kill()
+	}
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return &ExitError{Code: exitErr.ExitCode()}
+		}
+		return err
+	}
+	return nil
+}
+
+// runArgs builds the run command line of spec
+func (c *CLI) runArgs(spec *Spec) []string {
+	args := []string{"run", "--rm", "--name", spec.Name}
+	if spec.GPUs {
+		if c.GPUMode == GPUCDI {
+			args = append(args, "--device", CDIDevice)
+		} else {
+			args = append(args, "--gpus", "all")
+		}
+	}
+
+	keys := make([]string, 0, len(spec.Labels))
+	for k := range spec.Labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		args = append(args, "--label", k+"="+spec.Labels[k])
+	}
+
+	for _, m := range spec.Mounts {
+		var opts []string
+		if m.ReadOnly {
+			opts = append(opts, "ro")
+		}
+		if c.Relabel {
+			// Shared label, the input cache is mounted into every job
+			opts = append(opts, "z")
+		}
+		volume := fmt.Sprintf("%s:%s", m.Source, m.Target)
+		if len(opts) > 0 {
+			volume += ":" + strings.Join(opts, ",")
+		}
+		args = append(args, "-v", volume)
+	}
+	for _, env := range spec.Env {
+		args = append(args, "-e", env)
+	}
+
+	args = append(args, spec.Image)
+	return append(args, spec.Args...)
+}
+
+// Pause freezes all processes of a running container
+func (c *CLI) Pause(name string) error {
+	if output, err := exec.Command(c.Binary, "pause", name).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to pause container %s: %s", name, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// Unpause resumes a container frozen by Pause
+func (c *CLI) Unpause(name string) error {
+	if output, err := exec.Command(c.Binary, "unpause", name).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to unpause container %s: %s", name, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+func (c *CLI) ImageExists(image string) bool {
+	return exec.Command(c.Binary, "image", "inspect", "--format", "{{.Id}}", image).Run() == nil
+}
+
+// ImageResolvable checks that the registry knows the image without pulling it
+func (c *CLI) ImageResolvable(image string) error {
+	if c.ResolveArgs == nil {
+		return nil
+	}
+	args := append(append([]string{}, c.ResolveArgs...), image)
+	output, err := exec.Command(c.Binary, args...).CombinedOutput()
+	if err == nil {
+		return nil
+	}
+	msg := strings.TrimSpace(string(output))
+	// Registries without credentials for a private image, or out of reach,
+	// fail in other ways; only these say the image doesn't exist
+	lower := strings.ToLower(msg)
+	if strings.Contains(lower, "manifest unknown") || strings.Contains(lower, "no such manifest") {
+		return fmt.Errorf("%w: %s: %s", ErrImageNotFound, image, msg)
+	}
+	return fmt.Errorf("unable to resolve image %s: %s", image, msg)
+}
+
+// ImageDigest returns the registry digest of a local image (repo@sha256:...),
+// or its image ID if it was built locally and has none
+func (c *CLI) ImageDigest(image string) (string, error) {
+	cmd := exec.Command(c.Binary, "image", "inspect", "--format", "{{.Id}} {{range .RepoDigests}}{{.}} {{end}}", image)
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to inspect image %s: %w", image, err)
+	}
+
+	fields := strings.Fields(string(output))
+	if len(fields) == 0 {
+		return "", fmt.Errorf("image %s has no ID", image)
+	}
+	if len(fields) > 1 {
+		return fields[1], nil
+	}
+	// Podman prints image IDs without the algorithm
+	if !strings.Contains(fields[0], ":") {
+		return "sha256:" + fields[0], nil
+	}
+	return fields[0], nil
+}
+
+// NetworkIO returns the bytes a running container has received and sent, as
+// reported by its stats
+func (c *CLI) NetworkIO(name string) (rx, tx int64, err error) {
+	cmd := exec.Command(c.Binary, "stats", "--no-stream", "--format", "{{.NetIO}}", name)
+	output, err := cmd.Output()
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to read network stats of container %s: %w", name, err)
+	}
+
+	// e.g. "1.23MB / 456kB"
+	in, out, ok := strings.Cut(strings.TrimSpace(string(output)), " / ")
+	if !ok {
+		return 0, 0, fmt.Errorf("unexpected network stats %q", strings.TrimSpace(string(output)))
+	}
+	if rx, err = parseSize(in); err != nil {
+		return 0, 0, err
+	}
+	if tx, err = parseSize(out); err != nil {
+		return 0, 0, err
+	}
+	return rx, tx, nil
+}
+
+var sizeUnits = map[string]float64{
+	"B":   1,
+	"kB":  1e3,
+	"KB":  1e3,
+	"MB":  1e6,
+	"GB":  1e9,
+	"TB":  1e12,
+	"KiB": 1 << 10,
+	"MiB": 1 << 20,
+	"GiB": 1 << 30,
+	"TiB": 1 << 40,
+}
+
+// parseSize parses a human-readable size as printed by the stats command,
+// e.g. "1.5MB"
+func parseSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	i := strings.IndexFunc(s, func(r rune) bool { return (r < '0' || r > '9') && r != '.' })
+	if i <= 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	value, err := strconv.ParseFloat(s[:i], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	unit, ok := sizeUnits[strings.TrimSpace(s[i:])]
+	if !ok {
+		return 0, fmt.Errorf("invalid size unit in %q", s)
+	}
+	return int64(value * unit), nil
+}
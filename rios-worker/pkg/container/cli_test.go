@@ -0,0 +1,89 @@
+package container
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int64
+		wantErr bool
+	}{
+		{in: "0B", want: 0},
+		{in: "512B", want: 512},
+		{in: "1.5kB", want: 1500},
+		{in: "456KB", want: 456000},
+		{in: "1.23MB", want: 1230000},
+		{in: " 2GB ", want: 2000000000},
+		{in: "1TB", want: 1000000000000},
+		{in: "1KiB", want: 1024},
+		{in: "1.5MiB", want: 1572864},
+		{in: "2GiB", want: 2 << 30},
+		{in: "", wantErr: true},
+		{in: "MB", wantErr: true},
+		{in: "12", wantErr: true},
+		{in: "12XB", wantErr: true},
+		{in: "1.2.3MB", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseSize(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseSize(%q) = %d, want an error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseSize(%q): %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseSize(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestRunArgs(t *testing.T) {
+	spec := &Spec{
+		Name:   "rios-job-1",
+		Image:  "rios/comfyui",
+		Args:   []string{"--output", "/workspace/output/"},
+		Env:    []string{"RIOS_SEED=42"},
+		Labels: map[string]string{"rios.job": "1", "rios.node": "7"},
+		Mounts: []Mount{
+			{Source: "/work/input", Target: "/workspace/input", ReadOnly: true},
+			{Source: "/work/output", Target: "/workspace/output"},
+		},
+		GPUs: true,
+	}
+
+	tests := []struct {
+		name string
+		cli  *CLI
+		want []string
+	}{
+		{
+			name: "docker",
+			cli:  NewDocker(),
+			want: []string{"run", "--rm", "--name", "rios-job-1", "--gpus", "all",
+				"--label", "rios.job=1", "--label", "rios.node=7",
+				"-v", "/work/input:/workspace/input:ro", "-v", "/work/output:/workspace/output",
+				"-e", "RIOS_SEED=42", "rios/comfyui", "--output", "/workspace/output/"},
+		},
+		{
+			name: "podman with SELinux",
+			cli:  &CLI{Binary: "podman", GPUMode: GPUCDI, Relabel: true},
+			want: []string{"run", "--rm", "--name", "rios-job-1", "--device", CDIDevice,
+				"--label", "rios.job=1", "--label", "rios.node=7",
+				"-v", "/work/input:/workspace/input:ro,z", "-v", "/work/output:/workspace/output:z",
+				"-e", "RIOS_SEED=42", "rios/comfyui", "--output", "/workspace/output/"},
+		},
+	}
+	for _, tt := range tests {
+		if got := tt.cli.runArgs(spec); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: runArgs = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package container
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrImageNotFound is returned by ImageResolvable when the registry says the
+// image doesn't exist, as opposed to failing to answer
+var ErrImageNotFound = errors.New("image not found in registry")
+
+// Runtime runs job containers. In production it is a CLI (Docker, Podman or
+// nerdctl); Fake lets the worker run end to end on machines without one.
+type Runtime interface {
+	// Name identifies the runtime in messages, e.g. "podman"
+	Name() string
+	// Check returns an error if the runtime isn't installed or running, or
+	// can't give containers GPUs
+	Check() error
+	// Run runs a container until it exits. Cancelling ctx kills it.
+	Run(ctx context.Context, spec *Spec) error
+	// Pause freezes a running container and Unpause thaws it
+	Pause(name string) error
+	Unpause(name string) error
+	// ImageExists reports whether an image is present locally
+	ImageExists(image string) bool
+	// ImageResolvable returns an error if an image can't be pulled, wrapping
+	// ErrImageNotFound if the registry doesn't know it
+	ImageResolvable(image string) error
+	// ImageDigest identifies the exact content of a local image
+	ImageDigest(image string) (string, error)
+	// NetworkIO returns the bytes a running container has received and sent
+	NetworkIO(name string) (rx, tx int64, err error)
+}
+
+// Spec describes a job container
+type Spec struct {
+	Name   string
+	Image  string
+	Args   []string // passed to the image's entrypoint
+	Env    []string // KEY=value
+	Mounts []Mount
+	Labels map[string]string
+	GPUs   bool // give the container every GPU
+
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// Mount is a host path bind-mounted into the container
+type Mount struct {
+	Source   string
+	Target   string
+	ReadOnly bool
+}
+
+// Where a job container finds its input and writes its output
+const (
+	InputPath  = "/workspace/input"
+	OutputPath = "/workspace/output"
+)
+
+// Labels set on every job container
+const (
+	LabelJobID    = "rios.job_id"
+	LabelTaskType = "rios.task_type"
+)
+
+// ExitError is returned by Run when the container exits with a non-zero code
+type ExitError struct {
+	Code int
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("container exited with code %d", e.Code)
+}
+
+// Target returns the host path mounted at target, or "" if there is none
+func (s *Spec) Target(target string) string {
+	for _, m := range s.Mounts {
+		if m.Target == target {
+			return m.Source
+		}
+	}
+	return ""
+}
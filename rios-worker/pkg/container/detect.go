@@ -0,0 +1,92 @@
+package container
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// CDIDirs are where CDI specs are looked up, static ones first
+var CDIDirs = []string{"/etc/cdi", "/var/run/cdi"}
+
+// New returns the runtime called name: docker, podman, nerdctl or auto to
+// detect the one installed
+func New(name string) (Runtime, error) {
+	switch name {
+	case "auto":
+		return Detect()
+	case "docker":
+		return NewDocker(), nil
+	case "podman":
+		return NewPodman(), nil
+	case "nerdctl":
+		return NewNerdctl(), nil
+	}
+	return nil, fmt.Errorf("unknown container runtime %q", name)
+}
+
+// Detect picks the first of Docker, Podman and nerdctl that is installed and
+// running. If none is running, the first installed one is returned so that
+// its Check explains what is wrong.
+func Detect() (Runtime, error) {
+	var installed []*CLI
+	for _, c := range []*CLI{NewDocker(), NewPodman(), NewNerdctl()} {
+		if _, err := exec.LookPath(c.Binary); err != nil {
+			continue
+		}
+		// podman-docker installs a docker command that runs podman
+		if c.Binary == "docker" && dockerIsPodman() {
+			continue
+		}
+		if exec.Command(c.Binary, "ps").Run() == nil {
+			return c, nil
+		}
+		installed = append(installed, c)
+	}
+	if len(installed) > 0 {
+		return installed[0], nil
+	}
+	return nil, errors.New("no container runtime found. Please install Docker (https://docs.docker.com/get-docker/), Podman or nerdctl")
+}
+
+// selinuxEnabled reports whether SELinux is enabled, i.e. its filesystem
+// is mounted
+func selinuxEnabled() bool {
+	_, err := os.Stat("/sys/fs/selinux/enforce")
+	return err == nil
+}
+
+func dockerIsPodman() bool {
+	output, err := exec.Command("docker", "--version").Output()
+	return err == nil && strings.Contains(strings.ToLower(string(output)), "podman")
+}
+
+// FindCDISpec returns the path of the CDI spec that describes the NVIDIA
+// GPUs
+func FindCDISpec() (string, error) {
+	for _, dir := range CDIDirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			ext := filepath.Ext(entry.Name())
+			if entry.IsDir() || (ext != ".yaml" && ext != ".yml" && ext != ".json") {
+				continue
+			}
+			path := filepath.Join(dir, entry.Name())
+			data, err := os.ReadFile(path)
+			if err != nil {
+				continue
+			}
+			if bytes.Contains(data, []byte("nvidia.com/gpu")) {
+				return path, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("no CDI spec for NVIDIA GPUs in %s. Please generate one: sudo nvidia-ctk cdi generate --output=/etc/cdi/nvidia.yaml", strings.Join(CDIDirs, " or "))
+}
@@ -0,0 +1,318 @@
+package container
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FakeScript tells the fake runtime what the container of each task type
+// does
+type FakeScript struct {
+	Tasks map[string]*FakeTask `json:"tasks"` // by task type, "*" for any other
+
+	// Images that can't be found locally or in a registry
+	MissingImages []string `json:"missing_images,omitempty"`
+}
+
+// FakeTask is what a fake container does: run for a while, print a log,
+// write outputs and exit
+type FakeTask struct {
+	DurationMs int          `json:"duration_ms,omitempty"`
+	ExitCode   int          `json:"exit_code,omitempty"`
+	Log        string       `json:"log,omitempty"` // written to stdout
+	Outputs    []FakeOutput `json:"outputs,omitempty"`
+}
+
+// FakeOutput is a file written by a fake container. Images, videos and
+// checkpoints are valid files of their kind; their content depends on the
+// path and RIOS_SEED, so a rerun with the same seed gives the same hashes.
+type FakeOutput struct {
+	Path            string  `json:"path"` // relative to the output directory
+	Kind            string  `json:"kind"` // image, video, checkpoint or file
+	Width           int     `json:"width,omitempty"`
+	Height          int     `json:"height,omitempty"`
+	DurationSeconds float64 `json:"duration_seconds,omitempty"`
+	Content         string  `json:"content,omitempty"`    // of a file
+	SizeBytes       int64   `json:"size_bytes,omitempty"` // a file is padded to this size
+}
+
+// DefaultFakeScript produces the main output each task type must have
+func DefaultFakeScript() *FakeScript {
+	return &FakeScript{Tasks: map[string]*FakeTask{
+		"comfyui": {
+			DurationMs: 2000,
+			Log:        "fake comfyui: rendering 1 image\n",
+			Outputs:    []FakeOutput{{Path: "ComfyUI_00001_.png", Kind: "image", Width: 512, Height: 512}},
+		},
+		"training": {
+			DurationMs: 5000,
+			Log:        "fake training: 1 epoch\n",
+			Outputs:    []FakeOutput{{Path: "model.safetensors", Kind: "checkpoint"}},
+		},
+		"*": {
+			DurationMs: 1000,
+			Outputs:    []FakeOutput{{Path: "output.txt", Kind: "file", Content: "fake output\n"}},
+		},
+	}}
+}
+
+// LoadFakeScript reads a fake runtime script from a JSON file
+func LoadFakeScript(path string) (*FakeScript, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read fake runtime script: %w", err)
+	}
+	var script FakeScript
+	if err := json.Unmarshal(data, &script); err != nil {
+		return nil, fmt.Errorf("failed to parse fake runtime script: %w", err)
+	}
+	for taskType, task := range script.Tasks {
+		for _, out := range task.Outputs {
+			if !filepath.IsLocal(filepath.FromSlash(out.Path)) {
+				return nil, fmt.Errorf("%s output %q must be relative to the output directory", taskType, out.Path)
+			}
+		}
+	}
+	return &script, nil
+}
+
+// Fake is a Runtime that runs no containers. It waits for the task's
+// duration, honouring pause, and writes the outputs its script lists.
+type Fake struct {
+	Script *FakeScript
+
+	mu     sync.Mutex
+	paused map[string]bool
+}
+
+// NewFake creates a fake runtime; a nil script uses DefaultFakeScript
+func NewFake(script *FakeScript) *Fake {
+	if script == nil {
+		script = DefaultFakeScript()
+	}
+	return &Fake{Script: script, paused: make(map[string]bool)}
+}
+
+func (f *Fake) Name() string { return "fake" }
+
+func (f *Fake) Check() error { return nil }
+
+func (f *Fake) Run(ctx context.Context, spec *Spec) error {
+	task := f.Script.Tasks[spec.Labels[LabelTaskType]]
+	if task == nil {
+		task = f.Script.Tasks["*"]
+	}
+	if task == nil {
+		return fmt.Errorf("fake runtime has no script for task type %q", spec.Labels[LabelTaskType])
+	}
+
+	if task.Log != "" && spec.Stdout != nil {
+		io.WriteString(spec.Stdout, task.Log)
+	}
+
+	// Only time spent unpaused counts towards the duration, like a frozen container
+	remaining := time.Duration(task.DurationMs) * time.Millisecond
+	const tick = 50 * time.Millisecond
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+	for remaining > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			if !f.isPaused(spec.Name) {
+				remaining -= tick
+			}
+		}
+	}
+
+	outputDir := spec.Target(OutputPath)
+	if outputDir == "" {
+		return fmt.Errorf("fake runtime: no %s mount", OutputPath)
+	}
+	seed := envValue(spec.Env, "RIOS_SEED")
+	for _, out := range task.Outputs {
+		if err := writeFakeOutput(filepath.Join(outputDir, filepath.FromSlash(out.Path)), out, seed); err != nil {
+			return fmt.Errorf("fake runtime: %s: %w", out.Path, err)
+		}
+	}
+
+	if task.ExitCode != 0 {
+		return &ExitError{Code: task.ExitCode}
+	}
+	return nil
+}
+
+func (f *Fake) isPaused(name string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.paused[name]
+}
+
+func (f *Fake) Pause(name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.paused[name] = true
+	return nil
+}
+
+func (f *Fake) Unpause(name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.paused, name)
+	return nil
+}
+
+func (f *Fake) ImageExists(image string) bool {
+	return f.ImageResolvable(image) == nil
+}
+
+func (f *Fake) ImageResolvable(image string) error {
+	for _, missing := range f.Script.MissingImages {
+		if missing == image {
+			return fmt.Errorf("%w: %s", ErrImageNotFound, image)
+		}
+	}
+	return nil
+}
+
+func (f *Fake) ImageDigest(image string) (string, error) {
+	sum := sha256.Sum256([]byte(image))
+	return "sha256:" + hex.EncodeToString(sum[:]), nil
+}
+
+func (f *Fake) NetworkIO(name string) (rx, tx int64, err error) {
+	return 0, 0, nil
+}
+
+func envValue(env []string, key string) string {
+	for _, kv := range env {
+		if v, ok := strings.CutPrefix(kv, key+"="); ok {
+			return v
+		}
+	}
+	return ""
+}
+
+// writeFakeOutput writes a valid file of out's kind to path
+func writeFakeOutput(path string, out FakeOutput, seed string) error {
+	// Everything generated derives from the path and seed
+	sum := sha256.Sum256([]byte(out.Path + "\x00" + seed))
+
+	var data []byte
+	switch out.Kind {
+	case "image":
+		data = fakePNG(out.Width, out.Height, sum)
+	case "video":
+		data = fakeMP4(out.Width, out.Height, out.DurationSeconds, sum)
+	case "checkpoint":
+		data = fakeSafetensors(sum)
+	case "file", "":
+		data = []byte(out.Content)
+		if int64(len(data)) < out.SizeBytes {
+			data = append(data, bytes.Repeat([]byte{0}, int(out.SizeBytes)-len(data))...)
+		}
+	default:
+		return fmt.Errorf("unknown output kind %q", out.Kind)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+func fakePNG(width, height int, sum [32]byte) []byte {
+	if width <= 0 {
+		width = 64
+	}
+	if height <= 0 {
+		height = 64
+	}
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{sum[0] + uint8(x), sum[1] + uint8(y), sum[2], 255})
+		}
+	}
+	var buf bytes.Buffer
+	png.Encode(&buf, img)
+	return buf.Bytes()
+}
+
+// fakeMP4 builds the smallest MP4 the output validator accepts: a movie
+// header with the duration, one video track with the frame size and some
+// media data
+func fakeMP4(width, height int, seconds float64, sum [32]byte) []byte {
+	if width <= 0 {
+		width = 512
+	}
+	if height <= 0 {
+		height = 512
+	}
+	if seconds <= 0 {
+		seconds = 2
+	}
+	const timescale = 1000
+
+	mvhd := make([]byte, 100)
+	binary.BigEndian.PutUint32(mvhd[12:], timescale)
+	binary.BigEndian.PutUint32(mvhd[16:], uint32(seconds*timescale))
+	binary.BigEndian.PutUint32(mvhd[20:], 0x00010000) // rate 1.0
+	binary.BigEndian.PutUint16(mvhd[24:], 0x0100)     // volume 1.0
+	binary.BigEndian.PutUint32(mvhd[96:], 2)          // next track ID
+
+	tkhd := make([]byte, 84)
+	binary.BigEndian.PutUint32(tkhd[12:], 1) // track ID
+	binary.BigEndian.PutUint32(tkhd[20:], uint32(seconds*timescale))
+	binary.BigEndian.PutUint32(tkhd[76:], uint32(width)<<16)
+	binary.BigEndian.PutUint32(tkhd[80:], uint32(height)<<16)
+
+	var buf bytes.Buffer
+	buf.Write(mp4Box("ftyp", []byte("isom\x00\x00\x02\x00isommp41")))
+	buf.Write(mp4Box("moov", append(mp4Box("mvhd", mvhd), mp4Box("trak", mp4Box("tkhd", tkhd))...)))
+	buf.Write(mp4Box("mdat", bytes.Repeat(sum[:], 32)))
+	return buf.Bytes()
+}
+
+func mp4Box(typ string, payload []byte) []byte {
+	box := make([]byte, 8, 8+len(payload))
+	binary.BigEndian.PutUint32(box, uint32(8+len(payload)))
+	copy(box[4:], typ)
+	return append(box, payload...)
+}
+
+// fakeSafetensors builds a safetensors file with one small F32 tensor
+func fakeSafetensors(sum [32]byte) []byte {
+	data := bytes.Repeat(sum[:], 4) // 32 float32 values
+	header, _ := json.Marshal(map[string]interface{}{
+		"weight": map[string]interface{}{
+			"dtype":        "F32",
+			"shape":        []int{len(data) / 4},
+			"data_offsets": []int{0, len(data)},
+		},
+	})
+	// The header is padded to 8 bytes like the reference implementation does
+	for len(header)%8 != 0 {
+		header = append(header, ' ')
+	}
+
+	buf := make([]byte, 8, 8+len(header)+len(data))
+	binary.LittleEndian.PutUint64(buf, uint64(len(header)))
+	buf = append(buf, header...)
+	return append(buf, data...)
+}
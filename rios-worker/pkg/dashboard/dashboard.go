@@ -0,0 +1,205 @@
+// Package dashboard draws a full-screen terminal view of a running worker:
+// its status, the job it is running, GPU telemetry, earnings and recent
+// jobs, with the latest log lines below.
+package dashboard
+
+import (
+	"context"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rios/worker/pkg/gpu"
+	"github.com/rios/worker/pkg/history"
+	"github.com/rios/worker/pkg/worker"
+)
+
+const (
+	redrawInterval  = time.Second
+	historyInterval = 5 * time.Second
+	chartDays       = 14
+	recentJobs      = 5
+	maxLogLines     = 200
+)
+
+// ANSI sequences of the alternate screen, which keeps the shell's scrollback
+// intact, and of the cursor
+const (
+	enterScreen = "\x1b[?1049h\x1b[?25l"
+	leaveScreen = "\x1b[?25h\x1b[?1049l"
+)
+
+// Info is what the dashboard shows about the node that doesn't change
+type Info struct {
+	NodeID  int
+	Version string
+	API     string
+	GPU     string
+	Runtime string
+}
+
+// Dashboard is the full-screen view of a worker. Lines written to it are
+// shown in its log pane while it runs and go to the terminal after it has
+// stopped.
+type Dashboard struct {
+	info      Info
+	state     *worker.State
+	telemetry func() []gpu.Sample
+	store     *history.Store
+	out       *os.File
+	stop      func() // set by Start
+
+	mu      sync.Mutex
+	logs    []string
+	partial string
+	running bool
+}
+
+// New returns a dashboard drawn on out
+func New(out *os.File, info Info, state *worker.State, telemetry func() []gpu.Sample, store *history.Store) *Dashboard {
+	return &Dashboard{info: info, state: state, telemetry: telemetry, store: store, out: out}
+}
+
+// Supported reports whether f is a terminal the dashboard can be drawn on
+func Supported(f *os.File) bool {
+	_, _, ok := terminalSize(f)
+	return ok
+}
+
+// Write adds lines to the log pane
+func (d *Dashboard) Write(p []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.running {
+		return d.out.Write(p)
+	}
+
+	lines := strings.Split(d.partial+string(p), "\n")
+	d.partial = lines[len(lines)-1]
+	for _, line := range lines[:len(lines)-1] {
+		if line = strings.TrimRight(line, "\r "); line != "" {
+			d.logs = append(d.logs, line)
+		}
+	}
+	if len(d.logs) > maxLogLines {
+		d.logs = append([]string(nil), d.logs[len(d.logs)-maxLogLines:]...)
+	}
+	return len(p), nil
+}
+
+// Start switches the terminal to the dashboard and redraws it until Stop
+func (d *Dashboard) Start() {
+	d.mu.Lock()
+	d.running = true
+	d.mu.Unlock()
+	io.WriteString(d.out, enterScreen)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	d.stop = func() {
+		cancel()
+		<-done
+	}
+	go func() {
+		defer close(done)
+		d.run(ctx)
+	}()
+}
+
+// Stop restores the terminal; later lines written to the dashboard go to it
+func (d *Dashboard) Stop() {
+	if d.stop == nil {
+		return
+	}
+	d.stop()
+	d.stop = nil
+	io.WriteString(d.out, leaveScreen)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.running = false
+	if d.partial != "" {
+		io.WriteString(d.out, d.partial)
+		d.partial = ""
+	}
+}
+
+func (d *Dashboard) run(ctx context.Context) {
+	resized := make(chan struct{}, 1)
+	stopResize := notifyResize(resized)
+	defer stopResize()
+
+	ticker := time.NewTicker(redrawInterval)
+	defer ticker.Stop()
+
+	var snap snapshot
+	var historyAt time.Time
+	for {
+		now := time.Now()
+		if now.Sub(historyAt) >= historyInterval {
+			d.loadHistory(&snap, now)
+			historyAt = now
+		}
+		d.loadState(&snap, now)
+		d.draw(&snap)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-resized:
+		}
+	}
+}
+
+// loadState fills in what changes from second to second
+func (d *Dashboard) loadState(snap *snapshot, now time.Time) {
+	snap.info = d.info
+	snap.now = now
+	snap.status = d.state.Status()
+	snap.holdReason = d.state.HoldReason()
+	snap.uptime = now.Sub(d.state.StartedAt())
+	snap.jobs = d.state.Jobs()
+	snap.gpus = d.telemetry()
+	snap.sessionJobs, snap.sessionRewards = d.state.Session()
+
+	d.mu.Lock()
+	snap.logs = append(snap.logs[:0], d.logs...)
+	d.mu.Unlock()
+}
+
+// loadHistory fills in the lifetime earnings and recent jobs. Errors leave
+// the previous values, the dashboard has nowhere to show them.
+func (d *Dashboard) loadHistory(snap *snapshot, now time.Time) {
+	if jobs, rewards, err := d.store.TotalEarnings(); err == nil {
+		snap.lifetimeJobs, snap.lifetimeRewards = jobs, rewards
+	}
+	if recent, err := d.store.RecentJobs(recentJobs); err == nil {
+		snap.recent = recent
+	}
+
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	first := today.AddDate(0, 0, -(chartDays - 1))
+	rows, err := d.store.EarningsSince(first)
+	if err != nil {
+		return
+	}
+	byDate := make(map[string]float64)
+	for _, row := range rows {
+		byDate[row.Date] += row.Paid + row.Pending
+	}
+	snap.daily = snap.daily[:0]
+	for day := first; !day.After(today); day = day.AddDate(0, 0, 1) {
+		snap.daily = append(snap.daily, dayEarnings{date: day, rewards: byDate[day.Format("2006-01-02")]})
+	}
+}
+
+func (d *Dashboard) draw(snap *snapshot) {
+	width, height, ok := terminalSize(d.out)
+	if !ok {
+		width, height = 80, 24
+	}
+	io.WriteString(d.out, render(snap, width, height))
+}
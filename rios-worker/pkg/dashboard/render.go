@@ -0,0 +1,321 @@
+package dashboard
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/rios/worker/pkg/gpu"
+	"github.com/rios/worker/pkg/history"
+	"github.com/rios/worker/pkg/worker"
+)
+
+const chartHeight = 4
+
+// phases are the steps of a job shown in the phase line
+var phases = []string{worker.PhaseDownloading, worker.PhaseRunning, worker.PhaseValidating, worker.PhaseUploading}
+
+var statusIcons = map[string]string{
+	worker.StatusOnline:   "🟢",
+	worker.StatusBusy:     "🔵",
+	worker.StatusPaused:   "🟡",
+	worker.StatusDraining: "🟠",
+}
+
+// Text styles
+const (
+	styleNone    = ""
+	styleBold    = "\x1b[1m"
+	styleDim     = "\x1b[2m"
+	styleHeading = "\x1b[1;36m"
+	styleReset   = "\x1b[0m"
+)
+
+// snapshot is everything one frame shows
+type snapshot struct {
+	info       Info
+	now        time.Time
+	status     string
+	holdReason string
+	uptime     time.Duration
+	jobs       []worker.JobInfo
+	gpus       []gpu.Sample
+
+	sessionJobs     int
+	sessionRewards  float64
+	lifetimeJobs    int
+	lifetimeRewards float64
+	daily           []dayEarnings // oldest first, ending today
+	recent          []history.JobRecord
+
+	logs []string
+}
+
+type dayEarnings struct {
+	date    time.Time
+	rewards float64
+}
+
+type line struct {
+	text  string
+	style string
+}
+
+// frame collects the lines of one frame
+type frame struct {
+	lines []line
+}
+
+func (f *frame) add(style, format string, args ...interface{}) {
+	f.lines = append(f.lines, line{text: fmt.Sprintf(format, args...), style: style})
+}
+
+func (f *frame) heading(title string) {
+	f.add(styleNone, "")
+	f.add(styleHeading, "%s", title)
+}
+
+// render returns the escape sequences that draw snap on a terminal of
+// width columns and height rows
+func render(snap *snapshot, width, height int) string {
+	f := &frame{}
+	renderHeader(f, snap, width)
+	renderJobs(f, snap)
+	renderGPUs(f, snap)
+	renderEarnings(f, snap)
+	renderRecent(f, snap)
+
+	// The log pane gets the rows that are left
+	if free := height - len(f.lines) - 2; free > 0 && len(snap.logs) > 0 {
+		f.heading("Log")
+		logs := snap.logs
+		if len(logs) > free {
+			logs = logs[len(logs)-free:]
+		}
+		for _, l := range logs {
+			f.add(styleNone, "%s", l)
+		}
+	}
+	if len(f.lines) > height {
+		f.lines = f.lines[:height]
+	}
+
+	var b strings.Builder
+	b.WriteString("\x1b[H")
+	for i, l := range f.lines {
+		if i > 0 {
+			b.WriteString("\r\n")
+		}
+		text := fit(l.text, width)
+		if l.style != styleNone {
+			text = l.style + text + styleReset
+		}
+		b.WriteString(text)
+		b.WriteString("\x1b[K")
+	}
+	b.WriteString("\x1b[J")
+	return b.String()
+}
+
+func renderHeader(f *frame, snap *snapshot, width int) {
+	title := fmt.Sprintf("RiOS Worker %s · node %d", snap.info.Version, snap.info.NodeID)
+	clock := snap.now.Format("15:04:05") + " · Ctrl+C to stop"
+	gap := width - cellWidth(title) - cellWidth(clock)
+	if gap < 2 {
+		gap = 2
+	}
+	f.add(styleBold, "%s%s%s", title, strings.Repeat(" ", gap), clock)
+
+	icon := statusIcons[snap.status]
+	if icon == "" {
+		icon = "⚪"
+	}
+	f.add(styleNone, "%s %s · up %s · %s · %s", icon, snap.status, snap.uptime.Round(time.Second), snap.info.Runtime, snap.info.API)
+	f.add(styleNone, "   GPU: %s", snap.info.GPU)
+	if snap.holdReason != "" {
+		f.add(styleNone, "⏸  Not taking jobs: %s", snap.holdReason)
+	}
+}
+
+func renderJobs(f *frame, snap *snapshot) {
+	f.heading("Current job")
+	if len(snap.jobs) == 0 {
+		f.add(styleDim, "   No job running")
+		return
+	}
+	for _, job := range snap.jobs {
+		f.add(styleBold, "   %s  %s  %s", job.JobID, job.TaskType, job.DockerImage)
+		f.add(styleNone, "   %s   %s", phaseLine(job.Phase), snap.now.Sub(job.StartedAt).Round(time.Second))
+
+		if job.DownloadedBytes == 0 && job.Phase != worker.PhaseDownloading {
+			continue
+		}
+		if job.InputBytes > 0 {
+			done := float64(job.DownloadedBytes) / float64(job.InputBytes)
+			f.add(styleNone, "   Download %s %3.0f%%  %s of %s", bar(done, 20), math.Min(done, 1)*100,
+				formatGB(job.DownloadedBytes), formatGB(job.InputBytes))
+		} else {
+			f.add(styleNone, "   Download %s", formatGB(job.DownloadedBytes))
+		}
+	}
+}
+
+// phaseLine marks the phases a job has been through, is in and has ahead
+func phaseLine(phase string) string {
+	current := -1
+	for i, p := range phases {
+		if p == phase {
+			current = i
+		}
+	}
+	parts := make([]string, len(phases))
+	for i, p := range phases {
+		switch {
+		case i < current:
+			parts[i] = "✓ " + p
+		case i == current:
+			parts[i] = "▶ " + p
+		default:
+			parts[i] = "· " + p
+		}
+	}
+	return strings.Join(parts, "  ")
+}
+
+func renderGPUs(f *frame, snap *snapshot) {
+	f.heading("GPUs")
+	if len(snap.gpus) == 0 {
+		f.add(styleDim, "   No telemetry yet")
+		return
+	}
+	for _, s := range snap.gpus {
+		text := fmt.Sprintf("   GPU %d %s %3.0f%%  mem %.1f/%.1f GB  %.0f°C  %.0f/%.0f W",
+			s.Index, bar(s.UtilizationPct/100, 10), s.UtilizationPct,
+			s.MemoryUsedMiB/1024, s.MemoryTotalMiB/1024, s.TemperatureC, s.PowerDrawW, s.PowerLimitW)
+		if s.Throttled() {
+			text += "  throttled (" + strings.Join(s.ThrottleReasons, ", ") + ")"
+		}
+		f.add(styleNone, "%s", text)
+	}
+}
+
+func renderEarnings(f *frame, snap *snapshot) {
+	f.heading("Earnings")
+	f.add(styleNone, "   Session   %4d jobs  %.8f $ROS", snap.sessionJobs, snap.sessionRewards)
+	f.add(styleNone, "   Lifetime  %4d jobs  %.8f $ROS", snap.lifetimeJobs, snap.lifetimeRewards)
+	if len(snap.daily) == 0 {
+		return
+	}
+
+	// One column per day, scaled to the best day
+	var best float64
+	for _, d := range snap.daily {
+		best = math.Max(best, d.rewards)
+	}
+	const eighths = " ▁▂▃▄▅▆▇█"
+	blocks := []rune(eighths)
+	for row := chartHeight - 1; row >= 0; row-- {
+		var b strings.Builder
+		b.WriteString("   ")
+		for _, d := range snap.daily {
+			level := 0
+			if best > 0 {
+				level = int(math.Round(d.rewards / best * chartHeight * 8))
+			}
+			level -= row * 8
+			switch {
+			case level >= 8:
+				b.WriteRune(blocks[8])
+			case level <= 0 && row == 0:
+				b.WriteRune('·')
+			case level <= 0:
+				b.WriteRune(' ')
+			default:
+				b.WriteRune(blocks[level])
+			}
+			b.WriteRune(' ')
+		}
+		if row == chartHeight-1 {
+			fmt.Fprintf(&b, "  best day %.8f $ROS", best)
+		}
+		f.add(styleNone, "%s", b.String())
+	}
+	first := snap.daily[0].date.Format("01-02")
+	f.add(styleDim, "   %-*s%s", 2*len(snap.daily)-len("today"), first, "today")
+}
+
+func renderRecent(f *frame, snap *snapshot) {
+	f.heading("Recent jobs")
+	if len(snap.recent) == 0 {
+		f.add(styleDim, "   No jobs yet")
+		return
+	}
+	for _, rec := range snap.recent {
+		reward := ""
+		if rec.Reward > 0 {
+			reward = fmt.Sprintf("%.8f $ROS", rec.Reward)
+		}
+		f.add(styleNone, "   %s  %-16s  %-10s  %-9s  %8s  %s",
+			rec.StartedAt.Format("01-02 15:04"), fit(rec.JobID, 16), rec.TaskType, rec.Status,
+			rec.Duration().Round(time.Second), reward)
+	}
+}
+
+// bar draws a progress bar of width cells filled to fraction
+func bar(fraction float64, width int) string {
+	fraction = math.Max(0, math.Min(fraction, 1))
+	filled := int(math.Round(fraction * float64(width)))
+	return "[" + strings.Repeat("█", filled) + strings.Repeat("░", width-filled) + "]"
+}
+
+func formatGB(n int64) string {
+	return fmt.Sprintf("%.1f GB", float64(n)/(1<<30))
+}
+
+// fit cuts s to width terminal cells
+func fit(s string, width int) string {
+	if cellWidth(s) <= width {
+		return s
+	}
+	var b strings.Builder
+	used := 0
+	for _, r := range s {
+		w := runeWidth(r)
+		if used+w > width-1 {
+			break
+		}
+		b.WriteRune(r)
+		used += w
+	}
+	b.WriteString("…")
+	return b.String()
+}
+
+// cellWidth returns how many terminal cells s takes
+func cellWidth(s string) int {
+	n := 0
+	for _, r := range s {
+		n += runeWidth(r)
+	}
+	return n
+}
+
+// runeWidth approximates the cells a rune takes: two for emoji and East
+// Asian wide characters, none for combining marks and variation selectors
+func runeWidth(r rune) int {
+	switch {
+	case r == 0xFE0F || r == 0x200D || unicode.Is(unicode.Mn, r):
+		return 0
+	case r >= 0x1F000 && r <= 0x1FAFF,
+		r >= 0x1100 && r <= 0x115F,
+		r >= 0x2E80 && r <= 0xA4CF,
+		r >= 0xAC00 && r <= 0xD7A3,
+		r >= 0xF900 && r <= 0xFAFF,
+		r >= 0xFF00 && r <= 0xFF60,
+		r >= 0x20000 && r <= 0x3FFFD:
+		return 2
+	}
+	return 1
+}
@@ -0,0 +1,15 @@
+//go:build windows
+
+package dashboard
+
+import "os"
+
+// terminalSize reports no terminal: the dashboard isn't supported on
+// Windows consoles yet and the worker logs as usual
+func terminalSize(f *os.File) (width, height int, ok bool) {
+	return 0, 0, false
+}
+
+func notifyResize(ch chan struct{}) (stop func()) {
+	return func() {}
+}
@@ -0,0 +1,114 @@
+package devserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/rios/worker/pkg/api"
+)
+
+// Fault makes matching API requests misbehave. A fault with a Delay and no
+// Status or Drop slows requests down but still handles them.
+type Fault struct {
+	Endpoint    string  `json:"endpoint,omitempty"`    // e.g. "heartbeat" or "get-job"; empty for every endpoint
+	Status      int     `json:"status,omitempty"`      // answer with this HTTP status instead of handling the request
+	Drop        bool    `json:"drop,omitempty"`        // close the connection without answering
+	DelayMs     int     `json:"delay_ms,omitempty"`    // wait before answering
+	Probability float64 `json:"probability,omitempty"` // chance of applying to a request, 0 for always
+	Times       int     `json:"times,omitempty"`       // requests it applies to before expiring, 0 for no limit
+}
+
+// AddFault starts injecting f
+func (s *Server) AddFault(f Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = append(s.faults, &f)
+}
+
+// ClearFaults stops all fault injection
+func (s *Server) ClearFaults() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = nil
+}
+
+// injectFault applies the first fault matching endpoint and reports whether
+// it answered the request
+func (s *Server) injectFault(endpoint string, w http.ResponseWriter) bool {
+	s.mu.Lock()
+	var fault *Fault
+	for i, f := range s.faults {
+		if f.Endpoint != "" && f.Endpoint != endpoint {
+			continue
+		}
+		if f.Probability > 0 && s.rand.Float64() >= f.Probability {
+			continue
+		}
+		fault = f
+		if f.Times > 0 {
+			f.Times--
+			if f.Times == 0 {
+				s.faults = append(s.faults[:i], s.faults[i+1:]...)
+			}
+		}
+		break
+	}
+	s.mu.Unlock()
+
+	if fault == nil {
+		return false
+	}
+	if fault.DelayMs > 0 {
+		time.Sleep(time.Duration(fault.DelayMs) * time.Millisecond)
+	}
+
+	switch {
+	case fault.Drop:
+		s.logf("fault: dropping %s request", endpoint)
+		if hj, ok := w.(http.Hijacker); ok {
+			if conn, _, err := hj.Hijack(); err == nil {
+				conn.Close()
+				return true
+			}
+		}
+		// Not hijackable (e.g. HTTP/2): the closest thing is an empty 502
+		w.WriteHeader(http.StatusBadGateway)
+		return true
+	case fault.Status != 0:
+		s.logf("fault: answering %s with HTTP %d", endpoint, fault.Status)
+		writeError(w, fault.Status, "injected fault")
+		return true
+	}
+	return false
+}
+
+// Script is a scenario for the server: jobs to queue and faults to inject
+// from the start
+type Script struct {
+	Jobs   []*api.Job `json:"jobs"`
+	Faults []Fault    `json:"faults"`
+}
+
+// LoadScript reads a script from a JSON file
+func LoadScript(path string) (*Script, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read script: %w", err)
+	}
+	var script Script
+	if err := json.Unmarshal(data, &script); err != nil {
+		return nil, fmt.Errorf("failed to parse script: %w", err)
+	}
+	return &script, nil
+}
+
+// Apply queues the script's jobs and adds its faults
+func (s *Server) Apply(script *Script) {
+	s.Enqueue(script.Jobs...)
+	for _, f := range script.Faults {
+		s.AddFault(f)
+	}
+}
@@ -0,0 +1,651 @@
+package devserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rios/worker/pkg/api"
+	"github.com/rios/worker/pkg/receipt"
+)
+
+// Server is an in-memory orchestrator implementing the worker API. Jobs are
+// queued with Enqueue or POST /dev/jobs and handed out in order; faults
+// added with AddFault make endpoints fail, stall or drop connections.
+type Server struct {
+	mu         sync.Mutex
+	nodes      map[string]*Node // by auth token
+	queue      []*queuedJob
+	running    map[string]*queuedJob // by job ID
+	results    []Result
+	rejections []Rejection
+	faults     []*Fault
+	nextNodeID int
+	nextJobID  int
+	rand       *rand.Rand
+
+	handler http.Handler
+
+	// Logf reports every request; nil for no logging
+	Logf func(format string, args ...interface{})
+
+	// MinVersion is the oldest worker version heartbeats accept, if any
+	MinVersion string
+
+	// Protocol versions the server speaks and the feature flags it reports
+	// in the handshake. Requests outside the range get 426 Upgrade Required.
+	MinProtocol int
+	MaxProtocol int
+	Features    []string
+}
+
+// Node is a registered worker
+type Node struct {
+	ID             int                        `json:"id"`
+	Token          string                     `json:"-"`
+	Registration   api.RegisterRequest        `json:"registration"`
+	Hardware       *api.UpdateHardwareRequest `json:"hardware,omitempty"`
+	Status         string                     `json:"status,omitempty"`
+	Heartbeats     int                        `json:"heartbeats"`
+	LastHeartbeat  time.Time                  `json:"last_heartbeat,omitempty"`
+	CachedInputs   []string                   `json:"cached_inputs,omitempty"`
+	TelemetryError string                     `json:"telemetry_error,omitempty"`
+	WorkerVersion  string                     `json:"worker_version,omitempty"`
+	Capabilities   []string                   `json:"capabilities,omitempty"`
+}
+
+// Result is a submitted job result
+type Result struct {
+	NodeID       int                     `json:"node_id"`
+	TaskType     string                  `json:"task_type"`
+	AssignedAt   time.Time               `json:"assigned_at"`
+	ReceivedAt   time.Time               `json:"received_at"`
+	Reward       float64                 `json:"reward"` // paid for completed jobs
+	Request      api.SubmitResultRequest `json:"request"`
+	ReceiptError string                  `json:"receipt_error,omitempty"` // why the receipt failed to verify
+}
+
+// Rejection is a job a node handed back
+type Rejection struct {
+	NodeID  int                  `json:"node_id"`
+	Request api.RejectJobRequest `json:"request"`
+}
+
+type queuedJob struct {
+	job        *api.Job
+	nodeID     int          // node running the job
+	assignedAt time.Time    // when the node got it
+	rejectedBy map[int]bool // nodes that declined it, so it goes to another one
+}
+
+// New creates an empty server
+func New() *Server {
+	s := &Server{
+		nodes:   make(map[string]*Node),
+		running: make(map[string]*queuedJob),
+		rand:    rand.New(rand.NewSource(time.Now().UnixNano())),
+
+		MinProtocol: api.ProtocolVersion,
+		MaxProtocol: api.ProtocolVersion,
+		Features:    []string{api.CapRejectJob, api.CapJobHints, api.CapCachedInputs, api.CapReceipts},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/worker/handshake", s.route("handshake", false, s.handleHandshake))
+	mux.HandleFunc("/api/worker/register", s.route("register", false, s.handleRegister))
+	mux.HandleFunc("/api/worker/heartbeat", s.route("heartbeat", true, s.handleHeartbeat))
+	mux.HandleFunc("/api/worker/get-job", s.route("get-job", true, s.handleGetJob))
+	mux.HandleFunc("/api/worker/submit-result", s.route("submit-result", true, s.handleSubmitResult))
+	mux.HandleFunc("/api/worker/reject-job", s.route("reject-job", true, s.handleRejectJob))
+	mux.HandleFunc("/api/worker/update-hardware", s.route("update-hardware", true, s.handleUpdateHardware))
+	mux.HandleFunc("/api/worker/status", s.route("status", true, s.handleStatus))
+	mux.HandleFunc("/api/worker/earnings", s.route("earnings", true, s.handleEarnings))
+	mux.HandleFunc("/api/worker/jobs", s.route("jobs", true, s.handleJobs))
+	mux.HandleFunc("/dev/jobs", s.handleDevJobs)
+	mux.HandleFunc("/dev/faults", s.handleDevFaults)
+	mux.HandleFunc("/dev/state", s.handleDevState)
+	s.handler = mux
+	return s
+}
+
+// ServeHTTP serves the worker API and the /dev control endpoints
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.handler.ServeHTTP(w, r)
+}
+
+// Enqueue adds jobs to the end of the queue. Jobs without an ID get one.
+func (s *Server) Enqueue(jobs ...*api.Job) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, job := range jobs {
+		if job.JobID == "" {
+			s.nextJobID++
+			job.JobID = fmt.Sprintf("dev-job-%d", s.nextJobID)
+		}
+		if job.Payload == nil {
+			job.Payload = &api.JobPayload{}
+		}
+		s.queue = append(s.queue, &queuedJob{job: job, rejectedBy: make(map[int]bool)})
+	}
+}
+
+// Results returns the results submitted so far
+func (s *Server) Results() []Result {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Result(nil), s.results...)
+}
+
+// Snapshot is the state of the server as shown by GET /dev/state
+type Snapshot struct {
+	Nodes      []Node      `json:"nodes"`
+	Queued     []*api.Job  `json:"queued"`
+	Running    []string    `json:"running"`
+	Results    []Result    `json:"results"`
+	Rejections []Rejection `json:"rejections"`
+	Faults     []Fault     `json:"faults"`
+}
+
+// Snapshot returns a copy of the server state
+func (s *Server) Snapshot() *Snapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	snap := &Snapshot{
+		Nodes:      []Node{},
+		Queued:     []*api.Job{},
+		Running:    []string{},
+		Results:    append([]Result{}, s.results...),
+		Rejections: append([]Rejection{}, s.rejections...),
+		Faults:     []Fault{},
+	}
+	for _, node := range s.nodes {
+		snap.Nodes = append(snap.Nodes, *node)
+	}
+	for _, q := range s.queue {
+		snap.Queued = append(snap.Queued, q.job)
+	}
+	for id := range s.running {
+		snap.Running = append(snap.Running, id)
+	}
+	for _, f := range s.faults {
+		snap.Faults = append(snap.Faults, *f)
+	}
+	return snap
+}
+
+// route wraps an API handler with fault injection, logging and, if auth is
+// set, bearer token authentication
+func (s *Server) route(endpoint string, auth bool, handle func(w http.ResponseWriter, r *http.Request, node *Node)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.logf("%s %s", r.Method, r.URL.Path)
+		if s.injectFault(endpoint, w) {
+			return
+		}
+		// The handshake answers every worker, so it can learn what to upgrade to
+		if endpoint != "handshake" && !s.checkProtocol(w, r) {
+			return
+		}
+
+		var node *Node
+		if auth {
+			token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			s.mu.Lock()
+			node = s.nodes[token]
+			if node != nil {
+				node.WorkerVersion, node.Capabilities = workerHeaders(r)
+			}
+			s.mu.Unlock()
+			if node == nil {
+				writeError(w, http.StatusUnauthorized, "unknown node token")
+				return
+			}
+		}
+		handle(w, r, node)
+	}
+}
+
+// checkProtocol answers requests in a protocol the server doesn't speak.
+// Workers that predate the protocol header speak protocol 1.
+func (s *Server) checkProtocol(w http.ResponseWriter, r *http.Request) bool {
+	protocol := 1
+	if header := r.Header.Get(api.HeaderProtocol); header != "" {
+		n, err := strconv.Atoi(header)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid %s header %q", api.HeaderProtocol, header))
+			return false
+		}
+		protocol = n
+	}
+
+	switch {
+	case protocol < s.MinProtocol:
+		writeJSON(w, http.StatusUpgradeRequired, api.UpgradeRequiredError{
+			Message:     fmt.Sprintf("protocol %d is no longer supported", protocol),
+			MinProtocol: s.MinProtocol,
+			MinVersion:  s.MinVersion,
+		})
+		return false
+	case protocol > s.MaxProtocol:
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("protocol %d is newer than this server's %d", protocol, s.MaxProtocol))
+		return false
+	}
+	return true
+}
+
+// workerHeaders returns the version and capabilities a worker sent
+func workerHeaders(r *http.Request) (string, []string) {
+	var capabilities []string
+	if header := r.Header.Get(api.HeaderCapabilities); header != "" {
+		capabilities = strings.Split(header, ",")
+	}
+	return r.Header.Get(api.HeaderWorkerVersion), capabilities
+}
+
+func (s *Server) handleHandshake(w http.ResponseWriter, r *http.Request, _ *Node) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	writeJSON(w, http.StatusOK, api.HandshakeResponse{
+		Success:     true,
+		Message:     "dev server",
+		MinProtocol: s.MinProtocol,
+		MaxProtocol: s.MaxProtocol,
+		Features:    s.Features,
+		MinVersion:  s.MinVersion,
+	})
+}
+
+func (s *Server) handleRegister(w http.ResponseWriter, r *http.Request, _ *Node) {
+	var req api.RegisterRequest
+	if !decode(w, r, http.MethodPost, &req) {
+		return
+	}
+	if req.RosWalletAddress == "" {
+		writeError(w, http.StatusBadRequest, "wallet address is required")
+		return
+	}
+
+	s.mu.Lock()
+	s.nextNodeID++
+	node := &Node{
+		ID:           s.nextNodeID,
+		Token:        fmt.Sprintf("dev-token-%d", s.nextNodeID),
+		Registration: req,
+	}
+	node.WorkerVersion, node.Capabilities = workerHeaders(r)
+	s.nodes[node.Token] = node
+	s.mu.Unlock()
+
+	s.logf("registered node %d (%d x %s)", node.ID, req.GPUCount, req.GPUType)
+	writeJSON(w, http.StatusOK, api.RegisterResponse{
+		Success:       true,
+		NodeID:        node.ID,
+		NodeAuthToken: node.Token,
+		Message:       "registered with dev server",
+		Status:        "approved",
+	})
+}
+
+func (s *Server) handleHeartbeat(w http.ResponseWriter, r *http.Request, node *Node) {
+	var req api.HeartbeatRequest
+	if !decode(w, r, http.MethodPost, &req) {
+		return
+	}
+
+	s.mu.Lock()
+	node.Status = req.Status
+	node.Heartbeats++
+	node.LastHeartbeat = time.Now()
+	node.CachedInputs = req.CachedInputs
+	node.TelemetryError = req.TelemetryError
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, api.HeartbeatResponse{Success: true, Message: "ok", MinVersion: s.MinVersion})
+}
+
+// handleGetJob hands out the first queued job this node hasn't declined
+func (s *Server) handleGetJob(w http.ResponseWriter, r *http.Request, node *Node) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	s.mu.Lock()
+	var job *api.Job
+	for i, q := range s.queue {
+		if q.rejectedBy[node.ID] {
+			continue
+		}
+		s.queue = append(s.queue[:i], s.queue[i+1:]...)
+		q.nodeID = node.ID
+		q.assignedAt = time.Now()
+		s.running[q.job.JobID] = q
+		job = q.job
+		break
+	}
+	s.mu.Unlock()
+
+	if job == nil {
+		writeJSON(w, http.StatusOK, api.GetJobResponse{Success: true, Message: "no jobs available"})
+		return
+	}
+	s.logf("assigned job %s (%s) to node %d", job.JobID, job.TaskType, node.ID)
+	writeJSON(w, http.StatusOK, api.GetJobResponse{Success: true, Job: job})
+}
+
+func (s *Server) handleSubmitResult(w http.ResponseWriter, r *http.Request, node *Node) {
+	var req api.SubmitResultRequest
+	if !decode(w, r, http.MethodPost, &req) {
+		return
+	}
+
+	s.mu.Lock()
+	q, ok := s.running[req.JobID]
+	if !ok || q.nodeID != node.ID {
+		s.mu.Unlock()
+		writeError(w, http.StatusNotFound, fmt.Sprintf("job %s is not assigned to this node", req.JobID))
+		return
+	}
+	delete(s.running, req.JobID)
+	result := Result{
+		NodeID:     node.ID,
+		TaskType:   q.job.TaskType,
+		AssignedAt: q.assignedAt,
+		ReceivedAt: time.Now(),
+		Request:    req,
+	}
+	if req.Status == "completed" {
+		result.Reward = q.job.Reward
+	}
+	if err := checkReceipt(req.Receipt, node, req.JobID); err != nil {
+		result.ReceiptError = err.Error()
+	}
+	s.results = append(s.results, result)
+	s.mu.Unlock()
+
+	resp := api.SubmitResultResponse{Success: true, Message: "result recorded", RewardPaid: result.Reward}
+	if req.Status == "completed" {
+		resp.RewardStatus = "paid"
+	}
+	s.logf("node %d submitted job %s: %s", node.ID, req.JobID, req.Status)
+	if result.ReceiptError != "" {
+		s.logf("receipt of job %s is invalid: %s", req.JobID, result.ReceiptError)
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// checkReceipt verifies a result's receipt against the node's registered key
+func checkReceipt(signed *api.SignedReceipt, node *Node, jobID string) error {
+	if signed == nil {
+		return fmt.Errorf("no receipt")
+	}
+	rcpt, err := receipt.Verify(signed)
+	if err != nil {
+		return err
+	}
+	if node.Registration.PublicKey != "" && signed.PublicKey != node.Registration.PublicKey {
+		return fmt.Errorf("signed by a key the node didn't register")
+	}
+	if rcpt.JobID != jobID {
+		return fmt.Errorf("receipt is for job %s", rcpt.JobID)
+	}
+	return nil
+}
+
+// handleRejectJob puts a declined job back in the queue for other nodes
+func (s *Server) handleRejectJob(w http.ResponseWriter, r *http.Request, node *Node) {
+	var req api.RejectJobRequest
+	if !decode(w, r, http.MethodPost, &req) {
+		return
+	}
+
+	s.mu.Lock()
+	q, ok := s.running[req.JobID]
+	if !ok || q.nodeID != node.ID {
+		s.mu.Unlock()
+		writeError(w, http.StatusNotFound, fmt.Sprintf("job %s is not assigned to this node", req.JobID))
+		return
+	}
+	delete(s.running, req.JobID)
+	q.rejectedBy[node.ID] = true
+	s.queue = append(s.queue, q)
+	s.rejections = append(s.rejections, Rejection{NodeID: node.ID, Request: req})
+	s.mu.Unlock()
+
+	s.logf("node %d declined job %s: %s", node.ID, req.JobID, req.Reason)
+	writeJSON(w, http.StatusOK, api.RejectJobResponse{Success: true})
+}
+
+func (s *Server) handleUpdateHardware(w http.ResponseWriter, r *http.Request, node *Node) {
+	var req api.UpdateHardwareRequest
+	if !decode(w, r, http.MethodPost, &req) {
+		return
+	}
+
+	s.mu.Lock()
+	node.Hardware = &req
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, api.UpdateHardwareResponse{Success: true})
+}
+
+// handleStatus reports the node as last seen in heartbeats
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request, node *Node) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	s.mu.Lock()
+	resp := api.NodeStatusResponse{
+		Success:  true,
+		NodeID:   node.ID,
+		Status:   node.Status,
+		GPUType:  node.Registration.GPUType,
+		GPUVram:  node.Registration.GPUVram,
+		GPUCount: node.Registration.GPUCount,
+	}
+	if node.Hardware != nil {
+		resp.GPUType, resp.GPUVram, resp.GPUCount = node.Hardware.GPUType, node.Hardware.GPUVram, node.Hardware.GPUCount
+	}
+	if !node.LastHeartbeat.IsZero() {
+		resp.LastHeartbeat = node.LastHeartbeat.Format(time.RFC3339)
+	}
+	for id, q := range s.running {
+		if q.nodeID == node.ID {
+			resp.CurrentJobID = id
+		}
+	}
+	s.mu.Unlock()
+
+	if resp.Status == "" {
+		resp.Status = "offline"
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// handleEarnings sums the rewards of the node's results by day and task
+// type; the dev server pays rewards right away
+func (s *Server) handleEarnings(w http.ResponseWriter, r *http.Request, node *Node) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	days, err := queryInt(r, "days", 30)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	now := time.Now()
+	since := time.Date(now.Year(), now.Month(), now.Day()-days+1, 0, 0, 0, 0, now.Location())
+
+	resp := api.EarningsResponse{Success: true, Days: []api.EarningsDay{}}
+	byKey := make(map[[2]string]int) // date, task type -> index in Days
+	s.mu.Lock()
+	for _, result := range s.results {
+		if result.NodeID != node.ID || result.Request.Status != "completed" || result.ReceivedAt.Before(since) {
+			continue
+		}
+		key := [2]string{result.ReceivedAt.Format("2006-01-02"), result.TaskType}
+		i, ok := byKey[key]
+		if !ok {
+			i = len(resp.Days)
+			byKey[key] = i
+			resp.Days = append(resp.Days, api.EarningsDay{Date: key[0], TaskType: key[1]})
+		}
+		resp.Days[i].Jobs++
+		resp.Days[i].Paid += result.Reward
+		resp.TotalPaid += result.Reward
+	}
+	s.mu.Unlock()
+
+	sort.Slice(resp.Days, func(i, j int) bool {
+		if resp.Days[i].Date != resp.Days[j].Date {
+			return resp.Days[i].Date > resp.Days[j].Date
+		}
+		return resp.Days[i].TaskType < resp.Days[j].TaskType
+	})
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// handleJobs lists the node's results, most recent first
+func (s *Server) handleJobs(w http.ResponseWriter, r *http.Request, node *Node) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	limit, err := queryInt(r, "limit", 20)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	resp := api.JobHistoryResponse{Success: true, Jobs: []api.JobSummary{}}
+	s.mu.Lock()
+	for i := len(s.results) - 1; i >= 0 && len(resp.Jobs) < limit; i-- {
+		result := s.results[i]
+		if result.NodeID != node.ID {
+			continue
+		}
+		job := api.JobSummary{
+			JobID:           result.Request.JobID,
+			TaskType:        result.TaskType,
+			Status:          result.Request.Status,
+			StartedAt:       result.AssignedAt.Format(time.RFC3339),
+			FinishedAt:      result.ReceivedAt.Format(time.RFC3339),
+			DurationSeconds: result.ReceivedAt.Sub(result.AssignedAt).Seconds(),
+			Reward:          result.Reward,
+			Traffic:         result.Request.Traffic,
+		}
+		if result.Reward > 0 {
+			job.RewardStatus = "paid"
+		}
+		resp.Jobs = append(resp.Jobs, job)
+	}
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// queryInt returns the positive integer query parameter name, or def if
+// it is not set
+func queryInt(r *http.Request, name string, def int) (int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid %s %q", name, value)
+	}
+	return n, nil
+}
+
+// handleDevJobs serves POST /dev/jobs, which queues a job or a list of jobs
+func (s *Server) handleDevJobs(w http.ResponseWriter, r *http.Request) {
+	var raw json.RawMessage
+	if !decode(w, r, http.MethodPost, &raw) {
+		return
+	}
+	var jobs []*api.Job
+	if err := json.Unmarshal(raw, &jobs); err != nil {
+		var job api.Job
+		if err := json.Unmarshal(raw, &job); err != nil {
+			writeError(w, http.StatusBadRequest, "expected a job or a list of jobs")
+			return
+		}
+		jobs = []*api.Job{&job}
+	}
+
+	s.Enqueue(jobs...)
+	ids := make([]string, len(jobs))
+	for i, job := range jobs {
+		ids[i] = job.JobID
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"success": true, "job_ids": ids})
+}
+
+// handleDevFaults serves POST /dev/faults to add a fault and DELETE to clear them
+func (s *Server) handleDevFaults(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodDelete {
+		s.ClearFaults()
+		writeJSON(w, http.StatusOK, map[string]interface{}{"success": true})
+		return
+	}
+
+	var fault Fault
+	if !decode(w, r, http.MethodPost, &fault) {
+		return
+	}
+	s.AddFault(fault)
+	writeJSON(w, http.StatusOK, map[string]interface{}{"success": true})
+}
+
+func (s *Server) handleDevState(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	writeJSON(w, http.StatusOK, s.Snapshot())
+}
+
+func (s *Server) logf(format string, args ...interface{}) {
+	if s.Logf != nil {
+		s.Logf(format, args...)
+	}
+}
+
+// decode checks the method and decodes the JSON body into v, answering
+// the request itself on failure
+func decode(w http.ResponseWriter, r *http.Request, method string, v interface{}) bool {
+	if r.Method != method {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return false
+	}
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid request body: %v", err))
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]interface{}{
+		"success": false,
+		"message": message,
+	})
+}
@@ -0,0 +1,344 @@
+package doctor
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rios/worker/pkg/api"
+	"github.com/rios/worker/pkg/container"
+	"github.com/rios/worker/pkg/gpu"
+	"github.com/rios/worker/pkg/worker"
+)
+
+// MinCUDAVersion is the CUDA version job images are built for
+const MinCUDAVersion = 12.0
+
+// Clock skew beyond which receipts and tokens get unreliable
+const (
+	skewWarn = 5 * time.Second
+	skewFail = time.Minute
+)
+
+const toolkitInstallURL = "https://docs.nvidia.com/datacenter/cloud-native/container-toolkit/latest/install-guide.html"
+
+// diagnosis collects check results and what later checks need from
+// earlier ones
+type diagnosis struct {
+	opts   Options
+	checks []Check
+
+	gpus      *gpu.GPUInfo // nil if the driver check failed
+	driver    string
+	cli       *container.CLI
+	runtimeOK bool
+	toolkitOK bool
+
+	orchestratorOK bool
+}
+
+func (d *diagnosis) add(name, status, detail, fix string) {
+	d.checks = append(d.checks, Check{Name: name, Status: status, Detail: detail, Fix: fix})
+}
+
+// checkDriver checks the NVIDIA driver and the CUDA version it supports
+func (d *diagnosis) checkDriver() {
+	info, err := gpu.Detect()
+	if err != nil {
+		d.add("driver", StatusFail, err.Error(),
+			"Install the NVIDIA driver (https://www.nvidia.com/drivers, or e.g. sudo apt install nvidia-driver-535) and check that nvidia-smi lists the GPUs")
+		return
+	}
+	d.gpus = info
+
+	driver, cuda, err := gpu.DriverVersion()
+	if err != nil {
+		d.add("driver", StatusWarn, fmt.Sprintf("%s, unable to read the driver version: %v", info, err), "Check that nvidia-smi runs without errors")
+		return
+	}
+	d.driver = driver
+
+	detail := fmt.Sprintf("%s, driver %s, CUDA %s", info, driver, cuda)
+	if v, err := strconv.ParseFloat(cuda, 64); err == nil && v < MinCUDAVersion {
+		d.add("driver", StatusWarn, detail,
+			fmt.Sprintf("Jobs need CUDA %.1f or newer, update the driver to version 525 or later", MinCUDAVersion))
+		return
+	}
+	d.add("driver", StatusOK, detail, "")
+}
+
+// checkRuntime checks that the container runtime is installed and running
+func (d *diagnosis) checkRuntime() {
+	if d.opts.Runtime == nil {
+		d.add("runtime", StatusFail, d.opts.RuntimeErr.Error(),
+			"Install Docker (https://docs.docker.com/get-docker/), Podman or nerdctl and start it")
+		return
+	}
+
+	cli, ok := d.opts.Runtime.(*container.CLI)
+	if !ok {
+		d.add("runtime", StatusOK, d.opts.Runtime.Name()+" (runs no containers)", "")
+		return
+	}
+	d.cli = cli
+
+	if err := cli.CheckInstalled(); err != nil {
+		fix := "Start the " + cli.Binary + " service"
+		switch cli.Binary {
+		case "docker":
+			fix = "Start Docker: sudo systemctl start docker, and add your user to the docker group"
+		case "podman":
+			fix = "Check what is wrong with: podman info"
+		case "nerdctl":
+			fix = "Start containerd: sudo systemctl start containerd (or containerd-rootless-setuptool.sh install for rootless)"
+		}
+		d.add("runtime", StatusFail, err.Error(), fix)
+		return
+	}
+	d.runtimeOK = true
+	d.add("runtime", StatusOK, fmt.Sprintf("%s (%s)", cli.Version(), describeGPUMode(cli.GPUMode)), "")
+}
+
+func describeGPUMode(mode string) string {
+	if mode == container.GPUCDI {
+		return "GPUs through CDI"
+	}
+	return "GPUs through --gpus"
+}
+
+// checkToolkit checks the NVIDIA Container Toolkit and, for runtimes that
+// use it, the CDI spec
+func (d *diagnosis) checkToolkit() {
+	if d.cli == nil {
+		d.add("toolkit", StatusSkip, "no container runtime that passes GPUs through", "")
+		return
+	}
+
+	version := ""
+	if output, err := exec.Command("nvidia-ctk", "--version").Output(); err == nil {
+		version, _, _ = strings.Cut(strings.TrimSpace(string(output)), "\n")
+	} else if _, err := exec.LookPath("nvidia-container-cli"); err == nil {
+		version = "nvidia-container-cli"
+	}
+
+	if d.cli.GPUMode != container.GPUCDI {
+		if version == "" {
+			d.add("toolkit", StatusFail, "the NVIDIA Container Toolkit is not installed",
+				"Install it ("+toolkitInstallURL+"), then run: sudo nvidia-ctk runtime configure --runtime=docker && sudo systemctl restart docker")
+			return
+		}
+		d.toolkitOK = true
+		d.add("toolkit", StatusOK, version, "")
+		return
+	}
+
+	const generate = "sudo nvidia-ctk cdi generate --output=/etc/cdi/nvidia.yaml"
+	spec, err := container.FindCDISpec()
+	if err != nil {
+		fix := "Generate the spec: " + generate
+		if version == "" {
+			fix = "Install the NVIDIA Container Toolkit (" + toolkitInstallURL + "), then generate the spec: " + generate
+		}
+		d.add("toolkit", StatusFail, err.Error(), fix)
+		return
+	}
+	d.toolkitOK = true
+
+	// A spec lists the driver libraries by version, so it goes stale when
+	// the driver is updated
+	if data, err := os.ReadFile(spec); err == nil && d.driver != "" && !bytes.Contains(data, []byte(d.driver)) {
+		d.add("toolkit", StatusWarn, fmt.Sprintf("%s was generated for another driver than %s", spec, d.driver),
+			"Generate the spec again: "+generate)
+		return
+	}
+	detail := "CDI spec " + spec
+	if version != "" {
+		detail = version + ", " + detail
+	}
+	d.add("toolkit", StatusOK, detail, "")
+}
+
+// checkPassthrough runs the probe image with every GPU and compares the GPUs
+// it sees with the host's
+func (d *diagnosis) checkPassthrough() {
+	switch {
+	case d.opts.Runtime != nil && d.cli == nil:
+		d.add("passthrough", StatusSkip, d.opts.Runtime.Name()+" runtime runs no containers", "")
+		return
+	case d.gpus == nil || !d.runtimeOK || !d.toolkitOK:
+		d.add("passthrough", StatusSkip, "needs a working driver, runtime and toolkit", "")
+		return
+	}
+
+	image := d.opts.ProbeImage
+	source := "cached"
+	if !d.cli.ImageExists(image) {
+		source = "downloaded"
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), d.opts.ProbeTimeout)
+	defer cancel()
+	var out bytes.Buffer
+	err := d.cli.Run(ctx, &container.Spec{
+		Name:   fmt.Sprintf("rios-doctor-%d", os.Getpid()),
+		Image:  image,
+		Args:   []string{"nvidia-smi", "-L"},
+		Labels: map[string]string{container.LabelTaskType: "doctor"},
+		GPUs:   true,
+		Stdout: &out,
+		Stderr: &out,
+	})
+
+	fix := "Configure the runtime: sudo nvidia-ctk runtime configure --runtime=docker && sudo systemctl restart docker"
+	if d.cli.GPUMode == container.GPUCDI {
+		fix = "Generate the CDI spec again: sudo nvidia-ctk cdi generate --output=/etc/cdi/nvidia.yaml"
+	}
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			d.add("passthrough", StatusFail, fmt.Sprintf("probe %s didn't finish within %s", image, d.opts.ProbeTimeout),
+				"Check that the registry can be reached, or pull the image by hand")
+			return
+		}
+		d.add("passthrough", StatusFail, fmt.Sprintf("probe %s failed: %v: %s", image, err, lastLine(out.String())), fix)
+		return
+	}
+
+	seen := 0
+	scanner := bufio.NewScanner(&out)
+	for scanner.Scan() {
+		if strings.HasPrefix(scanner.Text(), "GPU ") {
+			seen++
+		}
+	}
+	if seen < d.gpus.Count {
+		d.add("passthrough", StatusFail, fmt.Sprintf("a container sees %d of %d GPUs", seen, d.gpus.Count), fix)
+		return
+	}
+	d.add("passthrough", StatusOK, fmt.Sprintf("a container sees all %d GPUs (probe %s, %s)", seen, image, source), "")
+}
+
+func lastLine(s string) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	return lines[len(lines)-1]
+}
+
+// checkDisk checks the free space where job files are stored
+func (d *diagnosis) checkDisk() {
+	// The work directory is only created by run
+	dir := d.opts.WorkDir
+	for {
+		if _, err := os.Stat(dir); err == nil || filepath.Dir(dir) == dir {
+			break
+		}
+		dir = filepath.Dir(dir)
+	}
+
+	free, err := worker.FreeDiskBytes(dir)
+	if err != nil {
+		d.add("disk", StatusFail, fmt.Sprintf("unable to check free disk space in %s: %v", dir, err), "")
+		return
+	}
+
+	reserve := d.opts.MinFreeDiskBytes
+	if reserve <= 0 {
+		reserve = worker.DefaultMinFreeDiskBytes
+	}
+
+	const gb = 1 << 30
+	detail := fmt.Sprintf("%.1f GB free in %s", float64(free)/gb, dir)
+	switch {
+	case free < reserve:
+		d.add("disk", StatusFail, detail,
+			fmt.Sprintf("Free up space; the worker keeps %.0f GB free and rejects every job below that", float64(reserve)/gb))
+	case free < 10*reserve:
+		d.add("disk", StatusWarn, detail, "Free up space; jobs with large models or datasets will be rejected")
+	default:
+		d.add("disk", StatusOK, detail, "")
+	}
+}
+
+// checkOrchestrator checks that the orchestrator can be reached and works,
+// and compares the clocks using its Date header
+func (d *diagnosis) checkOrchestrator() {
+	client := &http.Client{Timeout: 10 * time.Second}
+	start := time.Now()
+	resp, err := client.Get(d.opts.APIEndpoint)
+	if err != nil {
+		d.add("orchestrator", StatusFail, err.Error(),
+			"Check the --api endpoint, DNS, firewall and HTTP(S)_PROXY settings")
+		d.add("clock", StatusSkip, "needs the orchestrator", "")
+		return
+	}
+	resp.Body.Close()
+	elapsed := time.Since(start)
+	if resp.StatusCode >= http.StatusInternalServerError {
+		d.add("orchestrator", StatusFail, fmt.Sprintf("%s answered %s", d.opts.APIEndpoint, resp.Status),
+			"The orchestrator or a proxy in front of it is failing; try again later")
+	} else {
+		d.orchestratorOK = true
+		d.add("orchestrator", StatusOK, fmt.Sprintf("%s answered in %d ms", d.opts.APIEndpoint, elapsed.Milliseconds()), "")
+	}
+
+	serverTime, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		d.add("clock", StatusSkip, "the orchestrator sent no Date header", "")
+		return
+	}
+	// The Date header has a resolution of one second and was set
+	// somewhere during the request
+	skew := start.Add(elapsed / 2).Sub(serverTime).Round(time.Second)
+	abs, direction := skew, "ahead of"
+	if abs < 0 {
+		abs, direction = -abs, "behind"
+	}
+
+	detail := fmt.Sprintf("local clock is %s %s the orchestrator", abs, direction)
+	const fix = "Enable time synchronisation: sudo timedatectl set-ntp true"
+	switch {
+	case abs > skewFail:
+		d.add("clock", StatusFail, detail, fix)
+	case abs > skewWarn:
+		d.add("clock", StatusWarn, detail, fix)
+	default:
+		d.add("clock", StatusOK, detail, "")
+	}
+}
+
+// checkProtocol negotiates the protocol version with the orchestrator
+func (d *diagnosis) checkProtocol() {
+	if !d.orchestratorOK {
+		d.add("protocol", StatusSkip, "needs the orchestrator", "")
+		return
+	}
+
+	client := api.NewClient(d.opts.APIEndpoint)
+	client.WorkerVersion = d.opts.WorkerVersion
+	handshake, err := client.Handshake()
+	var upgradeErr *api.UpgradeRequiredError
+	switch {
+	case errors.As(err, &upgradeErr):
+		d.add("protocol", StatusFail, upgradeErr.Error(), "Update the worker: rios-worker update")
+		return
+	case err != nil && handshake != nil:
+		d.add("protocol", StatusFail, err.Error(), "Use an orchestrator that supports this worker, or an older worker release")
+		return
+	case err != nil:
+		d.add("protocol", StatusWarn, fmt.Sprintf("handshake failed: %v", err), "")
+		return
+	}
+
+	detail := fmt.Sprintf("protocol %d (the orchestrator speaks %d to %d)", api.ProtocolVersion, handshake.MinProtocol, handshake.MaxProtocol)
+	if len(handshake.Features) > 0 {
+		detail += ", features: " + strings.Join(handshake.Features, ", ")
+	}
+	d.add("protocol", StatusOK, detail, "")
+}
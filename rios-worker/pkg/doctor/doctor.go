@@ -0,0 +1,93 @@
+// Package doctor checks step by step that a machine can run RiOS jobs: GPU
+// driver, container toolkit, GPU passthrough, disk, network and clock.
+package doctor
+
+import (
+	"time"
+
+	"github.com/rios/worker/pkg/container"
+)
+
+// Check results
+const (
+	StatusOK   = "ok"
+	StatusWarn = "warn" // works, but something should be fixed
+	StatusFail = "fail" // jobs can't run until it is fixed
+	StatusSkip = "skip" // not checked because an earlier check failed
+)
+
+// Check is the result of one diagnostic step
+type Check struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Detail string `json:"detail"`
+	Fix    string `json:"fix,omitempty"` // what to do about a warning or failure
+}
+
+// Report is the result of every check
+type Report struct {
+	GeneratedAt time.Time `json:"generated_at"`
+	Healthy     bool      `json:"healthy"` // no check failed
+	Checks      []Check   `json:"checks"`
+}
+
+// Options configures the checks
+type Options struct {
+	// Runtime runs the passthrough probe; nil if none could be found, in
+	// which case RuntimeErr says why
+	Runtime    container.Runtime
+	RuntimeErr error
+
+	// ProbeImage is run with every GPU to list them; it is kept after the
+	// first run so later checks don't download it again
+	ProbeImage   string
+	ProbeTimeout time.Duration
+
+	WorkDir          string // where job files are stored
+	MinFreeDiskBytes int64  // kept free in WorkDir (default worker.DefaultMinFreeDiskBytes)
+	APIEndpoint      string // orchestrator
+	WorkerVersion    string // sent in the protocol handshake
+}
+
+// DefaultProbeImage is a small image the NVIDIA toolkit can inject
+// nvidia-smi into
+const DefaultProbeImage = "ubuntu:22.04"
+
+// Run runs every check in order. Later checks that depend on an earlier one
+// are skipped when it fails.
+func Run(opts Options) *Report {
+	if opts.ProbeImage == "" {
+		opts.ProbeImage = DefaultProbeImage
+	}
+	if opts.ProbeTimeout == 0 {
+		opts.ProbeTimeout = 5 * time.Minute
+	}
+
+	d := &diagnosis{opts: opts}
+	d.checkDriver()
+	d.checkRuntime()
+	d.checkToolkit()
+	d.checkPassthrough()
+	d.checkDisk()
+	d.checkOrchestrator()
+	d.checkProtocol()
+
+	report := &Report{GeneratedAt: time.Now().UTC(), Healthy: true, Checks: d.checks}
+	for _, c := range d.checks {
+		if c.Status == StatusFail {
+			report.Healthy = false
+		}
+	}
+	return report
+}
+
+// Failed returns the number of failed checks
+func (r *Report) Failed() int {
+	n := 0
+	for _, c := range r.Checks {
+		if c.Status == StatusFail {
+			n++
+		}
+	}
+	return n
+}
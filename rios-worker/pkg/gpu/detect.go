@@ -15,6 +15,22 @@ type GPUInfo struct {
 	VRam  int // in GB
 }
 
+// Equal reports whether two GPU configurations are identical
+func (g *GPUInfo) Equal(other *GPUInfo) bool {
+	return other != nil && g.Type == other.Type && g.Count == other.Count && g.VRam == other.VRam
+}
+
+// Covers reports whether g provides at least the capacity advertised by other,
+// i.e. the same GPU model with no fewer cards and no less memory
+func (g *GPUInfo) Covers(other *GPUInfo) bool {
+	return other != nil && g.Type == other.Type && g.Count >= other.Count && g.VRam >= other.VRam
+}
+
+// String returns a short human-readable description
+func (g *GPUInfo) String() string {
+	return fmt.Sprintf("%d x %s (%d GB VRAM)", g.Count, g.Type, g.VRam)
+}
+
 // DetectNVIDIA detects NVIDIA GPU information using nvidia-smi
 func DetectNVIDIA() (*GPUInfo, error) {
 	// Check if nvidia-smi is available
@@ -41,7 +57,7 @@ func DetectNVIDIA() (*GPUInfo, error) {
 	// Extract memory in GB
 	re := regexp.MustCompile(`(\d+)\s*MiB`)
 	matches := re.FindStringSubmatch(memoryStr)
-	
+
 	var vramGB int
 	if len(matches) >= 2 {
 		vramMB, _ := strconv.Atoi(matches[1])
@@ -68,3 +84,43 @@ func Detect() (*GPUInfo, error) {
 	return info, nil
 }
 
+// QueryFreeMemory returns the free memory of each GPU in MiB
+func QueryFreeMemory() ([]float64, error) {
+	cmd := exec.Command("nvidia-smi", "--query-gpu=memory.free", "--format=csv,noheader,nounits")
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("nvidia-smi failed: %w", err)
+	}
+
+	var free []float64
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		v, err := strconv.ParseFloat(strings.TrimSpace(line), 64)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected nvidia-smi output %q", line)
+		}
+		free = append(free, v)
+	}
+	return free, nil
+}
+
+var cudaVersionRe = regexp.MustCompile(`CUDA Version:\s*([0-9.]+)`)
+
+// DriverVersion returns the NVIDIA driver version and the highest CUDA
+// version it supports
+func DriverVersion() (driver, cuda string, err error) {
+	output, err := exec.Command("nvidia-smi", "--query-gpu=driver_version", "--format=csv,noheader").Output()
+	if err != nil {
+		return "", "", fmt.Errorf("nvidia-smi failed: %w", err)
+	}
+	driver, _, _ = strings.Cut(strings.TrimSpace(string(output)), "\n")
+
+	// Only the summary table shows the CUDA version
+	output, err = exec.Command("nvidia-smi").Output()
+	if err != nil {
+		return "", "", fmt.Errorf("nvidia-smi failed: %w", err)
+	}
+	if m := cudaVersionRe.FindSubmatch(output); m != nil {
+		cuda = string(m[1])
+	}
+	return strings.TrimSpace(driver), cuda, nil
+}
@@ -0,0 +1,222 @@
+package gpu
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/rios/worker/pkg/config"
+)
+
+// GovernorAction is what the governor asks the worker to do
+type GovernorAction int
+
+const (
+	// PauseIntake stops the worker from taking new jobs
+	PauseIntake GovernorAction = iota
+	// ResumeIntake lets the worker take new jobs again
+	ResumeIntake
+	// SuspendJobs freezes the running containers
+	SuspendJobs
+	// ResumeJobs unfreezes suspended containers
+	ResumeJobs
+	// KillJobs stops the running jobs for good
+	KillJobs
+)
+
+func (a GovernorAction) String() string {
+	switch a {
+	case PauseIntake:
+		return "pause-intake"
+	case ResumeIntake:
+		return "resume-intake"
+	case SuspendJobs:
+		return "suspend-jobs"
+	case ResumeJobs:
+		return "resume-jobs"
+	case KillJobs:
+		return "kill-jobs"
+	}
+	return fmt.Sprintf("action(%d)", int(a))
+}
+
+// GovernorEvent is a decision of the governor and why it was taken
+type GovernorEvent struct {
+	Action GovernorAction
+	Reason string
+}
+
+// Governor watches GPU telemetry and protects the hardware: it pauses job
+// intake when temperature or power limits are crossed, and suspends and
+// eventually kills running jobs on sustained over-temperature.
+type Governor struct {
+	cfg config.GovernorConfig
+
+	// Samples older than this are ignored, 0 to use them all. Run sets it
+	// to StaleIntervals telemetry intervals.
+	maxAge time.Duration
+
+	intakePaused  bool
+	noTelemetry   bool // intake is paused only because telemetry stopped
+	criticalSince time.Time
+	suspendedAt   time.Time
+}
+
+// NewGovernor creates a governor; unset limits in cfg get their defaults
+func NewGovernor(cfg config.GovernorConfig) *Governor {
+	return &Governor{cfg: cfg.WithDefaults()}
+}
+
+// Evaluate decides what to do given the latest samples taken at now.
+// Without fresh samples the GPUs can't be watched, so intake is paused.
+func (g *Governor) Evaluate(samples []Sample, now time.Time) []GovernorEvent {
+	samples = freshSamples(samples, now, g.maxAge)
+	if len(samples) == 0 {
+		return g.telemetryLost(now)
+	}
+
+	var events []GovernorEvent
+
+	// Job intake, with hysteresis so a GPU hovering at the limit doesn't flap
+	if reason := g.overLimit(samples); reason != "" {
+		// Also when already paused for lack of telemetry, for the new reason
+		if !g.intakePaused || g.noTelemetry {
+			g.intakePaused, g.noTelemetry = true, false
+			events = append(events, GovernorEvent{PauseIntake, reason})
+		}
+	} else if g.intakePaused && g.noTelemetry {
+		g.intakePaused, g.noTelemetry = false, false
+		events = append(events, GovernorEvent{ResumeIntake, "GPU telemetry is back"})
+	} else if g.intakePaused && g.cooledDown(samples) {
+		g.intakePaused = false
+		events = append(events, GovernorEvent{ResumeIntake, "GPU temperature and power back within limits"})
+	}
+
+	// Running jobs
+	critical := g.critical(samples)
+	switch {
+	case critical != "" && g.criticalSince.IsZero():
+		g.criticalSince = now
+
+	case critical == "" && !g.criticalSince.IsZero() && g.suspendedAt.IsZero():
+		g.criticalSince = time.Time{}
+	}
+
+	if g.suspendedAt.IsZero() {
+		if critical != "" && now.Sub(g.criticalSince) >= time.Duration(g.cfg.CriticalSeconds)*time.Second {
+			g.suspendedAt = now
+			events = append(events, GovernorEvent{SuspendJobs, fmt.Sprintf("%s for %ds", critical, g.cfg.CriticalSeconds)})
+		}
+	} else if g.cooledDown(samples) {
+		g.suspendedAt = time.Time{}
+		g.criticalSince = time.Time{}
+		events = append(events, GovernorEvent{ResumeJobs, "GPU cooled down"})
+	} else if now.Sub(g.suspendedAt) >= time.Duration(g.cfg.KillAfterSeconds)*time.Second {
+		g.suspendedAt = time.Time{}
+		g.criticalSince = time.Time{}
+		events = append(events, GovernorEvent{KillJobs, fmt.Sprintf("GPU did not cool down within %ds of suspending the job", g.cfg.KillAfterSeconds)})
+	}
+
+	return events
+}
+
+// telemetryLost pauses intake while no fresh samples arrive. Suspended jobs
+// can't be seen cooling down, so they are killed once their time is up.
+func (g *Governor) telemetryLost(now time.Time) []GovernorEvent {
+	var events []GovernorEvent
+	if !g.intakePaused {
+		g.intakePaused, g.noTelemetry = true, true
+		events = append(events, GovernorEvent{PauseIntake, "no fresh GPU telemetry"})
+	}
+	if !g.suspendedAt.IsZero() && now.Sub(g.suspendedAt) >= time.Duration(g.cfg.KillAfterSeconds)*time.Second {
+		g.suspendedAt = time.Time{}
+		g.criticalSince = time.Time{}
+		events = append(events, GovernorEvent{KillJobs, fmt.Sprintf("no GPU telemetry within %ds of suspending the job", g.cfg.KillAfterSeconds)})
+	}
+	return events
+}
+
+// freshSamples returns the samples taken at most maxAge before now
+func freshSamples(samples []Sample, now time.Time, maxAge time.Duration) []Sample {
+	if maxAge <= 0 {
+		return samples
+	}
+	fresh := make([]Sample, 0, len(samples))
+	for _, s := range samples {
+		if now.Sub(s.Time) <= maxAge {
+			fresh = append(fresh, s)
+		}
+	}
+	return fresh
+}
+
+// overLimit returns why intake should be paused, or ""
+func (g *Governor) overLimit(samples []Sample) string {
+	for _, s := range samples {
+		if s.TemperatureC >= g.cfg.MaxTemperatureC {
+			return fmt.Sprintf("GPU %d temperature %.0f°C >= %.0f°C", s.Index, s.TemperatureC, g.cfg.MaxTemperatureC)
+		}
+		if g.cfg.MaxPowerW > 0 && s.PowerDrawW > g.cfg.MaxPowerW {
+			return fmt.Sprintf("GPU %d power draw %.0f W > %.0f W", s.Index, s.PowerDrawW, g.cfg.MaxPowerW)
+		}
+		if g.cfg.PauseOnThrottle && s.Throttled() {
+			return fmt.Sprintf("GPU %d throttled (%s)", s.Index, strings.Join(s.ThrottleReasons, ", "))
+		}
+	}
+	return ""
+}
+
+// critical returns a description of the hottest GPU at or above the critical temperature, or ""
+func (g *Governor) critical(samples []Sample) string {
+	for _, s := range samples {
+		if s.TemperatureC >= g.cfg.CriticalTemperatureC {
+			return fmt.Sprintf("GPU %d temperature %.0f°C >= %.0f°C", s.Index, s.TemperatureC, g.cfg.CriticalTemperatureC)
+		}
+	}
+	return ""
+}
+
+// cooledDown reports whether every GPU is back below the resume thresholds
+func (g *Governor) cooledDown(samples []Sample) bool {
+	for _, s := range samples {
+		if s.TemperatureC > g.cfg.ResumeTemperatureC {
+			return false
+		}
+		if g.cfg.MaxPowerW > 0 && s.PowerDrawW > g.cfg.MaxPowerW {
+			return false
+		}
+		if g.cfg.PauseOnThrottle && s.Throttled() {
+			return false
+		}
+	}
+	return true
+}
+
+// Run evaluates the sampler's latest telemetry every interval and passes
+// the resulting events to handle, until ctx is cancelled
+func (g *Governor) Run(ctx context.Context, sampler *Sampler, interval time.Duration, handle func(GovernorEvent)) {
+	g.maxAge = StaleIntervals * interval
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			for _, event := range g.Evaluate(sampler.Latest(), now) {
+				handle(event)
+			}
+		}
+	}
+}
+
+// SetPowerLimit applies a power limit in watts to all GPUs (requires root)
+func SetPowerLimit(watts float64) error {
+	cmd := exec.Command("nvidia-smi", "-pl", fmt.Sprintf("%.0f", watts))
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("nvidia-smi -pl failed: %s", strings.TrimSpace(string(output)))
+	}
+	return nil
+}
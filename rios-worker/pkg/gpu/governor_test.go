@@ -0,0 +1,186 @@
+package gpu
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/rios/worker/pkg/config"
+)
+
+func TestGovernorEvaluate(t *testing.T) {
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	// step is one evaluation, at seconds after start
+	type step struct {
+		at      int
+		temps   []float64 // one sample per GPU, taken at the time of the step
+		powerW  float64
+		reasons []string
+		age     int // seconds the samples are old
+		want    []GovernorAction
+	}
+	tests := []struct {
+		name  string
+		cfg   config.GovernorConfig
+		steps []step
+	}{
+		{
+			name: "within limits",
+			steps: []step{
+				{at: 0, temps: []float64{60, 70}},
+				{at: 2, temps: []float64{84, 84}},
+			},
+		},
+		{
+			name: "pauses at the maximum and resumes below the resume temperature",
+			steps: []step{
+				{at: 0, temps: []float64{70, 85}, want: []GovernorAction{PauseIntake}},
+				{at: 2, temps: []float64{70, 86}},
+				// Between the thresholds intake stays paused
+				{at: 4, temps: []float64{70, 80}},
+				{at: 6, temps: []float64{70, 76}},
+				{at: 8, temps: []float64{70, 75}, want: []GovernorAction{ResumeIntake}},
+				{at: 10, temps: []float64{70, 80}},
+			},
+		},
+		{
+			name: "configured thresholds",
+			cfg:  config.GovernorConfig{MaxTemperatureC: 70, ResumeTemperatureC: 50},
+			steps: []step{
+				{at: 0, temps: []float64{70}, want: []GovernorAction{PauseIntake}},
+				{at: 2, temps: []float64{55}},
+				{at: 4, temps: []float64{50}, want: []GovernorAction{ResumeIntake}},
+			},
+		},
+		{
+			name: "power draw over the limit",
+			cfg:  config.GovernorConfig{MaxPowerW: 300},
+			steps: []step{
+				{at: 0, temps: []float64{60}, powerW: 300},
+				{at: 2, temps: []float64{60}, powerW: 320, want: []GovernorAction{PauseIntake}},
+				{at: 4, temps: []float64{60}, powerW: 301},
+				{at: 6, temps: []float64{60}, powerW: 280, want: []GovernorAction{ResumeIntake}},
+			},
+		},
+		{
+			name: "no power limit",
+			steps: []step{
+				{at: 0, temps: []float64{60}, powerW: 600},
+			},
+		},
+		{
+			name: "throttling ignored by default",
+			steps: []step{
+				{at: 0, temps: []float64{60}, reasons: []string{"sw_power_cap"}},
+			},
+		},
+		{
+			name: "pause on throttle",
+			cfg:  config.GovernorConfig{PauseOnThrottle: true},
+			steps: []step{
+				{at: 0, temps: []float64{60}, reasons: []string{"sync_boost"}},
+				{at: 2, temps: []float64{60}, reasons: []string{"hw_thermal_slowdown"}, want: []GovernorAction{PauseIntake}},
+				{at: 4, temps: []float64{60}, want: []GovernorAction{ResumeIntake}},
+			},
+		},
+		{
+			name: "suspends after the critical time and resumes once cool",
+			steps: []step{
+				{at: 0, temps: []float64{91}, want: []GovernorAction{PauseIntake}},
+				{at: 29, temps: []float64{92}},
+				{at: 30, temps: []float64{92}, want: []GovernorAction{SuspendJobs}},
+				{at: 40, temps: []float64{80}},
+				{at: 50, temps: []float64{75}, want: []GovernorAction{ResumeIntake, ResumeJobs}},
+			},
+		},
+		{
+			name: "short critical spike",
+			steps: []step{
+				{at: 0, temps: []float64{91}, want: []GovernorAction{PauseIntake}},
+				{at: 20, temps: []float64{88}},
+				{at: 40, temps: []float64{91}},
+				{at: 69, temps: []float64{91}},
+				{at: 70, temps: []float64{91}, want: []GovernorAction{SuspendJobs}},
+			},
+		},
+		{
+			name: "kills jobs that don't cool down",
+			cfg:  config.GovernorConfig{CriticalSeconds: 10, KillAfterSeconds: 60},
+			steps: []step{
+				{at: 0, temps: []float64{95}, want: []GovernorAction{PauseIntake}},
+				{at: 10, temps: []float64{95}, want: []GovernorAction{SuspendJobs}},
+				{at: 69, temps: []float64{89}},
+				{at: 70, temps: []float64{89}, want: []GovernorAction{KillJobs}},
+				{at: 80, temps: []float64{70}, want: []GovernorAction{ResumeIntake}},
+			},
+		},
+		{
+			name: "no telemetry",
+			steps: []step{
+				{at: 0, want: []GovernorAction{PauseIntake}},
+				{at: 2},
+				{at: 4, temps: []float64{80}, want: []GovernorAction{ResumeIntake}},
+			},
+		},
+		{
+			name: "stale telemetry",
+			steps: []step{
+				{at: 0, temps: []float64{60}},
+				{at: 2, temps: []float64{60}, age: 6},
+				{at: 4, temps: []float64{60}, age: 7, want: []GovernorAction{PauseIntake}},
+				{at: 6, temps: []float64{60}, want: []GovernorAction{ResumeIntake}},
+			},
+		},
+		{
+			name: "telemetry back over the limit",
+			steps: []step{
+				{at: 0, want: []GovernorAction{PauseIntake}},
+				{at: 2, temps: []float64{88}, want: []GovernorAction{PauseIntake}},
+				{at: 4, temps: []float64{80}},
+				{at: 6, temps: []float64{70}, want: []GovernorAction{ResumeIntake}},
+			},
+		},
+		{
+			name: "telemetry lost while suspended",
+			cfg:  config.GovernorConfig{CriticalSeconds: 10, KillAfterSeconds: 60},
+			steps: []step{
+				{at: 0, temps: []float64{95}, want: []GovernorAction{PauseIntake}},
+				{at: 10, temps: []float64{95}, want: []GovernorAction{SuspendJobs}},
+				{at: 20},
+				{at: 70, want: []GovernorAction{KillJobs}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewGovernor(tt.cfg)
+			g.maxAge = 6 * time.Second
+			for _, st := range tt.steps {
+				now := start.Add(time.Duration(st.at) * time.Second)
+				var samples []Sample
+				for i, temp := range st.temps {
+					samples = append(samples, Sample{
+						Index:           i,
+						Time:            now.Add(-time.Duration(st.age) * time.Second),
+						TemperatureC:    temp,
+						PowerDrawW:      st.powerW,
+						ThrottleReasons: st.reasons,
+					})
+				}
+
+				events := g.Evaluate(samples, now)
+				var got []GovernorAction
+				for _, e := range events {
+					if e.Reason == "" {
+						t.Errorf("at %ds: %s has no reason", st.at, e.Action)
+					}
+					got = append(got, e.Action)
+				}
+				if fmt.Sprint(got) != fmt.Sprint(st.want) {
+					t.Errorf("at %ds: got %v, want %v (%+v)", st.at, got, st.want, events)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,42 @@
+package gpu
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// Process is a process using a GPU
+type Process struct {
+	PID           int
+	Name          string
+	UsedMemoryMiB float64
+}
+
+// ListProcesses returns the compute processes currently running on any GPU
+func ListProcesses() ([]Process, error) {
+	cmd := exec.Command("nvidia-smi", "--query-compute-apps=pid,process_name,used_memory", "--format=csv,noheader,nounits")
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("nvidia-smi failed: %w", err)
+	}
+
+	var processes []Process
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		parts := strings.Split(line, ",")
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("unexpected nvidia-smi output %q", line)
+		}
+		pid, _ := strconv.Atoi(strings.TrimSpace(parts[0]))
+		processes = append(processes, Process{
+			PID:           pid,
+			Name:          strings.TrimSpace(parts[1]),
+			UsedMemoryMiB: parseValue(strings.TrimSpace(parts[2])),
+		})
+	}
+	return processes, nil
+}
@@ -0,0 +1,141 @@
+package gpu
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Provider is where the worker gets its GPU inventory, telemetry and
+// controls from
+type Provider interface {
+	// Detect returns the GPU configuration
+	Detect() (*GPUInfo, error)
+	// FreeMemory returns the free memory of each GPU in MiB
+	FreeMemory() ([]float64, error)
+	// Processes lists the compute processes on the GPUs
+	Processes() ([]Process, error)
+	// Telemetry streams samples every interval
+	Telemetry(interval time.Duration) TelemetrySource
+	// SetPowerLimit caps the power draw of every GPU
+	SetPowerLimit(watts float64) error
+}
+
+// NVIDIA is the Provider for the machine's GPUs, backed by nvidia-smi
+type NVIDIA struct{}
+
+func (NVIDIA) Detect() (*GPUInfo, error)                        { return Detect() }
+func (NVIDIA) FreeMemory() ([]float64, error)                   { return QueryFreeMemory() }
+func (NVIDIA) Processes() ([]Process, error)                    { return ListProcesses() }
+func (NVIDIA) Telemetry(interval time.Duration) TelemetrySource { return NvidiaSMISource(interval) }
+func (NVIDIA) SetPowerLimit(watts float64) error                { return SetPowerLimit(watts) }
+
+// Simulated is a Provider for GPUs that aren't there, for testing the
+// worker on machines without them. Its telemetry is replayed from a file
+// recorded with nvidia-smi, or reports idle GPUs if none is given.
+type Simulated struct {
+	Type          string `json:"type"`
+	Count         int    `json:"count"`
+	VRamGB        int    `json:"vram_gb"`
+	TelemetryFile string `json:"telemetry_file,omitempty"` // in the TelemetryFields CSV format
+
+	mu         sync.Mutex
+	powerLimit float64
+}
+
+// DefaultSimulated is the simulated GPU used when no inventory is given
+func DefaultSimulated() *Simulated {
+	return &Simulated{Type: "Mock GPU (Testing)", Count: 1, VRamGB: 8}
+}
+
+// LoadSimulated reads a simulated GPU inventory from a JSON file. A relative
+// telemetry file is resolved against the inventory's directory.
+func LoadSimulated(path string) (*Simulated, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read GPU inventory: %w", err)
+	}
+	sim := DefaultSimulated()
+	if err := json.Unmarshal(data, sim); err != nil {
+		return nil, fmt.Errorf("failed to parse GPU inventory: %w", err)
+	}
+	if sim.Count < 1 || sim.VRamGB < 1 {
+		return nil, fmt.Errorf("GPU inventory %s needs a count and vram_gb of at least 1", path)
+	}
+	if sim.TelemetryFile != "" && !filepath.IsAbs(sim.TelemetryFile) {
+		sim.TelemetryFile = filepath.Join(filepath.Dir(path), sim.TelemetryFile)
+	}
+	return sim, nil
+}
+
+func (s *Simulated) Detect() (*GPUInfo, error) {
+	return &GPUInfo{Type: s.Type, Count: s.Count, VRam: s.VRamGB}, nil
+}
+
+func (s *Simulated) FreeMemory() ([]float64, error) {
+	free := make([]float64, s.Count)
+	for i := range free {
+		free[i] = float64(s.VRamGB * 1024)
+	}
+	return free, nil
+}
+
+func (s *Simulated) Processes() ([]Process, error) {
+	return nil, nil
+}
+
+func (s *Simulated) Telemetry(interval time.Duration) TelemetrySource {
+	if s.TelemetryFile != "" {
+		return FileSource(s.TelemetryFile, s.Count, interval)
+	}
+	return s.idleSource(interval)
+}
+
+// SetPowerLimit only remembers the limit, it shows up in the telemetry of
+// idle simulated GPUs
+func (s *Simulated) SetPowerLimit(watts float64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.powerLimit = watts
+	return nil
+}
+
+// idleSource reports every GPU as idle and cool
+func (s *Simulated) idleSource(interval time.Duration) TelemetrySource {
+	return func(ctx context.Context) (io.ReadCloser, error) {
+		pr, pw := io.Pipe()
+		go func() {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for {
+				s.mu.Lock()
+				limit := s.powerLimit
+				s.mu.Unlock()
+				if limit == 0 {
+					limit = 300
+				}
+
+				var lines strings.Builder
+				for i := 0; i < s.Count; i++ {
+					fmt.Fprintf(&lines, "%d, 0, 0, %d, 35, 20.00, %.2f, 210, 405, 0x0000000000000001\n", i, s.VRamGB*1024, limit)
+				}
+				if _, err := io.WriteString(pw, lines.String()); err != nil {
+					return
+				}
+				select {
+				case <-ctx.Done():
+					pw.CloseWithError(ctx.Err())
+					return
+				case <-ticker.C:
+				}
+			}
+		}()
+		return pr, nil
+	}
+}
@@ -0,0 +1,410 @@
+package gpu
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TelemetryFields are the nvidia-smi --query-gpu fields read by the sampler,
+// in the column order expected by ParseSample
+var TelemetryFields = []string{
+	"index",
+	"utilization.gpu",
+	"memory.used",
+	"memory.total",
+	"temperature.gpu",
+	"power.draw",
+	"power.limit",
+	"clocks.sm",
+	"clocks.mem",
+	"clocks_throttle_reasons.active",
+}
+
+// StaleIntervals is how many telemetry intervals a sample stays current.
+// Older samples mean the source stopped producing them.
+const StaleIntervals = 3
+
+// throttleReasons decodes the clocks_throttle_reasons.active bitmask
+var throttleReasons = []struct {
+	mask uint64
+	name string
+}{
+	{0x0000000000000002, "applications_clocks_setting"},
+	{0x0000000000000004, "sw_power_cap"},
+	{0x0000000000000008, "hw_slowdown"},
+	{0x0000000000000010, "sync_boost"},
+	{0x0000000000000020, "sw_thermal_slowdown"},
+	{0x0000000000000040, "hw_thermal_slowdown"},
+	{0x0000000000000080, "hw_power_brake_slowdown"},
+	{0x0000000000000100, "display_clock_setting"},
+}
+
+// Sample is one telemetry reading of one GPU. Values nvidia-smi reports as
+// unavailable ([N/A], [Not Supported]) are zero.
+type Sample struct {
+	Index           int       `json:"index"`
+	Time            time.Time `json:"time"`
+	UtilizationPct  float64   `json:"utilization_pct"`
+	MemoryUsedMiB   float64   `json:"memory_used_mib"`
+	MemoryTotalMiB  float64   `json:"memory_total_mib"`
+	TemperatureC    float64   `json:"temperature_c"`
+	PowerDrawW      float64   `json:"power_draw_w"`
+	PowerLimitW     float64   `json:"power_limit_w"`
+	ClockSMMHz      float64   `json:"clock_sm_mhz"`
+	ClockMemMHz     float64   `json:"clock_mem_mhz"`
+	ThrottleReasons []string  `json:"throttle_reasons,omitempty"`
+}
+
+// Throttled reports whether the GPU is slowed down for thermal or power reasons
+func (s *Sample) Throttled() bool {
+	for _, reason := range s.ThrottleReasons {
+		switch reason {
+		case "sw_power_cap", "hw_slowdown", "sw_thermal_slowdown", "hw_thermal_slowdown", "hw_power_brake_slowdown":
+			return true
+		}
+	}
+	return false
+}
+
+// ParseSample parses one line of
+// nvidia-smi --query-gpu=<TelemetryFields> --format=csv,noheader,nounits
+func ParseSample(line string, at time.Time) (Sample, error) {
+	parts := strings.Split(line, ",")
+	if len(parts) != len(TelemetryFields) {
+		return Sample{}, fmt.Errorf("unexpected nvidia-smi telemetry line %q", line)
+	}
+	for i := range parts {
+		parts[i] = strings.TrimSpace(parts[i])
+	}
+
+	index, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return Sample{}, fmt.Errorf("invalid GPU index %q", parts[0])
+	}
+
+	return Sample{
+		Index:           index,
+		Time:            at,
+		UtilizationPct:  parseValue(parts[1]),
+		MemoryUsedMiB:   parseValue(parts[2]),
+		MemoryTotalMiB:  parseValue(parts[3]),
+		TemperatureC:    parseValue(parts[4]),
+		PowerDrawW:      parseValue(parts[5]),
+		PowerLimitW:     parseValue(parts[6]),
+		ClockSMMHz:      parseValue(parts[7]),
+		ClockMemMHz:     parseValue(parts[8]),
+		ThrottleReasons: parseThrottleReasons(parts[9]),
+	}, nil
+}
+
+// parseValue parses a numeric field, treating [N/A] and friends as zero
+func parseValue(s string) float64 {
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0
+	}
+	return v
+}
+
+func parseThrottleReasons(s string) []string {
+	mask, err := strconv.ParseUint(strings.TrimPrefix(s, "0x"), 16, 64)
+	if err != nil {
+		return nil
+	}
+	var reasons []string
+	for _, r := range throttleReasons {
+		if mask&r.mask != 0 {
+			reasons = append(reasons, r.name)
+		}
+	}
+	return reasons
+}
+
+// TelemetrySource opens a stream of telemetry lines in the ParseSample format
+type TelemetrySource func(ctx context.Context) (io.ReadCloser, error)
+
+// NvidiaSMISource streams telemetry from nvidia-smi every interval
+func NvidiaSMISource(interval time.Duration) TelemetrySource {
+	return func(ctx context.Context) (io.ReadCloser, error) {
+		cmd := exec.CommandContext(ctx, "nvidia-smi",
+			"--query-gpu="+strings.Join(TelemetryFields, ","),
+			"--format=csv,noheader,nounits",
+			"-lms", strconv.FormatInt(interval.Milliseconds(), 10),
+		)
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			return nil, err
+		}
+		if err := cmd.Start(); err != nil {
+			return nil, fmt.Errorf("failed to start nvidia-smi: %w", err)
+		}
+		return &cmdReader{ReadCloser: stdout, cmd: cmd}, nil
+	}
+}
+
+// FileSource replays telemetry recorded to a CSV file, one GPU reading per
+// line and one line per GPU per interval, looping at the end of the file.
+// It is used for simulated GPUs and to test against recorded fixtures.
+func FileSource(path string, gpuCount int, interval time.Duration) TelemetrySource {
+	return func(ctx context.Context) (io.ReadCloser, error) {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read telemetry file: %w", err)
+		}
+		var lines []string
+		for _, line := range strings.Split(string(data), "\n") {
+			line = strings.TrimSpace(line)
+			if line != "" && !strings.HasPrefix(line, "#") {
+				lines = append(lines, line)
+			}
+		}
+		if len(lines) == 0 {
+			return nil, fmt.Errorf("telemetry file %s is empty", path)
+		}
+		if gpuCount < 1 {
+			gpuCount = 1
+		}
+
+		pr, pw := io.Pipe()
+		go func() {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for i := 0; ; {
+				for n := 0; n < gpuCount; n++ {
+					if _, err := io.WriteString(pw, lines[i%len(lines)]+"\n"); err != nil {
+						return
+					}
+					i++
+				}
+				select {
+				case <-ctx.Done():
+					pw.CloseWithError(ctx.Err())
+					return
+				case <-ticker.C:
+				}
+			}
+		}()
+		return pr, nil
+	}
+}
+
+type cmdReader struct {
+	io.ReadCloser
+	cmd *exec.Cmd
+}
+
+func (r *cmdReader) Close() error {
+	r.ReadCloser.Close()
+	return r.cmd.Wait()
+}
+
+// Sampler continuously reads GPU telemetry, keeps the latest sample of each
+// GPU and feeds per-job recordings
+type Sampler struct {
+	source TelemetrySource
+	maxAge time.Duration // samples older than this are stale, 0 for never
+
+	mu         sync.Mutex
+	latest     map[int]Sample
+	lastSample time.Time
+	recordings map[*Recording]struct{}
+	lastErr    error
+}
+
+// NewSampler creates a sampler reading from source, which produces samples
+// every interval. Samples older than StaleIntervals intervals are dropped;
+// an interval of 0 keeps them.
+func NewSampler(source TelemetrySource, interval time.Duration) *Sampler {
+	return &Sampler{
+		source:     source,
+		maxAge:     StaleIntervals * interval,
+		latest:     make(map[int]Sample),
+		recordings: make(map[*Recording]struct{}),
+	}
+}
+
+// Run reads telemetry until ctx is cancelled, restarting the source if it
+// exits or fails
+func (s *Sampler) Run(ctx context.Context) {
+	for ctx.Err() == nil {
+		if err := s.readOnce(ctx); err != nil && ctx.Err() == nil {
+			s.mu.Lock()
+			s.lastErr = err
+			s.mu.Unlock()
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+func (s *Sampler) readOnce(ctx context.Context) error {
+	stream, err := s.source(ctx)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	scanner := bufio.NewScanner(stream)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		sample, err := ParseSample(line, time.Now())
+		if err != nil {
+			return err
+		}
+		s.add(sample)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return fmt.Errorf("telemetry stream ended")
+}
+
+func (s *Sampler) add(sample Sample) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.latest[sample.Index] = sample
+	if sample.Time.After(s.lastSample) {
+		s.lastSample = sample.Time
+	}
+	s.lastErr = nil
+	for rec := range s.recordings {
+		rec.add(sample)
+	}
+}
+
+// Latest returns the most recent sample of each GPU that is not stale,
+// ordered by index
+func (s *Sampler) Latest() []Sample {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	samples := make([]Sample, 0, len(s.latest))
+	for _, sample := range s.latest {
+		samples = append(samples, sample)
+	}
+	samples = freshSamples(samples, time.Now(), s.maxAge)
+	sort.Slice(samples, func(i, j int) bool {
+		return samples[i].Index < samples[j].Index
+	})
+	return samples
+}
+
+// Err returns why the sampler has no current telemetry: the last error of
+// the source, or that it stopped producing samples. It is nil while
+// samples arrive.
+func (s *Sampler) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.lastErr != nil {
+		return s.lastErr
+	}
+	if s.maxAge > 0 && !s.lastSample.IsZero() && time.Since(s.lastSample) > s.maxAge {
+		return fmt.Errorf("no GPU telemetry since %s", s.lastSample.Format(time.TimeOnly))
+	}
+	return nil
+}
+
+// StartRecording starts aggregating samples, e.g. for the duration of a job
+func (s *Sampler) StartRecording() *Recording {
+	rec := &Recording{
+		sampler: s,
+		stats:   make(map[int]*Stats),
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.recordings[rec] = struct{}{}
+	return rec
+}
+
+// Recording aggregates telemetry between StartRecording and Stop
+type Recording struct {
+	sampler *Sampler
+	stats   map[int]*Stats // guarded by sampler.mu
+}
+
+// Stats holds the peak and average values of one GPU over a recording
+type Stats struct {
+	Index              int      `json:"index"`
+	Samples            int      `json:"samples"`
+	UtilizationAvgPct  float64  `json:"utilization_avg_pct"`
+	UtilizationPeakPct float64  `json:"utilization_peak_pct"`
+	MemoryUsedAvgMiB   float64  `json:"memory_used_avg_mib"`
+	MemoryUsedPeakMiB  float64  `json:"memory_used_peak_mib"`
+	TemperatureAvgC    float64  `json:"temperature_avg_c"`
+	TemperaturePeakC   float64  `json:"temperature_peak_c"`
+	PowerDrawAvgW      float64  `json:"power_draw_avg_w"`
+	PowerDrawPeakW     float64  `json:"power_draw_peak_w"`
+	ClockSMAvgMHz      float64  `json:"clock_sm_avg_mhz"`
+	ThrottleReasons    []string `json:"throttle_reasons,omitempty"`
+}
+
+func (r *Recording) add(sample Sample) {
+	st, ok := r.stats[sample.Index]
+	if !ok {
+		st = &Stats{Index: sample.Index}
+		r.stats[sample.Index] = st
+	}
+
+	// Keep running sums in the Avg fields until Stop
+	st.Samples++
+	st.UtilizationAvgPct += sample.UtilizationPct
+	st.MemoryUsedAvgMiB += sample.MemoryUsedMiB
+	st.TemperatureAvgC += sample.TemperatureC
+	st.PowerDrawAvgW += sample.PowerDrawW
+	st.ClockSMAvgMHz += sample.ClockSMMHz
+	st.UtilizationPeakPct = math.Max(st.UtilizationPeakPct, sample.UtilizationPct)
+	st.MemoryUsedPeakMiB = math.Max(st.MemoryUsedPeakMiB, sample.MemoryUsedMiB)
+	st.TemperaturePeakC = math.Max(st.TemperaturePeakC, sample.TemperatureC)
+	st.PowerDrawPeakW = math.Max(st.PowerDrawPeakW, sample.PowerDrawW)
+	for _, reason := range sample.ThrottleReasons {
+		if !contains(st.ThrottleReasons, reason) {
+			st.ThrottleReasons = append(st.ThrottleReasons, reason)
+		}
+	}
+}
+
+// Stop ends the recording and returns the statistics of each GPU, ordered by index
+func (r *Recording) Stop() []Stats {
+	r.sampler.mu.Lock()
+	defer r.sampler.mu.Unlock()
+	delete(r.sampler.recordings, r)
+
+	result := make([]Stats, 0, len(r.stats))
+	for _, st := range r.stats {
+		n := float64(st.Samples)
+		st.UtilizationAvgPct /= n
+		st.MemoryUsedAvgMiB /= n
+		st.TemperatureAvgC /= n
+		st.PowerDrawAvgW /= n
+		st.ClockSMAvgMHz /= n
+		result = append(result, *st)
+	}
+	r.stats = nil
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Index < result[j].Index
+	})
+	return result
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
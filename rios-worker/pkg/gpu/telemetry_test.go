@@ -0,0 +1,173 @@
+package gpu
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const fixture = "testdata/rtx3090-load.csv"
+
+// readFixture returns the samples of the recorded RTX 3090 load
+func readFixture(t *testing.T) []Sample {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, err := FileSource(fixture, 1, time.Millisecond)(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+
+	var samples []Sample
+	scanner := bufio.NewScanner(stream)
+	for len(samples) < 7 && scanner.Scan() {
+		sample, err := ParseSample(scanner.Text(), time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		samples = append(samples, sample)
+	}
+	if len(samples) < 7 {
+		t.Fatalf("read %d samples from %s, want 7", len(samples), fixture)
+	}
+	return samples
+}
+
+func TestParseSampleFixture(t *testing.T) {
+	samples := readFixture(t)
+
+	tests := []struct {
+		line      int
+		util      float64
+		tempC     float64
+		powerW    float64
+		reasons   []string
+		throttled bool
+	}{
+		{line: 0, util: 3, tempC: 41, powerW: 31.42},
+		{line: 1, util: 87, tempC: 58, powerW: 281.77},
+		{line: 2, util: 99, tempC: 71, powerW: 346.03, reasons: []string{"sw_power_cap"}, throttled: true},
+		{line: 4, util: 100, tempC: 91, powerW: 336.10, reasons: []string{"sw_thermal_slowdown", "hw_thermal_slowdown"}, throttled: true},
+		// Values nvidia-smi can't read are zero
+		{line: 6, util: 0, tempC: 86, powerW: 0},
+	}
+	for _, tt := range tests {
+		s := samples[tt.line]
+		if s.Index != 0 || s.MemoryTotalMiB != 24576 || s.PowerLimitW != 350 {
+			t.Errorf("line %d: index %d, %v MiB, %v W limit, want 0, 24576 MiB, 350 W", tt.line, s.Index, s.MemoryTotalMiB, s.PowerLimitW)
+		}
+		if s.UtilizationPct != tt.util || s.TemperatureC != tt.tempC || s.PowerDrawW != tt.powerW {
+			t.Errorf("line %d: %v%%, %v C, %v W, want %v%%, %v C, %v W", tt.line,
+				s.UtilizationPct, s.TemperatureC, s.PowerDrawW, tt.util, tt.tempC, tt.powerW)
+		}
+		if !reflect.DeepEqual(s.ThrottleReasons, tt.reasons) || s.Throttled() != tt.throttled {
+			t.Errorf("line %d: throttle reasons %v (throttled %v), want %v (%v)", tt.line,
+				s.ThrottleReasons, s.Throttled(), tt.reasons, tt.throttled)
+		}
+	}
+}
+
+func TestFileSourceLoops(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, err := FileSource(fixture, 1, time.Millisecond)(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(stream)
+	for len(lines) < 8 && scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if len(lines) < 8 || lines[7] != lines[0] {
+		t.Errorf("after the last of 7 lines got %q, want the first line again", lines[len(lines)-1])
+	}
+}
+
+func TestRecordingFixture(t *testing.T) {
+	sampler := NewSampler(nil, 0)
+	rec := sampler.StartRecording()
+	for _, s := range readFixture(t) {
+		sampler.add(s)
+	}
+	stats := rec.Stop()
+
+	if len(stats) != 1 {
+		t.Fatalf("got stats of %d GPUs, want 1", len(stats))
+	}
+	st := stats[0]
+	if st.Samples != 7 {
+		t.Errorf("Samples = %d, want 7", st.Samples)
+	}
+	if math.Abs(st.UtilizationAvgPct-487.0/7) > 1e-9 || st.UtilizationPeakPct != 100 {
+		t.Errorf("utilization avg %v peak %v, want %v and 100", st.UtilizationAvgPct, st.UtilizationPeakPct, 487.0/7)
+	}
+	if st.TemperaturePeakC != 91 || st.PowerDrawPeakW != 349.88 || st.MemoryUsedPeakMiB != 18220 {
+		t.Errorf("peaks %v C, %v W, %v MiB, want 91 C, 349.88 W, 18220 MiB", st.TemperaturePeakC, st.PowerDrawPeakW, st.MemoryUsedPeakMiB)
+	}
+	want := []string{"sw_power_cap", "sw_thermal_slowdown", "hw_thermal_slowdown"}
+	if !reflect.DeepEqual(st.ThrottleReasons, want) {
+		t.Errorf("ThrottleReasons = %v, want %v", st.ThrottleReasons, want)
+	}
+
+	// Samples after Stop are not recorded
+	sampler.add(Sample{Index: 0, TemperatureC: 99})
+	if latest := sampler.Latest(); len(latest) != 1 || latest[0].TemperatureC != 99 {
+		t.Errorf("Latest = %+v, want the last sample", latest)
+	}
+}
+
+func TestSamplerStale(t *testing.T) {
+	sampler := NewSampler(nil, time.Second)
+	if err := sampler.Err(); err != nil {
+		t.Errorf("Err before the first sample = %v, want nil", err)
+	}
+
+	now := time.Now()
+	sampler.add(Sample{Index: 0, Time: now, TemperatureC: 60})
+	sampler.add(Sample{Index: 1, Time: now.Add(-5 * time.Second), TemperatureC: 70})
+	if latest := sampler.Latest(); len(latest) != 1 || latest[0].Index != 0 {
+		t.Errorf("Latest = %+v, want only the fresh sample of GPU 0", latest)
+	}
+	if err := sampler.Err(); err != nil {
+		t.Errorf("Err = %v, want nil while samples arrive", err)
+	}
+
+	// The source stopped five seconds ago, more than three intervals
+	sampler = NewSampler(nil, time.Second)
+	sampler.add(Sample{Index: 0, Time: now.Add(-5 * time.Second), TemperatureC: 60})
+	if latest := sampler.Latest(); len(latest) != 0 {
+		t.Errorf("Latest = %+v, want no stale samples", latest)
+	}
+	if err := sampler.Err(); err == nil || !strings.Contains(err.Error(), "no GPU telemetry since") {
+		t.Errorf("Err = %v, want no GPU telemetry", err)
+	}
+}
+
+func TestSamplerSourceError(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sampler := NewSampler(func(ctx context.Context) (io.ReadCloser, error) {
+		return io.NopCloser(strings.NewReader("0, 3, 1024, 24576, 41\n")), nil
+	}, time.Second)
+	go sampler.Run(ctx)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for sampler.Err() == nil && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := sampler.Err(); err == nil || !strings.Contains(err.Error(), "unexpected nvidia-smi telemetry line") {
+		t.Errorf("Err = %v, want the parse error", err)
+	}
+	if latest := sampler.Latest(); len(latest) != 0 {
+		t.Errorf("Latest = %+v, want no samples", latest)
+	}
+}
@@ -0,0 +1,379 @@
+package history
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/rios/worker/pkg/config"
+	_ "modernc.org/sqlite" // pure Go driver, keeps CGO_ENABLED=0 builds working
+)
+
+const HistoryFile = "history.db"
+
+// Job statuses stored in the history
+const (
+	StatusRunning   = "running"
+	StatusCompleted = "completed"
+	StatusFailed    = "failed"
+	StatusRejected  = "rejected"
+)
+
+// Reward statuses stored in the history
+const (
+	RewardPending = "pending"
+	RewardPaid    = "paid"
+)
+
+// migrations are applied in order; the index of the last applied migration
+// is tracked with PRAGMA user_version
+var migrations = []string{
+	`CREATE TABLE IF NOT EXISTS jobs (
+		job_id        TEXT PRIMARY KEY,
+		task_type     TEXT NOT NULL,
+		docker_image  TEXT NOT NULL DEFAULT '',
+		status        TEXT NOT NULL,
+		error_message TEXT NOT NULL DEFAULT '',
+		started_at    INTEGER NOT NULL,
+		finished_at   INTEGER NOT NULL DEFAULT 0,
+		reward        REAL NOT NULL DEFAULT 0,
+		reward_status TEXT NOT NULL DEFAULT ''
+	);
+	CREATE INDEX IF NOT EXISTS jobs_started_at ON jobs(started_at);`,
+
+	`ALTER TABLE jobs ADD COLUMN download_bytes INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE jobs ADD COLUMN upload_bytes INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE jobs ADD COLUMN container_rx_bytes INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE jobs ADD COLUMN container_tx_bytes INTEGER NOT NULL DEFAULT 0;`,
+}
+
+// jobColumns is the column list read by scanJobs
+const jobColumns = `job_id, task_type, docker_image, status, error_message,
+	started_at, finished_at, reward, reward_status,
+	download_bytes, upload_bytes, container_rx_bytes, container_tx_bytes`
+
+// JobRecord is a single job as seen by this worker
+type JobRecord struct {
+	JobID        string
+	TaskType     string
+	DockerImage  string
+	Status       string
+	ErrorMessage string
+	StartedAt    time.Time
+	FinishedAt   time.Time // zero while running
+	Reward       float64
+	RewardStatus string
+
+	// Network usage
+	DownloadBytes    int64
+	UploadBytes      int64
+	ContainerRxBytes int64
+	ContainerTxBytes int64
+}
+
+// TrafficBytes returns all bytes the job transferred
+func (r *JobRecord) TrafficBytes() int64 {
+	return r.DownloadBytes + r.UploadBytes + r.ContainerRxBytes + r.ContainerTxBytes
+}
+
+// Duration returns how long the job ran (so far, if still running)
+func (r *JobRecord) Duration() time.Duration {
+	if r.FinishedAt.IsZero() {
+		return time.Since(r.StartedAt)
+	}
+	return r.FinishedAt.Sub(r.StartedAt)
+}
+
+// EarningsRow aggregates rewards for one day and task type
+type EarningsRow struct {
+	Date     string // YYYY-MM-DD in local time
+	TaskType string
+	Jobs     int
+	Pending  float64
+	Paid     float64
+}
+
+// Store is the local SQLite-backed job history
+type Store struct {
+	db *sql.DB
+}
+
+// DefaultPath returns the history database path in the data directory
+func DefaultPath() (string, error) {
+	dir, err := config.DataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, HistoryFile), nil
+}
+
+// Open opens (and creates or migrates if needed) the history database at path
+func Open(path string) (*Store, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create history directory: %w", err)
+	}
+
+	// Transactions take the write lock up front, so two processes migrating
+	// at once wait for each other instead of failing
+	db, err := sql.Open("sqlite", path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_txlock=immediate")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open history database: %w", err)
+	}
+	// The worker and the status commands may use the database at the same time;
+	// a single connection per process keeps SQLite locking simple
+	db.SetMaxOpenConns(1)
+
+	s := &Store{db: db}
+	if err := s.migrate(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// OpenReadOnly opens an existing history database without migrating or
+// otherwise changing it, e.g. to copy it somewhere else
+func OpenReadOnly(path string) (*Store, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("failed to open history database: %w", err)
+	}
+	u := url.URL{Scheme: "file", Path: path, RawQuery: "mode=ro&_pragma=busy_timeout(5000)"}
+	db, err := sql.Open("sqlite", u.String())
+	if err != nil {
+		return nil, fmt.Errorf("failed to open history database: %w", err)
+	}
+	db.SetMaxOpenConns(1)
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to open history database: %w", err)
+	}
+	return &Store{db: db}, nil
+}
+
+// OpenDefault opens the history database at DefaultPath
+func OpenDefault() (*Store, error) {
+	path, err := DefaultPath()
+	if err != nil {
+		return nil, err
+	}
+	return Open(path)
+}
+
+// Close closes the database
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// migrate applies the pending migrations one at a time
+func (s *Store) migrate() error {
+	for {
+		applied, err := s.migrateNext()
+		if err != nil || !applied {
+			return err
+		}
+	}
+}
+
+// migrateNext applies the next pending migration, if any, in a transaction
+// together with its user_version bump, so a failed migration leaves the
+// schema as it was. The version is read in the same transaction in case
+// another process migrated first.
+func (s *Store) migrateNext() (applied bool, err error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return false, fmt.Errorf("failed to migrate history schema: %w", err)
+	}
+	defer tx.Rollback()
+
+	var version int
+	if err := tx.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return false, fmt.Errorf("failed to read history schema version: %w", err)
+	}
+	if version >= len(migrations) {
+		return false, nil
+	}
+	if _, err := tx.Exec(migrations[version]); err != nil {
+		return false, fmt.Errorf("failed to migrate history schema to version %d: %w", version+1, err)
+	}
+	if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", version+1)); err != nil {
+		return false, fmt.Errorf("failed to update history schema version: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to migrate history schema to version %d: %w", version+1, err)
+	}
+	return true, nil
+}
+
+// CopyTo writes a consistent copy of the database to path, including rows
+// still in the write-ahead log. path must not exist.
+func (s *Store) CopyTo(path string) error {
+	if _, err := s.db.Exec("VACUUM INTO ?", path); err != nil {
+		return fmt.Errorf("failed to copy history to %s: %w", path, err)
+	}
+	return nil
+}
+
+// RecordJob inserts a job or replaces the existing record with the same ID
+func (s *Store) RecordJob(rec *JobRecord) error {
+	_, err := s.db.Exec(`INSERT INTO jobs (`+jobColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(job_id) DO UPDATE SET
+			task_type = excluded.task_type,
+			docker_image = excluded.docker_image,
+			status = excluded.status,
+			error_message = excluded.error_message,
+			started_at = excluded.started_at,
+			finished_at = excluded.finished_at,
+			reward = excluded.reward,
+			reward_status = excluded.reward_status,
+			download_bytes = excluded.download_bytes,
+			upload_bytes = excluded.upload_bytes,
+			container_rx_bytes = excluded.container_rx_bytes,
+			container_tx_bytes = excluded.container_tx_bytes`,
+		rec.JobID, rec.TaskType, rec.DockerImage, rec.Status, rec.ErrorMessage,
+		rec.StartedAt.Unix(), unixOrZero(rec.FinishedAt), rec.Reward, rec.RewardStatus,
+		rec.DownloadBytes, rec.UploadBytes, rec.ContainerRxBytes, rec.ContainerTxBytes,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record job %s: %w", rec.JobID, err)
+	}
+	return nil
+}
+
+// FailRunning marks jobs left running by a previous session (e.g. after a
+// crash or power loss) as failed
+func (s *Store) FailRunning(reason string) error {
+	_, err := s.db.Exec(`UPDATE jobs SET status = ?, error_message = ?, finished_at = ? WHERE status = ?`,
+		StatusFailed, reason, time.Now().Unix(), StatusRunning)
+	if err != nil {
+		return fmt.Errorf("failed to update interrupted jobs: %w", err)
+	}
+	return nil
+}
+
+// RecentJobs returns up to limit jobs, newest first
+func (s *Store) RecentJobs(limit int) ([]JobRecord, error) {
+	rows, err := s.db.Query(`SELECT `+jobColumns+`
+		FROM jobs ORDER BY started_at DESC LIMIT ?`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query jobs: %w", err)
+	}
+	defer rows.Close()
+
+	return scanJobs(rows)
+}
+
+// CurrentJob returns the most recent job that is still running, or nil
+func (s *Store) CurrentJob() (*JobRecord, error) {
+	rows, err := s.db.Query(`SELECT `+jobColumns+`
+		FROM jobs WHERE status = ? ORDER BY started_at DESC LIMIT 1`, StatusRunning)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query jobs: %w", err)
+	}
+	defer rows.Close()
+
+	jobs, err := scanJobs(rows)
+	if err != nil || len(jobs) == 0 {
+		return nil, err
+	}
+	return &jobs[0], nil
+}
+
+// EarningsSince aggregates rewards of finished jobs by day and task type,
+// newest day first
+func (s *Store) EarningsSince(since time.Time) ([]EarningsRow, error) {
+	rows, err := s.db.Query(`SELECT `+jobColumns+`
+		FROM jobs WHERE finished_at >= ? AND reward > 0`, since.Unix())
+	if err != nil {
+		return nil, fmt.Errorf("failed to query earnings: %w", err)
+	}
+	defer rows.Close()
+
+	jobs, err := scanJobs(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	// Group in Go so days follow the local time zone
+	byKey := make(map[[2]string]*EarningsRow)
+	for _, job := range jobs {
+		key := [2]string{job.FinishedAt.Format("2006-01-02"), job.TaskType}
+		row, ok := byKey[key]
+		if !ok {
+			row = &EarningsRow{Date: key[0], TaskType: key[1]}
+			byKey[key] = row
+		}
+		row.Jobs++
+		if job.RewardStatus == RewardPaid {
+			row.Paid += job.Reward
+		} else {
+			row.Pending += job.Reward
+		}
+	}
+
+	result := make([]EarningsRow, 0, len(byKey))
+	for _, row := range byKey {
+		result = append(result, *row)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Date != result[j].Date {
+			return result[i].Date > result[j].Date
+		}
+		return result[i].TaskType < result[j].TaskType
+	})
+	return result, nil
+}
+
+// TotalEarnings returns the lifetime number of completed jobs and rewards
+func (s *Store) TotalEarnings() (jobs int, rewards float64, err error) {
+	err = s.db.QueryRow(`SELECT COUNT(*), COALESCE(SUM(reward), 0) FROM jobs WHERE status = ?`,
+		StatusCompleted).Scan(&jobs, &rewards)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to query total earnings: %w", err)
+	}
+	return jobs, rewards, nil
+}
+
+// TrafficSince returns the bytes transferred by jobs started at or after since
+func (s *Store) TrafficSince(since time.Time) (int64, error) {
+	var total int64
+	err := s.db.QueryRow(`SELECT COALESCE(SUM(download_bytes + upload_bytes + container_rx_bytes + container_tx_bytes), 0)
+		FROM jobs WHERE started_at >= ?`, since.Unix()).Scan(&total)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query traffic: %w", err)
+	}
+	return total, nil
+}
+
+func scanJobs(rows *sql.Rows) ([]JobRecord, error) {
+	var jobs []JobRecord
+	for rows.Next() {
+		var rec JobRecord
+		var startedAt, finishedAt int64
+		if err := rows.Scan(&rec.JobID, &rec.TaskType, &rec.DockerImage, &rec.Status, &rec.ErrorMessage,
+			&startedAt, &finishedAt, &rec.Reward, &rec.RewardStatus,
+			&rec.DownloadBytes, &rec.UploadBytes, &rec.ContainerRxBytes, &rec.ContainerTxBytes); err != nil {
+			return nil, fmt.Errorf("failed to read job: %w", err)
+		}
+		rec.StartedAt = time.Unix(startedAt, 0)
+		if finishedAt != 0 {
+			rec.FinishedAt = time.Unix(finishedAt, 0)
+		}
+		jobs = append(jobs, rec)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read jobs: %w", err)
+	}
+	return jobs, nil
+}
+
+func unixOrZero(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
@@ -0,0 +1,285 @@
+package history
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func openTemp(t *testing.T) *Store {
+	t.Helper()
+	s, err := Open(filepath.Join(t.TempDir(), HistoryFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func schemaVersion(t *testing.T, db *sql.DB) int {
+	t.Helper()
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		t.Fatal(err)
+	}
+	return version
+}
+
+func TestOpen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data", HistoryFile)
+	for i := 0; i < 2; i++ {
+		s, err := Open(path)
+		if err != nil {
+			t.Fatalf("open %d: %v", i+1, err)
+		}
+		if v := schemaVersion(t, s.db); v != len(migrations) {
+			t.Errorf("open %d: schema version %d, want %d", i+1, v, len(migrations))
+		}
+		if err := s.RecordJob(&JobRecord{JobID: fmt.Sprintf("job-%d", i), TaskType: "comfyui", Status: StatusCompleted, StartedAt: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+		s.Close()
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	// A database written before traffic was recorded
+	path := filepath.Join(t.TempDir(), HistoryFile)
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := time.Date(2026, 3, 1, 12, 0, 0, 0, time.Local)
+	for _, stmt := range []string{
+		migrations[0],
+		"PRAGMA user_version = 1",
+		fmt.Sprintf(`INSERT INTO jobs (job_id, task_type, status, started_at, finished_at, reward, reward_status)
+			VALUES ('old', 'comfyui', 'completed', %d, %d, 1.5, 'paid')`, started.Unix(), started.Add(time.Minute).Unix()),
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.Close()
+
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if v := schemaVersion(t, s.db); v != len(migrations) {
+		t.Errorf("schema version %d, want %d", v, len(migrations))
+	}
+	jobs, err := s.RecentJobs(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 1 || jobs[0].JobID != "old" || jobs[0].Reward != 1.5 || jobs[0].TrafficBytes() != 0 {
+		t.Errorf("jobs %+v, want the old job without traffic", jobs)
+	}
+}
+
+func TestOpenReadOnly(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, HistoryFile)
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, stmt := range []string{
+		migrations[0],
+		"PRAGMA user_version = 1",
+		`INSERT INTO jobs (job_id, task_type, status, started_at) VALUES ('old', 'comfyui', 'completed', 1)`,
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.Close()
+
+	if _, err := OpenReadOnly(filepath.Join(dir, "missing.db")); err == nil {
+		t.Error("opened a database that doesn't exist")
+	}
+	s, err := OpenReadOnly(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RecordJob(&JobRecord{JobID: "new", TaskType: "comfyui", Status: StatusRunning, StartedAt: time.Now()}); err == nil {
+		t.Error("wrote to a read-only database")
+	}
+	copyPath := filepath.Join(dir, "copy.db")
+	if err := s.CopyTo(copyPath); err != nil {
+		t.Fatal(err)
+	}
+	if v := schemaVersion(t, s.db); v != 1 {
+		t.Errorf("schema version %d after a read-only open, want 1", v)
+	}
+	s.Close()
+
+	copied, err := Open(copyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer copied.Close()
+	jobs, err := copied.RecentJobs(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 1 || jobs[0].JobID != "old" {
+		t.Errorf("copied jobs %+v, want the old job", jobs)
+	}
+}
+
+func TestMigrateRollsBack(t *testing.T) {
+	path := filepath.Join(t.TempDir(), HistoryFile)
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	// The first statement of the migration succeeds, the second fails
+	defer func(saved []string) { migrations = saved }(migrations)
+	migrations = append(migrations[:len(migrations):len(migrations)],
+		`CREATE TABLE payouts (id INTEGER PRIMARY KEY);
+		ALTER TABLE missing ADD COLUMN x INTEGER;`)
+
+	if _, err := Open(path); err == nil || !strings.Contains(err.Error(), fmt.Sprintf("version %d", len(migrations))) {
+		t.Fatalf("err = %v, want the failed migration", err)
+	}
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if v := schemaVersion(t, db); v != len(migrations)-1 {
+		t.Errorf("schema version %d after a failed migration, want %d", v, len(migrations)-1)
+	}
+	var tables int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE name = 'payouts'`).Scan(&tables); err != nil {
+		t.Fatal(err)
+	}
+	if tables != 0 {
+		t.Error("the failed migration was partly applied")
+	}
+}
+
+func TestRecordJob(t *testing.T) {
+	s := openTemp(t)
+	base := time.Date(2026, 3, 1, 12, 0, 0, 0, time.Local)
+
+	for i, id := range []string{"a", "b", "c"} {
+		rec := &JobRecord{JobID: id, TaskType: "comfyui", DockerImage: "rios/comfyui:latest", Status: StatusRunning,
+			StartedAt: base.Add(time.Duration(i) * time.Minute)}
+		if err := s.RecordJob(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	current, err := s.CurrentJob()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current == nil || current.JobID != "c" || !current.FinishedAt.IsZero() {
+		t.Fatalf("current job %+v, want c", current)
+	}
+
+	// Finishing c replaces its record
+	done := &JobRecord{JobID: "c", TaskType: "comfyui", Status: StatusCompleted, StartedAt: base.Add(2 * time.Minute),
+		FinishedAt: base.Add(5 * time.Minute), Reward: 2, RewardStatus: RewardPending,
+		DownloadBytes: 100, UploadBytes: 20, ContainerRxBytes: 3, ContainerTxBytes: 4}
+	if err := s.RecordJob(done); err != nil {
+		t.Fatal(err)
+	}
+	if current, err = s.CurrentJob(); err != nil || current == nil || current.JobID != "b" {
+		t.Fatalf("current job %+v (%v), want b", current, err)
+	}
+
+	// Jobs left running by a crash
+	if err := s.FailRunning("worker restarted"); err != nil {
+		t.Fatal(err)
+	}
+	if current, err = s.CurrentJob(); err != nil || current != nil {
+		t.Fatalf("current job %+v (%v), want none", current, err)
+	}
+
+	jobs, err := s.RecentJobs(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 2 || jobs[0].JobID != "c" || jobs[1].JobID != "b" {
+		t.Fatalf("recent jobs %+v, want c and b", jobs)
+	}
+	if got := jobs[0]; got.Status != StatusCompleted || got.Duration() != 3*time.Minute || got.Reward != 2 ||
+		got.RewardStatus != RewardPending || got.TrafficBytes() != 127 || got.DockerImage != "" {
+		t.Errorf("job c %+v, want the finished record", got)
+	}
+	if got := jobs[1]; got.Status != StatusFailed || got.ErrorMessage != "worker restarted" || got.FinishedAt.IsZero() {
+		t.Errorf("job b %+v, want failed by the restart", got)
+	}
+}
+
+func TestEarnings(t *testing.T) {
+	s := openTemp(t)
+	day1 := time.Date(2026, 3, 1, 10, 0, 0, 0, time.Local)
+	day2 := day1.AddDate(0, 0, 1)
+
+	for _, rec := range []JobRecord{
+		{JobID: "1", TaskType: "comfyui", Status: StatusCompleted, StartedAt: day1, FinishedAt: day1.Add(time.Hour), Reward: 1, RewardStatus: RewardPaid, DownloadBytes: 1000},
+		{JobID: "2", TaskType: "comfyui", Status: StatusCompleted, StartedAt: day1, FinishedAt: day1.Add(2 * time.Hour), Reward: 2, RewardStatus: RewardPending, UploadBytes: 10},
+		{JobID: "3", TaskType: "wan", Status: StatusCompleted, StartedAt: day1, FinishedAt: day1.Add(3 * time.Hour), Reward: 4, RewardStatus: RewardPaid},
+		{JobID: "4", TaskType: "comfyui", Status: StatusCompleted, StartedAt: day2, FinishedAt: day2.Add(time.Hour), Reward: 8, RewardStatus: RewardPaid, ContainerRxBytes: 5, ContainerTxBytes: 5},
+		// Failed and rejected jobs earn nothing
+		{JobID: "5", TaskType: "comfyui", Status: StatusFailed, StartedAt: day2, FinishedAt: day2.Add(time.Hour), DownloadBytes: 300},
+		{JobID: "6", TaskType: "wan", Status: StatusRejected, StartedAt: day2, FinishedAt: day2},
+	} {
+		if err := s.RecordJob(&rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		since       time.Time
+		wantRows    string
+		wantTraffic int64
+	}{
+		{
+			since:       day1.Add(-time.Hour),
+			wantRows:    "[{2026-03-02 comfyui 1 0 8} {2026-03-01 comfyui 2 2 1} {2026-03-01 wan 1 0 4}]",
+			wantTraffic: 1320,
+		},
+		{
+			since:       day2,
+			wantRows:    "[{2026-03-02 comfyui 1 0 8}]",
+			wantTraffic: 310,
+		},
+		{since: day2.AddDate(0, 0, 1), wantRows: "[]"},
+	}
+	for _, tt := range tests {
+		rows, err := s.EarningsSince(tt.since)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := fmt.Sprint(rows); got != tt.wantRows {
+			t.Errorf("earnings since %s = %s, want %s", tt.since, got, tt.wantRows)
+		}
+		traffic, err := s.TrafficSince(tt.since)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if traffic != tt.wantTraffic {
+			t.Errorf("traffic since %s = %d, want %d", tt.since, traffic, tt.wantTraffic)
+		}
+	}
+
+	jobs, rewards, err := s.TotalEarnings()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if jobs != 4 || rewards != 15 {
+		t.Errorf("total earnings %d jobs, %v, want 4 jobs, 15", jobs, rewards)
+	}
+}
@@ -0,0 +1,163 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// consoleHandler renders records for people: an emoji, the message and its
+// attributes as key=value. Lines about a job are indented under it and the
+// node ID, the same on every line, is left out. In journald mode every
+// line starts with its syslog priority, which journald strips and records
+// (see sd-daemon(3)).
+type consoleHandler struct {
+	out      *console
+	level    slog.Level
+	journald bool
+
+	attrs  []slog.Attr // from WithAttrs, shown after the record's own
+	group  string      // prefix of attribute keys from WithGroup
+	inJob  bool
+	stream bool
+}
+
+// console serializes the lines of every handler derived from one
+type console struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func newConsoleHandler(w io.Writer, level slog.Level, journald bool) *consoleHandler {
+	return &consoleHandler{out: &console{w: w}, level: level, journald: journald}
+}
+
+func (h *consoleHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level
+}
+
+func (h *consoleHandler) Handle(_ context.Context, r slog.Record) error {
+	var b strings.Builder
+	if h.journald {
+		fmt.Fprintf(&b, "<%d>", priority(r.Level))
+	}
+	if h.inJob {
+		b.WriteString("   ")
+	}
+
+	// Container output is shown as the container wrote it
+	if h.stream {
+		b.WriteString(r.Message)
+		b.WriteByte('\n')
+		return h.write(b.String())
+	}
+
+	icon := ""
+	var attrs []slog.Attr
+	r.Attrs(func(a slog.Attr) bool {
+		if a.Key == KeyIcon {
+			icon = a.Value.String()
+		} else {
+			attrs = append(attrs, a)
+		}
+		return true
+	})
+	if icon == "" {
+		switch {
+		case r.Level >= slog.LevelError:
+			icon = "❌"
+		case r.Level >= slog.LevelWarn:
+			icon = "⚠️ "
+		}
+	}
+	if icon != "" {
+		b.WriteString(icon)
+		b.WriteByte(' ')
+	}
+	b.WriteString(r.Message)
+
+	for _, a := range append(attrs, h.attrs...) {
+		h.appendAttr(&b, h.group, a)
+	}
+	b.WriteByte('\n')
+	return h.write(b.String())
+}
+
+func (h *consoleHandler) write(line string) error {
+	h.out.mu.Lock()
+	defer h.out.mu.Unlock()
+	_, err := io.WriteString(h.out.w, line)
+	return err
+}
+
+func (h *consoleHandler) appendAttr(b *strings.Builder, prefix string, a slog.Attr) {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+	key := a.Key
+	if prefix != "" {
+		key = prefix + "." + key
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		for _, ga := range a.Value.Group() {
+			h.appendAttr(b, key, ga)
+		}
+		return
+	}
+
+	value := a.Value.String()
+	if value == "" || strings.ContainsAny(value, " \t\n\"=") {
+		value = strconv.Quote(value)
+	}
+	fmt.Fprintf(b, "  %s=%s", key, value)
+}
+
+func (h *consoleHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	clone.attrs = append([]slog.Attr{}, h.attrs...)
+	for _, a := range attrs {
+		switch {
+		case h.group == "" && a.Key == KeyJobID:
+			clone.inJob = true
+		case h.group == "" && a.Key == KeyStream:
+			clone.stream = true
+		case h.group == "" && a.Key == KeyNodeID:
+		default:
+			if h.group != "" {
+				a = slog.Group(h.group, a)
+			}
+			clone.attrs = append(clone.attrs, a)
+		}
+	}
+	return &clone
+}
+
+func (h *consoleHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	clone := *h
+	if clone.group != "" {
+		name = clone.group + "." + name
+	}
+	clone.group = name
+	return &clone
+}
+
+// priority maps a level to a syslog priority
+func priority(level slog.Level) int {
+	switch {
+	case level >= slog.LevelError:
+		return 3
+	case level >= slog.LevelWarn:
+		return 4
+	case level >= slog.LevelInfo:
+		return 6
+	}
+	return 7
+}
@@ -0,0 +1,33 @@
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// JournalWriter returns a writer that prefixes every line with the syslog
+// priority of level, for output that doesn't go through the logger such as
+// cobra's error messages. journald strips the "<N>" prefix and records it
+// as the priority of the line (see sd-daemon(3)).
+func JournalWriter(w io.Writer, level slog.Level) io.Writer {
+	return &journalWriter{out: w, priority: priority(level)}
+}
+
+type journalWriter struct {
+	out      io.Writer
+	priority int
+}
+
+func (j *journalWriter) Write(p []byte) (int, error) {
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if _, err := fmt.Fprintf(j.out, "<%d>%s\n", j.priority, line); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
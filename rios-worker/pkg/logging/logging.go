@@ -0,0 +1,154 @@
+// Package logging builds the worker's slog loggers. Every format carries the
+// same records; pretty renders them as the emoji console output people read
+// in a terminal, json and text are for log aggregators and journald for the
+// systemd journal.
+package logging
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+)
+
+// Log formats
+const (
+	FormatPretty   = "pretty"
+	FormatText     = "text"
+	FormatJSON     = "json"
+	FormatJournald = "journald"
+)
+
+// Attribute keys with a meaning of their own
+const (
+	KeyNodeID = "node_id"
+	KeyJobID  = "job_id"
+	// KeyIcon is the emoji a pretty line starts with; other formats drop it
+	KeyIcon = "icon"
+	// KeyStream marks a line a container wrote to stdout or stderr
+	KeyStream = "stream"
+)
+
+// Icon returns the attribute that sets the emoji of a pretty line
+func Icon(emoji string) slog.Attr {
+	return slog.String(KeyIcon, emoji)
+}
+
+// Bytes logs a number of bytes, readable in pretty and text logs and a
+// plain number in JSON
+type Bytes int64
+
+// String renders the byte count with a binary unit
+func (b Bytes) String() string {
+	const unit = 1024
+	n := int64(b)
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for v := n / unit; v >= unit; v /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// New returns a logger writing records of at least level to w in format
+func New(w io.Writer, format string, level slog.Level) (*slog.Logger, error) {
+	opts := &slog.HandlerOptions{Level: level, ReplaceAttr: dropIcon}
+	switch format {
+	case FormatPretty:
+		return slog.New(newConsoleHandler(w, level, false)), nil
+	case FormatJournald:
+		return slog.New(newConsoleHandler(w, level, true)), nil
+	case FormatText:
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case FormatJSON:
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	}
+	return nil, fmt.Errorf("invalid log format %q: must be pretty, text, json or journald", format)
+}
+
+// ParseLevel parses debug, info, warn or error
+func ParseLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return 0, fmt.Errorf("invalid log level %q: must be debug, info, warn or error", s)
+	}
+	return level, nil
+}
+
+func dropIcon(groups []string, a slog.Attr) slog.Attr {
+	if len(groups) == 0 && a.Key == KeyIcon {
+		return slog.Attr{}
+	}
+	return a
+}
+
+type contextKey struct{}
+
+// NewContext returns a context carrying logger, e.g. one with a job ID
+func NewContext(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, logger)
+}
+
+// FromContext returns the logger of ctx, or the default logger
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(contextKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// Writer returns a writer that logs every line written to it as a record
+// of stream, e.g. a container's stdout. Close logs an unterminated last
+// line.
+func Writer(logger *slog.Logger, stream string) io.WriteCloser {
+	return &lineWriter{logger: logger.With(KeyStream, stream)}
+}
+
+type lineWriter struct {
+	logger *slog.Logger
+	mu     sync.Mutex
+	buf    []byte
+}
+
+func (l *lineWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.buf = append(l.buf, p...)
+	for {
+		i := bytes.IndexByte(l.buf, '\n')
+		if i < 0 {
+			break
+		}
+		l.log(l.buf[:i])
+		l.buf = l.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+func (l *lineWriter) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.buf) > 0 {
+		l.log(l.buf)
+		l.buf = nil
+	}
+	return nil
+}
+
+func (l *lineWriter) log(line []byte) {
+	// Progress bars redraw with carriage returns, keep the final state
+	text := string(line)
+	if i := strings.LastIndexByte(strings.TrimRight(text, "\r"), '\r'); i >= 0 {
+		text = text[i+1:]
+	}
+	text = strings.TrimRight(text, "\r ")
+	if text != "" {
+		l.logger.Info(text)
+	}
+}
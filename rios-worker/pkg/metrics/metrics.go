@@ -0,0 +1,191 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/rios/worker/pkg/gpu"
+)
+
+const namespace = "rios_worker"
+
+// Job outcomes used as the status label of JobsTotal
+const (
+	JobCompleted = "completed"
+	JobFailed    = "failed"
+	JobCancelled = "cancelled"
+)
+
+// Traffic kinds used as the kind label of TrafficBytes
+const (
+	TrafficDownload    = "download"
+	TrafficUpload      = "upload"
+	TrafficContainerRx = "container_rx"
+	TrafficContainerTx = "container_tx"
+)
+
+// Verification results used as the result label of Verifications
+const (
+	VerificationPassed = "passed"
+	VerificationFailed = "failed"
+)
+
+// GPUSampler returns the latest telemetry sample of each GPU, none while
+// telemetry is unavailable
+type GPUSampler func() []gpu.Sample
+
+// Metrics holds the Prometheus metrics exported by a running worker
+type Metrics struct {
+	registry *prometheus.Registry
+
+	JobsTotal         *prometheus.CounterVec
+	JobDuration       *prometheus.HistogramVec
+	HeartbeatFailures prometheus.Counter
+	RewardsTotal      prometheus.Counter
+	RunningJobs       prometheus.Gauge
+	Paused            prometheus.Gauge
+	TrafficBytes      *prometheus.CounterVec
+	Verifications     *prometheus.CounterVec
+}
+
+// New creates the worker metrics. sampleGPU may be nil when GPU telemetry
+// is not available.
+func New(sampleGPU GPUSampler) *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		JobsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "jobs_total",
+			Help:      "Jobs processed by this worker, by outcome.",
+		}, []string{"status"}),
+		JobDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "job_duration_seconds",
+			Help:      "Job execution time from receipt to result submission.",
+			Buckets:   []float64{10, 30, 60, 120, 300, 600, 1200, 1800, 3600, 7200, 14400},
+		}, []string{"task_type"}),
+		HeartbeatFailures: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "heartbeat_failures_total",
+			Help:      "Heartbeats that could not be delivered to the orchestrator.",
+		}),
+		RewardsTotal: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "rewards_ros_total",
+			Help:      "$ROS rewarded for jobs since the worker started.",
+		}),
+		RunningJobs: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "running_jobs",
+			Help:      "Jobs currently being executed.",
+		}),
+		Paused: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "paused",
+			Help:      "1 if the worker is not taking new jobs.",
+		}),
+		TrafficBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "traffic_bytes_total",
+			Help:      "Network traffic of jobs, by kind.",
+		}, []string{"kind"}),
+		Verifications: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "verifications_total",
+			Help:      "Verification and canary jobs whose outputs were compared, by result.",
+		}, []string{"result"}),
+	}
+
+	m.registry.MustRegister(
+		m.JobsTotal,
+		m.JobDuration,
+		m.HeartbeatFailures,
+		m.RewardsTotal,
+		m.RunningJobs,
+		m.Paused,
+		m.TrafficBytes,
+		m.Verifications,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	if sampleGPU != nil {
+		m.registry.MustRegister(&gpuCollector{sample: sampleGPU})
+	}
+
+	// Make every outcome visible from the first scrape
+	for _, status := range []string{JobCompleted, JobFailed, JobCancelled} {
+		m.JobsTotal.WithLabelValues(status)
+	}
+	for _, kind := range []string{TrafficDownload, TrafficUpload, TrafficContainerRx, TrafficContainerTx} {
+		m.TrafficBytes.WithLabelValues(kind)
+	}
+	for _, result := range []string{VerificationPassed, VerificationFailed} {
+		m.Verifications.WithLabelValues(result)
+	}
+
+	return m
+}
+
+// Handler returns the HTTP handler serving the metrics
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// gpuCollector exports the latest GPU telemetry on every scrape
+type gpuCollector struct {
+	sample GPUSampler
+}
+
+var gpuDescs = struct {
+	utilization, memoryUsed, temperature, powerDraw, clockSM, throttled *prometheus.Desc
+}{
+	utilization: gpuDesc("gpu_utilization_percent", "GPU utilization as reported by the driver."),
+	memoryUsed:  gpuDesc("gpu_memory_used_bytes", "GPU memory in use."),
+	temperature: gpuDesc("gpu_temperature_celsius", "GPU core temperature."),
+	powerDraw:   gpuDesc("gpu_power_draw_watts", "GPU power draw."),
+	clockSM:     gpuDesc("gpu_sm_clock_hertz", "GPU SM clock."),
+	throttled:   gpuDesc("gpu_throttled", "1 if the GPU is throttled for thermal or power reasons."),
+}
+
+var telemetryUpDesc = prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "gpu_telemetry_up"),
+	"1 if current GPU telemetry is available.", nil, nil)
+
+func gpuDesc(name, help string) *prometheus.Desc {
+	return prometheus.NewDesc(prometheus.BuildFQName(namespace, "", name), help, []string{"gpu"}, nil)
+}
+
+func (c *gpuCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- gpuDescs.utilization
+	ch <- gpuDescs.memoryUsed
+	ch <- gpuDescs.temperature
+	ch <- gpuDescs.powerDraw
+	ch <- gpuDescs.clockSM
+	ch <- gpuDescs.throttled
+	ch <- telemetryUpDesc
+}
+
+func (c *gpuCollector) Collect(ch chan<- prometheus.Metric) {
+	samples := c.sample()
+	up := 0.0
+	if len(samples) > 0 {
+		up = 1
+	}
+	ch <- prometheus.MustNewConstMetric(telemetryUpDesc, prometheus.GaugeValue, up)
+
+	for _, s := range samples {
+		label := strconv.Itoa(s.Index)
+		throttled := 0.0
+		if s.Throttled() {
+			throttled = 1
+		}
+		ch <- prometheus.MustNewConstMetric(gpuDescs.utilization, prometheus.GaugeValue, s.UtilizationPct, label)
+		ch <- prometheus.MustNewConstMetric(gpuDescs.memoryUsed, prometheus.GaugeValue, s.MemoryUsedMiB*1024*1024, label)
+		ch <- prometheus.MustNewConstMetric(gpuDescs.temperature, prometheus.GaugeValue, s.TemperatureC, label)
+		ch <- prometheus.MustNewConstMetric(gpuDescs.powerDraw, prometheus.GaugeValue, s.PowerDrawW, label)
+		ch <- prometheus.MustNewConstMetric(gpuDescs.clockSM, prometheus.GaugeValue, s.ClockSMMHz*1e6, label)
+		ch <- prometheus.MustNewConstMetric(gpuDescs.throttled, prometheus.GaugeValue, throttled, label)
+	}
+}
@@ -0,0 +1,92 @@
+package output
+
+import (
+	"archive/zip"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/rios/worker/pkg/api"
+)
+
+// maxSafetensorsHeader bounds the JSON header of a safetensors file
+const maxSafetensorsHeader = 100 << 20
+
+// inspectCheckpoint checks that a checkpoint would load: safetensors files
+// must have a header whose tensors exactly cover the data, PyTorch files
+// must be a readable zip archive with a pickle or a legacy pickle stream
+func inspectCheckpoint(f *os.File, file *api.ResultFile) error {
+	var magic [8]byte
+	if _, err := f.ReadAt(magic[:], 0); err != nil {
+		return fmt.Errorf("checkpoint is truncated")
+	}
+
+	if strings.HasSuffix(strings.ToLower(f.Name()), ".safetensors") {
+		return inspectSafetensors(f, binary.LittleEndian.Uint64(magic[:]), file)
+	}
+
+	switch {
+	case string(magic[:4]) == "PK\x03\x04":
+		zr, err := zip.NewReader(f, file.SizeBytes)
+		if err != nil {
+			return fmt.Errorf("checkpoint archive is corrupt: %w", err)
+		}
+		for _, entry := range zr.File {
+			if strings.HasSuffix(entry.Name, "/data.pkl") || entry.Name == "data.pkl" {
+				return nil
+			}
+		}
+		return fmt.Errorf("checkpoint archive has no data.pkl")
+	case magic[0] == 0x80 && magic[1] >= 2 && magic[1] <= 5:
+		// Legacy torch.save format: a pickle protocol header
+		return nil
+	}
+	return fmt.Errorf("not a valid checkpoint")
+}
+
+func inspectSafetensors(f *os.File, headerLen uint64, file *api.ResultFile) error {
+	if headerLen == 0 || headerLen > maxSafetensorsHeader || int64(headerLen) > file.SizeBytes-8 {
+		return fmt.Errorf("not a valid safetensors file: bad header length %d", headerLen)
+	}
+
+	header := make([]byte, headerLen)
+	if _, err := f.ReadAt(header, 8); err != nil {
+		return fmt.Errorf("safetensors file is truncated")
+	}
+	var tensors map[string]json.RawMessage
+	if err := json.Unmarshal(header, &tensors); err != nil {
+		return fmt.Errorf("not a valid safetensors header: %w", err)
+	}
+
+	dataLen := uint64(file.SizeBytes) - 8 - headerLen
+	var end uint64
+	for name, raw := range tensors {
+		if name == "__metadata__" {
+			continue
+		}
+		var info struct {
+			DType       string   `json:"dtype"`
+			DataOffsets []uint64 `json:"data_offsets"`
+		}
+		if err := json.Unmarshal(raw, &info); err != nil || info.DType == "" || len(info.DataOffsets) != 2 {
+			return fmt.Errorf("safetensors tensor %q has an invalid header entry", name)
+		}
+		if info.DataOffsets[0] > info.DataOffsets[1] || info.DataOffsets[1] > dataLen {
+			return fmt.Errorf("safetensors tensor %q lies outside the file (truncated?)", name)
+		}
+		if info.DataOffsets[1] > end {
+			end = info.DataOffsets[1]
+		}
+		file.Tensors++
+	}
+
+	if file.Tensors == 0 {
+		return fmt.Errorf("safetensors file has no tensors")
+	}
+	if end != dataLen {
+		return fmt.Errorf("safetensors data is %d bytes, tensors cover %d", dataLen, end)
+	}
+	return nil
+}
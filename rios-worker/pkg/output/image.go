@@ -0,0 +1,81 @@
+package output
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	_ "image/gif" // register decoders for image.Decode
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"os"
+
+	"github.com/rios/worker/pkg/api"
+)
+
+// inspectImage reads the dimensions of an image and decodes it fully, so a
+// truncated file fails rather than only its header being checked
+func inspectImage(f *os.File, file *api.ResultFile) error {
+	header := make([]byte, 30)
+	n, _ := f.ReadAt(header, 0)
+	if n >= 12 && bytes.Equal(header[0:4], []byte("RIFF")) && bytes.Equal(header[8:12], []byte("WEBP")) {
+		return inspectWebP(header[:n], file)
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	cfg, format, err := image.DecodeConfig(f)
+	if err != nil {
+		return fmt.Errorf("not a valid image: %w", err)
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 {
+		return fmt.Errorf("%s image has no pixels", format)
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if _, _, err := image.Decode(f); err != nil {
+		return fmt.Errorf("%s image is corrupt: %w", format, err)
+	}
+
+	file.Width, file.Height = cfg.Width, cfg.Height
+	return nil
+}
+
+// inspectWebP reads the dimensions from a WebP header. The standard library
+// has no WebP decoder, so the RIFF size is checked against the file size to
+// catch truncation.
+func inspectWebP(header []byte, file *api.ResultFile) error {
+	if riffSize := int64(binary.LittleEndian.Uint32(header[4:8])) + 8; riffSize != file.SizeBytes {
+		return fmt.Errorf("webp image is truncated: header says %d bytes, file has %d", riffSize, file.SizeBytes)
+	}
+	if len(header) < 30 {
+		return fmt.Errorf("webp image is truncated")
+	}
+
+	switch string(header[12:16]) {
+	case "VP8 ":
+		// Lossy: 14-bit dimensions after the key frame start code
+		file.Width = int(binary.LittleEndian.Uint16(header[26:28]) & 0x3fff)
+		file.Height = int(binary.LittleEndian.Uint16(header[28:30]) & 0x3fff)
+	case "VP8L":
+		// Lossless: 14-bit width-1 and height-1 after the signature byte
+		bits := binary.LittleEndian.Uint32(header[21:25])
+		file.Width = int(bits&0x3fff) + 1
+		file.Height = int(bits>>14&0x3fff) + 1
+	case "VP8X":
+		// Extended: 24-bit canvas width-1 and height-1
+		file.Width = int(uint32(header[24])|uint32(header[25])<<8|uint32(header[26])<<16) + 1
+		file.Height = int(uint32(header[27])|uint32(header[28])<<8|uint32(header[29])<<16) + 1
+	default:
+		return fmt.Errorf("not a valid webp image")
+	}
+
+	if file.Width <= 0 || file.Height <= 0 {
+		return fmt.Errorf("webp image has no pixels")
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package output
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/rios/worker/pkg/api"
+)
+
+// Kinds of output files
+const (
+	KindImage      = "image"
+	KindVideo      = "video"
+	KindCheckpoint = "checkpoint"
+	KindOther      = "other"
+)
+
+var kindsByExt = map[string]string{
+	".png":         KindImage,
+	".jpg":         KindImage,
+	".jpeg":        KindImage,
+	".gif":         KindImage,
+	".webp":        KindImage,
+	".mp4":         KindVideo,
+	".m4v":         KindVideo,
+	".mov":         KindVideo,
+	".webm":        KindVideo,
+	".mkv":         KindVideo,
+	".safetensors": KindCheckpoint,
+	".ckpt":        KindCheckpoint,
+	".pt":          KindCheckpoint,
+	".pth":         KindCheckpoint,
+}
+
+// requiredKinds is what each task type must produce at least one of
+var requiredKinds = map[string][]string{
+	"comfyui":  {KindImage, KindVideo},
+	"training": {KindCheckpoint},
+}
+
+// Validate inspects every file in dir and returns the result manifest. It
+// fails if an image, video or checkpoint is empty or doesn't parse as what
+// its extension says, if images or videos miss expect, or if the task type's
+// main output is missing.
+func Validate(taskType, dir string, expect *api.OutputExpectations) (*api.ResultManifest, error) {
+	manifest := &api.ResultManifest{Files: []api.ResultFile{}}
+
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if !d.Type().IsRegular() {
+			return fmt.Errorf("%s is not a regular file", rel)
+		}
+
+		file, err := Inspect(path)
+		if err != nil {
+			return fmt.Errorf("%s: %w", rel, err)
+		}
+		file.Path = rel
+		if err := check(file, expect); err != nil {
+			return fmt.Errorf("%s: %w", rel, err)
+		}
+		manifest.Files = append(manifest.Files, *file)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(manifest.Files) == 0 {
+		return nil, fmt.Errorf("no output files generated")
+	}
+	if kinds, ok := requiredKinds[taskType]; ok && !hasKind(manifest, kinds) {
+		return nil, fmt.Errorf("%s job produced no %s output", taskType, strings.Join(kinds, " or "))
+	}
+
+	sort.Slice(manifest.Files, func(i, j int) bool {
+		return manifest.Files[i].Path < manifest.Files[j].Path
+	})
+	return manifest, nil
+}
+
+// Inspect hashes the file at path and parses it according to its extension
+func Inspect(path string) (*api.ResultFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	hasher := sha256.New()
+	size, err := io.Copy(hasher, f)
+	if err != nil {
+		return nil, err
+	}
+
+	file := &api.ResultFile{
+		SizeBytes: size,
+		SHA256:    hex.EncodeToString(hasher.Sum(nil)),
+		Kind:      KindOther,
+	}
+	if kind, ok := kindsByExt[strings.ToLower(filepath.Ext(path))]; ok {
+		file.Kind = kind
+	}
+	// Other files, e.g. an empty log, may legitimately be empty
+	if size == 0 && file.Kind != KindOther {
+		return nil, fmt.Errorf("%s is empty", file.Kind)
+	}
+
+	switch file.Kind {
+	case KindImage:
+		err = inspectImage(f, file)
+	case KindVideo:
+		err = inspectVideo(f, path, file)
+	case KindCheckpoint:
+		err = inspectCheckpoint(f, file)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+// check compares a file with the job's expectations
+func check(file *api.ResultFile, expect *api.OutputExpectations) error {
+	if expect == nil {
+		return nil
+	}
+	if file.Kind == KindImage {
+		if expect.Width > 0 && file.Width != expect.Width || expect.Height > 0 && file.Height != expect.Height {
+			return fmt.Errorf("image is %dx%d, expected %dx%d", file.Width, file.Height, expect.Width, expect.Height)
+		}
+	}
+	if file.Kind == KindVideo && expect.MinDurationSeconds > 0 && file.DurationSeconds < expect.MinDurationSeconds {
+		return fmt.Errorf("video is %.1fs long, expected at least %.1fs", file.DurationSeconds, expect.MinDurationSeconds)
+	}
+	return nil
+}
+
+func hasKind(manifest *api.ResultManifest, kinds []string) bool {
+	for _, file := range manifest.Files {
+		for _, kind := range kinds {
+			if file.Kind == kind {
+				return true
+			}
+		}
+	}
+	return false
+}
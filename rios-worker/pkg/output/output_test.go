@@ -0,0 +1,218 @@
+package output
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rios/worker/pkg/api"
+)
+
+func pngFile(t *testing.T, width, height int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, width, height))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// box encodes an MP4 box around its payload
+func box(typ string, payload ...[]byte) []byte {
+	var body []byte
+	for _, p := range payload {
+		body = append(body, p...)
+	}
+	b := binary.BigEndian.AppendUint32(nil, uint32(8+len(body)))
+	return append(append(b, typ...), body...)
+}
+
+func u32(values ...uint32) []byte {
+	var b []byte
+	for _, v := range values {
+		b = binary.BigEndian.AppendUint32(b, v)
+	}
+	return b
+}
+
+// trackHeader is a version 0 tkhd payload of track 1
+func trackHeader(width, height int) []byte {
+	tkhd := make([]byte, 84)
+	binary.BigEndian.PutUint32(tkhd[12:], 1)
+	binary.BigEndian.PutUint32(tkhd[76:], uint32(width)<<16)
+	binary.BigEndian.PutUint32(tkhd[80:], uint32(height)<<16)
+	return tkhd
+}
+
+// mp4File is a 640x480 video of the given length in milliseconds
+func mp4File(durationMs uint32) []byte {
+	var f []byte
+	f = append(f, box("ftyp", []byte("isom"))...)
+	f = append(f, box("moov",
+		box("mvhd", u32(0, 0, 0, 1000, durationMs)),
+		box("trak", box("tkhd", trackHeader(640, 480))))...)
+	return append(f, box("mdat", []byte("frames"))...)
+}
+
+// fragmentedMP4File is a 25 fps video in two fragments: 50 frames of the
+// track's default duration, then 25 frames of their own duration. Its
+// movie header has no duration.
+func fragmentedMP4File() []byte {
+	var f []byte
+	f = append(f, box("ftyp", []byte("iso6"))...)
+	f = append(f, box("moov",
+		box("mvhd", u32(0, 0, 0, 1000, 0)),
+		box("trak", box("tkhd", trackHeader(640, 480)), box("mdia", box("mdhd", u32(0, 0, 0, 25, 0)))),
+		box("mvex", box("trex", u32(0, 1, 1, 1, 0, 0))))...)
+
+	// tfhd with a default sample duration, trun without sample durations
+	f = append(f, box("moof", box("mfhd", u32(0, 1)),
+		box("traf", box("tfhd", u32(0x08, 1, 1)), box("trun", u32(0, 50))))...)
+	f = append(f, box("mdat", []byte("frames"))...)
+
+	// trun with a data offset and the duration and size of each sample
+	samples := u32(0x301, 25, 0)
+	for i := 0; i < 25; i++ {
+		samples = append(samples, u32(1, 100)...)
+	}
+	f = append(f, box("moof", box("mfhd", u32(0, 2)), box("traf", box("tfhd", u32(0, 1)), box("trun", samples)))...)
+	return append(f, box("mdat", []byte("frames"))...)
+}
+
+func TestValidate(t *testing.T) {
+	// A legacy torch.save pickle of {"a": 1}
+	checkpoint := []byte("\x80\x02}q\x00X\x01\x00\x00\x00aq\x01K\x01s.")
+
+	tests := []struct {
+		name     string
+		taskType string
+		files    map[string][]byte
+		expect   *api.OutputExpectations
+		wantErr  string // substring of the error, empty if valid
+	}{
+		{
+			name:     "image",
+			taskType: "comfyui",
+			files:    map[string][]byte{"ComfyUI_00001_.png": pngFile(t, 64, 32)},
+			expect:   &api.OutputExpectations{Width: 64, Height: 32},
+		},
+		{
+			name:     "image of the wrong size",
+			taskType: "comfyui",
+			files:    map[string][]byte{"ComfyUI_00001_.png": pngFile(t, 64, 32)},
+			expect:   &api.OutputExpectations{Width: 512, Height: 512},
+			wantErr:  "image is 64x32, expected 512x512",
+		},
+		{
+			name:     "corrupt image",
+			taskType: "comfyui",
+			files:    map[string][]byte{"ComfyUI_00001_.png": pngFile(t, 64, 32)[:40]},
+			wantErr:  "ComfyUI_00001_.png",
+		},
+		{
+			name:     "empty image",
+			taskType: "comfyui",
+			files:    map[string][]byte{"ComfyUI_00001_.png": nil},
+			wantErr:  "image is empty",
+		},
+		{
+			name:     "empty log next to an image",
+			taskType: "comfyui",
+			files:    map[string][]byte{"ComfyUI_00001_.png": pngFile(t, 8, 8), "logs/run.log": nil},
+		},
+		{
+			name:     "video",
+			taskType: "comfyui",
+			files:    map[string][]byte{"AnimateDiff_00001.mp4": mp4File(2500)},
+			expect:   &api.OutputExpectations{MinDurationSeconds: 2},
+		},
+		{
+			name:     "video too short",
+			taskType: "comfyui",
+			files:    map[string][]byte{"AnimateDiff_00001.mp4": mp4File(1500)},
+			expect:   &api.OutputExpectations{MinDurationSeconds: 2},
+			wantErr:  "video is 1.5s long",
+		},
+		{
+			name:     "video without duration",
+			taskType: "comfyui",
+			files:    map[string][]byte{"AnimateDiff_00001.mp4": mp4File(0)},
+			wantErr:  "video has no duration",
+		},
+		{
+			name:     "truncated video",
+			taskType: "comfyui",
+			files:    map[string][]byte{"AnimateDiff_00001.mp4": mp4File(2500)[:60]},
+			wantErr:  "truncated",
+		},
+		{
+			name:     "fragmented video",
+			taskType: "comfyui",
+			files:    map[string][]byte{"AnimateDiff_00001.mp4": fragmentedMP4File()},
+			expect:   &api.OutputExpectations{MinDurationSeconds: 3},
+		},
+		{
+			name:     "checkpoint",
+			taskType: "training",
+			files:    map[string][]byte{"model.pt": checkpoint},
+		},
+		{
+			name:     "training without checkpoint",
+			taskType: "training",
+			files:    map[string][]byte{"loss.csv": []byte("step,loss\n")},
+			wantErr:  "training job produced no checkpoint output",
+		},
+		{
+			name:     "no outputs",
+			taskType: "comfyui",
+			wantErr:  "no output files generated",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, data := range tt.files {
+				path := filepath.Join(dir, name)
+				if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, data, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			manifest, err := Validate(tt.taskType, dir, tt.expect)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Validate error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Validate: %v", err)
+			}
+			if len(manifest.Files) != len(tt.files) {
+				t.Errorf("manifest has %d files, want %d", len(manifest.Files), len(tt.files))
+			}
+		})
+	}
+}
+
+func TestInspectFragmentedMP4(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "video.mp4")
+	if err := os.WriteFile(path, fragmentedMP4File(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	file, err := Inspect(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file.Kind != KindVideo || file.Width != 640 || file.Height != 480 || file.DurationSeconds != 3 {
+		t.Errorf("Inspect = %s %dx%d %.2fs, want video 640x480 3.00s", file.Kind, file.Width, file.Height, file.DurationSeconds)
+	}
+}
@@ -0,0 +1,59 @@
+package output
+
+import (
+	"encoding/hex"
+	"fmt"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/rios/worker/pkg/api"
+)
+
+// CheckReference checks a verification reference before the job runs, so
+// a job that could never pass isn't executed. A nil reference is valid.
+func CheckReference(v *api.Verification) error {
+	if v == nil {
+		return nil
+	}
+	switch v.Kind {
+	case api.VerifyReexecution, api.VerifyCanary:
+	default:
+		return fmt.Errorf("unknown verification kind %q", v.Kind)
+	}
+	if len(v.Reference) == 0 {
+		return fmt.Errorf("no reference hashes")
+	}
+	for p, digest := range v.Reference {
+		if !filepath.IsLocal(filepath.FromSlash(p)) || path.Clean(p) != p {
+			return fmt.Errorf("reference path %q must be relative to the output directory", p)
+		}
+		if b, err := hex.DecodeString(digest); err != nil || len(b) != 32 {
+			return fmt.Errorf("invalid sha256 digest %q for %s", digest, p)
+		}
+	}
+	return nil
+}
+
+// Compare checks the manifest's hashes against the reference. Every
+// referenced output must exist with the same hash; extra outputs are ignored.
+func Compare(v *api.Verification, manifest *api.ResultManifest) *api.Verdict {
+	hashes := make(map[string]string, len(manifest.Files))
+	for _, file := range manifest.Files {
+		hashes[file.Path] = file.SHA256
+	}
+
+	verdict := &api.Verdict{Kind: v.Kind, Passed: true}
+	for p, expected := range v.Reference {
+		got := hashes[p]
+		if !strings.EqualFold(got, expected) {
+			verdict.Passed = false
+			verdict.Mismatches = append(verdict.Mismatches, api.Mismatch{Path: p, Expected: expected, SHA256: got})
+		}
+	}
+	sort.Slice(verdict.Mismatches, func(i, j int) bool {
+		return verdict.Mismatches[i].Path < verdict.Mismatches[j].Path
+	})
+	return verdict
+}
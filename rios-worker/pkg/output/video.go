@@ -0,0 +1,404 @@
+package output
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/rios/worker/pkg/api"
+)
+
+var errTruncated = errors.New("video is truncated")
+
+// inspectVideo reads the duration and dimensions of a video. MP4 and
+// QuickTime files are parsed directly; other containers need ffprobe.
+func inspectVideo(f *os.File, path string, file *api.ResultFile) error {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".mp4", ".m4v", ".mov":
+		return inspectMP4(f, file)
+	}
+	return inspectFFprobe(path, file)
+}
+
+// inspectMP4 walks the box structure of an MP4/QuickTime file. Every box
+// must fit in the file, there must be media data, and the movie must have a
+// positive duration. Fragmented MP4s leave the duration in the movie header
+// at 0, theirs is summed from the samples of the fragments (moof).
+func inspectMP4(f *os.File, file *api.ResultFile) error {
+	var hasMoov, hasMdat bool
+	frags := newFragments()
+	err := walkBoxes(f, 0, file.SizeBytes, func(typ string, start, end int64) error {
+		switch typ {
+		case "mdat":
+			hasMdat = hasMdat || end > start
+		case "moov":
+			hasMoov = true
+			return walkBoxes(f, start, end, func(typ string, start, end int64) error {
+				switch typ {
+				case "mvhd":
+					return readMovieHeader(f, start, end, file)
+				case "trak":
+					return readTrack(f, start, end, file, frags)
+				case "mvex":
+					return walkBoxes(f, start, end, func(typ string, start, end int64) error {
+						if typ == "trex" {
+							return frags.readTrackExtends(f, start, end)
+						}
+						return nil
+					})
+				}
+				return nil
+			})
+		case "moof":
+			frags.found = true
+			return walkBoxes(f, start, end, func(typ string, start, end int64) error {
+				if typ == "traf" {
+					return frags.readTrackFragment(f, start, end)
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if !hasMoov {
+		return fmt.Errorf("not a valid video: no movie header")
+	}
+	if !hasMdat {
+		return fmt.Errorf("not a valid video: no media data")
+	}
+	if file.DurationSeconds <= 0 && frags.found {
+		file.DurationSeconds = frags.duration()
+	}
+	if file.DurationSeconds <= 0 {
+		return fmt.Errorf("video has no duration")
+	}
+	return nil
+}
+
+// readTrack reads the frame size and the media timescale of a trak box
+func readTrack(r io.ReaderAt, start, end int64, file *api.ResultFile, frags *fragments) error {
+	var trackID uint32
+	return walkBoxes(r, start, end, func(typ string, start, end int64) error {
+		switch typ {
+		case "tkhd":
+			id, err := readTrackHeader(r, start, end, file)
+			trackID = id
+			return err
+		case "mdia":
+			return walkBoxes(r, start, end, func(typ string, start, end int64) error {
+				if typ != "mdhd" {
+					return nil
+				}
+				buf, err := readFullBox(r, start, end, 24)
+				if err != nil {
+					return err
+				}
+				// Same layout as mvhd
+				offset := 12
+				if buf[0] == 1 {
+					offset = 20
+				}
+				if len(buf) < offset+4 {
+					return fmt.Errorf("not a valid video: bad media header")
+				}
+				frags.timescale[trackID] = binary.BigEndian.Uint32(buf[offset : offset+4])
+				return nil
+			})
+		}
+		return nil
+	})
+}
+
+// walkBoxes calls fn with the payload range of each box in [start, end)
+func walkBoxes(r io.ReaderAt, start, end int64, fn func(typ string, start, end int64) error) error {
+	for off := start; off < end; {
+		if end-off < 8 {
+			return errTruncated
+		}
+		var hdr [16]byte
+		if _, err := r.ReadAt(hdr[:8], off); err != nil {
+			return errTruncated
+		}
+		size := int64(binary.BigEndian.Uint32(hdr[0:4]))
+		typ := string(hdr[4:8])
+		headerLen := int64(8)
+		if !isBoxType(hdr[4:8]) {
+			return fmt.Errorf("not a valid video: unexpected data at offset %d", off)
+		}
+
+		switch size {
+		case 0: // extends to the end of the file
+			size = end - off
+		case 1: // 64-bit size follows the type
+			if _, err := r.ReadAt(hdr[8:16], off+8); err != nil {
+				return errTruncated
+			}
+			size = int64(binary.BigEndian.Uint64(hdr[8:16]))
+			headerLen = 16
+		}
+		if size < headerLen {
+			return fmt.Errorf("not a valid video: bad %q box size", typ)
+		}
+		if size > end-off {
+			return fmt.Errorf("%w: %q box needs %d bytes, %d left", errTruncated, typ, size, end-off)
+		}
+
+		if err := fn(typ, off+headerLen, off+size); err != nil {
+			return err
+		}
+		off += size
+	}
+	return nil
+}
+
+// isBoxType reports whether b looks like a box type such as "moov" or "©too"
+func isBoxType(b []byte) bool {
+	for _, c := range b {
+		if (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') && (c < '0' || c > '9') && c != ' ' && c != 0xa9 {
+			return false
+		}
+	}
+	return true
+}
+
+// readMovieHeader reads the duration from an mvhd box
+func readMovieHeader(r io.ReaderAt, start, end int64, file *api.ResultFile) error {
+	buf := make([]byte, min64(end-start, 32))
+	if _, err := r.ReadAt(buf, start); err != nil {
+		return errTruncated
+	}
+
+	var timescale, duration uint64
+	switch {
+	case len(buf) >= 20 && buf[0] == 0:
+		timescale = uint64(binary.BigEndian.Uint32(buf[12:16]))
+		duration = uint64(binary.BigEndian.Uint32(buf[16:20]))
+	case len(buf) >= 32 && buf[0] == 1:
+		timescale = uint64(binary.BigEndian.Uint32(buf[20:24]))
+		duration = binary.BigEndian.Uint64(buf[24:32])
+	default:
+		return fmt.Errorf("not a valid video: bad movie header")
+	}
+	if timescale > 0 {
+		file.DurationSeconds = float64(duration) / float64(timescale)
+	}
+	return nil
+}
+
+// readTrackHeader reads the track ID and the frame size from a tkhd box;
+// audio tracks have no frame size
+func readTrackHeader(r io.ReaderAt, start, end int64, file *api.ResultFile) (uint32, error) {
+	buf := make([]byte, min64(end-start, 92))
+	if _, err := r.ReadAt(buf, start); err != nil {
+		return 0, errTruncated
+	}
+
+	idOffset, sizeOffset := 12, 76
+	if len(buf) > 0 && buf[0] == 1 {
+		idOffset, sizeOffset = 20, 88
+	}
+	var trackID uint32
+	if len(buf) >= idOffset+4 {
+		trackID = binary.BigEndian.Uint32(buf[idOffset : idOffset+4])
+	}
+	// Width and height are 16.16 fixed point at the end of the box
+	if len(buf) < sizeOffset+8 || file.Width != 0 {
+		return trackID, nil
+	}
+	file.Width = int(binary.BigEndian.Uint32(buf[sizeOffset:sizeOffset+4]) >> 16)
+	file.Height = int(binary.BigEndian.Uint32(buf[sizeOffset+4:sizeOffset+8]) >> 16)
+	return trackID, nil
+}
+
+// fragments sums the sample durations of each track over the movie
+// fragments of a fragmented MP4
+type fragments struct {
+	found           bool
+	timescale       map[uint32]uint32 // per track, from mdhd
+	defaultDuration map[uint32]uint32 // per track, from trex
+	ticks           map[uint32]uint64 // per track, in its timescale
+}
+
+func newFragments() *fragments {
+	return &fragments{
+		timescale:       make(map[uint32]uint32),
+		defaultDuration: make(map[uint32]uint32),
+		ticks:           make(map[uint32]uint64),
+	}
+}
+
+// readTrackExtends reads the default sample duration of a track from a trex box
+func (fr *fragments) readTrackExtends(r io.ReaderAt, start, end int64) error {
+	buf, err := readFullBox(r, start, end, 16)
+	if err != nil {
+		return err
+	}
+	if len(buf) < 16 {
+		return fmt.Errorf("not a valid video: bad track extends box")
+	}
+	fr.defaultDuration[binary.BigEndian.Uint32(buf[4:8])] = binary.BigEndian.Uint32(buf[12:16])
+	return nil
+}
+
+// readTrackFragment adds the sample durations of a traf box: the tfhd box
+// names the track and may override its default sample duration, each trun
+// box lists samples with their own duration or the default one
+func (fr *fragments) readTrackFragment(r io.ReaderAt, start, end int64) error {
+	var trackID, defaultDuration uint32
+	return walkBoxes(r, start, end, func(typ string, start, end int64) error {
+		switch typ {
+		case "tfhd":
+			buf, err := readFullBox(r, start, end, end-start)
+			if err != nil {
+				return err
+			}
+			if len(buf) < 8 {
+				return fmt.Errorf("not a valid video: bad track fragment header")
+			}
+			flags := fullBoxFlags(buf)
+			trackID = binary.BigEndian.Uint32(buf[4:8])
+			defaultDuration = fr.defaultDuration[trackID]
+
+			offset := 8
+			if flags&0x01 != 0 { // base data offset
+				offset += 8
+			}
+			if flags&0x02 != 0 { // sample description index
+				offset += 4
+			}
+			if flags&0x08 != 0 {
+				if len(buf) < offset+4 {
+					return fmt.Errorf("not a valid video: bad track fragment header")
+				}
+				defaultDuration = binary.BigEndian.Uint32(buf[offset : offset+4])
+			}
+		case "trun":
+			buf, err := readFullBox(r, start, end, end-start)
+			if err != nil {
+				return err
+			}
+			if len(buf) < 8 {
+				return fmt.Errorf("not a valid video: bad track run")
+			}
+			flags := fullBoxFlags(buf)
+			count := int64(binary.BigEndian.Uint32(buf[4:8]))
+			if flags&0x100 == 0 {
+				fr.ticks[trackID] += uint64(count) * uint64(defaultDuration)
+				return nil
+			}
+
+			offset := int64(8)
+			if flags&0x01 != 0 { // data offset
+				offset += 4
+			}
+			if flags&0x04 != 0 { // first sample flags
+				offset += 4
+			}
+			// Each sample has a duration, then optionally size, flags and
+			// composition time offset
+			recordLen := int64(4)
+			for _, bit := range []uint32{0x200, 0x400, 0x800} {
+				if flags&bit != 0 {
+					recordLen += 4
+				}
+			}
+			if offset+count*recordLen > int64(len(buf)) {
+				return fmt.Errorf("%w: track run lists more samples than it holds", errTruncated)
+			}
+			for i := int64(0); i < count; i++ {
+				at := offset + i*recordLen
+				fr.ticks[trackID] += uint64(binary.BigEndian.Uint32(buf[at : at+4]))
+			}
+		}
+		return nil
+	})
+}
+
+// duration returns the length of the longest track in seconds
+func (fr *fragments) duration() float64 {
+	var longest float64
+	for track, ticks := range fr.ticks {
+		if timescale := fr.timescale[track]; timescale > 0 {
+			if d := float64(ticks) / float64(timescale); d > longest {
+				longest = d
+			}
+		}
+	}
+	return longest
+}
+
+// readFullBox reads up to n bytes of the payload of a full box, which
+// starts with a version byte and 24 bits of flags
+func readFullBox(r io.ReaderAt, start, end, n int64) ([]byte, error) {
+	buf := make([]byte, min64(end-start, n))
+	if _, err := r.ReadAt(buf, start); err != nil {
+		return nil, errTruncated
+	}
+	if len(buf) < 4 {
+		return nil, fmt.Errorf("not a valid video: bad full box")
+	}
+	return buf, nil
+}
+
+func fullBoxFlags(buf []byte) uint32 {
+	return uint32(buf[1])<<16 | uint32(buf[2])<<8 | uint32(buf[3])
+}
+
+// inspectFFprobe reads the duration and size of a video with ffprobe. Without
+// ffprobe the file is only hashed.
+func inspectFFprobe(path string, file *api.ResultFile) error {
+	if _, err := exec.LookPath("ffprobe"); err != nil {
+		return nil
+	}
+
+	out, err := exec.Command("ffprobe", "-v", "error",
+		"-show_entries", "format=duration:stream=width,height",
+		"-of", "json", path).Output()
+	if err != nil {
+		return fmt.Errorf("not a valid video: ffprobe failed")
+	}
+
+	var probe struct {
+		Format struct {
+			Duration string `json:"duration"`
+		} `json:"format"`
+		Streams []struct {
+			Width  int `json:"width"`
+			Height int `json:"height"`
+		} `json:"streams"`
+	}
+	if err := json.Unmarshal(out, &probe); err != nil {
+		return fmt.Errorf("failed to parse ffprobe output: %w", err)
+	}
+
+	duration, err := strconv.ParseFloat(probe.Format.Duration, 64)
+	if err != nil || duration <= 0 {
+		return fmt.Errorf("video has no duration")
+	}
+	file.DurationSeconds = duration
+	for _, stream := range probe.Streams {
+		if stream.Width > 0 {
+			file.Width, file.Height = stream.Width, stream.Height
+			break
+		}
+	}
+	return nil
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
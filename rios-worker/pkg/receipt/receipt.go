@@ -0,0 +1,169 @@
+package receipt
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/rios/worker/pkg/api"
+	"github.com/rios/worker/pkg/config"
+)
+
+// KeyFile is the node's signing key, kept next to the configuration
+const KeyFile = "node.key"
+
+// DefaultKeyPath returns the path of the node key (~/.rios/node.key)
+func DefaultKeyPath() (string, error) {
+	dir, err := config.Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, KeyFile), nil
+}
+
+// DefaultDir returns where receipts are stored (~/.rios/receipts)
+func DefaultDir() (string, error) {
+	dir, err := config.DataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "receipts"), nil
+}
+
+// LoadKey reads the Ed25519 key at path, generating it on first use. The
+// key identifies the node, so it is never replaced once created.
+func LoadKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return createKey(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read node key: %w", err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, fmt.Errorf("node key %s is not a PEM private key", path)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse node key: %w", err)
+	}
+	key, ok := parsed.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("node key %s is not an Ed25519 key", path)
+	}
+	return key, nil
+}
+
+func createKey(path string) (ed25519.PrivateKey, error) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate node key: %w", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode node key: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create key directory: %w", err)
+	}
+	// O_EXCL so two workers starting at once can't each write a different key
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if errors.Is(err, os.ErrExist) {
+		return LoadKey(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create node key: %w", err)
+	}
+	if err := pem.Encode(f, &pem.Block{Type: "PRIVATE KEY", Bytes: der}); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to write node key: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return nil, fmt.Errorf("failed to write node key: %w", err)
+	}
+	return key, nil
+}
+
+// PublicKey returns the base64 encoding of key's public half
+func PublicKey(key ed25519.PrivateKey) string {
+	return base64.StdEncoding.EncodeToString(key.Public().(ed25519.PublicKey))
+}
+
+// Sign encodes r and signs the encoding with key
+func Sign(r *api.Receipt, key ed25519.PrivateKey) (*api.SignedReceipt, error) {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode receipt: %w", err)
+	}
+	return &api.SignedReceipt{
+		Receipt:   data,
+		PublicKey: PublicKey(key),
+		Signature: base64.StdEncoding.EncodeToString(ed25519.Sign(key, data)),
+	}, nil
+}
+
+// Verify checks the signature of s against its public key and decodes the
+// receipt. Whether the key belongs to the node is up to the caller.
+func Verify(s *api.SignedReceipt) (*api.Receipt, error) {
+	pub, err := base64.StdEncoding.DecodeString(s.PublicKey)
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid receipt public key")
+	}
+	sig, err := base64.StdEncoding.DecodeString(s.Signature)
+	if err != nil {
+		return nil, fmt.Errorf("invalid receipt signature: %w", err)
+	}
+	if !ed25519.Verify(pub, s.Receipt, sig) {
+		return nil, fmt.Errorf("receipt signature does not match")
+	}
+
+	var r api.Receipt
+	if err := json.Unmarshal(s.Receipt, &r); err != nil {
+		return nil, fmt.Errorf("failed to decode receipt: %w", err)
+	}
+	return &r, nil
+}
+
+// Save writes the receipt of jobID to dir
+func Save(dir, jobID string, s *api.SignedReceipt) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create receipt directory: %w", err)
+	}
+	// Not indented: that would reformat the signed bytes too
+	data, err := json.Marshal(s)
+	if err != nil {
+		return fmt.Errorf("failed to encode receipt: %w", err)
+	}
+	if err := os.WriteFile(receiptPath(dir, jobID), data, 0644); err != nil {
+		return fmt.Errorf("failed to write receipt: %w", err)
+	}
+	return nil
+}
+
+// Load reads the receipt of jobID from dir
+func Load(dir, jobID string) (*api.SignedReceipt, error) {
+	data, err := os.ReadFile(receiptPath(dir, jobID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read receipt: %w", err)
+	}
+	var s api.SignedReceipt
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("failed to parse receipt: %w", err)
+	}
+	return &s, nil
+}
+
+// receiptPath keeps job IDs from the orchestrator from naming files outside dir
+func receiptPath(dir, jobID string) string {
+	return filepath.Join(dir, filepath.Base(filepath.Clean("/"+jobID))+".json")
+}
@@ -0,0 +1,118 @@
+package receipt
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/rios/worker/pkg/api"
+)
+
+func TestSignVerify(t *testing.T) {
+	key, err := LoadKey(filepath.Join(t.TempDir(), "node.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rcpt := &api.Receipt{
+		JobID:       "job-1",
+		NodeID:      7,
+		Image:       "rios/comfyui:latest",
+		ImageDigest: "sha256:abc",
+		Inputs:      []api.ReceiptFile{{Name: "workflow", Path: "workflow.json", SizeBytes: 2, SHA256: "44136fa3"}},
+		Outputs:     []api.ReceiptFile{{Path: "ComfyUI_00001_.png", SizeBytes: 1024, SHA256: "9f86d081"}},
+		StartedAt:   time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+		FinishedAt:  time.Date(2024, 1, 1, 12, 5, 0, 0, time.UTC),
+		GPUType:     "NVIDIA GeForce RTX 3090",
+		GPUCount:    1,
+		Status:      "completed",
+	}
+
+	tests := []struct {
+		name    string
+		tamper  func(s *api.SignedReceipt)
+		wantErr bool
+	}{
+		{name: "valid"},
+		{
+			name: "changed receipt",
+			tamper: func(s *api.SignedReceipt) {
+				var r api.Receipt
+				json.Unmarshal(s.Receipt, &r)
+				r.Status = "failed"
+				s.Receipt, _ = json.Marshal(r)
+			},
+			wantErr: true,
+		},
+		{
+			name:    "other public key",
+			tamper:  func(s *api.SignedReceipt) { s.PublicKey = PublicKey(otherKey) },
+			wantErr: true,
+		},
+		{
+			name: "signed by another key",
+			tamper: func(s *api.SignedReceipt) {
+				s.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(otherKey, s.Receipt))
+			},
+			wantErr: true,
+		},
+		{
+			name:    "malformed public key",
+			tamper:  func(s *api.SignedReceipt) { s.PublicKey = "not base64!" },
+			wantErr: true,
+		},
+		{
+			name:    "malformed signature",
+			tamper:  func(s *api.SignedReceipt) { s.Signature = "not base64!" },
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signed, err := Sign(rcpt, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.tamper != nil {
+				tt.tamper(signed)
+			}
+
+			got, err := Verify(signed)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("Verify succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Verify: %v", err)
+			}
+			if !reflect.DeepEqual(got, rcpt) {
+				t.Errorf("Verify = %+v, want %+v", got, rcpt)
+			}
+		})
+	}
+}
+
+func TestLoadKeyKeepsKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "node.key")
+	first, err := LoadKey(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := LoadKey(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !first.Equal(second) {
+		t.Error("LoadKey generated a new key instead of loading the existing one")
+	}
+}
@@ -0,0 +1,61 @@
+package runner
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/rios/worker/pkg/api"
+	"github.com/rios/worker/pkg/config"
+	"github.com/rios/worker/pkg/gpu"
+	"github.com/rios/worker/pkg/logging"
+)
+
+// SyncHardware compares the detected GPUs with what was registered and pushes
+// any change to the orchestrator. If the update cannot be delivered and the
+// machine now has less capacity than registered, the worker refuses to start
+// rather than advertise GPUs it no longer has.
+func SyncHardware(log *slog.Logger, client *api.Client, cfg *config.Config, detected *gpu.GPUInfo) error {
+	registered := &gpu.GPUInfo{
+		Type:  cfg.GPUType,
+		Count: cfg.GPUCount,
+		VRam:  cfg.GPUVram,
+	}
+	if registered.Equal(detected) {
+		return nil
+	}
+
+	// Configs written before hardware was recorded have nothing to compare against
+	known := cfg.GPUType != ""
+	if known {
+		log.Info("Hardware change detected", logging.Icon("🔄"), "registered", registered.String(), "detected", detected.String())
+	} else {
+		log.Info("Reporting hardware to orchestrator...", logging.Icon("🔄"))
+	}
+
+	resp, err := client.UpdateHardware(&api.UpdateHardwareRequest{
+		GPUType:  detected.Type,
+		GPUVram:  detected.VRam,
+		GPUCount: detected.Count,
+	})
+	if err == nil && !resp.Success {
+		err = fmt.Errorf("%s", resp.Message)
+	}
+	if err != nil {
+		if known && !detected.Covers(registered) {
+			return fmt.Errorf("hardware changed from %s to %s and the orchestrator could not be updated: %w. "+
+				"Refusing to advertise capacity that no longer exists; run 'rios-worker register --yes' to re-register", registered, detected, err)
+		}
+		log.Warn("Failed to update hardware", "error", err)
+		return nil
+	}
+
+	cfg.GPUType = detected.Type
+	cfg.GPUVram = detected.VRam
+	cfg.GPUCount = detected.Count
+	if err := config.Save(cfg); err != nil {
+		log.Warn("Failed to save configuration", "error", err)
+	}
+
+	log.Info("Hardware updated with orchestrator", logging.Icon("✅"))
+	return nil
+}
@@ -0,0 +1,92 @@
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/rios/worker/pkg/api"
+	"github.com/rios/worker/pkg/logging"
+	"github.com/rios/worker/pkg/update"
+)
+
+// checkVersion holds job intake while this worker is older than the
+// minimum version the orchestrator supports. Responses without a minimum
+// leave the last one known in place.
+func (r *Runner) checkVersion(minVersion string) {
+	r.versionMu.Lock()
+	defer r.versionMu.Unlock()
+	if minVersion != "" {
+		r.minVersion = minVersion
+	}
+	minVersion = r.minVersion
+
+	if update.Older(r.Version, minVersion) {
+		if r.State.Hold("version", fmt.Sprintf("version %s is below the supported minimum %s", r.Version, minVersion)) {
+			r.Log.Error("This worker is too old for the orchestrator, pausing until it is updated with 'rios-worker update'",
+				"version", r.Version, "min_version", minVersion)
+		}
+	} else if r.State.Release("version") {
+		r.Log.Info("Worker version is supported again, taking jobs", logging.Icon("✅"))
+	}
+}
+
+// checkUpgrade records the outcome of a request to endpoint, err being nil
+// if it succeeded. Job intake is held while any endpoint answers 426
+// Upgrade Required, until that endpoint succeeds again. It reports whether
+// err was such an answer.
+func (r *Runner) checkUpgrade(endpoint string, err error) bool {
+	var upgradeErr *api.UpgradeRequiredError
+	isUpgrade := errors.As(err, &upgradeErr)
+	if err != nil && !isUpgrade {
+		// Other failures say nothing about whether this worker is accepted
+		return false
+	}
+
+	r.versionMu.Lock()
+	defer r.versionMu.Unlock()
+	if isUpgrade {
+		if r.upgradeRequired == nil {
+			r.upgradeRequired = make(map[string]string)
+		}
+		r.upgradeRequired[endpoint] = upgradeErr.Error()
+	} else {
+		delete(r.upgradeRequired, endpoint)
+	}
+
+	if len(r.upgradeRequired) == 0 {
+		if r.State.Release("upgrade") {
+			r.Log.Info("The orchestrator accepts this worker again, taking jobs", logging.Icon("✅"))
+		}
+	} else if isUpgrade && r.State.Hold("upgrade", upgradeErr.Error()) {
+		r.Log.Error("The orchestrator requires a newer worker, pausing until it is updated with 'rios-worker update'",
+			"version", r.Version, "error", upgradeErr)
+	}
+	return isUpgrade
+}
+
+// NegotiateProtocol checks that the orchestrator speaks this worker's
+// protocol. A worker the orchestrator rejects refuses to start; if the
+// handshake fails otherwise, the worker starts anyway and the handshake is
+// nil.
+func NegotiateProtocol(log *slog.Logger, client *api.Client) (*api.HandshakeResponse, error) {
+	handshake, err := client.Handshake()
+	var upgradeErr *api.UpgradeRequiredError
+	switch {
+	case errors.As(err, &upgradeErr):
+		return nil, fmt.Errorf("%w. Update it with 'rios-worker update'", err)
+	case err != nil && handshake != nil:
+		return nil, err
+	case err != nil:
+		log.Warn("Protocol handshake failed", "error", err)
+		return nil, nil
+	}
+
+	attrs := []any{logging.Icon("🤝"), "protocol", api.ProtocolVersion}
+	if len(handshake.Features) > 0 {
+		attrs = append(attrs, "features", strings.Join(handshake.Features, ","))
+	}
+	log.Info("Protocol negotiated", attrs...)
+	return handshake, nil
+}
@@ -0,0 +1,541 @@
+// Package runner is the worker's job loop: it heartbeats, takes the jobs
+// its policy and pre-flight checks accept, runs them and submits their
+// results with a signed receipt.
+package runner
+
+import (
+	"context"
+	"crypto/ed25519"
+	"fmt"
+	"log/slog"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/rios/worker/pkg/api"
+	"github.com/rios/worker/pkg/config"
+	"github.com/rios/worker/pkg/container"
+	"github.com/rios/worker/pkg/gpu"
+	"github.com/rios/worker/pkg/history"
+	"github.com/rios/worker/pkg/logging"
+	"github.com/rios/worker/pkg/metrics"
+	"github.com/rios/worker/pkg/output"
+	"github.com/rios/worker/pkg/receipt"
+	"github.com/rios/worker/pkg/schedule"
+	"github.com/rios/worker/pkg/worker"
+)
+
+// Runner holds everything needed to talk to the orchestrator and run jobs
+type Runner struct {
+	Log      *slog.Logger
+	Client   *api.Client
+	Executor *worker.Executor
+	Store    *history.Store
+	State    *worker.State
+	Metrics  *metrics.Metrics
+	Sampler  *gpu.Sampler
+	Runtime  container.Runtime
+
+	// Which jobs are taken and when
+	Schedule  *schedule.Schedule
+	Idle      *schedule.IdleDetector // nil unless idle-only mode is enabled
+	Policy    *worker.Policy
+	Bandwidth config.BandwidthConfig
+
+	// Release of this worker, checked against the orchestrator's minimum
+	Version string
+
+	// Job receipts name the node and the detected GPU, are signed with NodeKey
+	// and kept in ReceiptDir
+	NodeID     int
+	GPU        gpu.GPUInfo
+	NodeKey    ed25519.PrivateKey
+	ReceiptDir string
+
+	// Protocol versions and features of the orchestrator, nil if the
+	// handshake failed
+	Handshake *api.HandshakeResponse
+
+	// What the orchestrator last said about this worker's version: the
+	// minimum it supports and the endpoints that answered 426
+	versionMu       sync.Mutex
+	minVersion      string
+	upgradeRequired map[string]string // endpoint -> reason
+
+	// Last GPU telemetry error that was logged, only used by Poll
+	telemetryErr string
+}
+
+// Run polls for jobs every interval until ctx is cancelled or the worker
+// is drained
+func (r *Runner) Run(ctx context.Context, interval time.Duration) {
+	if r.Handshake != nil {
+		r.checkVersion(r.Handshake.MinVersion)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if r.Poll() {
+				return
+			}
+		}
+	}
+}
+
+// Poll does one round of the job loop. Unless a job is running, it updates
+// the intake holds, heartbeats and takes the next job, which runs in the
+// background. It reports whether the worker is drained and should stop.
+func (r *Runner) Poll() (drained bool) {
+	if r.State.Busy() {
+		return false
+	}
+
+	if r.State.Draining() {
+		r.Log.Info("Drained: no jobs running", logging.Icon("🚰"))
+		return true
+	}
+
+	r.updateAvailability(time.Now())
+	r.checkTelemetry()
+
+	// Send heartbeat
+	if r.State.Paused() || r.State.HoldReason() != "" {
+		r.Metrics.Paused.Set(1)
+	} else {
+		r.Metrics.Paused.Set(0)
+	}
+	err := r.heartbeat(r.State.Status(), r.State.HoldReason())
+	if !r.checkUpgrade("heartbeat", err) && err != nil {
+		r.Log.Warn("Heartbeat failed", "error", err)
+	}
+	if err != nil {
+		return false
+	}
+
+	// A worker told to upgrade keeps asking for jobs, which is how
+	// it finds out that the orchestrator accepts it again
+	if !r.State.AcceptingJobsExcept("upgrade") {
+		return false
+	}
+
+	// Try to get a job, with hints only for an orchestrator that
+	// reads them
+	var hints *api.JobHints
+	if r.hasFeature(api.CapJobHints) {
+		hints = r.Policy.Hints()
+	}
+	job, err := r.Client.GetJob(hints)
+	if !r.checkUpgrade("get-job", err) && err != nil {
+		r.Log.Warn("Failed to get job", "error", err)
+	}
+	if err != nil {
+		return false
+	}
+
+	if job == nil {
+		r.Log.Info("No jobs available, waiting...", logging.Icon("⏳"))
+		return false
+	}
+
+	// Hand back jobs we don't want instead of failing them
+	if rejection := r.Policy.Evaluate(job); rejection != nil {
+		r.rejectJob(job, rejection)
+		return false
+	}
+
+	// Make sure the job can actually run here before taking it
+	if rejection := r.Executor.Preflight(logging.NewContext(context.Background(), r.Log), job); rejection != nil {
+		r.rejectJob(job, rejection)
+		return false
+	}
+
+	// Process job
+	ctx, cancel := context.WithCancelCause(context.Background())
+	r.State.StartJob(job, cancel)
+	r.Metrics.RunningJobs.Inc()
+	go func() {
+		defer func() {
+			cancel(nil)
+			r.State.FinishJob(job.JobID)
+			r.Metrics.RunningJobs.Dec()
+		}()
+		r.processJob(ctx, job)
+	}()
+	return false
+}
+
+// Shutdown tells the orchestrator the worker is going offline and logs
+// what it did this session
+func (r *Runner) Shutdown() {
+	r.Log.Info("Shutting down gracefully...", logging.Icon("⏹️ "))
+
+	// Send offline heartbeat
+	if err := r.heartbeat("offline", ""); err != nil {
+		r.Log.Warn("Failed to send offline heartbeat", "error", err)
+	}
+
+	totalJobsCompleted, totalRewardsEarned := r.State.Session()
+	r.Log.Info("Worker stopped", logging.Icon("👋"))
+	summary := []any{logging.Icon("📊"), "jobs_completed", totalJobsCompleted, "rewards_ros", totalRewardsEarned}
+	if jobs, rewards, err := r.Store.TotalEarnings(); err == nil {
+		summary = append(summary, "lifetime_jobs", jobs, "lifetime_rewards_ros", rewards)
+	}
+	r.Log.Info("Session summary", summary...)
+}
+
+// hasFeature reports whether the orchestrator announced a feature flag in
+// the handshake; without a handshake it is assumed to have none
+func (r *Runner) hasFeature(feature string) bool {
+	return r.Handshake != nil && r.Handshake.HasFeature(feature)
+}
+
+// rejectJob declines a job so the orchestrator reassigns it. An
+// orchestrator without the reject-job feature gets it back as failed.
+func (r *Runner) rejectJob(job *api.Job, rejection *worker.Rejection) {
+	r.Log.Info("Declined job", logging.Icon("🙅"),
+		logging.KeyJobID, job.JobID, "type", job.TaskType, "code", rejection.Code, "reason", rejection.Reason)
+
+	var err error
+	if r.hasFeature(api.CapRejectJob) {
+		_, err = r.Client.RejectJob(&api.RejectJobRequest{
+			JobID:  job.JobID,
+			Code:   rejection.Code,
+			Reason: rejection.Reason,
+		})
+	} else {
+		_, err = r.Client.SubmitResult(&api.SubmitResultRequest{
+			JobID:        job.JobID,
+			Status:       "failed",
+			ErrorMessage: "declined: " + rejection.Reason,
+		})
+	}
+	if err != nil {
+		r.Log.Warn("Failed to reject job", logging.KeyJobID, job.JobID, "error", err)
+	}
+
+	now := time.Now()
+	recordJob(r.Log, r.Store, &history.JobRecord{
+		JobID:        job.JobID,
+		TaskType:     job.TaskType,
+		DockerImage:  job.Payload.DockerImage,
+		Status:       history.StatusRejected,
+		ErrorMessage: rejection.Reason,
+		StartedAt:    now,
+		FinishedAt:   now,
+	})
+}
+
+// checkTelemetry logs when GPU telemetry stops and comes back
+func (r *Runner) checkTelemetry() {
+	var msg string
+	if err := r.Sampler.Err(); err != nil {
+		msg = err.Error()
+	}
+	switch {
+	case msg == r.telemetryErr:
+	case msg == "":
+		r.Log.Info("GPU telemetry is back", logging.Icon("🌡️ "))
+	default:
+		r.Log.Warn("GPU telemetry unavailable", "error", msg)
+	}
+	r.telemetryErr = msg
+}
+
+// updateAvailability holds job intake outside the schedule windows, once
+// the monthly traffic cap is reached and, in idle-only mode, while another
+// program is using the GPU
+func (r *Runner) updateAvailability(now time.Time) {
+	r.checkBandwidth(now)
+
+	if r.Schedule.Active(now) {
+		if r.State.Release("schedule") {
+			r.Log.Info("Entered availability window, taking jobs", logging.Icon("🗓️ "))
+		}
+	} else if r.State.Hold("schedule", "outside availability schedule") {
+		r.Log.Info("Outside availability window, pausing", logging.Icon("🗓️ "))
+	}
+
+	if r.Idle == nil {
+		return
+	}
+	if idle, reason := r.Idle.Check(now); idle {
+		if r.State.Release("idle") {
+			r.Log.Info("GPU is idle, taking jobs", logging.Icon("😴"))
+		}
+	} else if r.State.Hold("idle", reason) {
+		r.Log.Info("GPU in use, pausing", logging.Icon("🎮"), "reason", reason)
+	}
+}
+
+// checkBandwidth holds job intake while the traffic of the current billing
+// period is at or above the monthly cap
+func (r *Runner) checkBandwidth(now time.Time) {
+	capBytes := int64(r.Bandwidth.MonthlyCapGB * (1 << 30))
+	if capBytes <= 0 {
+		return
+	}
+	used, err := r.Store.TrafficSince(r.Bandwidth.PeriodStart(now))
+	if err != nil {
+		r.Log.Warn("Failed to check the traffic cap", "error", err)
+		return
+	}
+
+	if used < capBytes {
+		if r.State.Release("bandwidth") {
+			r.Log.Info("Traffic below the monthly cap, taking jobs", logging.Icon("📶"))
+		}
+	} else if r.State.Hold("bandwidth", fmt.Sprintf("monthly traffic cap reached (%s of %s)", logging.Bytes(used), logging.Bytes(capBytes))) {
+		r.Log.Info("Monthly traffic cap reached, pausing until the next billing period", logging.Icon("📶"),
+			"used", logging.Bytes(used), "cap", logging.Bytes(capBytes))
+	}
+}
+
+// heartbeat reports status together with the latest GPU telemetry, or why
+// there is none
+func (r *Runner) heartbeat(status, reason string) error {
+	var telemetryErr string
+	if err := r.Sampler.Err(); err != nil {
+		telemetryErr = err.Error()
+	}
+	resp, err := r.Client.Heartbeat(&api.HeartbeatRequest{
+		Status:         status,
+		Reason:         reason,
+		GPUs:           r.Sampler.Latest(),
+		TelemetryError: telemetryErr,
+
+		CachedInputs: r.cachedInputs(),
+	})
+	if err != nil {
+		r.Metrics.HeartbeatFailures.Inc()
+		return err
+	}
+	r.checkVersion(resp.MinVersion)
+	return nil
+}
+
+// cachedInputs returns the digests in the input cache, if there is one
+func (r *Runner) cachedInputs() []string {
+	if r.Executor.Cache == nil {
+		return nil
+	}
+	return r.Executor.Cache.Digests()
+}
+
+// processJob executes a job and submits its result
+func (r *Runner) processJob(ctx context.Context, job *api.Job) {
+	r.Log.Info("New job received", logging.Icon("🎯"),
+		logging.KeyJobID, job.JobID, "type", job.TaskType, "image", job.Payload.DockerImage)
+
+	// Everything logged about the job carries its ID
+	log := r.Log.With(logging.KeyJobID, job.JobID)
+	ctx = logging.NewContext(ctx, log)
+
+	rec := &history.JobRecord{
+		JobID:       job.JobID,
+		TaskType:    job.TaskType,
+		DockerImage: job.Payload.DockerImage,
+		Status:      history.StatusRunning,
+		StartedAt:   time.Now(),
+	}
+	recordJob(log, r.Store, rec)
+
+	// Send busy heartbeat
+	if err := r.heartbeat(worker.StatusBusy, ""); err != nil {
+		log.Warn("Failed to send busy heartbeat", "error", err)
+	}
+
+	// Execute job
+	recording := r.Sampler.StartRecording()
+	traffic := &api.Traffic{}
+	r.State.SetTraffic(job.JobID, traffic)
+	rcpt := &api.Receipt{
+		JobID:     job.JobID,
+		NodeID:    r.NodeID,
+		Image:     job.Payload.DockerImage,
+		StartedAt: rec.StartedAt,
+		GPUType:   r.GPU.Type,
+		GPUCount:  r.GPU.Count,
+		Inputs:    []api.ReceiptFile{},
+		Outputs:   []api.ReceiptFile{},
+	}
+	outputURL, manifest, err := r.Executor.Execute(ctx, job, traffic, rcpt)
+	telemetry := recording.Stop()
+	rec.FinishedAt = time.Now()
+	r.Metrics.JobDuration.WithLabelValues(job.TaskType).Observe(rec.Duration().Seconds())
+	r.recordTraffic(rec, traffic)
+
+	// Submit result
+	req := &api.SubmitResultRequest{
+		JobID:        job.JobID,
+		GPUTelemetry: telemetry,
+		Traffic:      traffic,
+	}
+
+	if err != nil {
+		if cause := context.Cause(ctx); cause != nil {
+			log.Warn("Job stopped", logging.Icon("🛑"), "reason", cause)
+			err = cause
+			r.Metrics.JobsTotal.WithLabelValues(metrics.JobCancelled).Inc()
+		} else {
+			log.Error("Job failed", "error", err)
+			r.Metrics.JobsTotal.WithLabelValues(metrics.JobFailed).Inc()
+		}
+		req.Status = "failed"
+		req.ErrorMessage = err.Error()
+		rec.Status = history.StatusFailed
+		rec.ErrorMessage = err.Error()
+	} else {
+		log.Info("Job completed successfully", logging.Icon("✅"))
+		r.Metrics.JobsTotal.WithLabelValues(metrics.JobCompleted).Inc()
+		req.Status = "completed"
+		req.OutputS3URL = outputURL
+		req.Manifest = manifest
+		rec.Status = history.StatusCompleted
+
+		if v := job.Payload.Verification; v != nil {
+			req.Verdict = r.verify(log, v, manifest)
+		}
+	}
+
+	rcpt.FinishedAt = rec.FinishedAt
+	rcpt.Status = req.Status
+	req.Receipt = r.signReceipt(log, rcpt)
+
+	for _, st := range telemetry {
+		log.Info("GPU usage", logging.Icon("📈"), "gpu", st.Index,
+			"util_avg_pct", math.Round(st.UtilizationAvgPct), "util_peak_pct", math.Round(st.UtilizationPeakPct),
+			"temp_peak_c", math.Round(st.TemperaturePeakC), "power_peak_w", math.Round(st.PowerDrawPeakW))
+	}
+	log.Info("Traffic", logging.Icon("📶"),
+		"download", logging.Bytes(traffic.DownloadBytes), "upload", logging.Bytes(traffic.UploadBytes),
+		"container_rx", logging.Bytes(traffic.ContainerRxBytes), "container_tx", logging.Bytes(traffic.ContainerTxBytes))
+
+	resp, submitErr := r.Client.SubmitResult(req)
+	if submitErr != nil {
+		log.Warn("Failed to submit result", "error", submitErr)
+		recordJob(log, r.Store, rec)
+		return
+	}
+
+	rec.Reward = resp.RewardPaid
+	rec.RewardStatus = resp.RewardStatus
+	if rec.RewardStatus == "" && rec.Reward > 0 {
+		rec.RewardStatus = history.RewardPending
+	}
+	recordJob(log, r.Store, rec)
+
+	if resp.Success {
+		r.State.AddCompleted(resp.RewardPaid)
+		r.Metrics.RewardsTotal.Add(resp.RewardPaid)
+		totalJobsCompleted, totalRewardsEarned := r.State.Session()
+
+		log.Info("Reward earned", logging.Icon("💰"), "reward_ros", resp.RewardPaid,
+			"session_rewards_ros", totalRewardsEarned, "session_jobs", totalJobsCompleted)
+	}
+}
+
+// verify compares the outputs of a verification job with its reference.
+// A mismatch doesn't fail the job: it ran, and the orchestrator decides
+// which side of the comparison was wrong.
+func (r *Runner) verify(log *slog.Logger, v *api.Verification, manifest *api.ResultManifest) *api.Verdict {
+	verdict := output.Compare(v, manifest)
+	if verdict.Passed {
+		log.Info("Verification passed: all outputs match the reference", logging.Icon("🔎"),
+			"kind", v.Kind, "outputs", len(v.Reference))
+		r.Metrics.Verifications.WithLabelValues(metrics.VerificationPassed).Inc()
+		return verdict
+	}
+
+	log.Warn("Verification failed: outputs differ from the reference", logging.Icon("🔎"),
+		"kind", v.Kind, "mismatches", len(verdict.Mismatches), "outputs", len(v.Reference))
+	for _, m := range verdict.Mismatches {
+		got := m.SHA256
+		if got == "" {
+			got = "missing"
+		}
+		log.Warn("Output differs", logging.Icon("🔎"), "path", m.Path, "expected", m.Expected, "got", got)
+	}
+	r.Metrics.Verifications.WithLabelValues(metrics.VerificationFailed).Inc()
+	return verdict
+}
+
+// signReceipt signs a job's receipt and keeps a copy. The result is still
+// submitted without a receipt if signing fails.
+func (r *Runner) signReceipt(log *slog.Logger, rcpt *api.Receipt) *api.SignedReceipt {
+	signed, err := receipt.Sign(rcpt, r.NodeKey)
+	if err != nil {
+		log.Warn("Failed to sign receipt", "error", err)
+		return nil
+	}
+	if err := receipt.Save(r.ReceiptDir, rcpt.JobID, signed); err != nil {
+		log.Warn("Failed to save receipt", "error", err)
+	}
+	return signed
+}
+
+// recordTraffic copies a job's network usage into its history record and metrics
+func (r *Runner) recordTraffic(rec *history.JobRecord, traffic *api.Traffic) {
+	rec.DownloadBytes = traffic.DownloadBytes
+	rec.UploadBytes = traffic.UploadBytes
+	rec.ContainerRxBytes = traffic.ContainerRxBytes
+	rec.ContainerTxBytes = traffic.ContainerTxBytes
+
+	r.Metrics.TrafficBytes.WithLabelValues(metrics.TrafficDownload).Add(float64(traffic.DownloadBytes))
+	r.Metrics.TrafficBytes.WithLabelValues(metrics.TrafficUpload).Add(float64(traffic.UploadBytes))
+	r.Metrics.TrafficBytes.WithLabelValues(metrics.TrafficContainerRx).Add(float64(traffic.ContainerRxBytes))
+	r.Metrics.TrafficBytes.WithLabelValues(metrics.TrafficContainerTx).Add(float64(traffic.ContainerTxBytes))
+}
+
+// HandleGovernorEvent applies a thermal governor decision and reports it
+func (r *Runner) HandleGovernorEvent(event gpu.GovernorEvent) {
+	switch event.Action {
+	case gpu.PauseIntake:
+		r.Log.Warn("Pausing job intake", logging.Icon("🌡️ "), "reason", event.Reason)
+		r.State.Hold("governor", event.Reason)
+
+	case gpu.ResumeIntake:
+		r.Log.Info("Resuming job intake", logging.Icon("🌡️ "), "reason", event.Reason)
+		r.State.Release("governor")
+
+	case gpu.SuspendJobs:
+		for _, job := range r.State.Jobs() {
+			r.Log.Warn("Suspending job", logging.Icon("🧊"), logging.KeyJobID, job.JobID, "reason", event.Reason)
+			if err := r.Runtime.Pause(worker.ContainerName(job.JobID)); err != nil {
+				r.Log.Warn("Failed to suspend job", logging.KeyJobID, job.JobID, "error", err)
+			}
+		}
+
+	case gpu.ResumeJobs:
+		for _, job := range r.State.Jobs() {
+			r.Log.Info("Resuming job", logging.Icon("▶️ "), logging.KeyJobID, job.JobID, "reason", event.Reason)
+			if err := r.Runtime.Unpause(worker.ContainerName(job.JobID)); err != nil {
+				r.Log.Warn("Failed to resume job", logging.KeyJobID, job.JobID, "error", err)
+			}
+		}
+
+	case gpu.KillJobs:
+		for _, job := range r.State.Jobs() {
+			r.Log.Error("Killing job", logging.Icon("🔥"), logging.KeyJobID, job.JobID, "reason", event.Reason)
+			// A frozen container can't be killed by every runtime version
+			r.Runtime.Unpause(worker.ContainerName(job.JobID))
+			r.State.CancelJob(job.JobID, fmt.Errorf("job stopped by thermal governor: %s", event.Reason))
+		}
+	}
+
+	// Tell the orchestrator right away, the job loop doesn't heartbeat while busy
+	if err := r.heartbeat(r.State.Status(), event.Reason); err != nil {
+		r.Log.Warn("Failed to report governor action", "error", err)
+	}
+}
+
+// recordJob writes a job to the local history, warning on failure
+func recordJob(log *slog.Logger, store *history.Store, rec *history.JobRecord) {
+	if err := store.RecordJob(rec); err != nil {
+		log.Warn("Failed to update job history", "error", err)
+	}
+}
@@ -0,0 +1,425 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rios/worker/pkg/api"
+	"github.com/rios/worker/pkg/config"
+	"github.com/rios/worker/pkg/container"
+	"github.com/rios/worker/pkg/devserver"
+	"github.com/rios/worker/pkg/gpu"
+	"github.com/rios/worker/pkg/history"
+	"github.com/rios/worker/pkg/metrics"
+	"github.com/rios/worker/pkg/receipt"
+	"github.com/rios/worker/pkg/schedule"
+	"github.com/rios/worker/pkg/worker"
+)
+
+// testScript is what the fake containers do: comfyui renders an image
+// quickly, slow jobs run long enough to be suspended or killed
+var testScript = &container.FakeScript{Tasks: map[string]*container.FakeTask{
+	"comfyui": {
+		Outputs: []container.FakeOutput{{Path: "ComfyUI_00001_.png", Kind: "image", Width: 64, Height: 64}},
+	},
+	"slow": {
+		DurationMs: 300,
+		Outputs:    []container.FakeOutput{{Path: "output.txt", Kind: "file", Content: "done\n"}},
+	},
+	"stuck": {
+		DurationMs: 60000,
+	},
+}}
+
+// newTestRunner registers a worker with simulated GPUs and the fake runtime
+// with a dev-server. setup changes the server before it starts.
+func newTestRunner(t *testing.T, setup func(*devserver.Server)) (*devserver.Server, *Runner) {
+	t.Helper()
+	server := devserver.New()
+	if setup != nil {
+		setup(server)
+	}
+	ts := httptest.NewServer(server)
+	t.Cleanup(ts.Close)
+
+	dir := t.TempDir()
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	gpus := gpu.DefaultSimulated()
+	detected, err := gpus.Detect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := receipt.LoadKey(filepath.Join(dir, "node.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := api.NewClient(ts.URL)
+	client.WorkerVersion = "v1.0.0"
+	reg, err := client.Register(&api.RegisterRequest{
+		GPUType:          detected.Type,
+		GPUVram:          detected.VRam,
+		GPUCount:         detected.Count,
+		RosWalletAddress: "0xdev",
+		PublicKey:        receipt.PublicKey(key),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.SetAuthToken(reg.NodeAuthToken)
+	handshake, err := NegotiateProtocol(log, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := history.Open(filepath.Join(dir, "history.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	sampler := gpu.NewSampler(gpus.Telemetry(10*time.Millisecond), 10*time.Millisecond)
+	go sampler.Run(ctx)
+
+	executor := worker.NewExecutor(t.TempDir())
+	executor.Runtime = container.NewFake(testScript)
+	executor.MinFreeDiskBytes = 1
+	executor.FreeVRAM = gpus.FreeMemory
+	state := worker.NewState()
+	executor.OnPhase = state.SetPhase
+
+	sched, err := schedule.New(config.ScheduleConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return server, &Runner{
+		Log:      log,
+		Client:   client,
+		Executor: executor,
+		Store:    store,
+		State:    state,
+		Metrics:  metrics.New(sampler.Latest),
+		Sampler:  sampler,
+		Runtime:  executor.Runtime,
+
+		Schedule: sched,
+		Policy:   worker.NewPolicy(config.PolicyConfig{MinReward: 1}, detected.VRam),
+
+		Version:    "v1.0.0",
+		NodeID:     reg.NodeID,
+		GPU:        *detected,
+		NodeKey:    key,
+		ReceiptDir: filepath.Join(dir, "receipts"),
+
+		Handshake: handshake,
+	}
+}
+
+// waitIdle waits for the running job to finish
+func waitIdle(t *testing.T, r *Runner) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for r.State.Busy() {
+		if time.Now().After(deadline) {
+			t.Fatal("job still running after 10s")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// waitBusy waits for the job the last Poll took to start running
+func waitBusy(t *testing.T, r *Runner) {
+	t.Helper()
+	if !r.State.Busy() {
+		t.Fatal("Poll didn't take the job")
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for r.State.Jobs()[0].Phase != worker.PhaseRunning {
+		if time.Now().After(deadline) {
+			t.Fatal("job container not running after 10s")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestProcessJob(t *testing.T) {
+	server, r := newTestRunner(t, nil)
+	server.Enqueue(&api.Job{
+		TaskType: "comfyui",
+		Reward:   2,
+		Payload:  &api.JobPayload{DockerImage: "rios/comfyui:dev", OutputS3Path: "s3://dev/out/"},
+	})
+
+	if r.Poll() {
+		t.Fatal("Poll reported the worker drained")
+	}
+	waitIdle(t, r)
+
+	results := server.Results()
+	if len(results) != 1 {
+		t.Fatalf("server has %d results, want 1", len(results))
+	}
+	res := results[0]
+	if res.Request.Status != "completed" || res.Reward != 2 {
+		t.Fatalf("result %s (%s) with reward %v, want completed with 2", res.Request.Status, res.Request.ErrorMessage, res.Reward)
+	}
+	if res.ReceiptError != "" {
+		t.Errorf("receipt rejected: %s", res.ReceiptError)
+	}
+
+	rcpt, err := receipt.Verify(res.Request.Receipt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest := res.Request.Manifest
+	if manifest == nil || len(manifest.Files) != 1 || len(rcpt.Outputs) != 1 || rcpt.Outputs[0].SHA256 != manifest.Files[0].SHA256 {
+		t.Errorf("manifest %+v, receipt outputs %+v, want the image in both", manifest, rcpt.Outputs)
+	}
+	if rcpt.GPUType != r.GPU.Type || rcpt.GPUCount != r.GPU.Count || rcpt.ExitCode != 0 || rcpt.Status != "completed" {
+		t.Errorf("receipt %+v, want a completed job on %s", rcpt, r.GPU.String())
+	}
+	if saved, err := receipt.Load(r.ReceiptDir, rcpt.JobID); err != nil || saved.Signature != res.Request.Receipt.Signature {
+		t.Errorf("saved receipt %+v, %v, want the submitted one", saved, err)
+	}
+
+	jobs, err := r.Store.RecentJobs(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 1 || jobs[0].Status != history.StatusCompleted || jobs[0].Reward != 2 {
+		t.Errorf("history %+v, want the completed job with its reward", jobs)
+	}
+	if completed, rewards := r.State.Session(); completed != 1 || rewards != 2 {
+		t.Errorf("session %d jobs, %v rewards, want 1 and 2", completed, rewards)
+	}
+}
+
+func TestRejectJob(t *testing.T) {
+	tests := []struct {
+		name     string
+		features []string
+	}{
+		{"reject-job", []string{api.CapRejectJob}},
+		{"failed result without reject-job", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, r := newTestRunner(t, func(s *devserver.Server) { s.Features = tt.features })
+			// Below the policy's minimum reward
+			server.Enqueue(&api.Job{TaskType: "comfyui", Reward: 0.5})
+
+			r.Poll()
+			if r.State.Busy() {
+				t.Fatal("Poll took a job below the minimum reward")
+			}
+
+			snap := server.Snapshot()
+			if tt.features != nil {
+				if len(snap.Rejections) != 1 || snap.Rejections[0].Request.Code != worker.RejectRewardTooLow || len(snap.Results) != 0 {
+					t.Errorf("rejections %+v, results %+v, want one %s rejection", snap.Rejections, snap.Results, worker.RejectRewardTooLow)
+				}
+			} else {
+				if len(snap.Results) != 1 || snap.Results[0].Request.Status != "failed" ||
+					!strings.HasPrefix(snap.Results[0].Request.ErrorMessage, "declined: ") || len(snap.Rejections) != 0 {
+					t.Errorf("results %+v, rejections %+v, want one declined failure", snap.Results, snap.Rejections)
+				}
+			}
+
+			jobs, err := r.Store.RecentJobs(10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(jobs) != 1 || jobs[0].Status != history.StatusRejected {
+				t.Errorf("history %+v, want the rejected job", jobs)
+			}
+		})
+	}
+}
+
+func TestUpgradeHolds(t *testing.T) {
+	t.Run("426 on get-job", func(t *testing.T) {
+		server, r := newTestRunner(t, nil)
+		server.Enqueue(&api.Job{TaskType: "comfyui", Reward: 2})
+		server.AddFault(devserver.Fault{Endpoint: "get-job", Status: http.StatusUpgradeRequired, Times: 2})
+
+		// Successful heartbeats in between don't lift the hold of get-job
+		for i := 0; i < 2; i++ {
+			r.Poll()
+			if !strings.Contains(r.State.HoldReason(), "newer worker") {
+				t.Fatalf("poll %d: hold reason %q, want an upgrade hold", i+1, r.State.HoldReason())
+			}
+		}
+
+		// Once get-job answers again, the job is taken
+		r.Poll()
+		if r.State.HoldReason() != "" || !r.State.Busy() {
+			t.Errorf("hold reason %q, busy %v, want the hold released and the job taken", r.State.HoldReason(), r.State.Busy())
+		}
+		waitIdle(t, r)
+	})
+
+	t.Run("426 on heartbeat", func(t *testing.T) {
+		server, r := newTestRunner(t, nil)
+		server.Enqueue(&api.Job{TaskType: "comfyui", Reward: 2})
+		server.AddFault(devserver.Fault{Endpoint: "heartbeat", Status: http.StatusUpgradeRequired, Times: 1})
+
+		r.Poll()
+		if !strings.Contains(r.State.HoldReason(), "newer worker") || r.State.Busy() {
+			t.Fatalf("hold reason %q, busy %v, want an upgrade hold", r.State.HoldReason(), r.State.Busy())
+		}
+
+		// The next heartbeat goes through and lifts its own hold
+		r.Poll()
+		if r.State.HoldReason() != "" || !r.State.Busy() {
+			t.Errorf("hold reason %q, busy %v, want the hold released and the job taken", r.State.HoldReason(), r.State.Busy())
+		}
+		waitIdle(t, r)
+	})
+
+	t.Run("min_version", func(t *testing.T) {
+		server, r := newTestRunner(t, func(s *devserver.Server) { s.MinVersion = "v2.0.0" })
+		server.Enqueue(&api.Job{TaskType: "comfyui", Reward: 2})
+
+		r.Poll()
+		if !strings.Contains(r.State.HoldReason(), "below the supported minimum v2.0.0") || r.State.Busy() {
+			t.Fatalf("hold reason %q, busy %v, want a version hold", r.State.HoldReason(), r.State.Busy())
+		}
+
+		// An updated worker takes jobs again
+		r.Version = "v2.0.0"
+		r.Poll()
+		if r.State.HoldReason() != "" || !r.State.Busy() {
+			t.Errorf("hold reason %q, busy %v, want the hold released and the job taken", r.State.HoldReason(), r.State.Busy())
+		}
+		waitIdle(t, r)
+	})
+}
+
+func TestGovernor(t *testing.T) {
+	t.Run("pause intake", func(t *testing.T) {
+		server, r := newTestRunner(t, nil)
+		server.Enqueue(&api.Job{TaskType: "comfyui", Reward: 2})
+
+		r.HandleGovernorEvent(gpu.GovernorEvent{Action: gpu.PauseIntake, Reason: "GPU 0 at 91°C"})
+		r.Poll()
+		if r.State.Busy() || len(server.Snapshot().Queued) != 1 {
+			t.Fatal("a job was taken while the governor held intake")
+		}
+		if reason := r.State.HoldReason(); reason != "GPU 0 at 91°C" {
+			t.Errorf("hold reason %q, want the governor's", reason)
+		}
+
+		r.HandleGovernorEvent(gpu.GovernorEvent{Action: gpu.ResumeIntake, Reason: "GPU 0 cooled down"})
+		r.Poll()
+		if !r.State.Busy() {
+			t.Fatal("no job was taken after the governor released intake")
+		}
+		waitIdle(t, r)
+	})
+
+	t.Run("suspend and resume jobs", func(t *testing.T) {
+		server, r := newTestRunner(t, nil)
+		server.Enqueue(&api.Job{TaskType: "slow", Reward: 2})
+		r.Poll()
+		waitBusy(t, r)
+
+		r.HandleGovernorEvent(gpu.GovernorEvent{Action: gpu.SuspendJobs, Reason: "GPU 0 at 95°C"})
+		time.Sleep(600 * time.Millisecond)
+		if !r.State.Busy() {
+			t.Fatal("suspended job finished")
+		}
+
+		r.HandleGovernorEvent(gpu.GovernorEvent{Action: gpu.ResumeJobs, Reason: "GPU 0 cooled down"})
+		waitIdle(t, r)
+		if results := server.Results(); len(results) != 1 || results[0].Request.Status != "completed" {
+			t.Errorf("results %+v, want the resumed job completed", results)
+		}
+	})
+
+	t.Run("kill jobs", func(t *testing.T) {
+		server, r := newTestRunner(t, nil)
+		server.Enqueue(&api.Job{TaskType: "stuck", Reward: 2})
+		r.Poll()
+		waitBusy(t, r)
+
+		r.HandleGovernorEvent(gpu.GovernorEvent{Action: gpu.KillJobs, Reason: "GPU 0 at 98°C"})
+		waitIdle(t, r)
+		results := server.Results()
+		if len(results) != 1 || results[0].Request.Status != "failed" ||
+			!strings.Contains(results[0].Request.ErrorMessage, "job stopped by thermal governor: GPU 0 at 98°C") {
+			t.Errorf("results %+v, want the job failed by the governor", results)
+		}
+	})
+}
+
+func TestTelemetryError(t *testing.T) {
+	server, r := newTestRunner(t, nil)
+	r.Poll()
+	if nodes := server.Snapshot().Nodes; nodes[0].TelemetryError != "" {
+		t.Errorf("heartbeat telemetry error %q, want none", nodes[0].TelemetryError)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r.Sampler = gpu.NewSampler(func(ctx context.Context) (io.ReadCloser, error) {
+		return nil, errors.New("nvidia-smi not found")
+	}, time.Second)
+	go r.Sampler.Run(ctx)
+	for deadline := time.Now().Add(5 * time.Second); r.Sampler.Err() == nil && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	r.Poll()
+	if nodes := server.Snapshot().Nodes; nodes[0].TelemetryError != "nvidia-smi not found" {
+		t.Errorf("heartbeat telemetry error %q, want the sampler's", nodes[0].TelemetryError)
+	}
+}
+
+func TestSyncHardware(t *testing.T) {
+	tests := []struct {
+		name       string
+		registered gpu.GPUInfo
+		fault      bool // update-hardware fails
+		wantErr    bool
+		wantSaved  bool // the detected GPUs end up in the config
+	}{
+		{"unchanged", gpu.GPUInfo{Type: "Mock GPU (Testing)", Count: 1, VRam: 8}, true, false, false},
+		{"first report", gpu.GPUInfo{}, false, false, true},
+		{"GPU removed", gpu.GPUInfo{Type: "Mock GPU (Testing)", Count: 2, VRam: 8}, false, false, true},
+		{"GPU removed, update fails", gpu.GPUInfo{Type: "Mock GPU (Testing)", Count: 2, VRam: 8}, true, true, false},
+		{"GPU added, update fails", gpu.GPUInfo{Type: "Mock GPU (Testing)", Count: 1, VRam: 4}, true, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(config.ConfigDirEnv, t.TempDir())
+			server, r := newTestRunner(t, nil)
+			if tt.fault {
+				server.AddFault(devserver.Fault{Endpoint: "update-hardware", Status: http.StatusInternalServerError})
+			}
+			cfg := &config.Config{NodeID: r.NodeID, GPUType: tt.registered.Type, GPUCount: tt.registered.Count, GPUVram: tt.registered.VRam}
+
+			err := SyncHardware(r.Log, r.Client, cfg, &r.GPU)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SyncHardware() error = %v, want error %v", err, tt.wantErr)
+			}
+			saved := gpu.GPUInfo{Type: cfg.GPUType, Count: cfg.GPUCount, VRam: cfg.GPUVram}
+			if got := saved.Equal(&r.GPU) && !tt.registered.Equal(&r.GPU); got != tt.wantSaved {
+				t.Errorf("config has %s, want the detected %s saved: %v", saved.String(), r.GPU.String(), tt.wantSaved)
+			}
+			if nodes := server.Snapshot().Nodes; tt.wantSaved && (nodes[0].Hardware == nil || nodes[0].Hardware.GPUCount != r.GPU.Count) {
+				t.Errorf("orchestrator has hardware %+v, want %s", nodes[0].Hardware, r.GPU.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package schedule
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/rios/worker/pkg/gpu"
+)
+
+// IdleDetector decides whether the GPUs are free for the worker, i.e. no
+// other program (a game, a desktop compute app, ...) has used them recently.
+// It must only be consulted while the worker itself is not running a job.
+type IdleDetector struct {
+	// Processes lists the compute processes on the GPUs
+	Processes func() ([]gpu.Process, error)
+	// Samples returns the latest GPU telemetry; graphics workloads such as
+	// games don't show up as compute processes but do show up as utilization
+	Samples func() []gpu.Sample
+	// MaxUtilizationPct above which a GPU counts as in use
+	MaxUtilizationPct float64
+	// IdleFor is how long the GPUs must have been unused
+	IdleFor time.Duration
+
+	lastActive time.Time
+}
+
+// NewIdleDetector creates a detector backed by the GPU process list and the
+// telemetry sampler
+func NewIdleDetector(processes func() ([]gpu.Process, error), sampler *gpu.Sampler, idleFor time.Duration) *IdleDetector {
+	return &IdleDetector{
+		Processes:         processes,
+		Samples:           sampler.Latest,
+		MaxUtilizationPct: 15,
+		IdleFor:           idleFor,
+	}
+}
+
+// Check reports whether the GPUs are idle at now; if not, reason says why
+func (d *IdleDetector) Check(now time.Time) (idle bool, reason string) {
+	if activity := d.activity(); activity != "" {
+		d.lastActive = now
+		return false, "GPU in use by another program: " + activity
+	}
+
+	if !d.lastActive.IsZero() {
+		if wait := d.IdleFor - now.Sub(d.lastActive); wait > 0 {
+			return false, fmt.Sprintf("waiting for the GPU to stay idle (%s left)", wait.Round(time.Second))
+		}
+	}
+	return true, ""
+}
+
+func (d *IdleDetector) activity() string {
+	processes, err := d.Processes()
+	if err != nil {
+		// Err on the side of the contributor's own use of the GPU
+		return fmt.Sprintf("unable to list GPU processes (%v)", err)
+	}
+	if len(processes) > 0 {
+		return fmt.Sprintf("%s (pid %d)", filepath.Base(processes[0].Name), processes[0].PID)
+	}
+
+	for _, s := range d.Samples() {
+		if s.UtilizationPct > d.MaxUtilizationPct {
+			return fmt.Sprintf("GPU %d at %.0f%% utilization", s.Index, s.UtilizationPct)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,162 @@
+package schedule
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rios/worker/pkg/config"
+)
+
+var dayNames = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// Window is a recurring period in which the worker takes jobs
+type Window struct {
+	days  [7]bool
+	start int // minutes since midnight
+	end   int // minutes since midnight; <= start means the window ends the next day
+}
+
+// ParseWindow parses a window of the form "<days> <HH:MM>-<HH:MM>" where days is
+// "*", a day ("sat"), a range ("mon-fri") or a list ("sat,sun"), and the time
+// range may be "*" for the whole day. A range ending before it starts crosses
+// midnight, e.g. "mon-fri 22:00-07:00" runs from 22:00 until 07:00 the next day.
+func ParseWindow(s string) (Window, error) {
+	fields := strings.Fields(s)
+	if len(fields) != 2 {
+		return Window{}, fmt.Errorf("invalid schedule window %q: expected \"<days> <HH:MM>-<HH:MM>\"", s)
+	}
+
+	var w Window
+	if err := w.parseDays(fields[0]); err != nil {
+		return Window{}, fmt.Errorf("invalid schedule window %q: %w", s, err)
+	}
+
+	if fields[1] == "*" {
+		w.start, w.end = 0, 0
+		return w, nil
+	}
+
+	from, to, ok := strings.Cut(fields[1], "-")
+	if !ok {
+		return Window{}, fmt.Errorf("invalid schedule window %q: expected a time range like 09:00-17:00", s)
+	}
+	var err error
+	if w.start, err = parseClock(from); err != nil {
+		return Window{}, fmt.Errorf("invalid schedule window %q: %w", s, err)
+	}
+	if w.end, err = parseClock(to); err != nil {
+		return Window{}, fmt.Errorf("invalid schedule window %q: %w", s, err)
+	}
+	return w, nil
+}
+
+func (w *Window) parseDays(spec string) error {
+	if spec == "*" {
+		for i := range w.days {
+			w.days[i] = true
+		}
+		return nil
+	}
+
+	for _, part := range strings.Split(strings.ToLower(spec), ",") {
+		from, to, isRange := strings.Cut(part, "-")
+		first, ok := dayNames[from]
+		if !ok {
+			return fmt.Errorf("unknown day %q", from)
+		}
+		if !isRange {
+			w.days[first] = true
+			continue
+		}
+		last, ok := dayNames[to]
+		if !ok {
+			return fmt.Errorf("unknown day %q", to)
+		}
+		for d := first; ; d = (d + 1) % 7 {
+			w.days[d] = true
+			if d == last {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		if s == "24:00" {
+			return 24 * 60, nil
+		}
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// contains reports whether the local time t falls into the window
+func (w *Window) contains(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+	day := t.Weekday()
+	yesterday := (day + 6) % 7
+
+	if w.start < w.end {
+		return w.days[day] && minute >= w.start && minute < w.end
+	}
+	// Whole day or crossing midnight
+	return (w.days[day] && minute >= w.start) || (w.days[yesterday] && minute < w.end)
+}
+
+// Schedule decides when the worker is available for jobs
+type Schedule struct {
+	windows  []Window
+	location *time.Location
+}
+
+// New builds a schedule from the config. A schedule without windows is always active.
+func New(cfg config.ScheduleConfig) (*Schedule, error) {
+	s := &Schedule{location: time.Local}
+	if cfg.Timezone != "" {
+		loc, err := time.LoadLocation(cfg.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule timezone %q: %w", cfg.Timezone, err)
+		}
+		s.location = loc
+	}
+
+	for _, spec := range cfg.Windows {
+		w, err := ParseWindow(spec)
+		if err != nil {
+			return nil, err
+		}
+		s.windows = append(s.windows, w)
+	}
+	return s, nil
+}
+
+// Active reports whether t falls into one of the windows
+func (s *Schedule) Active(t time.Time) bool {
+	if len(s.windows) == 0 {
+		return true
+	}
+	t = t.In(s.location)
+	for i := range s.windows {
+		if s.windows[i].contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// Location returns the time zone the windows are evaluated in
+func (s *Schedule) Location() *time.Location {
+	return s.location
+}
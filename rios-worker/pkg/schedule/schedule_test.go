@@ -0,0 +1,84 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseWindow(t *testing.T) {
+	weekdays := [7]bool{false, true, true, true, true, true, false}
+	weekend := [7]bool{true, false, false, false, false, false, true}
+	all := [7]bool{true, true, true, true, true, true, true}
+
+	tests := []struct {
+		in      string
+		want    Window
+		wantErr bool
+	}{
+		{in: "mon-fri 09:00-17:00", want: Window{days: weekdays, start: 9 * 60, end: 17 * 60}},
+		{in: "mon-fri 22:00-07:00", want: Window{days: weekdays, start: 22 * 60, end: 7 * 60}},
+		{in: "sat,sun *", want: Window{days: weekend}},
+		{in: "SAT,Sun 00:00-24:00", want: Window{days: weekend, end: 24 * 60}},
+		{in: "fri-mon 18:30-23:45", want: Window{days: [7]bool{true, true, false, false, false, true, true}, start: 18*60 + 30, end: 23*60 + 45}},
+		{in: "* 08:00-20:00", want: Window{days: all, start: 8 * 60, end: 20 * 60}},
+		{in: "wed 12:00-13:00", want: Window{days: [7]bool{3: true}, start: 12 * 60, end: 13 * 60}},
+		{in: "mon-fri", wantErr: true},
+		{in: "mon-fri 09:00", wantErr: true},
+		{in: "mon-fri 9am-5pm", wantErr: true},
+		{in: "mon-fri 09:00-25:00", wantErr: true},
+		{in: "weekdays 09:00-17:00", wantErr: true},
+		{in: "mon-xyz 09:00-17:00", wantErr: true},
+		{in: "mon 09:00-17:00 extra", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseWindow(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseWindow(%q) = %+v, want an error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseWindow(%q): %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseWindow(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestWindowContains(t *testing.T) {
+	// 2024-01-01 is a Monday
+	at := func(day int, clock string) time.Time {
+		c, _ := time.Parse("15:04", clock)
+		return time.Date(2024, 1, day, c.Hour(), c.Minute(), 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		window string
+		t      time.Time
+		want   bool
+	}{
+		{"mon-fri 09:00-17:00", at(1, "09:00"), true},
+		{"mon-fri 09:00-17:00", at(1, "17:00"), false},
+		{"mon-fri 09:00-17:00", at(6, "12:00"), false},
+		// Crossing midnight: Friday night runs into Saturday morning
+		{"mon-fri 22:00-07:00", at(5, "23:00"), true},
+		{"mon-fri 22:00-07:00", at(6, "06:59"), true},
+		{"mon-fri 22:00-07:00", at(1, "06:00"), false},
+		{"mon-fri 22:00-07:00", at(6, "22:30"), false},
+		{"sat,sun *", at(7, "00:00"), true},
+		{"sat,sun *", at(7, "23:59"), true},
+		{"sat,sun *", at(1, "00:00"), false},
+	}
+	for _, tt := range tests {
+		w, err := ParseWindow(tt.window)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := w.contains(tt.t); got != tt.want {
+			t.Errorf("%q contains %s = %v, want %v", tt.window, tt.t.Format("Mon 15:04"), got, tt.want)
+		}
+	}
+}
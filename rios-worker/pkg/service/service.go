@@ -0,0 +1,378 @@
+// Package service installs the worker as a systemd service running as a
+// dedicated user, with its configuration in /etc/rios and its data in
+// /var/lib/rios.
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/rios/worker/pkg/history"
+)
+
+const (
+	Name      = "rios-worker"
+	UnitPath  = "/etc/systemd/system/rios-worker.service"
+	User      = "rios"
+	ConfigDir = "/etc/rios"
+	DataDir   = "/var/lib/rios"
+)
+
+// Unit describes the systemd unit of the worker
+type Unit struct {
+	Binary  string   // absolute path of rios-worker
+	User    string   // the service runs as
+	Groups  []string // supplementary groups, e.g. docker
+	After   []string // units to start after, e.g. docker.service
+	RunArgs []string // extra arguments of rios-worker run
+}
+
+var unitTemplate = template.Must(template.New("unit").Parse(`[Unit]
+Description=RiOS Worker - Decentralized GPU Compute Node
+Documentation=https://docs.rios.com.ai
+After=network-online.target{{range .After}} {{.}}{{end}}
+Wants=network-online.target{{range .After}} {{.}}{{end}}
+
+[Service]
+Type=simple
+User={{.User}}
+Group={{.User}}
+{{- if .Groups}}
+SupplementaryGroups={{range $i, $g := .Groups}}{{if $i}} {{end}}{{$g}}{{end}}
+{{- end}}
+
+# Configuration and data live outside any home directory
+Environment=RIOS_CONFIG_DIR={{.ConfigDir}}
+Environment=RIOS_DATA_DIR={{.DataDir}}
+StateDirectory=rios
+StateDirectoryMode=0750
+
+ExecStart={{.ExecStart}}
+
+# A drained worker exits cleanly and stays stopped
+Restart=on-failure
+RestartSec=10
+# Leave time to send the offline heartbeat
+TimeoutStopSec=60
+
+# Resource limits
+LimitNOFILE=65536
+
+# Logging
+StandardOutput=journal
+StandardError=journal
+SyslogIdentifier=rios-worker
+
+# Security
+NoNewPrivileges=true
+PrivateTmp=true
+ProtectHome=true
+ProtectSystem=full
+ReadWritePaths={{.ConfigDir}}
+
+[Install]
+WantedBy=multi-user.target
+`))
+
+// Render returns the unit file
+func (u *Unit) Render() string {
+	args := append([]string{u.Binary, "run", "--log-format", "journald"}, u.RunArgs...)
+	for i, arg := range args {
+		if strings.ContainsAny(arg, " \t\"'\\") {
+			args[i] = strconv.Quote(arg)
+		}
+	}
+
+	var buf bytes.Buffer
+	unitTemplate.Execute(&buf, map[string]interface{}{
+		"User":      u.User,
+		"Groups":    u.Groups,
+		"After":     u.After,
+		"ConfigDir": ConfigDir,
+		"DataDir":   DataDir,
+		"ExecStart": strings.Join(args, " "),
+	})
+	return buf.String()
+}
+
+// Systemctl runs systemctl with args and returns its error output on failure
+func Systemctl(args ...string) error {
+	if output, err := exec.Command("systemctl", args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("systemctl %s failed: %s", strings.Join(args, " "), strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// HasSystemd reports whether the machine was booted with systemd
+func HasSystemd() bool {
+	_, err := os.Stat("/run/systemd/system")
+	return err == nil
+}
+
+// EnsureUser creates the system user name with a group of the same name if
+// it doesn't exist yet, and adds it to groups that exist on the machine
+func EnsureUser(name string, groups []string) (created bool, err error) {
+	if _, err := user.Lookup(name); err != nil {
+		cmd := exec.Command("useradd", "--system", "--user-group", "--home-dir", DataDir, "--no-create-home", "--shell", "/usr/sbin/nologin", name)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return false, fmt.Errorf("failed to create user %s: %s", name, strings.TrimSpace(string(output)))
+		}
+		created = true
+	}
+	for _, group := range groups {
+		if output, err := exec.Command("usermod", "--append", "--groups", group, name).CombinedOutput(); err != nil {
+			return created, fmt.Errorf("failed to add user %s to group %s: %s", name, group, strings.TrimSpace(string(output)))
+		}
+	}
+	return created, nil
+}
+
+// ExistingGroups returns the groups of names that exist on the machine
+func ExistingGroups(names ...string) []string {
+	var groups []string
+	for _, name := range names {
+		if _, err := user.LookupGroup(name); err == nil {
+			groups = append(groups, name)
+		}
+	}
+	return groups
+}
+
+// Owner returns the uid and gid of a user
+func Owner(name string) (uid, gid int, err error) {
+	u, err := user.Lookup(name)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to look up user %s: %w", name, err)
+	}
+	uid, _ = strconv.Atoi(u.Uid)
+	gid, _ = strconv.Atoi(u.Gid)
+	return uid, gid, nil
+}
+
+// EnsureDir creates dir with mode and hands it to uid:gid
+func EnsureDir(dir string, mode os.FileMode, uid, gid int) error {
+	if err := os.MkdirAll(dir, mode); err != nil {
+		return fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+	if err := os.Chmod(dir, mode); err != nil {
+		return fmt.Errorf("failed to set the mode of %s: %w", dir, err)
+	}
+	if err := os.Chown(dir, uid, gid); err != nil {
+		return fmt.Errorf("failed to change the owner of %s: %w", dir, err)
+	}
+	return nil
+}
+
+// Migrate copies a registration made with 'rios-worker register' as a
+// regular user (~/.rios) into the service directories: the configuration
+// and node key into ConfigDir, the job history and receipts into DataDir.
+// Nothing is copied if the service already has a configuration, and it
+// fails while a worker still uses the registration.
+func Migrate(fromDir string, uid, gid int) (bool, error) {
+	if _, err := os.Stat(filepath.Join(ConfigDir, "config.json")); err == nil {
+		return false, nil
+	}
+	if _, err := os.Stat(filepath.Join(fromDir, "config.json")); err != nil {
+		return false, nil
+	}
+
+	// The history of a running worker would miss the jobs it records after
+	// the copy, and the node would run twice
+	historyPath := filepath.Join(fromDir, history.HistoryFile)
+	if pids := openedBy(historyPath); len(pids) > 0 {
+		return false, fmt.Errorf("a worker (pid %d) is still running from %s; stop it before installing the service", pids[0], fromDir)
+	}
+	if err := migrateHistory(historyPath, filepath.Join(DataDir, history.HistoryFile), uid, gid); err != nil {
+		return false, err
+	}
+
+	files := map[string]string{
+		"config.json": ConfigDir,
+		"node.key":    ConfigDir,
+	}
+	if receipts, err := os.ReadDir(filepath.Join(fromDir, "receipts")); err == nil {
+		if err := EnsureDir(filepath.Join(DataDir, "receipts"), 0700, uid, gid); err != nil {
+			return false, err
+		}
+		for _, r := range receipts {
+			files[filepath.Join("receipts", r.Name())] = DataDir
+		}
+	}
+
+	for name, toDir := range files {
+		data, err := os.ReadFile(filepath.Join(fromDir, name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return false, fmt.Errorf("failed to read %s: %w", name, err)
+		}
+		to := filepath.Join(toDir, name)
+		if err := os.WriteFile(to, data, 0600); err != nil {
+			return false, fmt.Errorf("failed to write %s: %w", to, err)
+		}
+		if err := os.Chown(to, uid, gid); err != nil {
+			return false, fmt.Errorf("failed to change the owner of %s: %w", to, err)
+		}
+	}
+	return true, nil
+}
+
+// migrateHistory copies the job history through SQLite, so that rows still
+// in the write-ahead log (history.db-wal) are copied too
+func migrateHistory(from, to string, uid, gid int) error {
+	if _, err := os.Stat(from); err != nil {
+		return nil
+	}
+	// Read-only, so an older worker can still use the history it left behind
+	store, err := history.OpenReadOnly(from)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	for _, suffix := range []string{"", "-wal", "-shm"} {
+		if err := os.Remove(to + suffix); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove %s: %w", to+suffix, err)
+		}
+	}
+	if err := store.CopyTo(to); err != nil {
+		return err
+	}
+	// The copy keeps the old schema; bring it up to date for the service
+	copied, err := history.Open(to)
+	if err != nil {
+		return err
+	}
+	if err := copied.Close(); err != nil {
+		return fmt.Errorf("failed to close %s: %w", to, err)
+	}
+	if err := os.Chmod(to, 0600); err != nil {
+		return fmt.Errorf("failed to set the mode of %s: %w", to, err)
+	}
+	if err := os.Chown(to, uid, gid); err != nil {
+		return fmt.Errorf("failed to change the owner of %s: %w", to, err)
+	}
+	return nil
+}
+
+// openedBy returns the processes that have path open, found through
+// /proc/<pid>/fd; it needs root to see the processes of other users
+func openedBy(path string) []int {
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+	procs, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil
+	}
+	var pids []int
+	for _, p := range procs {
+		pid, err := strconv.Atoi(p.Name())
+		if err != nil || pid == os.Getpid() {
+			continue
+		}
+		fdDir := filepath.Join("/proc", p.Name(), "fd")
+		fds, err := os.ReadDir(fdDir)
+		if err != nil {
+			continue
+		}
+		for _, fd := range fds {
+			if target, err := os.Readlink(filepath.Join(fdDir, fd.Name())); err == nil && target == path {
+				pids = append(pids, pid)
+				break
+			}
+		}
+	}
+	return pids
+}
+
+// Status is the state of the installed service
+type Status struct {
+	Installed  bool   `json:"installed"`
+	Enabled    string `json:"enabled"` // enabled, disabled, ...
+	Active     string `json:"active"`  // active, inactive, failed, ...
+	SubState   string `json:"sub_state"`
+	MainPID    int    `json:"main_pid,omitempty"`
+	Since      string `json:"since,omitempty"`
+	Restarts   int    `json:"restarts"`
+	Registered *bool  `json:"registered,omitempty"` // nil if the config dir can't be read
+	ConfigDir  string `json:"config_dir"`
+	DataDir    string `json:"data_dir"`
+	UnitPath   string `json:"unit_path"`
+}
+
+// GetStatus asks systemd about the service
+func GetStatus() (*Status, error) {
+	status := &Status{ConfigDir: ConfigDir, DataDir: DataDir, UnitPath: UnitPath}
+	if _, err := os.Stat(UnitPath); err != nil {
+		return status, nil
+	}
+	status.Installed = true
+
+	output, err := exec.Command("systemctl", "show", Name+".service",
+		"--property=UnitFileState,ActiveState,SubState,MainPID,ActiveEnterTimestamp,NRestarts").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to query systemd: %w", err)
+	}
+	for _, line := range strings.Split(string(output), "\n") {
+		key, value, _ := strings.Cut(line, "=")
+		switch key {
+		case "UnitFileState":
+			status.Enabled = value
+		case "ActiveState":
+			status.Active = value
+		case "SubState":
+			status.SubState = value
+		case "MainPID":
+			status.MainPID, _ = strconv.Atoi(value)
+		case "ActiveEnterTimestamp":
+			status.Since = value
+		case "NRestarts":
+			status.Restarts, _ = strconv.Atoi(value)
+		}
+	}
+
+	_, err = os.Stat(filepath.Join(ConfigDir, "config.json"))
+	if err == nil || os.IsNotExist(err) {
+		registered := err == nil
+		status.Registered = &registered
+	}
+	return status, nil
+}
+
+// InstalledUser returns the user the installed unit runs as
+func InstalledUser() string {
+	data, err := os.ReadFile(UnitPath)
+	if err != nil {
+		return ""
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if name, ok := strings.CutPrefix(line, "User="); ok {
+			return strings.TrimSpace(name)
+		}
+	}
+	return ""
+}
+
+// RemoveUser deletes a user created by EnsureUser; root and missing users
+// are left alone
+func RemoveUser(name string) error {
+	if name == "" || name == "root" {
+		return nil
+	}
+	if _, err := user.Lookup(name); err != nil {
+		return nil
+	}
+	if output, err := exec.Command("userdel", name).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to delete user %s: %s", name, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
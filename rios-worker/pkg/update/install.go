@@ -0,0 +1,123 @@
+package update
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// checkTimeout bounds how long a freshly installed binary may take to
+// report its version
+const checkTimeout = 30 * time.Second
+
+// BackupPath returns where the binary replaced by Install is kept
+func BackupPath(exe string) string {
+	return exe + ".old"
+}
+
+// Download fetches the asset of the manifest at source next to exe and
+// checks its size and SHA-256. It returns the path of the downloaded file,
+// which is removed again if the checks fail.
+func Download(source string, asset *Asset, exe string) (string, error) {
+	ref, err := resolve(source, asset.URL)
+	if err != nil {
+		return "", fmt.Errorf("invalid asset URL %q: %w", asset.URL, err)
+	}
+	body, err := open(ref)
+	if err != nil {
+		return "", fmt.Errorf("failed to download %s: %w", ref, err)
+	}
+	defer body.Close()
+
+	// The same directory as exe, so the binary can be renamed into place
+	f, err := os.CreateTemp(filepath.Dir(exe), ".rios-worker-update-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create download file: %w", err)
+	}
+	staged := f.Name()
+	ok := false
+	defer func() {
+		if !ok {
+			os.Remove(staged)
+		}
+	}()
+
+	hash := sha256.New()
+	n, err := io.Copy(io.MultiWriter(f, hash), io.LimitReader(body, asset.SizeBytes+1))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to download %s: %w", ref, err)
+	}
+	if n != asset.SizeBytes {
+		return "", fmt.Errorf("downloaded binary has %d bytes, the manifest says %d", n, asset.SizeBytes)
+	}
+	if sum := hex.EncodeToString(hash.Sum(nil)); !strings.EqualFold(sum, asset.SHA256) {
+		return "", fmt.Errorf("downloaded binary has SHA-256 %s, the manifest says %s", sum, asset.SHA256)
+	}
+
+	if info, err := os.Stat(exe); err == nil {
+		err = os.Chmod(staged, info.Mode().Perm())
+	} else {
+		err = os.Chmod(staged, 0755)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to make the binary executable: %w", err)
+	}
+	ok = true
+	return staged, nil
+}
+
+// Install replaces exe with the binary at staged, keeping the old one at
+// BackupPath, and checks that the new binary reports version. If it
+// doesn't, the old binary is put back.
+func Install(exe, staged, version string) error {
+	if err := replace(exe, staged); err != nil {
+		os.Remove(staged)
+		return err
+	}
+	if err := checkBinary(exe, version); err != nil {
+		if rollbackErr := Rollback(exe); rollbackErr != nil {
+			return fmt.Errorf("%w; restoring the previous binary failed too: %v", err, rollbackErr)
+		}
+		return fmt.Errorf("%w; the previous binary was restored", err)
+	}
+	return nil
+}
+
+// Rollback puts back the binary replaced by the last Install
+func Rollback(exe string) error {
+	backup := BackupPath(exe)
+	if _, err := os.Stat(backup); err != nil {
+		return fmt.Errorf("no previous binary to roll back to (%s)", backup)
+	}
+	return restore(exe, backup)
+}
+
+// checkBinary runs the binary at exe and compares the version it reports
+func checkBinary(exe, version string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), checkTimeout)
+	defer cancel()
+	output, err := exec.CommandContext(ctx, exe, "version").Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && len(bytes.TrimSpace(exitErr.Stderr)) > 0 {
+			err = fmt.Errorf("%w: %s", err, strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return fmt.Errorf("the new binary failed to run: %w", err)
+	}
+	if got := strings.TrimSpace(string(output)); got != version {
+		return fmt.Errorf("the new binary reports version %q instead of %s", got, version)
+	}
+	return nil
+}
@@ -0,0 +1,265 @@
+// Package update finds, verifies and installs new releases of the worker.
+// Releases are described by a manifest signed with the release key; the
+// worker only trusts the key it was built with.
+package update
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultManifestURL is where the manifest of the latest release is published
+const DefaultManifestURL = "https://github.com/rios/worker/releases/latest/download/manifest.json"
+
+// ReleasePublicKey is the base64 Ed25519 key release manifests are signed
+// with, set at build time with
+// -X github.com/rios/worker/pkg/update.ReleasePublicKey=...
+var ReleasePublicKey string
+
+// Manifest describes a release and its binaries
+type Manifest struct {
+	Version    string    `json:"version"`
+	ReleasedAt time.Time `json:"released_at"`
+	Notes      string    `json:"notes,omitempty"`
+	Assets     []Asset   `json:"assets"`
+}
+
+// Asset is the binary of a release for one platform
+type Asset struct {
+	OS        string `json:"os"`
+	Arch      string `json:"arch"`
+	URL       string `json:"url"` // relative URLs are resolved against the manifest's
+	SHA256    string `json:"sha256"`
+	SizeBytes int64  `json:"size_bytes"`
+}
+
+// SignedManifest is a manifest as signed by the release key. The signature
+// covers the exact bytes of Manifest, so they are kept as they were signed.
+type SignedManifest struct {
+	Manifest  json.RawMessage `json:"manifest"`
+	Signature string          `json:"signature"` // base64 Ed25519 signature
+}
+
+// AssetName returns the file name of the binary for a platform, as built
+// by make build-all
+func AssetName(goos, goarch string) string {
+	name := "rios-worker-" + goos + "-" + goarch
+	if goos == "windows" {
+		name += ".exe"
+	}
+	return name
+}
+
+// ParsePublicKey decodes a base64 Ed25519 public key
+func ParsePublicKey(s string) (ed25519.PublicKey, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid release public key")
+	}
+	return key, nil
+}
+
+// Sign encodes m and signs the encoding with key
+func Sign(m *Manifest, key ed25519.PrivateKey) (*SignedManifest, error) {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode manifest: %w", err)
+	}
+	return &SignedManifest{
+		Manifest:  data,
+		Signature: base64.StdEncoding.EncodeToString(ed25519.Sign(key, data)),
+	}, nil
+}
+
+// Verify checks the signature of s against key and decodes the manifest
+func Verify(s *SignedManifest, key ed25519.PublicKey) (*Manifest, error) {
+	sig, err := base64.StdEncoding.DecodeString(s.Signature)
+	if err != nil {
+		return nil, fmt.Errorf("invalid manifest signature: %w", err)
+	}
+	if !ed25519.Verify(key, s.Manifest, sig) {
+		return nil, fmt.Errorf("manifest signature does not match the release key")
+	}
+
+	var m Manifest
+	if err := json.Unmarshal(s.Manifest, &m); err != nil {
+		return nil, fmt.Errorf("failed to decode manifest: %w", err)
+	}
+	if _, err := ParseVersion(m.Version); err != nil {
+		return nil, fmt.Errorf("manifest has an %w", err)
+	}
+	return &m, nil
+}
+
+// Fetch reads the signed manifest at source, an http(s) URL or a file
+// path, and verifies it with key
+func Fetch(source string, key ed25519.PublicKey) (*Manifest, error) {
+	body, err := open(source)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch manifest: %w", err)
+	}
+	defer body.Close()
+
+	var signed SignedManifest
+	if err := json.NewDecoder(io.LimitReader(body, 1<<20)).Decode(&signed); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+	return Verify(&signed, key)
+}
+
+// Asset returns the binary for a platform
+func (m *Manifest) Asset(goos, goarch string) (*Asset, error) {
+	for i := range m.Assets {
+		if m.Assets[i].OS == goos && m.Assets[i].Arch == goarch {
+			return &m.Assets[i], nil
+		}
+	}
+	return nil, fmt.Errorf("release %s has no binary for %s/%s", m.Version, goos, goarch)
+}
+
+// resolve returns where an asset URL points, relative to the manifest at source
+func resolve(source, ref string) (string, error) {
+	if isURL(ref) || filepath.IsAbs(ref) {
+		return ref, nil
+	}
+	if isURL(source) {
+		base, err := url.Parse(source)
+		if err != nil {
+			return "", err
+		}
+		rel, err := url.Parse(ref)
+		if err != nil {
+			return "", err
+		}
+		return base.ResolveReference(rel).String(), nil
+	}
+	return filepath.Join(filepath.Dir(source), filepath.FromSlash(ref)), nil
+}
+
+func isURL(s string) bool {
+	return strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://")
+}
+
+// open returns the contents of an http(s) URL or a file
+func open(source string) (io.ReadCloser, error) {
+	if !isURL(source) {
+		return os.Open(source)
+	}
+	client := &http.Client{Timeout: 10 * time.Minute}
+	resp, err := client.Get(source)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s: HTTP %d", source, resp.StatusCode)
+	}
+	return resp.Body, nil
+}
+
+// Version is a parsed vMAJOR.MINOR.PATCH[-PRERELEASE] version
+type Version struct {
+	Major, Minor, Patch int
+	Pre                 string
+}
+
+// ParseVersion parses a version such as v1.2.3 or 1.2.3-rc.1
+func ParseVersion(s string) (Version, error) {
+	var v Version
+	core, pre, _ := strings.Cut(strings.TrimPrefix(strings.TrimSpace(s), "v"), "-")
+	parts := strings.Split(core, ".")
+	if len(parts) != 3 {
+		return v, fmt.Errorf("invalid version %q", s)
+	}
+	nums := make([]int, 3)
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return v, fmt.Errorf("invalid version %q", s)
+		}
+		nums[i] = n
+	}
+	return Version{Major: nums[0], Minor: nums[1], Patch: nums[2], Pre: pre}, nil
+}
+
+// Compare returns -1, 0 or 1 as v is older than, the same as or newer
+// than w. A pre-release is older than its release, and pre-releases are
+// ordered like semver orders them, e.g. rc.9 before rc.10.
+func (v Version) Compare(w Version) int {
+	for _, d := range []int{v.Major - w.Major, v.Minor - w.Minor, v.Patch - w.Patch} {
+		if d < 0 {
+			return -1
+		}
+		if d > 0 {
+			return 1
+		}
+	}
+	switch {
+	case v.Pre == w.Pre:
+		return 0
+	case v.Pre == "":
+		return 1
+	case w.Pre == "":
+		return -1
+	}
+	return comparePre(v.Pre, w.Pre)
+}
+
+// comparePre compares dot-separated pre-release identifiers: numeric ones
+// as numbers and before alphanumeric ones, others as strings. Of two
+// pre-releases that agree as far as both go, the shorter is older.
+func comparePre(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		an, aErr := strconv.ParseUint(as[i], 10, 64)
+		bn, bErr := strconv.ParseUint(bs[i], 10, 64)
+		switch {
+		case aErr == nil && bErr == nil:
+			if an != bn {
+				return cmpInt(an < bn)
+			}
+		case aErr == nil:
+			return -1
+		case bErr == nil:
+			return 1
+		case as[i] != bs[i]:
+			return cmpInt(as[i] < bs[i])
+		}
+	}
+	if len(as) != len(bs) {
+		return cmpInt(len(as) < len(bs))
+	}
+	return 0
+}
+
+// cmpInt returns -1 if less, 1 otherwise
+func cmpInt(less bool) int {
+	if less {
+		return -1
+	}
+	return 1
+}
+
+// Older reports whether version a is older than version b; versions that
+// don't parse, such as those of development builds, are never older
+func Older(a, b string) bool {
+	va, err := ParseVersion(a)
+	if err != nil {
+		return false
+	}
+	vb, err := ParseVersion(b)
+	if err != nil {
+		return false
+	}
+	return va.Compare(vb) < 0
+}
@@ -0,0 +1,30 @@
+//go:build !windows
+
+package update
+
+import (
+	"fmt"
+	"os"
+)
+
+// replace moves staged over exe in one rename, so exe is always either the
+// old or the new binary, after linking the old one to its backup path
+func replace(exe, staged string) error {
+	backup := BackupPath(exe)
+	os.Remove(backup)
+	if err := os.Link(exe, backup); err != nil {
+		return fmt.Errorf("failed to back up %s: %w", exe, err)
+	}
+	if err := os.Rename(staged, exe); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", exe, err)
+	}
+	return nil
+}
+
+// restore moves backup over exe in one rename
+func restore(exe, backup string) error {
+	if err := os.Rename(backup, exe); err != nil {
+		return fmt.Errorf("failed to restore %s: %w", exe, err)
+	}
+	return nil
+}
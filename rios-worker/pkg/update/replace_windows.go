@@ -0,0 +1,36 @@
+//go:build windows
+
+package update
+
+import (
+	"fmt"
+	"os"
+)
+
+// replace moves exe to its backup path and staged into its place. Windows
+// can't overwrite a running executable, but it can rename it.
+func replace(exe, staged string) error {
+	backup := BackupPath(exe)
+	os.Remove(backup)
+	if err := os.Rename(exe, backup); err != nil {
+		return fmt.Errorf("failed to back up %s: %w", exe, err)
+	}
+	if err := os.Rename(staged, exe); err != nil {
+		os.Rename(backup, exe)
+		return fmt.Errorf("failed to replace %s: %w", exe, err)
+	}
+	return nil
+}
+
+// restore moves the broken binary aside and backup into its place
+func restore(exe, backup string) error {
+	broken := exe + ".broken"
+	os.Remove(broken)
+	if err := os.Rename(exe, broken); err != nil {
+		return fmt.Errorf("failed to restore %s: %w", exe, err)
+	}
+	if err := os.Rename(backup, exe); err != nil {
+		return fmt.Errorf("failed to restore %s: %w", exe, err)
+	}
+	return nil
+}
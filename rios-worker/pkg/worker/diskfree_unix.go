@@ -0,0 +1,15 @@
+//go:build !windows
+
+package worker
+
+import "syscall"
+
+// FreeDiskBytes returns the space available to unprivileged users on the
+// filesystem containing path
+func FreeDiskBytes(path string) (int64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, err
+	}
+	return int64(stat.Bavail) * int64(stat.Bsize), nil
+}
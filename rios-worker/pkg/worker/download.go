@@ -0,0 +1,497 @@
+package worker
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rios/worker/pkg/api"
+)
+
+// DefaultMaxDownloadBytes caps a single input whose size is not declared
+const DefaultMaxDownloadBytes = 20 * bytesPerGB
+
+// DefaultDownloadSegments is how many ranges of a large input are fetched at once
+const DefaultDownloadSegments = 4
+
+const (
+	minSegmentBytes  = 64 << 20 // inputs smaller than two segments are fetched in one piece
+	saveEveryBytes   = 16 << 20 // progress is saved after this many bytes per segment
+	downloadRetries  = 5
+	maxRetryInterval = 30 * time.Second
+)
+
+// errContentChanged is returned when the server ignores a range request,
+// which means the file changed since the partial download started
+var errContentChanged = errors.New("remote file changed during download")
+
+// statusError is an unexpected HTTP response
+type statusError struct {
+	Name   string
+	Code   int
+	Status string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("download of %s failed: HTTP %s", e.Name, e.Status)
+}
+
+// partialDownload is the progress of an interrupted download. It is saved
+// next to the partial file so a retry, or the next worker run for cached
+// inputs, continues where the download stopped.
+type partialDownload struct {
+	URL       string     `json:"url"`
+	Size      int64      `json:"size"`
+	Validator string     `json:"validator,omitempty"` // ETag or Last-Modified of the remote file
+	Segments  []*segment `json:"segments"`
+}
+
+type segment struct {
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`  // exclusive
+	Done  int64 `json:"done"` // bytes written from Start
+}
+
+// remoteFile is what a HEAD request tells about a download
+type remoteFile struct {
+	size      int64 // -1 if unknown
+	ranges    bool
+	validator string
+}
+
+// downloadFile downloads url to path. Servers that support range requests
+// are fetched in parallel segments and resumed from a partial file after a
+// dropped connection; others are streamed in one piece. The file only
+// appears at path once the status code, size and SHA-256 have been checked
+// against want (nil to only check the status and size cap). It returns the
+// size and SHA-256 of the downloaded file.
+func (e *Executor) downloadFile(ctx context.Context, url, path string, want *api.InputDigest) (*api.InputDigest, error) {
+	// For S3 URLs, create a placeholder
+	// In production, use AWS SDK to download from S3
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		// A placeholder can't stand in for content the job vouches for
+		if want != nil && (want.SHA256 != "" || want.SizeBytes > 0) {
+			return nil, fmt.Errorf("cannot download %s: only http(s) URLs can be checked against the declared digest", filepath.Base(path))
+		}
+		placeholder := []byte("{}")
+		if err := os.WriteFile(path, placeholder, 0644); err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(placeholder)
+		return &api.InputDigest{SizeBytes: int64(len(placeholder)), SHA256: hex.EncodeToString(sum[:])}, nil
+	}
+
+	name := filepath.Base(path)
+	partPath := path + ".part"
+	statePath := partPath + ".json"
+
+	unlock := e.lockDownload(partPath)
+	defer unlock()
+
+	limit := e.maxDownloadBytes()
+	if want != nil && want.SizeBytes > 0 {
+		limit = want.SizeBytes
+	}
+
+	remote, err := e.probe(ctx, url, name)
+	if err != nil {
+		return nil, err
+	}
+	if want != nil && want.SizeBytes > 0 && remote.size >= 0 && remote.size != want.SizeBytes {
+		return nil, fmt.Errorf("size mismatch for %s: expected %d bytes, server has %d", name, want.SizeBytes, remote.size)
+	}
+	if remote.size > limit {
+		return nil, fmt.Errorf("%s is %d bytes, limit is %d bytes", name, remote.size, limit)
+	}
+
+	if remote.ranges && remote.size > 0 {
+		err = e.downloadSegments(ctx, url, partPath, statePath, remote)
+		if errors.Is(err, errContentChanged) {
+			// Start over once against the new content
+			os.Remove(statePath)
+			if remote, err = e.probe(ctx, url, name); err == nil {
+				err = e.downloadSegments(ctx, url, partPath, statePath, remote)
+			}
+		}
+	} else {
+		os.Remove(statePath)
+		err = e.downloadStream(ctx, url, partPath, name, limit)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	got, err := verifyDownload(partPath, name, limit, want)
+	if err != nil {
+		// A corrupt partial would fail again on resume
+		os.Remove(partPath)
+		os.Remove(statePath)
+		return nil, err
+	}
+
+	os.Remove(statePath)
+	if err := os.Rename(partPath, path); err != nil {
+		return nil, err
+	}
+	return got, nil
+}
+
+// probe asks the server for the size of url and whether it supports ranges,
+// with a GET of its first byte: unlike HEAD, that works with pre-signed URLs
+// that are only valid for GET. Servers that ignore the range are treated as
+// plain streams.
+func (e *Executor) probe(ctx context.Context, url, name string) (*remoteFile, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", "bytes=0-0")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	// Closing without reading drops the connection rather than fetching the
+	// whole file from a server that ignored the range
+	resp.Body.Close()
+
+	remote := &remoteFile{
+		validator: resp.Header.Get("ETag"),
+	}
+	if remote.validator == "" {
+		remote.validator = resp.Header.Get("Last-Modified")
+	}
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		// Content-Range: bytes 0-0/<size>, the size may be unknown ("*")
+		remote.size = -1
+		if _, total, ok := strings.Cut(resp.Header.Get("Content-Range"), "/"); ok {
+			if size, err := strconv.ParseInt(total, 10, 64); err == nil {
+				remote.size = size
+				remote.ranges = true
+			}
+		}
+	case http.StatusOK:
+		remote.size = resp.ContentLength
+	case http.StatusRequestedRangeNotSatisfiable:
+		// An empty file has no first byte
+		remote.size = -1
+	default:
+		return nil, &statusError{name, resp.StatusCode, resp.Status}
+	}
+	return remote, nil
+}
+
+// downloadStream fetches url into partPath in one request, from the start
+func (e *Executor) downloadStream(ctx context.Context, url, partPath, name string, limit int64) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return &statusError{name, resp.StatusCode, resp.Status}
+	}
+	if resp.ContentLength > limit {
+		return fmt.Errorf("%s is %d bytes, limit is %d bytes", name, resp.ContentLength, limit)
+	}
+
+	out, err := os.Create(partPath)
+	if err != nil {
+		return err
+	}
+	// Read one byte past the limit to tell "exactly at the limit" from "over it"
+	_, err = io.Copy(out, e.throttle(ctx, countDownload(ctx, io.LimitReader(resp.Body, limit+1))))
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// downloadSegments fetches url into partPath as parallel ranges, continuing
+// from the progress in statePath if it matches the remote file
+func (e *Executor) downloadSegments(ctx context.Context, url, partPath, statePath string, remote *remoteFile) error {
+	state := loadPartial(statePath)
+	if state == nil || state.URL != url || state.Size != remote.size || state.Validator != remote.validator {
+		state = newPartial(url, remote, e.segmentCount())
+		os.Remove(partPath)
+	}
+
+	out, err := os.OpenFile(partPath, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	if err := out.Truncate(state.Size); err != nil {
+		return err
+	}
+
+	var mu sync.Mutex // guards the progress in state and its file
+	save := func() {
+		mu.Lock()
+		defer mu.Unlock()
+		if data, err := json.Marshal(state); err == nil {
+			os.WriteFile(statePath, data, 0644)
+		}
+	}
+	defer save()
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	var wg sync.WaitGroup
+	for _, seg := range state.Segments {
+		if seg.Done >= seg.End-seg.Start {
+			continue
+		}
+		wg.Add(1)
+		go func(seg *segment) {
+			defer wg.Done()
+			if err := e.fetchSegment(ctx, url, remote.validator, out, seg, &mu, save); err != nil {
+				cancel(err)
+			}
+		}(seg)
+	}
+	wg.Wait()
+
+	return context.Cause(ctx)
+}
+
+func newPartial(url string, remote *remoteFile, segments int) *partialDownload {
+	if max := int(remote.size / minSegmentBytes); segments > max {
+		segments = max
+	}
+	if segments < 1 {
+		segments = 1
+	}
+
+	state := &partialDownload{URL: url, Size: remote.size, Validator: remote.validator}
+	step := remote.size / int64(segments)
+	for i := 0; i < segments; i++ {
+		seg := &segment{Start: int64(i) * step, End: int64(i+1) * step}
+		if i == segments-1 {
+			seg.End = remote.size
+		}
+		state.Segments = append(state.Segments, seg)
+	}
+	return state
+}
+
+func loadPartial(statePath string) *partialDownload {
+	data, err := os.ReadFile(statePath)
+	if err != nil {
+		return nil
+	}
+	var state partialDownload
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil
+	}
+	return &state
+}
+
+// fetchSegment downloads the rest of seg, retrying with backoff after
+// network errors and server-side failures. Attempts that make progress
+// don't count against the retries.
+func (e *Executor) fetchSegment(ctx context.Context, url, validator string, out *os.File, seg *segment, mu *sync.Mutex, save func()) error {
+	for attempt := 0; ; attempt++ {
+		mu.Lock()
+		before := seg.Done
+		mu.Unlock()
+
+		err := e.fetchRange(ctx, url, validator, out, seg, mu, save)
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil || !retryable(err) {
+			return err
+		}
+		mu.Lock()
+		if seg.Done > before {
+			attempt = 0
+		}
+		mu.Unlock()
+		if attempt == downloadRetries {
+			return err
+		}
+
+		save()
+		wait := time.Second << attempt
+		if wait > maxRetryInterval {
+			wait = maxRetryInterval
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// fetchRange requests the missing bytes of seg and writes them in place
+func (e *Executor) fetchRange(ctx context.Context, url, validator string, out *os.File, seg *segment, mu *sync.Mutex, save func()) error {
+	mu.Lock()
+	offset := seg.Start + seg.Done
+	mu.Unlock()
+	if offset >= seg.End {
+		return nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, seg.End-1))
+	if validator != "" {
+		req.Header.Set("If-Range", validator)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusOK:
+		return errContentChanged
+	default:
+		return &statusError{strings.TrimSuffix(filepath.Base(out.Name()), ".part"), resp.StatusCode, resp.Status}
+	}
+
+	body := e.throttle(ctx, countDownload(ctx, io.LimitReader(resp.Body, seg.End-offset)))
+	buf := make([]byte, 256<<10)
+	var unsaved int64
+	for {
+		n, err := body.Read(buf)
+		if n > 0 {
+			if _, werr := out.WriteAt(buf[:n], offset); werr != nil {
+				return werr
+			}
+			offset += int64(n)
+			mu.Lock()
+			seg.Done = offset - seg.Start
+			mu.Unlock()
+
+			if unsaved += int64(n); unsaved >= saveEveryBytes {
+				save()
+				unsaved = 0
+			}
+		}
+		if err == io.EOF {
+			if offset < seg.End {
+				return io.ErrUnexpectedEOF
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// retryable reports whether a failed range request is worth repeating
+func retryable(err error) bool {
+	var status *statusError
+	if errors.As(err, &status) {
+		return status.Code >= 500 || status.Code == http.StatusTooManyRequests
+	}
+	var pathErr *os.PathError
+	if errors.As(err, &pathErr) {
+		// Local disk errors, e.g. a full disk
+		return false
+	}
+	return !errors.Is(err, errContentChanged)
+}
+
+// verifyDownload checks the finished file against the size cap and want
+func verifyDownload(partPath, name string, limit int64, want *api.InputDigest) (*api.InputDigest, error) {
+	f, err := os.Open(partPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	hasher := sha256.New()
+	n, err := io.Copy(hasher, f)
+	if err != nil {
+		return nil, err
+	}
+	got := &api.InputDigest{SizeBytes: n, SHA256: hex.EncodeToString(hasher.Sum(nil))}
+
+	if n > limit {
+		return nil, fmt.Errorf("%s exceeds the limit of %d bytes", name, limit)
+	}
+	if want == nil {
+		return got, nil
+	}
+	if want.SizeBytes > 0 && n != want.SizeBytes {
+		return nil, fmt.Errorf("size mismatch for %s: expected %d bytes, got %d", name, want.SizeBytes, n)
+	}
+	if want.SHA256 != "" && !strings.EqualFold(got.SHA256, want.SHA256) {
+		return nil, fmt.Errorf("sha256 mismatch for %s: expected %s, got %s", name, strings.ToLower(want.SHA256), got.SHA256)
+	}
+	return got, nil
+}
+
+// lockDownload serializes downloads to the same partial file, e.g. two
+// inputs of a job sharing a cached checkpoint. The lock is forgotten once
+// no download holds or waits for it.
+func (e *Executor) lockDownload(partPath string) (unlock func()) {
+	e.downloadLocksMu.Lock()
+	if e.downloadLocks == nil {
+		e.downloadLocks = make(map[string]*downloadLock)
+	}
+	lock := e.downloadLocks[partPath]
+	if lock == nil {
+		lock = &downloadLock{}
+		e.downloadLocks[partPath] = lock
+	}
+	lock.refs++
+	e.downloadLocksMu.Unlock()
+
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+		e.downloadLocksMu.Lock()
+		if lock.refs--; lock.refs == 0 {
+			delete(e.downloadLocks, partPath)
+		}
+		e.downloadLocksMu.Unlock()
+	}
+}
+
+// downloadLock is the lock of a partial file
+type downloadLock struct {
+	sync.Mutex
+	refs int // downloads holding or waiting for it
+}
+
+func (e *Executor) maxDownloadBytes() int64 {
+	if e.MaxDownloadBytes > 0 {
+		return e.MaxDownloadBytes
+	}
+	return DefaultMaxDownloadBytes
+}
+
+func (e *Executor) segmentCount() int {
+	if e.DownloadSegments > 0 {
+		return e.DownloadSegments
+	}
+	return DefaultDownloadSegments
+}
@@ -0,0 +1,123 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rios/worker/pkg/api"
+)
+
+// countingWriter counts the body bytes a handler sends
+type countingWriter struct {
+	http.ResponseWriter
+	n *int64
+}
+
+func (w countingWriter) Write(p []byte) (int, error) {
+	atomic.AddInt64(w.n, int64(len(p)))
+	return w.ResponseWriter.Write(p)
+}
+
+func TestDownloadResume(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789abcdef"), 1<<16) // 1 MiB
+	size := int64(len(data))
+	sum := sha256.Sum256(data)
+	digest := &api.InputDigest{SizeBytes: size, SHA256: hex.EncodeToString(sum[:])}
+
+	// Half the file in two segments: the first is complete, the second has
+	// 100 KiB of its 512 KiB
+	half := size / 2
+	progress := []*segment{{Start: 0, End: half, Done: half}, {Start: half, End: size, Done: 100 << 10}}
+	done := half + 100<<10
+
+	tests := []struct {
+		name       string
+		etag       string // of the file on the server
+		state      string // validator of the partial download, empty for none
+		corrupt    bool   // the partial file has wrong bytes in its done range
+		wantServed int64  // bytes sent after the probe
+		wantErr    string
+	}{
+		{name: "no partial download", etag: `"v1"`, wantServed: size},
+		{name: "resumes the missing bytes", etag: `"v1"`, state: `"v1"`, wantServed: size - done},
+		{name: "starts over when the file changed", etag: `"v2"`, state: `"v1"`, wantServed: size},
+		{name: "discards a corrupt partial download", etag: `"v1"`, state: `"v1"`, corrupt: true,
+			wantServed: size - done, wantErr: "sha256 mismatch"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var served int64
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("ETag", tt.etag)
+				if r.Header.Get("Range") != "bytes=0-0" {
+					w = countingWriter{w, &served}
+				}
+				http.ServeContent(w, r, "model.bin", time.Time{}, bytes.NewReader(data))
+			}))
+			defer srv.Close()
+			url := srv.URL + "/model.bin"
+
+			dir := t.TempDir()
+			path := filepath.Join(dir, "model.bin")
+			partPath, statePath := path+".part", path+".part.json"
+			if tt.state != "" {
+				partial := make([]byte, size)
+				for _, seg := range progress {
+					copy(partial[seg.Start:seg.Start+seg.Done], data[seg.Start:])
+				}
+				if tt.corrupt {
+					partial[0] ^= 0xff
+				}
+				if err := os.WriteFile(partPath, partial, 0644); err != nil {
+					t.Fatal(err)
+				}
+				state, _ := json.Marshal(&partialDownload{URL: url, Size: size, Validator: tt.state, Segments: progress})
+				if err := os.WriteFile(statePath, state, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			e := &Executor{}
+			got, err := e.downloadFile(context.Background(), url, path, digest)
+			if served != tt.wantServed {
+				t.Errorf("server sent %d bytes, want %d", served, tt.wantServed)
+			}
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("downloadFile error = %v, want %q", err, tt.wantErr)
+				}
+				for _, p := range []string{path, partPath, statePath} {
+					if _, err := os.Stat(p); err == nil {
+						t.Errorf("%s was left behind", filepath.Base(p))
+					}
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("downloadFile: %v", err)
+			}
+			if *got != *digest {
+				t.Errorf("downloadFile = %+v, want %+v", got, digest)
+			}
+			if written, _ := os.ReadFile(path); !bytes.Equal(written, data) {
+				t.Error("downloaded file differs from the served one")
+			}
+			for _, p := range []string{partPath, statePath} {
+				if _, err := os.Stat(p); err == nil {
+					t.Errorf("%s was left behind", filepath.Base(p))
+				}
+			}
+		})
+	}
+}
@@ -1,35 +1,72 @@
 package worker
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"io"
-	"net/http"
 	"os"
-	"os/exec"
 	"path/filepath"
-	"strings"
+	"sync"
 
 	"github.com/rios/worker/pkg/api"
+	"github.com/rios/worker/pkg/cache"
+	"github.com/rios/worker/pkg/container"
+	"github.com/rios/worker/pkg/logging"
+	"github.com/rios/worker/pkg/output"
 )
 
 // Executor handles job execution
 type Executor struct {
 	WorkDir string
+	Runtime container.Runtime
+
+	// Pre-flight settings
+	MinFreeDiskBytes int64                     // kept free on top of a job's inputs (default DefaultMinFreeDiskBytes)
+	FreeVRAM         func() ([]float64, error) // free MiB per GPU, nil to skip the VRAM check
+
+	// Content-addressed input cache, nil to download every input per job
+	Cache *cache.Cache
+
+	// Download settings
+	MaxDownloadBytes  int64 // caps inputs without a declared size (default DefaultMaxDownloadBytes)
+	ParallelDownloads int   // inputs fetched at once (default DefaultParallelDownloads)
+	DownloadSegments  int   // ranges of a large input fetched at once (default DefaultDownloadSegments)
+	MaxDownloadRate   int64 // bytes per second across all downloads, 0 for no limit
+
+	// OnPhase is called when a job enters the next phase, nil to not report them
+	OnPhase func(jobID, phase string)
+
+	limiterOnce     sync.Once
+	limiter         *rateLimiter
+	downloadLocksMu sync.Mutex
+	downloadLocks   map[string]*downloadLock // by partial file path
 }
 
 // NewExecutor creates a new executor
 func NewExecutor(workDir string) *Executor {
 	return &Executor{
 		WorkDir: workDir,
+		Runtime: container.NewDocker(),
 	}
 }
 
-// Execute executes a job
-func (e *Executor) Execute(job *api.Job) (outputURL string, err error) {
+// Execute executes a job and returns the URL and manifest of its validated
+// output. Cancelling ctx stops the download or kills the running container.
+// The job's network usage is added to traffic, and its inputs, image,
+// exit code and outputs to receipt, as they become known, so both are
+// complete as far as the job got even when it fails.
+func (e *Executor) Execute(ctx context.Context, job *api.Job, traffic *api.Traffic, receipt *api.Receipt) (outputURL string, manifest *api.ResultManifest, err error) {
+	ctx = withTraffic(ctx, traffic)
+	receipt.ExitCode = -1
+
+	if err := output.CheckReference(job.Payload.Verification); err != nil {
+		return "", nil, fmt.Errorf("invalid verification reference: %w", err)
+	}
+
 	// Create temporary work directory for this job
 	jobWorkDir := filepath.Join(e.WorkDir, job.JobID)
 	if err := os.MkdirAll(jobWorkDir, 0755); err != nil {
-		return "", fmt.Errorf("failed to create job work directory: %w", err)
+		return "", nil, fmt.Errorf("failed to create job work directory: %w", err)
 	}
 	defer func() {
 		// Clean up on error
@@ -40,124 +77,138 @@ func (e *Executor) Execute(job *api.Job) (outputURL string, err error) {
 
 	inputDir := filepath.Join(jobWorkDir, "input")
 	outputDir := filepath.Join(jobWorkDir, "output")
-	
+
 	if err := os.MkdirAll(inputDir, 0755); err != nil {
-		return "", fmt.Errorf("failed to create input directory: %w", err)
+		return "", nil, fmt.Errorf("failed to create input directory: %w", err)
 	}
 	if err := os.MkdirAll(outputDir, 0755); err != nil {
-		return "", fmt.Errorf("failed to create output directory: %w", err)
+		return "", nil, fmt.Errorf("failed to create output directory: %w", err)
 	}
 
 	// Download input files
-	fmt.Println("   📥 Downloading input files...")
-	if job.Payload.InputS3URL != "" {
-		inputFile := filepath.Join(inputDir, "workflow.json")
-		if err := e.downloadFile(job.Payload.InputS3URL, inputFile); err != nil {
-			return "", fmt.Errorf("failed to download input: %w", err)
-		}
+	log := logging.FromContext(ctx)
+	log.Info("Downloading input files...", logging.Icon("📥"))
+	e.setPhase(job, PhaseDownloading)
+	stageDir := filepath.Join(jobWorkDir, "downloads")
+	if err := os.MkdirAll(stageDir, 0755); err != nil {
+		return "", nil, fmt.Errorf("failed to create download directory: %w", err)
+	}
+	mounts, inputs, err := e.fetchInputs(ctx, inputManifest(job.Payload), inputDir, stageDir)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to download inputs: %w", err)
+	}
+	defer e.releaseMounts(mounts)
+	receipt.Inputs = inputs
+
+	// Run the job's container
+	log.Info("Running container...", logging.Icon("🐳"), "runtime", e.Runtime.Name())
+	e.setPhase(job, PhaseRunning)
+	err = e.runContainer(ctx, job, inputDir, outputDir, mounts)
+	var exitErr *container.ExitError
+	switch {
+	case err == nil:
+		receipt.ExitCode = 0
+	case errors.As(err, &exitErr):
+		receipt.ExitCode = exitErr.Code
+	}
+	// Running the container pulled the image, so its digest is what actually ran
+	if digest, digestErr := e.Runtime.ImageDigest(job.Payload.DockerImage); digestErr == nil {
+		receipt.ImageDigest = digest
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("%s execution failed: %w", e.Runtime.Name(), err)
 	}
 
-	// Execute Docker command
-	fmt.Println("   🐳 Running Docker container...")
-	if err := e.runDocker(job, inputDir, outputDir); err != nil {
-		return "", fmt.Errorf("docker execution failed: %w", err)
+	// Check the outputs before anything is submitted
+	log.Info("Validating output files...", logging.Icon("🔍"))
+	e.setPhase(job, PhaseValidating)
+	manifest, err = output.Validate(job.TaskType, outputDir, job.Payload.ExpectedOutput)
+	if err != nil {
+		return "", nil, fmt.Errorf("output validation failed: %w", err)
+	}
+	for _, file := range manifest.Files {
+		receipt.Outputs = append(receipt.Outputs, api.ReceiptFile{Path: file.Path, SizeBytes: file.SizeBytes, SHA256: file.SHA256})
 	}
 
 	// Upload output files
-	fmt.Println("   📤 Uploading output files...")
-	outputURL, err = e.uploadOutput(outputDir, job.Payload.OutputS3Path)
+	log.Info("Uploading output files...", logging.Icon("📤"))
+	e.setPhase(job, PhaseUploading)
+	outputURL, err = e.uploadOutput(ctx, manifest, job.Payload.OutputS3Path)
 	if err != nil {
-		return "", fmt.Errorf("failed to upload output: %w", err)
+		return "", nil, fmt.Errorf("failed to upload output: %w", err)
 	}
 
 	// Clean up work directory
 	os.RemoveAll(jobWorkDir)
 
-	return outputURL, nil
+	return outputURL, manifest, nil
 }
 
-// downloadFile downloads a file from URL to local path
-func (e *Executor) downloadFile(url, filepath string) error {
-	// For demo purposes, we'll just create a dummy file
-	// In production, this should download from S3
-	
-	// If URL starts with http/https, actually download
-	if strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://") {
-		resp, err := http.Get(url)
-		if err != nil {
-			return err
-		}
-		defer resp.Body.Close()
-
-		out, err := os.Create(filepath)
-		if err != nil {
-			return err
-		}
-		defer out.Close()
-
-		_, err = io.Copy(out, resp.Body)
-		return err
+func (e *Executor) setPhase(job *api.Job, phase string) {
+	if e.OnPhase != nil {
+		e.OnPhase(job.JobID, phase)
 	}
-
-	// For S3 URLs, create a placeholder
-	// In production, use AWS SDK to download from S3
-	return os.WriteFile(filepath, []byte("{}"), 0644)
 }
 
-// runDocker runs the Docker container for the job
-func (e *Executor) runDocker(job *api.Job, inputDir, outputDir string) error {
-	dockerImage := job.Payload.DockerImage
-	
-	// Build docker command
-	args := []string{
-		"run",
-		"--rm",
-		"--gpus", "all",
-		"-v", fmt.Sprintf("%s:/workspace/input", inputDir),
-		"-v", fmt.Sprintf("%s:/workspace/output", outputDir),
-		dockerImage,
+// runContainer runs the job's container until it exits
+func (e *Executor) runContainer(ctx context.Context, job *api.Job, inputDir, outputDir string, mounts []inputMount) error {
+	// The container's output is logged line by line as part of the job's log
+	log := logging.FromContext(ctx)
+	stdout, stderr := logging.Writer(log, "stdout"), logging.Writer(log, "stderr")
+	defer stdout.Close()
+	defer stderr.Close()
+
+	spec := &container.Spec{
+		Name:  ContainerName(job.JobID),
+		Image: job.Payload.DockerImage,
+		Env:   determinismEnv(job.Payload),
+		Mounts: []container.Mount{
+			{Source: inputDir, Target: container.InputPath},
+			{Source: outputDir, Target: container.OutputPath},
+		},
+		Labels: map[string]string{
+			container.LabelJobID:    job.JobID,
+			container.LabelTaskType: job.TaskType,
+		},
+		GPUs:   true,
+		Stdout: stdout,
+		Stderr: stderr,
+	}
+	// Cached inputs are shared between jobs, so containers get them read-only
+	for _, m := range mounts {
+		spec.Mounts = append(spec.Mounts, container.Mount{Source: m.hostPath, Target: m.containerPath, ReadOnly: true})
 	}
 
 	// Add task-specific arguments
-	if job.TaskType == "comfyui" {
-		args = append(args,
-			"--input", "/workspace/input/workflow.json",
-			"--output", "/workspace/output/",
-		)
-		
-		if job.Payload.Prompt != "" {
-			args = append(args, "--prompt", job.Payload.Prompt)
-		}
-	} else if job.TaskType == "training" {
-		args = append(args,
-			"--dataset", "/workspace/input/",
-			"--output", "/workspace/output/",
-		)
+	if handler, ok := taskHandlers[job.TaskType]; ok {
+		spec.Args = handler(job)
 	}
 
-	cmd := exec.Command("docker", args...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	stopWatching := make(chan struct{})
+	defer close(stopWatching)
+	go e.watchContainerTraffic(ctx, spec.Name, stopWatching)
 
-	return cmd.Run()
+	return e.Runtime.Run(ctx, spec)
 }
 
-// uploadOutput uploads output files to S3
-func (e *Executor) uploadOutput(outputDir, s3Path string) (string, error) {
-	// For demo purposes, just check if output files exist
-	// In production, use AWS SDK to upload to S3
-	
-	files, err := os.ReadDir(outputDir)
-	if err != nil {
-		return "", err
+// ContainerName returns the name of the container running jobID
+func ContainerName(jobID string) string {
+	// Docker names allow [a-zA-Z0-9][a-zA-Z0-9_.-]*
+	name := []byte(jobID)
+	for i, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '.' || c == '-') {
+			name[i] = '_'
+		}
 	}
+	return "rios-job-" + string(name)
+}
 
-	if len(files) == 0 {
-		return "", fmt.Errorf("no output files generated")
-	}
+// uploadOutput uploads the files of the manifest to S3
+func (e *Executor) uploadOutput(ctx context.Context, manifest *api.ResultManifest, s3Path string) (string, error) {
+	// For demo purposes, nothing is uploaded yet, so no upload traffic is counted
+	// In production, use AWS SDK to upload to S3
 
 	// Return a mock S3 URL
 	// In production, this should be the actual S3 URL after upload
 	return s3Path + "result.mp4", nil
 }
-
@@ -0,0 +1,146 @@
+package worker
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// errExtractLimit is returned when an archive unpacks to more than its limit
+var errExtractLimit = errors.New("archive exceeds the extraction size limit")
+
+// extractArchive unpacks archive into destDir. Entries that would land
+// outside destDir, links and special files are rejected, and extraction stops
+// once more than maxBytes have been written.
+func extractArchive(archive, format, destDir string, maxBytes int64) error {
+	if err := os.MkdirAll(destDir, 0755); err != nil {
+		return err
+	}
+	remaining := maxBytes
+
+	switch format {
+	case ExtractZip:
+		return extractZip(archive, destDir, &remaining)
+	case ExtractTar, ExtractTarGz:
+		f, err := os.Open(archive)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		var r io.Reader = f
+		if format == ExtractTarGz {
+			gz, err := gzip.NewReader(f)
+			if err != nil {
+				return fmt.Errorf("failed to read gzip archive: %w", err)
+			}
+			defer gz.Close()
+			r = gz
+		}
+		return extractTar(r, destDir, &remaining)
+	}
+	return fmt.Errorf("unsupported archive format %q", format)
+}
+
+func extractTar(r io.Reader, destDir string, remaining *int64) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read tar archive: %w", err)
+		}
+
+		target, err := entryPath(destDir, hdr.Name)
+		if err != nil {
+			return err
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := writeEntry(target, tr, hdr.FileInfo().Mode(), remaining); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("archive entry %s: unsupported type %q", hdr.Name, hdr.Typeflag)
+		}
+	}
+}
+
+func extractZip(archive, destDir string, remaining *int64) error {
+	zr, err := zip.OpenReader(archive)
+	if err != nil {
+		return fmt.Errorf("failed to read zip archive: %w", err)
+	}
+	defer zr.Close()
+
+	for _, f := range zr.File {
+		target, err := entryPath(destDir, f.Name)
+		if err != nil {
+			return err
+		}
+		mode := f.Mode()
+		switch {
+		case mode.IsDir():
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		case mode.IsRegular():
+			rc, err := f.Open()
+			if err != nil {
+				return fmt.Errorf("archive entry %s: %w", f.Name, err)
+			}
+			err = writeEntry(target, rc, mode, remaining)
+			rc.Close()
+			if err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("archive entry %s: unsupported file mode %s", f.Name, mode)
+		}
+	}
+	return nil
+}
+
+// entryPath resolves an archive entry name inside destDir, refusing absolute
+// paths and "../" components
+func entryPath(destDir, name string) (string, error) {
+	rel := filepath.Clean(filepath.FromSlash(name))
+	if !filepath.IsLocal(rel) {
+		return "", fmt.Errorf("archive entry %s escapes the input directory", name)
+	}
+	return filepath.Join(destDir, rel), nil
+}
+
+// writeEntry writes one file of an archive, charging its size to remaining
+func writeEntry(target string, r io.Reader, mode os.FileMode, remaining *int64) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode.Perm()|0600)
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(out, io.LimitReader(r, *remaining+1))
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to extract %s: %w", filepath.Base(target), err)
+	}
+	*remaining -= n
+	if *remaining < 0 {
+		return errExtractLimit
+	}
+	return nil
+}
@@ -0,0 +1,253 @@
+package worker
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEntryPath(t *testing.T) {
+	dest := filepath.Join("work", "input", "loras")
+	tests := []struct {
+		name string
+		want string // relative to dest, empty when the entry is refused
+	}{
+		{name: "style.safetensors", want: "style.safetensors"},
+		{name: "sub/style.safetensors", want: filepath.Join("sub", "style.safetensors")},
+		{name: "sub/../style.safetensors", want: "style.safetensors"},
+		{name: "./sub//style.safetensors", want: filepath.Join("sub", "style.safetensors")},
+		{name: "../style.safetensors"},
+		{name: "sub/../../style.safetensors"},
+		{name: ".."},
+		{name: "/etc/passwd"},
+	}
+	for _, tt := range tests {
+		got, err := entryPath(dest, tt.name)
+		switch {
+		case tt.want == "" && err == nil:
+			t.Errorf("entryPath(%q) = %s, want an error", tt.name, got)
+		case tt.want != "" && err != nil:
+			t.Errorf("entryPath(%q): %v", tt.name, err)
+		case tt.want != "" && got != filepath.Join(dest, tt.want):
+			t.Errorf("entryPath(%q) = %s, want %s", tt.name, got, filepath.Join(dest, tt.want))
+		}
+	}
+}
+
+// archiveEntry is one entry of a test archive
+type archiveEntry struct {
+	name string
+	body string
+	kind string // "" for a regular file, "dir", "symlink", "hardlink" or "device"
+}
+
+func writeTestTar(t *testing.T, path string, gz bool, entries []archiveEntry) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var w io.Writer = f
+	if gz {
+		zw := gzip.NewWriter(f)
+		defer zw.Close()
+		w = zw
+	}
+	tw := tar.NewWriter(w)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Mode: 0644, Typeflag: tar.TypeReg, Size: int64(len(e.body))}
+		switch e.kind {
+		case "dir":
+			hdr = &tar.Header{Name: e.name, Mode: 0755, Typeflag: tar.TypeDir}
+		case "symlink":
+			hdr = &tar.Header{Name: e.name, Mode: 0777, Typeflag: tar.TypeSymlink, Linkname: e.body}
+		case "hardlink":
+			hdr = &tar.Header{Name: e.name, Mode: 0644, Typeflag: tar.TypeLink, Linkname: e.body}
+		case "device":
+			hdr = &tar.Header{Name: e.name, Mode: 0666, Typeflag: tar.TypeChar, Devmajor: 1, Devminor: 3}
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			if _, err := io.WriteString(tw, e.body); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeTestZip(t *testing.T, path string, entries []archiveEntry) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	zw := zip.NewWriter(f)
+	for _, e := range entries {
+		hdr := &zip.FileHeader{Name: e.name, Method: zip.Deflate}
+		switch e.kind {
+		case "":
+			hdr.SetMode(0644)
+		case "dir":
+			hdr.Name = strings.TrimSuffix(e.name, "/") + "/"
+			hdr.SetMode(os.ModeDir | 0755)
+		case "symlink":
+			hdr.SetMode(os.ModeSymlink | 0777)
+		case "device":
+			hdr.SetMode(os.ModeDevice | os.ModeCharDevice | 0666)
+		default:
+			t.Fatalf("zip archives have no %s entries", e.kind)
+		}
+		w, err := zw.CreateHeader(hdr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(w, e.body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExtractArchive(t *testing.T) {
+	tests := []struct {
+		name      string
+		entries   []archiveEntry
+		maxBytes  int64
+		tarOnly   bool
+		want      map[string]string // extracted files and their contents
+		wantErr   string
+		wantLimit bool // fails with errExtractLimit
+	}{
+		{
+			name: "files and directories",
+			entries: []archiveEntry{
+				{name: "loras", kind: "dir"},
+				{name: "loras/style.safetensors", body: "style"},
+				{name: "vae/ae.safetensors", body: "vae"},
+			},
+			want: map[string]string{"loras/style.safetensors": "style", "vae/ae.safetensors": "vae"},
+		},
+		{
+			name:    "parent directory entry",
+			entries: []archiveEntry{{name: "ok.txt", body: "ok"}, {name: "../escape.txt", body: "x"}},
+			wantErr: "escapes the input directory",
+		},
+		{
+			name:    "parent directory inside the name",
+			entries: []archiveEntry{{name: "sub/../../escape.txt", body: "x"}},
+			wantErr: "escapes the input directory",
+		},
+		{
+			name:    "absolute path",
+			entries: []archiveEntry{{name: "/tmp/escape.txt", body: "x"}},
+			wantErr: "escapes the input directory",
+		},
+		{
+			name:    "symlink",
+			entries: []archiveEntry{{name: "link", body: "/etc/passwd", kind: "symlink"}},
+			wantErr: "unsupported",
+		},
+		{
+			name:    "hardlink",
+			entries: []archiveEntry{{name: "link", body: "/etc/passwd", kind: "hardlink"}},
+			tarOnly: true,
+			wantErr: "unsupported",
+		},
+		{
+			name:    "device file",
+			entries: []archiveEntry{{name: "null", kind: "device"}},
+			wantErr: "unsupported",
+		},
+		{
+			name:     "at the size limit",
+			entries:  []archiveEntry{{name: "a.bin", body: strings.Repeat("a", 32)}, {name: "b.bin", body: strings.Repeat("b", 32)}},
+			maxBytes: 64,
+			want:     map[string]string{"a.bin": strings.Repeat("a", 32), "b.bin": strings.Repeat("b", 32)},
+		},
+		{
+			name:      "over the size limit",
+			entries:   []archiveEntry{{name: "a.bin", body: strings.Repeat("a", 32)}, {name: "b.bin", body: strings.Repeat("b", 33)}},
+			maxBytes:  64,
+			wantLimit: true,
+		},
+	}
+	for _, format := range []string{ExtractTar, ExtractTarGz, ExtractZip} {
+		for _, tt := range tests {
+			if tt.tarOnly && format == ExtractZip {
+				continue
+			}
+			t.Run(format+"/"+tt.name, func(t *testing.T) {
+				dir := t.TempDir()
+				archive := filepath.Join(dir, "input."+format)
+				if format == ExtractZip {
+					writeTestZip(t, archive, tt.entries)
+				} else {
+					writeTestTar(t, archive, format == ExtractTarGz, tt.entries)
+				}
+				maxBytes := tt.maxBytes
+				if maxBytes == 0 {
+					maxBytes = 1 << 20
+				}
+
+				dest := filepath.Join(dir, "input", "models")
+				err := extractArchive(archive, format, dest, maxBytes)
+				switch {
+				case tt.wantLimit:
+					if !errors.Is(err, errExtractLimit) {
+						t.Fatalf("err = %v, want %v", err, errExtractLimit)
+					}
+				case tt.wantErr != "":
+					if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+						t.Fatalf("err = %v, want %q", err, tt.wantErr)
+					}
+				case err != nil:
+					t.Fatal(err)
+				}
+
+				for name, body := range tt.want {
+					got, err := os.ReadFile(filepath.Join(dest, filepath.FromSlash(name)))
+					if err != nil {
+						t.Fatal(err)
+					}
+					if string(got) != body {
+						t.Errorf("%s = %q, want %q", name, got, body)
+					}
+				}
+				// Nothing may land next to the input directory
+				for _, name := range []string{"escape.txt", filepath.Join("input", "escape.txt")} {
+					if _, err := os.Lstat(filepath.Join(dir, name)); err == nil {
+						t.Errorf("%s was written outside the input directory", name)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestExtractArchiveUnsupportedFormat(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "input.rar")
+	if err := os.WriteFile(archive, []byte("Rar!"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := extractArchive(archive, "rar", filepath.Join(dir, "out"), 1<<20); err == nil {
+		t.Fatal("extracted a rar archive, want an error")
+	}
+}
@@ -0,0 +1,258 @@
+package worker
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/rios/worker/pkg/api"
+	"github.com/rios/worker/pkg/container"
+)
+
+// DefaultParallelDownloads is how many inputs are downloaded at once
+const DefaultParallelDownloads = 4
+
+// Archive formats accepted in JobInput.Extract
+const (
+	ExtractTar   = "tar"
+	ExtractTarGz = "tar.gz"
+	ExtractZip   = "zip"
+)
+
+// inputManifest returns the job's inputs, with the legacy single-file fields
+// turned into manifest entries
+func inputManifest(payload *api.JobPayload) []api.JobInput {
+	var inputs []api.JobInput
+	if payload.InputS3URL != "" {
+		in := api.JobInput{Name: "workflow", URL: payload.InputS3URL, Path: "workflow.json"}
+		if payload.InputDigest != nil {
+			in.InputDigest = *payload.InputDigest
+		}
+		inputs = append(inputs, in)
+	}
+	if payload.InitVideoURL != "" {
+		in := api.JobInput{Name: "init_video", URL: payload.InitVideoURL, Path: "init_video" + urlExt(payload.InitVideoURL)}
+		if payload.InitVideoDigest != nil {
+			in.InputDigest = *payload.InitVideoDigest
+		}
+		inputs = append(inputs, in)
+	}
+	return append(inputs, payload.Inputs...)
+}
+
+// urlExt returns the extension of the file a URL points to, without the
+// query string of e.g. a pre-signed URL
+func urlExt(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return path.Ext(u.Path)
+}
+
+// inputBytes returns the declared size of the job's inputs, summing the
+// manifest when the payload has no total
+func inputBytes(payload *api.JobPayload) int64 {
+	if payload.InputSizeBytes > 0 {
+		return payload.InputSizeBytes
+	}
+	var total int64
+	for _, in := range inputManifest(payload) {
+		total += in.SizeBytes
+	}
+	return total
+}
+
+// validateInputs checks that every input has a URL, a known archive format
+// and a destination inside the input directory that no other input uses
+func validateInputs(inputs []api.JobInput) error {
+	seen := make(map[string]string)
+	for i, in := range inputs {
+		name := inputName(in, i)
+		if in.URL == "" {
+			return fmt.Errorf("input %s has no URL", name)
+		}
+		switch in.Extract {
+		case "", ExtractTar, ExtractTarGz, ExtractZip:
+		default:
+			return fmt.Errorf("input %s: unsupported archive format %q", name, in.Extract)
+		}
+		dest := filepath.Clean(filepath.FromSlash(in.Path))
+		if in.Path == "" || !filepath.IsLocal(dest) {
+			return fmt.Errorf("input %s: path %q must be relative to the input directory", name, in.Path)
+		}
+		if in.SHA256 != "" && !isSHA256(in.SHA256) {
+			return fmt.Errorf("input %s: invalid sha256 digest %q", name, in.SHA256)
+		}
+		if other, ok := seen[dest]; ok {
+			return fmt.Errorf("inputs %s and %s both write to %s", other, name, in.Path)
+		}
+		seen[dest] = name
+	}
+	return nil
+}
+
+func isSHA256(s string) bool {
+	b, err := hex.DecodeString(s)
+	return err == nil && len(b) == sha256.Size
+}
+
+func inputName(in api.JobInput, i int) string {
+	if in.Name != "" {
+		return fmt.Sprintf("%q", in.Name)
+	}
+	return fmt.Sprintf("#%d", i+1)
+}
+
+// inputMount is a cached input bind-mounted read-only into the container
+type inputMount struct {
+	digest        string
+	hostPath      string
+	containerPath string
+}
+
+// fetchInputs downloads all inputs into inputDir, at most ParallelDownloads
+// at a time. Archives are staged in stageDir and unpacked into their path.
+// Inputs with a digest go through the cache when there is one; files are
+// then returned as mounts instead of being copied into inputDir, and stay
+// pinned in the cache until releaseMounts. The first failure cancels the
+// remaining downloads.
+func (e *Executor) fetchInputs(ctx context.Context, inputs []api.JobInput, inputDir, stageDir string) ([]inputMount, []api.ReceiptFile, error) {
+	if err := validateInputs(inputs); err != nil {
+		return nil, nil, err
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	limit := e.ParallelDownloads
+	if limit <= 0 {
+		limit = DefaultParallelDownloads
+	}
+	sem := make(chan struct{}, limit)
+
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		mounts []inputMount
+		files  = make([]api.ReceiptFile, len(inputs))
+	)
+	for i, in := range inputs {
+		wg.Add(1)
+		go func(i int, in api.JobInput) {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-ctx.Done():
+				return
+			}
+			mount, digest, err := e.fetchInput(ctx, in, inputDir, stageDir, i)
+			if err != nil {
+				cancel(fmt.Errorf("input %s: %w", inputName(in, i), err))
+				return
+			}
+			files[i] = api.ReceiptFile{Name: in.Name, Path: in.Path, SizeBytes: digest.SizeBytes, SHA256: digest.SHA256}
+			if mount != nil {
+				mu.Lock()
+				mounts = append(mounts, *mount)
+				mu.Unlock()
+			}
+		}(i, in)
+	}
+	wg.Wait()
+
+	if err := context.Cause(ctx); err != nil {
+		e.releaseMounts(mounts)
+		return nil, nil, err
+	}
+	return mounts, files, nil
+}
+
+// releaseMounts unpins the cached files of a finished job
+func (e *Executor) releaseMounts(mounts []inputMount) {
+	for _, m := range mounts {
+		e.Cache.Unpin(m.digest)
+	}
+}
+
+// fetchInput downloads a single input and unpacks it if it is an archive.
+// The digest is that of the download, before unpacking.
+func (e *Executor) fetchInput(ctx context.Context, in api.JobInput, inputDir, stageDir string, i int) (*inputMount, *api.InputDigest, error) {
+	dest := filepath.Join(inputDir, filepath.FromSlash(in.Path))
+
+	if e.Cache != nil && in.SHA256 != "" {
+		cached, digest, err := e.fetchCached(ctx, in)
+		if err != nil {
+			return nil, nil, err
+		}
+		if in.Extract != "" {
+			defer e.Cache.Unpin(in.SHA256)
+			return nil, digest, extractArchive(cached, in.Extract, dest, e.maxDownloadBytes())
+		}
+		// The container runtime mounts over this placeholder
+		if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			e.Cache.Unpin(in.SHA256)
+			return nil, nil, err
+		}
+		if err := os.WriteFile(dest, nil, 0644); err != nil {
+			e.Cache.Unpin(in.SHA256)
+			return nil, nil, err
+		}
+		return &inputMount{
+			digest:        in.SHA256,
+			hostPath:      cached,
+			containerPath: path.Join(container.InputPath, filepath.ToSlash(in.Path)),
+		}, digest, nil
+	}
+
+	if in.Extract == "" {
+		if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			return nil, nil, err
+		}
+		digest, err := e.downloadFile(ctx, in.URL, dest, &in.InputDigest)
+		return nil, digest, err
+	}
+
+	archive := filepath.Join(stageDir, fmt.Sprintf("input-%d.%s", i, in.Extract))
+	digest, err := e.downloadFile(ctx, in.URL, archive, &in.InputDigest)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer os.Remove(archive)
+
+	return nil, digest, extractArchive(archive, in.Extract, dest, e.maxDownloadBytes())
+}
+
+// fetchCached returns the cached copy of in, downloading it into the cache
+// on a miss. The file is pinned until the caller unpins it.
+func (e *Executor) fetchCached(ctx context.Context, in api.JobInput) (string, *api.InputDigest, error) {
+	if cached, ok := e.Cache.Get(in.SHA256); ok {
+		info, err := os.Stat(cached)
+		if err != nil {
+			e.Cache.Unpin(in.SHA256)
+			return "", nil, err
+		}
+		// The cache is addressed by content, so the digest is the key
+		return cached, &api.InputDigest{SizeBytes: info.Size(), SHA256: strings.ToLower(in.SHA256)}, nil
+	}
+
+	// Named by digest so an interrupted download resumes on the next attempt
+	tmp := filepath.Join(e.Cache.TempDir(), strings.ToLower(in.SHA256))
+	digest, err := e.downloadFile(ctx, in.URL, tmp, &in.InputDigest)
+	if err != nil {
+		return "", nil, err
+	}
+	cached, err := e.Cache.Add(in.SHA256, tmp, digest.SHA256)
+	if err != nil {
+		return "", nil, err
+	}
+	return cached, digest, nil
+}
@@ -0,0 +1,215 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rios/worker/pkg/api"
+)
+
+func TestValidateInputs(t *testing.T) {
+	sum := strings.Repeat("ab", sha256.Size)
+	tests := []struct {
+		name    string
+		inputs  []api.JobInput
+		wantErr string
+	}{
+		{name: "no inputs"},
+		{
+			name: "files and archives",
+			inputs: []api.JobInput{
+				{Name: "workflow", URL: "https://example.com/w.json", Path: "workflow.json"},
+				{Name: "model", URL: "https://example.com/m", Path: "models/m.safetensors", InputDigest: api.InputDigest{SHA256: sum}},
+				{Name: "loras", URL: "https://example.com/l.zip", Path: "loras", Extract: ExtractZip},
+			},
+		},
+		{
+			name:    "no URL",
+			inputs:  []api.JobInput{{Name: "workflow", Path: "workflow.json"}},
+			wantErr: `input "workflow" has no URL`,
+		},
+		{
+			name:    "unknown archive format",
+			inputs:  []api.JobInput{{URL: "https://example.com/l.rar", Path: "loras", Extract: "rar"}},
+			wantErr: `input #1: unsupported archive format "rar"`,
+		},
+		{
+			name:    "no path",
+			inputs:  []api.JobInput{{Name: "model", URL: "https://example.com/m"}},
+			wantErr: "must be relative to the input directory",
+		},
+		{
+			name:    "absolute path",
+			inputs:  []api.JobInput{{Name: "model", URL: "https://example.com/m", Path: "/etc/passwd"}},
+			wantErr: "must be relative to the input directory",
+		},
+		{
+			name:    "parent directory",
+			inputs:  []api.JobInput{{Name: "model", URL: "https://example.com/m", Path: "models/../../m"}},
+			wantErr: "must be relative to the input directory",
+		},
+		{
+			name:    "invalid digest",
+			inputs:  []api.JobInput{{Name: "model", URL: "https://example.com/m", Path: "m", InputDigest: api.InputDigest{SHA256: "abc"}}},
+			wantErr: `invalid sha256 digest "abc"`,
+		},
+		{
+			name: "same destination",
+			inputs: []api.JobInput{
+				{Name: "a", URL: "https://example.com/a", Path: "models/m.safetensors"},
+				{Name: "b", URL: "https://example.com/b", Path: "models/./m.safetensors"},
+			},
+			wantErr: `inputs "a" and "b" both write to`,
+		},
+	}
+	for _, tt := range tests {
+		err := validateInputs(tt.inputs)
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("%s: %v", tt.name, err)
+		case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+			t.Errorf("%s: err = %v, want %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestFetchInputs(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "loras.tar")
+	writeTestTar(t, archive, false, []archiveEntry{
+		{name: "style.safetensors", body: "style"},
+		{name: "extra/detail.safetensors", body: "detail"},
+	})
+	tarData, err := os.ReadFile(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string][]byte{
+		"/workflow.json": []byte(`{"nodes":[]}`),
+		"/model.bin":     bytes.Repeat([]byte("m"), 4096),
+		"/loras.tar":     tarData,
+	}
+	digest := func(name string) api.InputDigest {
+		sum := sha256.Sum256(files[name])
+		return api.InputDigest{SizeBytes: int64(len(files[name])), SHA256: hex.EncodeToString(sum[:])}
+	}
+
+	var requests int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&requests, 1)
+		data, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeContent(w, r, r.URL.Path, time.Time{}, bytes.NewReader(data))
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name         string
+		inputs       []api.JobInput
+		want         map[string]string // files in the input directory
+		wantErr      string
+		wantRequests bool
+	}{
+		{
+			name: "files and an archive",
+			inputs: []api.JobInput{
+				{Name: "workflow", URL: srv.URL + "/workflow.json", Path: "workflow.json"},
+				{Name: "model", URL: srv.URL + "/model.bin", Path: "models/model.bin", InputDigest: digest("/model.bin")},
+				{Name: "loras", URL: srv.URL + "/loras.tar", Path: "models/loras", Extract: ExtractTar, InputDigest: digest("/loras.tar")},
+			},
+			want: map[string]string{
+				"workflow.json":                         `{"nodes":[]}`,
+				"models/model.bin":                      strings.Repeat("m", 4096),
+				"models/loras/style.safetensors":        "style",
+				"models/loras/extra/detail.safetensors": "detail",
+			},
+			wantRequests: true,
+		},
+		{
+			name: "missing input",
+			inputs: []api.JobInput{
+				{Name: "workflow", URL: srv.URL + "/workflow.json", Path: "workflow.json"},
+				{Name: "model", URL: srv.URL + "/missing.bin", Path: "models/model.bin"},
+			},
+			wantErr:      `input "model"`,
+			wantRequests: true,
+		},
+		{
+			name: "digest mismatch",
+			inputs: []api.JobInput{
+				{Name: "model", URL: srv.URL + "/model.bin", Path: "model.bin",
+					InputDigest: api.InputDigest{SHA256: digest("/workflow.json").SHA256}},
+			},
+			wantErr:      "sha256 mismatch",
+			wantRequests: true,
+		},
+		{
+			name: "invalid manifest is not downloaded",
+			inputs: []api.JobInput{
+				{Name: "workflow", URL: srv.URL + "/workflow.json", Path: "workflow.json"},
+				{Name: "model", URL: srv.URL + "/model.bin", Path: "../model.bin"},
+			},
+			wantErr: "must be relative to the input directory",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			atomic.StoreInt64(&requests, 0)
+			inputDir, stageDir := filepath.Join(t.TempDir(), "input"), t.TempDir()
+			e := &Executor{ParallelDownloads: 2}
+
+			mounts, receipt, err := e.fetchInputs(context.Background(), tt.inputs, inputDir, stageDir)
+			if got := atomic.LoadInt64(&requests) > 0; got != tt.wantRequests {
+				t.Errorf("made requests = %v, want %v", got, tt.wantRequests)
+			}
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(mounts) != 0 {
+				t.Errorf("got %d mounts without a cache", len(mounts))
+			}
+
+			for name, body := range tt.want {
+				got, err := os.ReadFile(filepath.Join(inputDir, filepath.FromSlash(name)))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(got) != body {
+					t.Errorf("%s = %q, want %q", name, got, body)
+				}
+			}
+			if len(receipt) != len(tt.inputs) {
+				t.Fatalf("got %d receipt files, want %d", len(receipt), len(tt.inputs))
+			}
+			for i, in := range tt.inputs {
+				want := digest(strings.TrimPrefix(in.URL, srv.URL))
+				if f := receipt[i]; f.Name != in.Name || f.Path != in.Path || f.SizeBytes != want.SizeBytes || f.SHA256 != want.SHA256 {
+					t.Errorf("receipt file %d = %+v, want %s at %s with %+v", i, f, in.Name, in.Path, want)
+				}
+			}
+			// Archives are removed from the staging directory once unpacked
+			if staged, _ := os.ReadDir(stageDir); len(staged) != 0 {
+				t.Errorf("%d files left in the staging directory", len(staged))
+			}
+		})
+	}
+}
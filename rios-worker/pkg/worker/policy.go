@@ -0,0 +1,125 @@
+package worker
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/rios/worker/pkg/api"
+	"github.com/rios/worker/pkg/config"
+)
+
+// Rejection codes reported to the orchestrator when declining a job
+const (
+	RejectTaskType      = "task_type_not_accepted"
+	RejectRewardTooLow  = "reward_below_minimum"
+	RejectVRAM          = "insufficient_vram"
+	RejectImageTooLarge = "image_too_large"
+	RejectImageDenied   = "image_not_allowed"
+	RejectInputTooLarge = "input_too_large"
+)
+
+const bytesPerGB = 1 << 30
+
+// Rejection is a structured reason for declining a job before running it
+type Rejection struct {
+	Code   string `json:"code"`
+	Reason string `json:"reason"`
+}
+
+func (r *Rejection) Error() string {
+	return r.Reason
+}
+
+// Policy decides which jobs this worker accepts
+type Policy struct {
+	cfg config.PolicyConfig
+}
+
+// NewPolicy creates a policy; an unset VRAM limit defaults to localVRAMGB
+func NewPolicy(cfg config.PolicyConfig, localVRAMGB int) *Policy {
+	if cfg.MaxVRAMGB == 0 || (localVRAMGB > 0 && cfg.MaxVRAMGB > localVRAMGB) {
+		cfg.MaxVRAMGB = localVRAMGB
+	}
+	return &Policy{cfg: cfg}
+}
+
+// Hints returns the policy as capability hints for get-job
+func (p *Policy) Hints() *api.JobHints {
+	hints := &api.JobHints{
+		MinReward:         p.cfg.MinReward,
+		MaxVRAMGB:         p.cfg.MaxVRAMGB,
+		MaxImageSizeBytes: int64(p.cfg.MaxImageSizeGB * bytesPerGB),
+		MaxInputSizeBytes: int64(p.cfg.MaxInputSizeGB * bytesPerGB),
+	}
+	for _, taskType := range p.cfg.TaskTypes {
+		if !containsFold(p.cfg.ExcludeTaskTypes, taskType) {
+			hints.TaskTypes = append(hints.TaskTypes, taskType)
+		}
+	}
+	return hints
+}
+
+// Evaluate returns why job should be declined, or nil if it is acceptable
+func (p *Policy) Evaluate(job *api.Job) *Rejection {
+	if len(p.cfg.TaskTypes) > 0 && !containsFold(p.cfg.TaskTypes, job.TaskType) ||
+		containsFold(p.cfg.ExcludeTaskTypes, job.TaskType) {
+		return &Rejection{RejectTaskType, fmt.Sprintf("task type %q is not accepted", job.TaskType)}
+	}
+
+	if p.cfg.MinReward > 0 && job.Reward < p.cfg.MinReward {
+		return &Rejection{RejectRewardTooLow, fmt.Sprintf("reward %.8f $ROS is below the minimum of %.8f $ROS", job.Reward, p.cfg.MinReward)}
+	}
+
+	payload := job.Payload
+	if p.cfg.MaxVRAMGB > 0 && payload.MinVRAMGB > p.cfg.MaxVRAMGB {
+		return &Rejection{RejectVRAM, fmt.Sprintf("job needs %d GB VRAM, limit is %d GB", payload.MinVRAMGB, p.cfg.MaxVRAMGB)}
+	}
+
+	if len(p.cfg.AllowedImages) > 0 && !imageAllowed(payload.DockerImage, p.cfg.AllowedImages) {
+		return &Rejection{RejectImageDenied, fmt.Sprintf("image %s is not in the allowed image list", payload.DockerImage)}
+	}
+
+	if limit := int64(p.cfg.MaxImageSizeGB * bytesPerGB); limit > 0 && payload.ImageSizeBytes > limit {
+		return &Rejection{RejectImageTooLarge, fmt.Sprintf("image is %.1f GB, limit is %.1f GB",
+			float64(payload.ImageSizeBytes)/bytesPerGB, p.cfg.MaxImageSizeGB)}
+	}
+
+	if limit := int64(p.cfg.MaxInputSizeGB * bytesPerGB); limit > 0 && inputBytes(payload) > limit {
+		return &Rejection{RejectInputTooLarge, fmt.Sprintf("inputs are %.1f GB, limit is %.1f GB",
+			float64(inputBytes(payload))/bytesPerGB, p.cfg.MaxInputSizeGB)}
+	}
+
+	return nil
+}
+
+// imageAllowed matches an image reference against allowed prefixes, both as
+// written and in its fully qualified form ("rios/x" is "docker.io/rios/x")
+func imageAllowed(image string, prefixes []string) bool {
+	normalized := normalizeImage(image)
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(image, prefix) || strings.HasPrefix(normalized, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func normalizeImage(image string) string {
+	first, _, hasSlash := strings.Cut(image, "/")
+	if hasSlash && (strings.ContainsAny(first, ".:") || first == "localhost") {
+		return image
+	}
+	if !hasSlash {
+		image = "library/" + image
+	}
+	return "docker.io/" + image
+}
+
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,137 @@
+package worker
+
+import (
+	"testing"
+
+	"github.com/rios/worker/pkg/api"
+	"github.com/rios/worker/pkg/config"
+)
+
+func TestPolicyEvaluate(t *testing.T) {
+	job := func(taskType string, reward float64, payload api.JobPayload) *api.Job {
+		if payload.DockerImage == "" {
+			payload.DockerImage = "rios/comfyui:latest"
+		}
+		return &api.Job{JobID: "job-1", TaskType: taskType, Reward: reward, Payload: &payload}
+	}
+
+	tests := []struct {
+		name     string
+		cfg      config.PolicyConfig
+		localGB  int
+		job      *api.Job
+		wantCode string // empty if the job is accepted
+	}{
+		{name: "no policy", job: job("comfyui", 0, api.JobPayload{})},
+		{
+			name: "task type accepted",
+			cfg:  config.PolicyConfig{TaskTypes: []string{"comfyui"}},
+			job:  job("ComfyUI", 0, api.JobPayload{}),
+		},
+		{
+			name:     "task type not in list",
+			cfg:      config.PolicyConfig{TaskTypes: []string{"comfyui"}},
+			job:      job("training", 0, api.JobPayload{}),
+			wantCode: RejectTaskType,
+		},
+		{
+			name:     "task type excluded",
+			cfg:      config.PolicyConfig{ExcludeTaskTypes: []string{"training"}},
+			job:      job("training", 0, api.JobPayload{}),
+			wantCode: RejectTaskType,
+		},
+		{
+			name:     "reward below minimum",
+			cfg:      config.PolicyConfig{MinReward: 1},
+			job:      job("comfyui", 0.5, api.JobPayload{}),
+			wantCode: RejectRewardTooLow,
+		},
+		{
+			name: "reward at minimum",
+			cfg:  config.PolicyConfig{MinReward: 1},
+			job:  job("comfyui", 1, api.JobPayload{}),
+		},
+		{
+			name:     "more VRAM than detected",
+			localGB:  24,
+			job:      job("comfyui", 0, api.JobPayload{MinVRAMGB: 40}),
+			wantCode: RejectVRAM,
+		},
+		{
+			name:     "limit above detected VRAM is capped",
+			cfg:      config.PolicyConfig{MaxVRAMGB: 80},
+			localGB:  24,
+			job:      job("comfyui", 0, api.JobPayload{MinVRAMGB: 40}),
+			wantCode: RejectVRAM,
+		},
+		{
+			name:    "VRAM within limit",
+			cfg:     config.PolicyConfig{MaxVRAMGB: 16},
+			localGB: 24,
+			job:     job("comfyui", 0, api.JobPayload{MinVRAMGB: 16}),
+		},
+		{
+			name: "image allowed in its short form",
+			cfg:  config.PolicyConfig{AllowedImages: []string{"docker.io/rios/"}},
+			job:  job("comfyui", 0, api.JobPayload{DockerImage: "rios/comfyui:latest"}),
+		},
+		{
+			name: "official image allowed",
+			cfg:  config.PolicyConfig{AllowedImages: []string{"docker.io/library/ubuntu"}},
+			job:  job("comfyui", 0, api.JobPayload{DockerImage: "ubuntu:22.04"}),
+		},
+		{
+			name:     "image not allowed",
+			cfg:      config.PolicyConfig{AllowedImages: []string{"ghcr.io/rios-network/"}},
+			job:      job("comfyui", 0, api.JobPayload{DockerImage: "ghcr.io/someone/comfyui"}),
+			wantCode: RejectImageDenied,
+		},
+		{
+			name:     "image too large",
+			cfg:      config.PolicyConfig{MaxImageSizeGB: 10},
+			job:      job("comfyui", 0, api.JobPayload{ImageSizeBytes: 11 * bytesPerGB}),
+			wantCode: RejectImageTooLarge,
+		},
+		{
+			name:     "inputs too large",
+			cfg:      config.PolicyConfig{MaxInputSizeGB: 0.5},
+			job:      job("comfyui", 0, api.JobPayload{InputSizeBytes: bytesPerGB}),
+			wantCode: RejectInputTooLarge,
+		},
+		{
+			name: "inputs within limit",
+			cfg:  config.PolicyConfig{MaxInputSizeGB: 1},
+			job:  job("comfyui", 0, api.JobPayload{InputSizeBytes: bytesPerGB}),
+		},
+		{
+			name: "manifest inputs too large",
+			cfg:  config.PolicyConfig{MaxInputSizeGB: 1},
+			job: job("comfyui", 0, api.JobPayload{Inputs: []api.JobInput{
+				{Name: "model", URL: "https://example.com/model.safetensors", Path: "model.safetensors",
+					InputDigest: api.InputDigest{SizeBytes: bytesPerGB * 3 / 4}},
+				{Name: "loras", URL: "https://example.com/loras.tar", Path: "loras", Extract: "tar",
+					InputDigest: api.InputDigest{SizeBytes: bytesPerGB / 2}},
+			}}),
+			wantCode: RejectInputTooLarge,
+		},
+		{
+			name: "manifest inputs within limit",
+			cfg:  config.PolicyConfig{MaxInputSizeGB: 2},
+			job: job("comfyui", 0, api.JobPayload{Inputs: []api.JobInput{
+				{Name: "model", URL: "https://example.com/model.safetensors", Path: "model.safetensors",
+					InputDigest: api.InputDigest{SizeBytes: bytesPerGB * 3 / 4}},
+			}}),
+		},
+	}
+	for _, tt := range tests {
+		got := NewPolicy(tt.cfg, tt.localGB).Evaluate(tt.job)
+		switch {
+		case tt.wantCode == "" && got != nil:
+			t.Errorf("%s: rejected with %s (%s), want accepted", tt.name, got.Code, got.Reason)
+		case tt.wantCode != "" && got == nil:
+			t.Errorf("%s: accepted, want %s", tt.name, tt.wantCode)
+		case tt.wantCode != "" && got.Code != tt.wantCode:
+			t.Errorf("%s: rejected with %s, want %s", tt.name, got.Code, tt.wantCode)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/rios/worker/pkg/api"
+	"github.com/rios/worker/pkg/container"
+	"github.com/rios/worker/pkg/logging"
+)
+
+// Rejection codes of the pre-flight checks
+const (
+	RejectDiskSpace        = "insufficient_disk"
+	RejectImageUnavailable = "image_unavailable"
+	RejectPreflightFailed  = "preflight_failed"
+)
+
+// DefaultMinFreeDiskBytes is the disk space kept free in WorkDir on top of a job's inputs
+const DefaultMinFreeDiskBytes = 5 * bytesPerGB
+
+// Preflight checks that this machine can run job right now: enough free disk
+// in WorkDir for the declared inputs, enough free VRAM and an image that is
+// present or that its registry knows. It returns nil if the job can be
+// accepted.
+func (e *Executor) Preflight(ctx context.Context, job *api.Job) *Rejection {
+	payload := job.Payload
+
+	// Disk: inputs plus headroom for outputs and logs
+	free, err := FreeDiskBytes(e.WorkDir)
+	if err != nil {
+		return &Rejection{RejectPreflightFailed, fmt.Sprintf("unable to check free disk space in %s: %v", e.WorkDir, err)}
+	}
+	reserve := e.MinFreeDiskBytes
+	if reserve == 0 {
+		reserve = DefaultMinFreeDiskBytes
+	}
+	if needed := inputBytes(payload) + reserve; free < needed {
+		return &Rejection{RejectDiskSpace, fmt.Sprintf("%.1f GB free in %s, job needs %.1f GB",
+			float64(free)/bytesPerGB, e.WorkDir, float64(needed)/bytesPerGB)}
+	}
+
+	// VRAM: the job must fit on at least one GPU as it is loaded right now
+	if payload.MinVRAMGB > 0 && e.FreeVRAM != nil {
+		freeMiB, err := e.FreeVRAM()
+		if err != nil {
+			return &Rejection{RejectPreflightFailed, fmt.Sprintf("unable to check free VRAM: %v", err)}
+		}
+		best := 0.0
+		for _, f := range freeMiB {
+			if f > best {
+				best = f
+			}
+		}
+		if best < float64(payload.MinVRAMGB*1024) {
+			return &Rejection{RejectVRAM, fmt.Sprintf("%.1f GB VRAM free, job needs %d GB", best/1024, payload.MinVRAMGB)}
+		}
+	}
+
+	// Image: present locally or known to the registry. A registry that
+	// can't be asked (offline, or a private image the CLI has no credentials
+	// to look up) may still let the pull through.
+	if !e.Runtime.ImageExists(payload.DockerImage) {
+		err := e.Runtime.ImageResolvable(payload.DockerImage)
+		if errors.Is(err, container.ErrImageNotFound) {
+			return &Rejection{RejectImageUnavailable, err.Error()}
+		}
+		if err != nil {
+			logging.FromContext(ctx).Warn("Unable to check the job image, taking the job", logging.KeyJobID, job.JobID, "error", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,133 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"testing"
+
+	"github.com/rios/worker/pkg/api"
+	"github.com/rios/worker/pkg/container"
+	"github.com/rios/worker/pkg/logging"
+)
+
+// offlineRegistry is the fake runtime without the image and without a
+// registry to ask about it
+type offlineRegistry struct {
+	*container.Fake
+}
+
+func (offlineRegistry) ImageExists(string) bool { return false }
+
+func (offlineRegistry) ImageResolvable(string) error {
+	return errors.New("dial tcp: lookup registry-1.docker.io: no such host")
+}
+
+func TestPreflight(t *testing.T) {
+	fake := container.NewFake(&container.FakeScript{MissingImages: []string{"rios/missing:latest"}})
+	freeVRAM := func() ([]float64, error) { return []float64{4096, 8192}, nil }
+	ctx := logging.NewContext(context.Background(), slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	tests := []struct {
+		name     string
+		payload  api.JobPayload
+		runtime  container.Runtime
+		reserve  int64
+		freeVRAM func() ([]float64, error)
+		workDir  string // a temporary directory if empty
+		wantCode string
+	}{
+		{name: "image present", payload: api.JobPayload{DockerImage: "rios/comfyui:latest"}},
+		{
+			name:     "image unknown to the registry",
+			payload:  api.JobPayload{DockerImage: "rios/missing:latest"},
+			wantCode: RejectImageUnavailable,
+		},
+		{
+			name:    "registry unreachable",
+			payload: api.JobPayload{DockerImage: "rios/comfyui:latest"},
+			runtime: offlineRegistry{fake},
+		},
+		{
+			name:     "too little disk for the reserve",
+			payload:  api.JobPayload{DockerImage: "rios/comfyui:latest"},
+			reserve:  1 << 60,
+			wantCode: RejectDiskSpace,
+		},
+		{
+			name:     "too little disk for the inputs",
+			payload:  api.JobPayload{DockerImage: "rios/comfyui:latest", InputSizeBytes: 1 << 60},
+			wantCode: RejectDiskSpace,
+		},
+		{
+			name: "too little disk for the input manifest",
+			payload: api.JobPayload{DockerImage: "rios/comfyui:latest", Inputs: []api.JobInput{
+				{Name: "model", URL: "https://example.com/model", Path: "model", InputDigest: api.InputDigest{SizeBytes: 1 << 60}},
+			}},
+			wantCode: RejectDiskSpace,
+		},
+		{
+			name:     "unknown work directory",
+			payload:  api.JobPayload{DockerImage: "rios/comfyui:latest"},
+			workDir:  filepath.Join(t.TempDir(), "missing"),
+			wantCode: RejectPreflightFailed,
+		},
+		{
+			name:     "fits on one GPU",
+			payload:  api.JobPayload{DockerImage: "rios/comfyui:latest", MinVRAMGB: 8},
+			freeVRAM: freeVRAM,
+		},
+		{
+			name:     "too little VRAM",
+			payload:  api.JobPayload{DockerImage: "rios/comfyui:latest", MinVRAMGB: 12},
+			freeVRAM: freeVRAM,
+			wantCode: RejectVRAM,
+		},
+		{
+			name:    "VRAM not checked without a requirement",
+			payload: api.JobPayload{DockerImage: "rios/comfyui:latest"},
+			freeVRAM: func() ([]float64, error) {
+				return nil, errors.New("nvidia-smi not found")
+			},
+		},
+		{
+			name:    "free VRAM unknown",
+			payload: api.JobPayload{DockerImage: "rios/comfyui:latest", MinVRAMGB: 8},
+			freeVRAM: func() ([]float64, error) {
+				return nil, errors.New("nvidia-smi not found")
+			},
+			wantCode: RejectPreflightFailed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			workDir := tt.workDir
+			if workDir == "" {
+				workDir = t.TempDir()
+			}
+			e := NewExecutor(workDir)
+			e.Runtime = fake
+			if tt.runtime != nil {
+				e.Runtime = tt.runtime
+			}
+			e.MinFreeDiskBytes = 1
+			if tt.reserve != 0 {
+				e.MinFreeDiskBytes = tt.reserve
+			}
+			e.FreeVRAM = tt.freeVRAM
+
+			payload := tt.payload
+			got := e.Preflight(ctx, &api.Job{JobID: "job-1", TaskType: "comfyui", Payload: &payload})
+			switch {
+			case tt.wantCode == "" && got != nil:
+				t.Errorf("rejected with %s (%s), want accepted", got.Code, got.Reason)
+			case tt.wantCode != "" && got == nil:
+				t.Errorf("accepted, want %s", tt.wantCode)
+			case tt.wantCode != "" && got.Code != tt.wantCode:
+				t.Errorf("rejected with %s (%s), want %s", got.Code, got.Reason, tt.wantCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package worker
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket shared by all downloads of an executor
+type rateLimiter struct {
+	rate float64 // bytes per second
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(bytesPerSec int64) *rateLimiter {
+	return &rateLimiter{rate: float64(bytesPerSec), last: time.Now()}
+}
+
+// wait blocks until n bytes may be transferred. Bytes are reserved right
+// away, so concurrent readers queue up behind each other.
+func (l *rateLimiter) wait(ctx context.Context, n int) error {
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.rate {
+		l.tokens = l.rate // at most one second of burst
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	deficit := -l.tokens
+	l.mu.Unlock()
+
+	if deficit <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(time.Duration(deficit / l.rate * float64(time.Second)))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// throttledReader paces reads to the executor's bandwidth limit
+type throttledReader struct {
+	ctx     context.Context
+	r       io.Reader
+	limiter *rateLimiter
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	// Small reads keep the pacing smooth
+	if len(p) > 32<<10 {
+		p = p[:32<<10]
+	}
+	n, err := t.r.Read(p)
+	if n > 0 {
+		if werr := t.limiter.wait(t.ctx, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+// throttle wraps r in the bandwidth limit, if there is one
+func (e *Executor) throttle(ctx context.Context, r io.Reader) io.Reader {
+	e.limiterOnce.Do(func() {
+		if e.MaxDownloadRate > 0 {
+			e.limiter = newRateLimiter(e.MaxDownloadRate)
+		}
+	})
+	if e.limiter == nil {
+		return r
+	}
+	return &throttledReader{ctx: ctx, r: r, limiter: e.limiter}
+}
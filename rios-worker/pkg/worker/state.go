@@ -0,0 +1,283 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rios/worker/pkg/api"
+)
+
+// Worker states as reported in heartbeats and by the admin API
+const (
+	StatusOnline   = "online"
+	StatusBusy     = "busy"
+	StatusPaused   = "paused"
+	StatusDraining = "draining"
+)
+
+// Phases of a running job, in order
+const (
+	PhaseStarting    = "starting"
+	PhaseDownloading = "downloading"
+	PhaseRunning     = "running"
+	PhaseValidating  = "validating"
+	PhaseUploading   = "uploading"
+)
+
+// JobInfo describes a job that is currently running
+type JobInfo struct {
+	JobID       string    `json:"job_id"`
+	TaskType    string    `json:"task_type"`
+	DockerImage string    `json:"docker_image"`
+	StartedAt   time.Time `json:"started_at"`
+	Phase       string    `json:"phase"`
+
+	// Download progress; InputBytes is 0 if the job didn't declare its size
+	DownloadedBytes int64 `json:"downloaded_bytes"`
+	InputBytes      int64 `json:"input_bytes,omitempty"`
+}
+
+type runningJob struct {
+	info    JobInfo
+	cancel  context.CancelCauseFunc
+	traffic *api.Traffic // nil until SetTraffic
+}
+
+// State is the runtime state of a worker, shared between the job loop and
+// the admin API. All methods are safe for concurrent use.
+type State struct {
+	mu        sync.Mutex
+	startedAt time.Time
+	paused    bool
+	draining  bool
+	holds     map[string]string // source -> reason
+	jobs      map[string]*runningJob
+
+	jobsCompleted int
+	rewardsEarned float64
+}
+
+// NewState creates the state for a freshly started worker
+func NewState() *State {
+	return &State{
+		startedAt: time.Now(),
+		holds:     make(map[string]string),
+		jobs:      make(map[string]*runningJob),
+	}
+}
+
+// StartedAt returns when the worker was started
+func (s *State) StartedAt() time.Time {
+	return s.startedAt
+}
+
+// Pause stops the worker from taking new jobs; running jobs continue
+func (s *State) Pause() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.paused = true
+}
+
+// Resume lets a paused worker take new jobs again
+func (s *State) Resume() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.paused = false
+}
+
+// Hold stops the worker from taking new jobs until Release is called with
+// the same source. Unlike Pause it is used by automatic safeguards (e.g. the
+// thermal governor) and is not lifted by Resume. It reports whether the hold
+// is new.
+func (s *State) Hold(source, reason string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, held := s.holds[source]
+	s.holds[source] = reason
+	return !held
+}
+
+// Release lifts the hold placed by source and reports whether there was one
+func (s *State) Release(source string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, held := s.holds[source]
+	delete(s.holds, source)
+	return held
+}
+
+// HoldReason returns why the worker is not taking jobs, or "" if it is
+func (s *State) HoldReason() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sources := make([]string, 0, len(s.holds))
+	for source := range s.holds {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+
+	var reasons []string
+	if s.paused {
+		reasons = append(reasons, "paused by operator")
+	}
+	for _, source := range sources {
+		reasons = append(reasons, s.holds[source])
+	}
+	return strings.Join(reasons, "; ")
+}
+
+// Drain stops the worker from taking new jobs and makes it exit once the
+// running jobs have finished
+func (s *State) Drain() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.draining = true
+}
+
+// Paused reports whether the worker has been paused
+func (s *State) Paused() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.paused
+}
+
+// Draining reports whether Drain has been called
+func (s *State) Draining() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.draining
+}
+
+// Busy reports whether a job is running
+func (s *State) Busy() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.jobs) > 0
+}
+
+// AcceptingJobs reports whether the worker should ask for a new job
+func (s *State) AcceptingJobs() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return !s.paused && !s.draining && len(s.holds) == 0 && len(s.jobs) == 0
+}
+
+// AcceptingJobsExcept reports whether the worker would take a new job if
+// the hold placed by source were lifted
+func (s *State) AcceptingJobsExcept(source string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	holds := len(s.holds)
+	if _, held := s.holds[source]; held {
+		holds--
+	}
+	return !s.paused && !s.draining && holds == 0 && len(s.jobs) == 0
+}
+
+// Status returns the status to report to the orchestrator
+func (s *State) Status() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case len(s.jobs) > 0:
+		return StatusBusy
+	case s.draining:
+		return StatusDraining
+	case s.paused, len(s.holds) > 0:
+		return StatusPaused
+	default:
+		return StatusOnline
+	}
+}
+
+// StartJob records job as running; cancel is called by CancelJob
+func (s *State) StartJob(job *api.Job, cancel context.CancelCauseFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jobs[job.JobID] = &runningJob{
+		info: JobInfo{
+			JobID:       job.JobID,
+			TaskType:    job.TaskType,
+			DockerImage: job.Payload.DockerImage,
+			StartedAt:   time.Now(),
+			Phase:       PhaseStarting,
+			InputBytes:  inputBytes(job.Payload),
+		},
+		cancel: cancel,
+	}
+}
+
+// SetPhase records the phase a running job has reached
+func (s *State) SetPhase(jobID, phase string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if job, ok := s.jobs[jobID]; ok {
+		job.info.Phase = phase
+	}
+}
+
+// SetTraffic makes Jobs report the download progress counted in traffic
+func (s *State) SetTraffic(jobID string, traffic *api.Traffic) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if job, ok := s.jobs[jobID]; ok {
+		job.traffic = traffic
+	}
+}
+
+// FinishJob removes a job from the running set
+func (s *State) FinishJob(jobID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.jobs, jobID)
+}
+
+// CancelJob cancels a running job; cause is reported as the job's error
+func (s *State) CancelJob(jobID string, cause error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[jobID]
+	if !ok {
+		return fmt.Errorf("job %s is not running", jobID)
+	}
+	job.cancel(cause)
+	return nil
+}
+
+// Jobs returns the running jobs, oldest first
+func (s *State) Jobs() []JobInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	jobs := make([]JobInfo, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		info := job.info
+		if job.traffic != nil {
+			info.DownloadedBytes = atomic.LoadInt64(&job.traffic.DownloadBytes)
+		}
+		jobs = append(jobs, info)
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].StartedAt.Before(jobs[j].StartedAt)
+	})
+	return jobs
+}
+
+// AddCompleted records a completed job and its reward for the session summary
+func (s *State) AddCompleted(reward float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jobsCompleted++
+	s.rewardsEarned += reward
+}
+
+// Session returns the jobs completed and rewards earned since the worker started
+func (s *State) Session() (jobsCompleted int, rewardsEarned float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.jobsCompleted, s.rewardsEarned
+}
@@ -0,0 +1,67 @@
+package worker
+
+import (
+	"strconv"
+
+	"github.com/rios/worker/pkg/api"
+)
+
+// taskHandler returns the container arguments of a task type
+type taskHandler func(job *api.Job) []string
+
+var taskHandlers = map[string]taskHandler{
+	"comfyui":  comfyUIArgs,
+	"training": trainingArgs,
+}
+
+func comfyUIArgs(job *api.Job) []string {
+	args := []string{
+		"--input", "/workspace/input/workflow.json",
+		"--output", "/workspace/output/",
+	}
+	if job.Payload.Prompt != "" {
+		args = append(args, "--prompt", job.Payload.Prompt)
+	}
+	return args
+}
+
+func trainingArgs(job *api.Job) []string {
+	args := []string{
+		"--dataset", "/workspace/input/",
+		"--output", "/workspace/output/",
+	}
+	return args
+}
+
+// deterministic reports whether a job must reproduce its outputs exactly.
+// Verification jobs always do, or their hashes could never match.
+func deterministic(payload *api.JobPayload) bool {
+	return payload.Deterministic || payload.Verification != nil
+}
+
+// determinismEnv passes the seed and determinism flag to the task image
+// (RIOS_SEED, RIOS_DETERMINISTIC) and sets the environment that makes CUDA
+// libraries and Python reproducible. They go through the environment rather
+// than the command line, which images that don't know them would reject.
+func determinismEnv(payload *api.JobPayload) []string {
+	var env []string
+	if payload.Seed != nil {
+		seed := strconv.FormatInt(*payload.Seed, 10)
+		env = append(env, "RIOS_SEED="+seed, "PYTHONHASHSEED="+seed)
+	}
+	if deterministic(payload) {
+		if payload.Seed == nil {
+			env = append(env, "PYTHONHASHSEED=0")
+		}
+		env = append(env,
+			"RIOS_DETERMINISTIC=1",
+			// cuBLAS needs a fixed workspace for reproducible reductions
+			"CUBLAS_WORKSPACE_CONFIG=:4096:8",
+			// TF32 matmuls round differently depending on the kernel chosen
+			"NVIDIA_TF32_OVERRIDE=0",
+			"TF_DETERMINISTIC_OPS=1",
+			"TF_CUDNN_DETERMINISTIC=1",
+		)
+	}
+	return env
+}
@@ -0,0 +1,68 @@
+package worker
+
+import (
+	"context"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/rios/worker/pkg/api"
+)
+
+// containerStatsInterval is how often a running container's network counters are read
+const containerStatsInterval = 5 * time.Second
+
+type trafficKey struct{}
+
+// withTraffic makes downloads under ctx count their bytes into traffic
+func withTraffic(ctx context.Context, traffic *api.Traffic) context.Context {
+	if traffic == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, trafficKey{}, traffic)
+}
+
+// countingReader adds the bytes read to a counter shared between goroutines
+type countingReader struct {
+	r io.Reader
+	n *int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	atomic.AddInt64(c.n, int64(n))
+	return n, err
+}
+
+// countDownload counts the bytes read from r as the job's download traffic
+func countDownload(ctx context.Context, r io.Reader) io.Reader {
+	traffic, ok := ctx.Value(trafficKey{}).(*api.Traffic)
+	if !ok {
+		return r
+	}
+	return &countingReader{r: r, n: &traffic.DownloadBytes}
+}
+
+// watchContainerTraffic records the network counters of the job's container
+// until stop is closed. They are only reported while the container runs,
+// so traffic after the last reading is not counted.
+func (e *Executor) watchContainerTraffic(ctx context.Context, containerName string, stop <-chan struct{}) {
+	traffic, ok := ctx.Value(trafficKey{}).(*api.Traffic)
+	if !ok {
+		return
+	}
+
+	ticker := time.NewTicker(containerStatsInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if rx, tx, err := e.Runtime.NetworkIO(containerName); err == nil {
+				atomic.StoreInt64(&traffic.ContainerRxBytes, rx)
+				atomic.StoreInt64(&traffic.ContainerTxBytes, tx)
+			}
+		}
+	}
+}
@@ -4,29 +4,22 @@
 # RiOS Worker - Systemd Service Setup
 # 
 # This script sets up RiOS Worker as a systemd service
-# that starts automatically on boot.
+# that starts automatically on boot. It is a thin wrapper around
+# 'rios-worker service install', which creates the rios user,
+# /etc/rios and /var/lib/rios, and copies the registration of the
+# user running sudo.
 #
 # Usage:
-#   sudo ./setup-service.sh
+#   sudo ./setup-service.sh [-- run flags]
 #
 #################################################################
 
 set -e
 
 # Colors
-GREEN='\033[0;32m'
-YELLOW='\033[1;33m'
 RED='\033[0;31m'
 NC='\033[0m'
 
-print_success() {
-    echo -e "${GREEN}✅ $1${NC}"
-}
-
-print_warning() {
-    echo -e "${YELLOW}⚠️  $1${NC}"
-}
-
 print_error() {
     echo -e "${RED}❌ $1${NC}"
 }
@@ -50,119 +43,20 @@ if ! command -v rios-worker &> /dev/null; then
     exit 1
 fi
 
-# Get the user who will run the service
-if [ -n "$SUDO_USER" ]; then
-    WORKER_USER="$SUDO_USER"
-else
-    echo "Enter the username that will run the worker:"
-    read -r WORKER_USER
-fi
-
-# Validate user exists
-if ! id "$WORKER_USER" &>/dev/null; then
-    print_error "User $WORKER_USER does not exist"
-    exit 1
-fi
-
-# Check if user has registered
-CONFIG_FILE="/home/$WORKER_USER/.rios/config.json"
-if [ ! -f "$CONFIG_FILE" ]; then
-    print_warning "Worker not registered yet"
-    echo ""
-    echo "Please register first as user $WORKER_USER:"
-    echo "  su - $WORKER_USER"
-    echo "  rios-worker register --api https://api.rios.com.ai"
-    exit 1
-fi
-
-print_success "Worker is registered (user: $WORKER_USER)"
-
-# Copy service file
-print_warning "Installing systemd service..."
-
-SERVICE_FILE="/etc/systemd/system/rios-worker@.service"
-cat > "$SERVICE_FILE" << 'EOF'
-[Unit]
-Description=RiOS Worker - Decentralized GPU Compute Node
-Documentation=https://docs.rios.com.ai
-After=network-online.target docker.service
-Wants=network-online.target
-Requires=docker.service
-
-[Service]
-Type=simple
-User=%i
-Group=docker
-
-# Environment
-Environment="PATH=/usr/local/bin:/usr/bin:/bin"
-
-# Worker configuration is loaded from ~/.rios/config.json
-ExecStart=/usr/local/bin/rios-worker run
-
-# Restart policy
-Restart=always
-RestartSec=10
+rios-worker service install "$@"
 
-# Resource limits
-LimitNOFILE=65536
-LimitNPROC=4096
-
-# Logging
-StandardOutput=journal
-StandardError=journal
-SyslogIdentifier=rios-worker
-
-# Security
-NoNewPrivileges=true
-PrivateTmp=true
-
-[Install]
-WantedBy=multi-user.target
-EOF
-
-print_success "Service file installed: $SERVICE_FILE"
-
-# Reload systemd
-systemctl daemon-reload
-print_success "Systemd daemon reloaded"
-
-# Enable service
-systemctl enable "rios-worker@$WORKER_USER"
-print_success "Service enabled (will start on boot)"
-
-# Start service
-systemctl start "rios-worker@$WORKER_USER"
-print_success "Service started"
-
-# Show status
-echo ""
-echo "━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━"
-echo "  🎉 Service Setup Complete!"
-echo "━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━"
-echo ""
-print_success "RiOS Worker is now running as a system service"
 echo ""
 echo "📋 Useful Commands:"
 echo ""
 echo "  Check status:"
-echo "    sudo systemctl status rios-worker@$WORKER_USER"
+echo "    rios-worker service status"
 echo ""
 echo "  View logs:"
-echo "    sudo journalctl -u rios-worker@$WORKER_USER -f"
-echo ""
-echo "  Stop service:"
-echo "    sudo systemctl stop rios-worker@$WORKER_USER"
+echo "    sudo journalctl -u rios-worker -f"
 echo ""
 echo "  Restart service:"
-echo "    sudo systemctl restart rios-worker@$WORKER_USER"
+echo "    sudo systemctl restart rios-worker"
 echo ""
-echo "  Disable service:"
-echo "    sudo systemctl disable rios-worker@$WORKER_USER"
+echo "  Remove service:"
+echo "    sudo rios-worker service uninstall"
 echo ""
-echo "━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━"
-echo ""
-
-# Show current status
-systemctl status "rios-worker@$WORKER_USER" --no-pager
-